@@ -0,0 +1,306 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// This file provides the binding of requests to request structs.
+//
+// Fields are filled from the parts of the request named by their tags:
+//
+//	path:"name"   the path value of the route pattern, such as {item_id}
+//	query:"name"  the query parameter
+//	form:"name"   the field of an urlencoded or multipart form; []byte fields take the uploaded file
+//	json:"name"   the key of a JSON body
+//
+// The body is read as JSON or as a form according to its Content-Type. Form fields without a form tag use their
+// JSON key, so that JSON endpoints also accept forms. Query and path values are bound after the body and override it.
+// After binding, the struct is validated with validate.
+//
+// Bodies of structs without []byte fields are limited to maxBodySize, and can't be multipart forms. Handlers of
+// structs with []byte fields take uploads, and limit the body to the size of the upload themselves.
+
+const (
+	// maxBodySize is the largest body of requests which don't upload files.
+	maxBodySize = 1 << 20
+	// maxFormMemory is how much of a multipart form is kept in memory; larger files are stored in temporary files.
+	maxFormMemory = 32 << 20
+)
+
+var (
+	errUnsupportedMediaType = errors.New("unsupported media type")
+	// errUploadNotAccepted is returned for multipart forms sent to requests which don't upload files.
+	errUploadNotAccepted = errors.New("file uploads are not accepted")
+)
+
+// BindError is every value of a request which could not be converted to its field.
+type BindError struct {
+	Errors []FieldError
+}
+
+func (e *BindError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		msgs = append(msgs, fe.Field+" "+fe.Message)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// bind fills v, a pointer to a request struct, from r and validates it.
+// Values of the wrong type are reported by a *BindError, and invalid values by a *ValidationError.
+func bind(r *http.Request, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("bind: %T is not a pointer to a struct", v))
+	}
+	rv = rv.Elem()
+
+	upload := hasUpload(rv.Type())
+	if !upload && r.Body != nil && r.Body != http.NoBody {
+		r.Body = http.MaxBytesReader(nil, r.Body, maxBodySize)
+	}
+
+	var errs []FieldError
+	bodyErrs, err := bindBody(r, v, rv, upload)
+	if err != nil {
+		return err
+	}
+	errs = append(errs, bodyErrs...)
+
+	query := r.URL.Query()
+	t := rv.Type()
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		if name := tagName(sf, "query"); name != "" {
+			if values, ok := query[name]; ok {
+				if msg := setField(rv.Field(i), values); msg != "" {
+					errs = append(errs, FieldError{Field: name, Message: msg})
+				}
+			}
+		}
+		if name := tagName(sf, "path"); name != "" {
+			if value := r.PathValue(name); value != "" {
+				if msg := setField(rv.Field(i), []string{value}); msg != "" {
+					errs = append(errs, FieldError{Field: name, Message: msg})
+				}
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return &BindError{Errors: errs}
+	}
+	return validate(v)
+}
+
+// tagName returns the name in the tag with the key, or "" if there is none.
+func tagName(sf reflect.StructField, key string) string {
+	name, _, _ := strings.Cut(sf.Tag.Get(key), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// hasUpload reports whether t has a []byte field, which takes an uploaded file.
+func hasUpload(t reflect.Type) bool {
+	for i := range t.NumField() {
+		if sf := t.Field(i); sf.IsExported() && sf.Type == reflect.TypeFor[[]byte]() {
+			return true
+		}
+	}
+	return false
+}
+
+// bindBody fills v from the body of r according to its Content-Type. Multipart forms are only read if upload is set.
+// Conversion problems are returned as field errors, and malformed bodies as an error.
+func bindBody(r *http.Request, v any, rv reflect.Value, upload bool) ([]FieldError, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	// a body without a Content-Type is taken as JSON, as clients of JSON APIs often omit it
+	case contentType == "" || mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return bindJSON(r, v)
+	case mediaType == "multipart/form-data" && !upload:
+		return nil, errUploadNotAccepted
+	case mediaType == "multipart/form-data" || mediaType == "application/x-www-form-urlencoded":
+		return bindForm(r, rv)
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnsupportedMediaType, mediaType)
+	}
+}
+
+func bindJSON(r *http.Request, v any) ([]FieldError, error) {
+	err := json.NewDecoder(r.Body).Decode(v)
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case err == nil, errors.Is(err, io.EOF):
+		// an empty body leaves every field unset
+		return nil, nil
+	case errors.As(err, &typeErr):
+		return []FieldError{{Field: typeErr.Field, Message: "must be " + kindName(typeErr.Type)}}, nil
+	default:
+		return nil, fmt.Errorf("failed to decode request body: %w", err)
+	}
+}
+
+func bindForm(r *http.Request, rv reflect.Value) ([]FieldError, error) {
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		err = r.ParseMultipartForm(maxFormMemory)
+	} else {
+		err = r.ParseForm()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse form: %w", err)
+	}
+
+	var errs []FieldError
+	t := rv.Type()
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name := tagName(sf, "form")
+		if name == "" {
+			name = tagName(sf, "json")
+		}
+		if name == "" {
+			continue
+		}
+
+		field := rv.Field(i)
+		if field.Type() == reflect.TypeFor[[]byte]() {
+			data, err := formFile(r, name)
+			if err != nil {
+				return nil, err
+			}
+			if data != nil {
+				field.SetBytes(data)
+			}
+			continue
+		}
+		if values, ok := r.PostForm[name]; ok {
+			if msg := setField(field, values); msg != "" {
+				errs = append(errs, FieldError{Field: name, Message: msg})
+			}
+		}
+	}
+	return errs, nil
+}
+
+// formFile returns the content of the uploaded file, or nil if there is none.
+func formFile(r *http.Request, name string) ([]byte, error) {
+	if r.MultipartForm == nil || len(r.MultipartForm.File[name]) == 0 {
+		return nil, nil
+	}
+	file, err := r.MultipartForm.File[name][0].Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return data, nil
+}
+
+// setField converts values to the type of field and sets it.
+// It returns a message describing the problem, or "" on success.
+func setField(field reflect.Value, values []string) string {
+	if field.Kind() == reflect.Pointer {
+		v := reflect.New(field.Type().Elem())
+		if msg := setField(v.Elem(), values); msg != "" {
+			return msg
+		}
+		field.Set(v)
+		return ""
+	}
+	if field.Kind() == reflect.Slice && field.Type().Elem().Kind() != reflect.Uint8 {
+		s := reflect.MakeSlice(field.Type(), len(values), len(values))
+		for i, value := range values {
+			if msg := setScalar(s.Index(i), value); msg != "" {
+				return msg
+			}
+		}
+		field.Set(s)
+		return ""
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	return setScalar(field, values[0])
+}
+
+func setScalar(field reflect.Value, value string) string {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "must be " + kindName(field.Type())
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, field.Type().Bits())
+		if err != nil {
+			return "must be " + kindName(field.Type())
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(strings.TrimSpace(value), 10, field.Type().Bits())
+		if err != nil {
+			return "must be " + kindName(field.Type())
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), field.Type().Bits())
+		if err != nil {
+			return "must be " + kindName(field.Type())
+		}
+		field.SetFloat(f)
+	default:
+		// request types only use the kinds above, so anything else is a programming error
+		panic(fmt.Sprintf("bind: unsupported field type %s", field.Type()))
+	}
+	return ""
+}
+
+// kindName describes the values of t for error messages.
+func kindName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "an integer"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a non-negative integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
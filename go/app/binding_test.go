@@ -0,0 +1,210 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type testBindRequest struct {
+	ID      int      `json:"-" path:"id" validate:"min=1"`
+	Page    int      `query:"page"`
+	Tags    []string `query:"tag"`
+	Name    string   `form:"name" json:"name" validate:"trim,required"`
+	Price   *int     `json:"price"`
+	Visible bool     `json:"visible"`
+	File    []byte   `form:"file" json:"file"`
+}
+
+func TestBind(t *testing.T) {
+	t.Parallel()
+
+	price := 1200
+	cases := map[string]struct {
+		build    func() *http.Request
+		want     testBindRequest
+		wantErrs []FieldError
+		wantErr  error
+	}{
+		"ok: json body with path and query": {
+			build: func() *http.Request {
+				req := httptest.NewRequest("POST", "/things/7?page=2&tag=a&tag=b", strings.NewReader(`{"name": " bag ", "price": 1200, "visible": true}`))
+				req.Header.Set("Content-Type", "application/json; charset=utf-8")
+				return req
+			},
+			want: testBindRequest{ID: 7, Page: 2, Tags: []string{"a", "b"}, Name: "bag", Price: &price, Visible: true},
+		},
+		"ok: json body without content type": {
+			build: func() *http.Request {
+				return httptest.NewRequest("POST", "/things/7", strings.NewReader(`{"name": "bag"}`))
+			},
+			want: testBindRequest{ID: 7, Name: "bag"},
+		},
+		"ok: urlencoded form falls back to json keys": {
+			build: func() *http.Request {
+				form := url.Values{"name": {"bag"}, "price": {"1200"}, "visible": {"true"}}
+				req := httptest.NewRequest("POST", "/things/7", strings.NewReader(form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return req
+			},
+			want: testBindRequest{ID: 7, Name: "bag", Price: &price, Visible: true},
+		},
+		"ok: multipart form with a file": {
+			build: func() *http.Request {
+				body := &bytes.Buffer{}
+				mw := multipart.NewWriter(body)
+				mw.WriteField("name", "bag")
+				fw, _ := mw.CreateFormFile("file", "a.txt")
+				fw.Write([]byte("content"))
+				mw.Close()
+				req := httptest.NewRequest("POST", "/things/7", body)
+				req.Header.Set("Content-Type", mw.FormDataContentType())
+				return req
+			},
+			want: testBindRequest{ID: 7, Name: "bag", File: []byte("content")},
+		},
+		"ng: conversion errors": {
+			build: func() *http.Request {
+				form := url.Values{"name": {"bag"}, "price": {"cheap"}}
+				req := httptest.NewRequest("POST", "/things/x?page=first", strings.NewReader(form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return req
+			},
+			wantErrs: []FieldError{
+				{Field: "price", Message: "must be an integer"},
+				{Field: "id", Message: "must be an integer"},
+				{Field: "page", Message: "must be an integer"},
+			},
+		},
+		"ng: json type error": {
+			build: func() *http.Request {
+				return httptest.NewRequest("POST", "/things/7", strings.NewReader(`{"name": "bag", "price": "cheap"}`))
+			},
+			wantErrs: []FieldError{{Field: "price", Message: "must be an integer"}},
+		},
+		"ng: unsupported media type": {
+			build: func() *http.Request {
+				req := httptest.NewRequest("POST", "/things/7", strings.NewReader("name=bag"))
+				req.Header.Set("Content-Type", "text/plain")
+				return req
+			},
+			wantErr: errUnsupportedMediaType,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var got testBindRequest
+			mux := http.NewServeMux()
+			var err error
+			mux.HandleFunc("POST /things/{id}", func(w http.ResponseWriter, r *http.Request) {
+				err = bind(r, &got)
+			})
+			mux.ServeHTTP(httptest.NewRecorder(), tt.build())
+
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("expected %v, got %v", tt.wantErr, err)
+				}
+			case tt.wantErrs != nil:
+				var bindErr *BindError
+				if !errors.As(err, &bindErr) {
+					t.Fatalf("expected *BindError, got %v", err)
+				}
+				if diff := cmp.Diff(tt.wantErrs, bindErr.Errors); diff != "" {
+					t.Errorf("unexpected errors (-want +got):\n%s", diff)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if diff := cmp.Diff(tt.want, got); diff != "" {
+					t.Errorf("unexpected request (-want +got):\n%s", diff)
+				}
+			}
+		})
+	}
+}
+
+func TestBindLimitsBodyWithoutUploads(t *testing.T) {
+	t.Parallel()
+
+	type request struct {
+		Name string `json:"name"`
+	}
+	cases := map[string]struct {
+		build    func() *http.Request
+		wantCode int
+	}{
+		"ng: json body over the limit": {
+			build: func() *http.Request {
+				return httptest.NewRequest("POST", "/", strings.NewReader(`{"name": "`+strings.Repeat("a", maxBodySize)+`"}`))
+			},
+			wantCode: http.StatusRequestEntityTooLarge,
+		},
+		"ng: urlencoded form over the limit": {
+			build: func() *http.Request {
+				req := httptest.NewRequest("POST", "/", strings.NewReader("name="+strings.Repeat("a", maxBodySize)))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return req
+			},
+			wantCode: http.StatusRequestEntityTooLarge,
+		},
+		"ng: multipart form": {
+			build: func() *http.Request {
+				body := &bytes.Buffer{}
+				mw := multipart.NewWriter(body)
+				mw.WriteField("name", "bag")
+				fw, _ := mw.CreateFormFile("file", "a.txt")
+				fw.Write([]byte("content"))
+				mw.Close()
+				req := httptest.NewRequest("POST", "/", body)
+				req.Header.Set("Content-Type", mw.FormDataContentType())
+				return req
+			},
+			wantCode: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			r := tt.build()
+			var req request
+			rr := httptest.NewRecorder()
+			if err := bind(r, &req); err != nil {
+				writeRequestError(rr, r, err)
+			}
+			if rr.Code != tt.wantCode {
+				t.Errorf("expected status code %d, got %d", tt.wantCode, rr.Code)
+			}
+			if r.MultipartForm != nil {
+				t.Errorf("expected the multipart form not to be parsed")
+			}
+		})
+	}
+}
+
+func TestWriteRequestErrorForBindError(t *testing.T) {
+	t.Parallel()
+
+	rr := httptest.NewRecorder()
+	writeRequestError(rr, httptest.NewRequest("GET", "/items/x", nil), &BindError{Errors: []FieldError{{Field: "item_id", Message: "must be an integer"}}})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `"field":"item_id"`) {
+		t.Errorf("expected the field in the response, got %s", rr.Body.String())
+	}
+}
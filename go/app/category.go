@@ -0,0 +1,497 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	errCategoryNotFound = errors.New("category not found")
+	// errCategoryExists is returned when a sibling category already has the name.
+	errCategoryExists = errors.New("category already exists")
+	// errInvalidCategory is returned for empty names and for changes which would break the hierarchy.
+	errInvalidCategory = errors.New("invalid category")
+)
+
+// Category is a node of the category hierarchy.
+type Category struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// ParentID is nil for root categories.
+	ParentID *int `json:"parent_id"`
+}
+
+// CategoryTree is a category with its subcategories.
+type CategoryTree struct {
+	Category
+	Children []CategoryTree `json:"children"`
+}
+
+// buildCategoryTree arranges categories into trees under their parents.
+// The order of siblings is the order of categories.
+func buildCategoryTree(categories []Category) []CategoryTree {
+	children := make(map[int][]Category)
+	var roots []Category
+	for _, c := range categories {
+		if c.ParentID == nil {
+			roots = append(roots, c)
+		} else {
+			children[*c.ParentID] = append(children[*c.ParentID], c)
+		}
+	}
+
+	var build func(cs []Category) []CategoryTree
+	build = func(cs []Category) []CategoryTree {
+		trees := make([]CategoryTree, 0, len(cs))
+		for _, c := range cs {
+			trees = append(trees, CategoryTree{Category: c, Children: build(children[c.ID])})
+		}
+		return trees
+	}
+	return build(roots)
+}
+
+// normalizeCategoryName returns the name to store and the key identifying it among its siblings.
+// The name is NFKC-normalized with surrounding spaces trimmed and inner spaces collapsed,
+// so that "Ｆａｓｈｉｏｎ " becomes "Fashion", and the key is additionally case-folded.
+func normalizeCategoryName(name string) (normalized, key string, err error) {
+	normalized = strings.Join(strings.Fields(norm.NFKC.String(name)), " ")
+	if normalized == "" {
+		return "", "", fmt.Errorf("%w: name is required", errInvalidCategory)
+	}
+	return normalized, cases.Fold().String(normalized), nil
+}
+
+// Please run `go generate ./...` to generate the mock implementation
+// CategoryRepository is an interface to manage the category hierarchy.
+//
+//go:generate go run go.uber.org/mock/mockgen -source=$GOFILE -package=${GOPACKAGE} -destination=./mock_$GOFILE
+type CategoryRepository interface {
+	// List returns every category ordered by name.
+	List(ctx context.Context) ([]Category, error)
+	// Get returns errCategoryNotFound if the category does not exist.
+	Get(ctx context.Context, id int) (Category, error)
+	// Create creates a category under the parent, or a root category if parentID is nil.
+	Create(ctx context.Context, name string, parentID *int) (Category, error)
+	Rename(ctx context.Context, id int, name string) (Category, error)
+	// Move moves the category with its subcategories under the parent, or to the root if parentID is nil.
+	Move(ctx context.Context, id int, parentID *int) (Category, error)
+	// Merge moves the items and subcategories of the source category into the target, and deletes the source.
+	// Subcategories with the same name as a subcategory of the target are merged into it as well.
+	Merge(ctx context.Context, sourceID, targetID int) error
+}
+
+// categoryRepository is an implementation of CategoryRepository
+type categoryRepository struct {
+	db *sql.DB
+}
+
+// NewCategoryRepository creates a new categoryRepository.
+func NewCategoryRepository(db *sql.DB) CategoryRepository {
+	return &categoryRepository{db: db}
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (c *categoryRepository) List(ctx context.Context) ([]Category, error) {
+	rows, err := c.db.QueryContext(ctx, "SELECT id, name, parent_id FROM categories ORDER BY name_key, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []Category
+	for rows.Next() {
+		var category Category
+		if err := rows.Scan(&category.ID, &category.Name, &category.ParentID); err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+	return categories, rows.Err()
+}
+
+func (c *categoryRepository) Get(ctx context.Context, id int) (Category, error) {
+	return getCategory(ctx, c.db, id)
+}
+
+func getCategory(ctx context.Context, q queryer, id int) (Category, error) {
+	category := Category{ID: id}
+	err := q.QueryRowContext(ctx, "SELECT name, parent_id FROM categories WHERE id = ?", id).Scan(&category.Name, &category.ParentID)
+	if err == sql.ErrNoRows {
+		return Category{}, errCategoryNotFound
+	}
+	return category, err
+}
+
+func (c *categoryRepository) Create(ctx context.Context, name string, parentID *int) (Category, error) {
+	name, key, err := normalizeCategoryName(name)
+	if err != nil {
+		return Category{}, err
+	}
+	if parentID != nil {
+		if err := checkParentCategory(ctx, c.db, *parentID); err != nil {
+			return Category{}, err
+		}
+	}
+
+	result, err := c.db.ExecContext(ctx, "INSERT INTO categories (name, name_key, parent_id) VALUES (?, ?, ?)", name, key, parentID)
+	if err != nil {
+		return Category{}, categoryWriteError(err, name)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Category{}, err
+	}
+	return Category{ID: int(id), Name: name, ParentID: parentID}, nil
+}
+
+func (c *categoryRepository) Rename(ctx context.Context, id int, name string) (Category, error) {
+	name, key, err := normalizeCategoryName(name)
+	if err != nil {
+		return Category{}, err
+	}
+
+	result, err := c.db.ExecContext(ctx, "UPDATE categories SET name = ?, name_key = ? WHERE id = ?", name, key, id)
+	if err != nil {
+		return Category{}, categoryWriteError(err, name)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return Category{}, err
+	} else if n == 0 {
+		return Category{}, errCategoryNotFound
+	}
+	return c.Get(ctx, id)
+}
+
+func (c *categoryRepository) Move(ctx context.Context, id int, parentID *int) (category Category, err error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Category{}, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	category, err = getCategory(ctx, tx, id)
+	if err != nil {
+		return Category{}, err
+	}
+	if parentID != nil {
+		if err := checkParentCategory(ctx, tx, *parentID); err != nil {
+			return Category{}, err
+		}
+		subtree, err := categorySubtree(ctx, tx, id)
+		if err != nil {
+			return Category{}, err
+		}
+		if slices.Contains(subtree, *parentID) {
+			return Category{}, fmt.Errorf("%w: category %d can't be moved under itself or its subcategories", errInvalidCategory, id)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE categories SET parent_id = ? WHERE id = ?", parentID, id); err != nil {
+		return Category{}, categoryWriteError(err, category.Name)
+	}
+	category.ParentID = parentID
+	return category, tx.Commit()
+}
+
+func (c *categoryRepository) Merge(ctx context.Context, sourceID, targetID int) (err error) {
+	if sourceID == targetID {
+		return fmt.Errorf("%w: category %d can't be merged into itself", errInvalidCategory, sourceID)
+	}
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, err := getCategory(ctx, tx, sourceID); err != nil {
+		return err
+	}
+	if _, err := getCategory(ctx, tx, targetID); err != nil {
+		return err
+	}
+	subtree, err := categorySubtree(ctx, tx, sourceID)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(subtree, targetID) {
+		return fmt.Errorf("%w: category %d can't be merged into its subcategory %d", errInvalidCategory, sourceID, targetID)
+	}
+
+	if err := mergeCategory(ctx, tx, sourceID, targetID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// mergeCategory moves the items and subcategories of source into target, and deletes source.
+func mergeCategory(ctx context.Context, tx *sql.Tx, sourceID, targetID int) error {
+	if _, err := tx.ExecContext(ctx, "UPDATE items SET category_id = ? WHERE category_id = ?", targetID, sourceID); err != nil {
+		return err
+	}
+
+	// subcategories whose name the target already has are merged into the target's, the others are moved
+	rows, err := tx.QueryContext(ctx, `
+		SELECT source.id, target.id
+		FROM categories AS source
+		LEFT JOIN categories AS target ON target.parent_id = ? AND target.name_key = source.name_key
+		WHERE source.parent_id = ?`, targetID, sourceID)
+	if err != nil {
+		return err
+	}
+	type child struct {
+		id      int
+		mergeTo sql.NullInt64
+	}
+	var children []child
+	for rows.Next() {
+		var c child
+		if err := rows.Scan(&c.id, &c.mergeTo); err != nil {
+			rows.Close()
+			return err
+		}
+		children = append(children, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, c := range children {
+		if c.mergeTo.Valid {
+			if err := mergeCategory(ctx, tx, c.id, int(c.mergeTo.Int64)); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE categories SET parent_id = ? WHERE id = ?", targetID, c.id); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM categories WHERE id = ?", sourceID)
+	return err
+}
+
+// checkParentCategory returns errInvalidCategory if the parent does not exist.
+func checkParentCategory(ctx context.Context, q queryer, parentID int) error {
+	_, err := getCategory(ctx, q, parentID)
+	if errors.Is(err, errCategoryNotFound) {
+		return fmt.Errorf("%w: parent category %d not found", errInvalidCategory, parentID)
+	}
+	return err
+}
+
+// categorySubtree returns the IDs of the category and all of its descendants.
+func categorySubtree(ctx context.Context, q queryer, id int) ([]int, error) {
+	rows, err := q.QueryContext(ctx, `
+		WITH RECURSIVE subtree(id) AS (
+			SELECT ?
+			UNION
+			SELECT categories.id FROM categories JOIN subtree ON categories.parent_id = subtree.id
+		)
+		SELECT id FROM subtree`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// categoryWriteError converts the violation of the unique index on sibling names to errCategoryExists.
+func categoryWriteError(err error, name string) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return fmt.Errorf("%w: %q", errCategoryExists, name)
+	}
+	return err
+}
+
+type GetCategoriesResponse struct {
+	Categories []CategoryTree `json:"categories"`
+}
+
+// GetCategories is a handler to return the category hierarchy for GET /categories .
+func (s *Handlers) GetCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := s.categoryRepo.List(r.Context())
+	if err != nil {
+		loggerFromContext(r.Context()).Error("failed to list categories: ", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, GetCategoriesResponse{Categories: buildCategoryTree(categories)})
+}
+
+type GetCategoryItemsRequest struct {
+	ID int `path:"category_id" validate:"required,min=1"`
+}
+
+type GetCategoryItemsResponse struct {
+	Category Category `json:"category"`
+	Items    []Item   `json:"items"`
+}
+
+// GetCategoryItems is a handler to return the items in a category and its subcategories for GET /categories/{category_id}/items .
+func (s *Handlers) GetCategoryItems(w http.ResponseWriter, r *http.Request) {
+	var req GetCategoryItemsRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+
+	category, err := s.categoryRepo.Get(r.Context(), req.ID)
+	if err != nil {
+		s.writeCategoryError(w, r, err)
+		return
+	}
+	items, err := s.itemRepo.FindItemsByCategory(r.Context(), req.ID)
+	if err != nil {
+		s.writeCategoryError(w, r, err)
+		return
+	}
+	if items == nil {
+		items = []Item{}
+	}
+	writeJSON(w, http.StatusOK, GetCategoryItemsResponse{Category: category, Items: items})
+}
+
+type CreateCategoryRequest struct {
+	Name string `json:"name" validate:"trim,required,max=50,singleline"`
+	// ParentID is the parent of the new category. Omit it to create a root category.
+	ParentID *int `json:"parent_id" validate:"min=1"`
+}
+
+// CreateCategory is a handler to create a category for POST /categories .
+func (s *Handlers) CreateCategory(w http.ResponseWriter, r *http.Request) {
+	var req CreateCategoryRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+
+	category, err := s.categoryRepo.Create(r.Context(), req.Name, req.ParentID)
+	if err != nil {
+		s.writeCategoryError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/categories/%d/items", category.ID))
+	writeJSON(w, http.StatusCreated, category)
+}
+
+type RenameCategoryRequest struct {
+	ID   int    `json:"-" path:"category_id" validate:"required,min=1"`
+	Name string `json:"name" validate:"trim,required,max=50,singleline"`
+}
+
+// RenameCategory is a handler to rename a category for POST /categories/{category_id}/rename .
+func (s *Handlers) RenameCategory(w http.ResponseWriter, r *http.Request) {
+	var req RenameCategoryRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+
+	category, err := s.categoryRepo.Rename(r.Context(), req.ID, req.Name)
+	if err != nil {
+		s.writeCategoryError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, category)
+}
+
+type MoveCategoryRequest struct {
+	ID int `json:"-" path:"category_id" validate:"required,min=1"`
+	// ParentID is the new parent. null moves the category to the root.
+	ParentID *int `json:"parent_id" validate:"min=1"`
+}
+
+// MoveCategory is a handler to move a category under another for POST /categories/{category_id}/move .
+func (s *Handlers) MoveCategory(w http.ResponseWriter, r *http.Request) {
+	var req MoveCategoryRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+
+	category, err := s.categoryRepo.Move(r.Context(), req.ID, req.ParentID)
+	if err != nil {
+		s.writeCategoryError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, category)
+}
+
+type MergeCategoryRequest struct {
+	ID int `json:"-" path:"category_id" validate:"required,min=1"`
+	// TargetID is the category which receives the items and subcategories.
+	TargetID int `json:"target_id" validate:"required,min=1"`
+}
+
+// MergeCategory is a handler to merge a category into another for POST /categories/{category_id}/merge .
+// This is how duplicates such as "ファッション" and "Fashion" are cleaned up.
+func (s *Handlers) MergeCategory(w http.ResponseWriter, r *http.Request) {
+	var req MergeCategoryRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+
+	if err := s.categoryRepo.Merge(r.Context(), req.ID, req.TargetID); err != nil {
+		s.writeCategoryError(w, r, err)
+		return
+	}
+	category, err := s.categoryRepo.Get(r.Context(), req.TargetID)
+	if err != nil {
+		s.writeCategoryError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, category)
+}
+
+// writeCategoryError writes the status code of a CategoryRepository error.
+func (s *Handlers) writeCategoryError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errCategoryNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errCategoryExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, errInvalidCategory):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		loggerFromContext(r.Context()).Error("failed to manage categories: ", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,326 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/mock/gomock"
+)
+
+func TestNormalizeCategoryName(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		name     string
+		wantName string
+		wantKey  string
+		wantErr  bool
+	}{
+		"ok: trimmed":               {name: " Fashion  ", wantName: "Fashion", wantKey: "fashion"},
+		"ok: inner spaces collapse": {name: "Home   &\tLiving", wantName: "Home & Living", wantKey: "home & living"},
+		"ok: full-width":            {name: "Ｆａｓｈｉｏｎ", wantName: "Fashion", wantKey: "fashion"},
+		"ok: half-width katakana":   {name: "ﾌｧｯｼｮﾝ", wantName: "ファッション", wantKey: "ファッション"},
+		"ng: blank":                 {name: " 　", wantErr: true},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			gotName, gotKey, err := normalizeCategoryName(tt.name)
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidCategory) {
+					t.Errorf("expected errInvalidCategory, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if gotName != tt.wantName || gotKey != tt.wantKey {
+				t.Errorf("expected %q, %q, got %q, %q", tt.wantName, tt.wantKey, gotName, gotKey)
+			}
+		})
+	}
+}
+
+func TestBuildCategoryTree(t *testing.T) {
+	t.Parallel()
+
+	fashion, tops := 1, 2
+	got := buildCategoryTree([]Category{
+		{ID: 3, Name: "Books"},
+		{ID: fashion, Name: "Fashion"},
+		{ID: 4, Name: "Shirts", ParentID: &tops},
+		{ID: tops, Name: "Tops", ParentID: &fashion},
+	})
+	want := []CategoryTree{
+		{Category: Category{ID: 3, Name: "Books"}, Children: []CategoryTree{}},
+		{Category: Category{ID: fashion, Name: "Fashion"}, Children: []CategoryTree{
+			{Category: Category{ID: tops, Name: "Tops", ParentID: &fashion}, Children: []CategoryTree{
+				{Category: Category{ID: 4, Name: "Shirts", ParentID: &tops}, Children: []CategoryTree{}},
+			}},
+		}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected tree (-want +got):\n%s", diff)
+	}
+}
+
+func TestCategoryRepository(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	db := newTestDB(t)
+	repo := NewCategoryRepository(db)
+	items := &itemRepository{fileName: t.TempDir() + "/items.json", db: db}
+
+	// free-text categories differing in case, width and spacing are one category
+	for _, category := range []string{"Fashion", "fashion ", "ＦＡＳＨＩＯＮ"} {
+		if err := items.Insert(ctx, &Item{Name: "shirt", Category: category, Image: "a.jpg"}); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+	categories, err := repo.List(ctx)
+	if err != nil {
+		t.Fatalf("failed to list categories: %v", err)
+	}
+	if len(categories) != 1 || categories[0].Name != "Fashion" {
+		t.Fatalf("expected a single category, got %+v", categories)
+	}
+	fashion := categories[0]
+
+	tops, err := repo.Create(ctx, "Tops", &fashion.ID)
+	if err != nil {
+		t.Fatalf("failed to create category: %v", err)
+	}
+	if _, err := repo.Create(ctx, "tops", &fashion.ID); !errors.Is(err, errCategoryExists) {
+		t.Errorf("expected errCategoryExists for a sibling with the same name, got %v", err)
+	}
+	if _, err := repo.Create(ctx, "Tops", nil); err != nil {
+		t.Errorf("expected the same name to be allowed under another parent, got %v", err)
+	}
+	missing := 999
+	if _, err := repo.Create(ctx, "Shoes", &missing); !errors.Is(err, errInvalidCategory) {
+		t.Errorf("expected errInvalidCategory for a missing parent, got %v", err)
+	}
+
+	// items in subcategories are listed under their ancestors
+	if err := items.Insert(ctx, &Item{Name: "t-shirt", CategoryID: tops.ID, Image: "b.jpg"}); err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+	if err := items.Insert(ctx, &Item{Name: "boots", CategoryID: missing, Image: "c.jpg"}); !errors.Is(err, errCategoryNotFound) {
+		t.Errorf("expected errCategoryNotFound for a missing category_id, got %v", err)
+	}
+	if got, err := items.FindItemsByCategory(ctx, fashion.ID); err != nil || len(got) != 4 {
+		t.Errorf("expected 4 items under Fashion, got %d, %v", len(got), err)
+	}
+	if got, err := items.FindItemsByCategory(ctx, tops.ID); err != nil || len(got) != 1 || got[0].Category != "Tops" {
+		t.Errorf("expected the t-shirt under Tops, got %+v, %v", got, err)
+	}
+
+	if _, err := repo.Move(ctx, fashion.ID, &tops.ID); !errors.Is(err, errInvalidCategory) {
+		t.Errorf("expected errInvalidCategory for moving a category under its subcategory, got %v", err)
+	}
+	renamed, err := repo.Rename(ctx, tops.ID, " Tops & Shirts ")
+	if err != nil || renamed.Name != "Tops & Shirts" {
+		t.Errorf("expected the category to be renamed, got %+v, %v", renamed, err)
+	}
+	if _, err := repo.Rename(ctx, missing, "Shoes"); !errors.Is(err, errCategoryNotFound) {
+		t.Errorf("expected errCategoryNotFound, got %v", err)
+	}
+}
+
+func TestCategoryRepositoryMerge(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	db := newTestDB(t)
+	repo := NewCategoryRepository(db)
+	items := &itemRepository{fileName: t.TempDir() + "/items.json", db: db}
+
+	create := func(name string, parentID *int) Category {
+		t.Helper()
+		c, err := repo.Create(ctx, name, parentID)
+		if err != nil {
+			t.Fatalf("failed to create category %s: %v", name, err)
+		}
+		return c
+	}
+	fashion := create("Fashion", nil)
+	fashionTops := create("Tops", &fashion.ID)
+	japanese := create("ファッション", nil)
+	japaneseTops := create("tops", &japanese.ID)
+	bags := create("Bags", &japanese.ID)
+
+	for _, item := range []Item{
+		{Name: "dress", CategoryID: japanese.ID, Image: "a.jpg"},
+		{Name: "shirt", CategoryID: japaneseTops.ID, Image: "b.jpg"},
+	} {
+		if err := items.Insert(ctx, &item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+
+	if err := repo.Merge(ctx, fashion.ID, fashionTops.ID); !errors.Is(err, errInvalidCategory) {
+		t.Errorf("expected errInvalidCategory for merging into a subcategory, got %v", err)
+	}
+	if err := repo.Merge(ctx, japanese.ID, fashion.ID); err != nil {
+		t.Fatalf("failed to merge: %v", err)
+	}
+
+	categories, err := repo.List(ctx)
+	if err != nil {
+		t.Fatalf("failed to list categories: %v", err)
+	}
+	want := []CategoryTree{
+		{Category: fashion, Children: []CategoryTree{
+			{Category: Category{ID: bags.ID, Name: "Bags", ParentID: &fashion.ID}, Children: []CategoryTree{}},
+			{Category: fashionTops, Children: []CategoryTree{}},
+		}},
+	}
+	if diff := cmp.Diff(want, buildCategoryTree(categories)); diff != "" {
+		t.Errorf("unexpected categories after merge (-want +got):\n%s", diff)
+	}
+	got, err := items.FindItemsByCategory(ctx, fashionTops.ID)
+	if err != nil || len(got) != 1 || got[0].Name != "shirt" {
+		t.Errorf("expected the shirt to be merged into Fashion > Tops, got %+v, %v", got, err)
+	}
+	if _, err := repo.Get(ctx, japaneseTops.ID); !errors.Is(err, errCategoryNotFound) {
+		t.Errorf("expected the merged subcategory to be deleted, got %v", err)
+	}
+}
+
+func TestCategoryHandlers(t *testing.T) {
+	t.Parallel()
+
+	fashion := Category{ID: 1, Name: "Fashion"}
+	cases := map[string]struct {
+		method, target, body string
+		handler              func(h *Handlers) http.HandlerFunc
+		injector             func(c *MockCategoryRepository, i *MockItemRepository)
+		wantCode             int
+		wantBody             string
+	}{
+		"ok: tree": {
+			method: "GET", target: "/categories",
+			handler: func(h *Handlers) http.HandlerFunc { return h.GetCategories },
+			injector: func(c *MockCategoryRepository, i *MockItemRepository) {
+				c.EXPECT().List(gomock.Any()).Return([]Category{fashion, {ID: 2, Name: "Tops", ParentID: &fashion.ID}}, nil)
+			},
+			wantCode: http.StatusOK,
+			wantBody: `{"categories":[{"id":1,"name":"Fashion","parent_id":null,"children":[{"id":2,"name":"Tops","parent_id":1,"children":[]}]}]}`,
+		},
+		"ok: items of a category": {
+			method: "GET", target: "/categories/1/items",
+			handler: func(h *Handlers) http.HandlerFunc { return h.GetCategoryItems },
+			injector: func(c *MockCategoryRepository, i *MockItemRepository) {
+				c.EXPECT().Get(gomock.Any(), 1).Return(fashion, nil)
+				i.EXPECT().FindItemsByCategory(gomock.Any(), 1).Return(nil, nil)
+			},
+			wantCode: http.StatusOK,
+			wantBody: `{"category":{"id":1,"name":"Fashion","parent_id":null},"items":[]}`,
+		},
+		"ng: items of a missing category": {
+			method: "GET", target: "/categories/9/items",
+			handler: func(h *Handlers) http.HandlerFunc { return h.GetCategoryItems },
+			injector: func(c *MockCategoryRepository, i *MockItemRepository) {
+				c.EXPECT().Get(gomock.Any(), 9).Return(Category{}, errCategoryNotFound)
+			},
+			wantCode: http.StatusNotFound,
+		},
+		"ok: create": {
+			method: "POST", target: "/categories", body: `{"name":"Fashion"}`,
+			handler: func(h *Handlers) http.HandlerFunc { return h.CreateCategory },
+			injector: func(c *MockCategoryRepository, i *MockItemRepository) {
+				c.EXPECT().Create(gomock.Any(), "Fashion", nil).Return(fashion, nil)
+			},
+			wantCode: http.StatusCreated,
+			wantBody: `{"id":1,"name":"Fashion","parent_id":null}`,
+		},
+		"ng: create a duplicate": {
+			method: "POST", target: "/categories", body: `{"name":"fashion"}`,
+			handler: func(h *Handlers) http.HandlerFunc { return h.CreateCategory },
+			injector: func(c *MockCategoryRepository, i *MockItemRepository) {
+				c.EXPECT().Create(gomock.Any(), "fashion", nil).Return(Category{}, errCategoryExists)
+			},
+			wantCode: http.StatusConflict,
+		},
+		"ng: move under a subcategory": {
+			method: "POST", target: "/categories/1/move", body: `{"parent_id":2}`,
+			handler: func(h *Handlers) http.HandlerFunc { return h.MoveCategory },
+			injector: func(c *MockCategoryRepository, i *MockItemRepository) {
+				c.EXPECT().Move(gomock.Any(), 1, gomock.Any()).Return(Category{}, errInvalidCategory)
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		"ok: merge": {
+			method: "POST", target: "/categories/3/merge", body: `{"target_id":1}`,
+			handler: func(h *Handlers) http.HandlerFunc { return h.MergeCategory },
+			injector: func(c *MockCategoryRepository, i *MockItemRepository) {
+				c.EXPECT().Merge(gomock.Any(), 3, 1).Return(nil)
+				c.EXPECT().Get(gomock.Any(), 1).Return(fashion, nil)
+			},
+			wantCode: http.StatusOK,
+			wantBody: `{"id":1,"name":"Fashion","parent_id":null}`,
+		},
+		"ng: merge without target": {
+			method: "POST", target: "/categories/3/merge", body: `{}`,
+			handler:  func(h *Handlers) http.HandlerFunc { return h.MergeCategory },
+			injector: func(c *MockCategoryRepository, i *MockItemRepository) {},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `{"message":"invalid request","errors":[{"field":"target_id","message":"is required"}]}`,
+		},
+		"ng: blank name": {
+			method: "POST", target: "/categories", body: `{"name":"  ","parent_id":0}`,
+			handler:  func(h *Handlers) http.HandlerFunc { return h.CreateCategory },
+			injector: func(c *MockCategoryRepository, i *MockItemRepository) {},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `{"message":"invalid request","errors":[{"field":"name","message":"is required"},{"field":"parent_id","message":"must be at least 1"}]}`,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockCR := NewMockCategoryRepository(ctrl)
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockCR, mockIR)
+			h := &Handlers{categoryRepo: mockCR, itemRepo: mockIR}
+
+			mux := http.NewServeMux()
+			pattern := tt.method + " " + tt.target
+			if strings.Count(tt.target, "/") > 1 {
+				pattern = tt.method + " /categories/{category_id}/" + tt.target[strings.LastIndex(tt.target, "/")+1:]
+			}
+			mux.HandleFunc(pattern, tt.handler(h))
+
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantCode {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wantCode, rr.Code, rr.Body.String())
+			}
+			if tt.wantBody == "" {
+				return
+			}
+			var got, want any
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatalf("failed to decode response body: %v", err)
+			}
+			json.Unmarshal([]byte(tt.wantBody), &want)
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("unexpected response body (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
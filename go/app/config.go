@@ -0,0 +1,584 @@
+package app
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces secrets when the configuration is printed.
+const redacted = "REDACTED"
+
+// Config is the configuration of the server and the commands.
+// Values are taken from, in increasing order of precedence: defaults, the YAML config file, environment variables and flags.
+type Config struct {
+	// Port is the port number to listen on.
+	Port string `yaml:"port"`
+	// ImageDirPath is the path to the directory storing images and the default image.
+	ImageDirPath string `yaml:"image_dir"`
+	// DatabasePath is the path to the SQLite database.
+	DatabasePath string             `yaml:"database_path"`
+	Log          LogConfig          `yaml:"log"`
+	CORS         CORSConfig         `yaml:"cors"`
+	Upload       UploadConfig       `yaml:"upload"`
+	ImageStore   ImageStoreConfig   `yaml:"image_store"`
+	Sweep        SweepConfig        `yaml:"sweep"`
+	HTTP         HTTPConfig         `yaml:"http"`
+	TLS          TLSConfig          `yaml:"tls"`
+	Tracing      TracingConfig      `yaml:"tracing"`
+	RateLimit    RateLimitConfig    `yaml:"rate_limit"`
+	Admin        AdminConfig        `yaml:"admin"`
+	Notification NotificationConfig `yaml:"notification"`
+}
+
+type LogConfig struct {
+	// Level is one of debug, info, warn and error.
+	Level string `yaml:"level"`
+	// Format is json or text.
+	Format string `yaml:"format"`
+}
+
+type CORSConfig struct {
+	// AllowedOrigins are the origins of the frontends allowed to call the API.
+	// An origin may start its host with "*." to allow every subdomain, and "*" allows any origin.
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	// AllowedHeaders are the request headers the frontends may send.
+	AllowedHeaders []string `yaml:"allowed_headers"`
+	// ExposedHeaders are the response headers the frontends may read.
+	ExposedHeaders []string `yaml:"exposed_headers"`
+	// AllowCredentials lets the frontends send cookies and authorization headers.
+	AllowCredentials bool `yaml:"allow_credentials"`
+	// MaxAge is how long browsers may cache a preflight response. Zero disables the cache.
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+type UploadConfig struct {
+	// MaxImageSize is the largest image accepted by POST /items, in bytes.
+	MaxImageSize int64 `yaml:"max_image_size"`
+}
+
+type ImageStoreConfig struct {
+	// Type is "local" to store images in ImageDirPath, or "s3" to store them in an S3-compatible bucket.
+	Type string   `yaml:"type"`
+	S3   S3Config `yaml:"s3"`
+}
+
+type SweepConfig struct {
+	// Interval is how often the server deletes unreferenced images.
+	Interval time.Duration `yaml:"interval"`
+	// Grace is how long an unreferenced image is kept after it was written.
+	Grace time.Duration `yaml:"grace"`
+}
+
+type HTTPConfig struct {
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	// ShutdownTimeout is how long in-flight requests are drained after SIGINT or SIGTERM.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// TrustedProxies are the addresses or CIDR prefixes of the reverse proxies
+	// whose X-Forwarded-For and X-Forwarded-Proto headers are believed.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+type TLSConfig struct {
+	// CertFile and KeyFile are the PEM files of the certificate and its private key.
+	// If both are set, the server serves HTTPS with HTTP/2 on Port, and reloads the files when they change.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// RedirectPort is the port of a plain HTTP listener redirecting every request to HTTPS. Empty disables it.
+	RedirectPort string `yaml:"redirect_port"`
+}
+
+// Enabled reports whether the server serves HTTPS.
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" && c.KeyFile != ""
+}
+
+type TracingConfig struct {
+	// Exporter is "none" to disable tracing, "stdout" to write spans to stdout, or "otlp" to send them over OTLP/HTTP.
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the OTLP/HTTP endpoint URL such as http://localhost:4318.
+	// If empty, the standard OTEL_EXPORTER_OTLP_* environment variables are used.
+	Endpoint string `yaml:"endpoint"`
+}
+
+type RateLimitConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Default is the limit of the routes not in Routes.
+	Default RateLimit `yaml:"default"`
+	// Routes are the limits of route patterns such as "POST /items".
+	Routes map[string]RateLimit `yaml:"routes"`
+	// PerAddress is the limit of all the requests from one address, checked before authentication
+	// so that unknown or rotated tokens don't get around it. Routes with limiting disabled are exempt.
+	PerAddress RateLimit `yaml:"per_address"`
+}
+
+type AdminConfig struct {
+	// Token is the bearer token of the admin API, such as category management. Empty disables the admin API.
+	Token string `yaml:"token"`
+}
+
+type NotificationConfig struct {
+	SMTP SMTPConfig `yaml:"smtp"`
+}
+
+type SMTPConfig struct {
+	// Addr is the host:port of the SMTP server to email notifications through. Empty disables emails.
+	Addr string `yaml:"addr"`
+	// From is the sender address of the emails.
+	From string `yaml:"from"`
+	// Username and Password authenticate to the server if Username is set.
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// DefaultConfig returns the configuration used when nothing is specified.
+func DefaultConfig() *Config {
+	return &Config{
+		Port:         "9000",
+		ImageDirPath: "images",
+		DatabasePath: "db/mercari.sqlite3",
+		Log:          LogConfig{Level: "debug", Format: "json"},
+		CORS: CORSConfig{
+			AllowedOrigins: []string{"http://localhost:3000"},
+			AllowedHeaders: []string{"Content-Type", "Authorization", requestIDHeader, "traceparent"},
+			ExposedHeaders: []string{"ETag", "Location", requestIDHeader, "Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy"},
+			MaxAge:         defaultCORSMaxAge,
+		},
+		Upload:     UploadConfig{MaxImageSize: defaultMaxImageSize},
+		ImageStore: ImageStoreConfig{Type: "local"},
+		Sweep:      SweepConfig{Interval: defaultSweepInterval, Grace: defaultSweepGrace},
+		HTTP: HTTPConfig{
+			ReadHeaderTimeout: readHeaderTimeout,
+			ReadTimeout:       readTimeout,
+			WriteTimeout:      writeTimeout,
+			IdleTimeout:       idleTimeout,
+			ShutdownTimeout:   shutdownTimeout,
+		},
+		Tracing: TracingConfig{Exporter: "none"},
+		RateLimit: RateLimitConfig{
+			Enabled: true,
+			Default: RateLimit{Requests: 300, Period: time.Minute, Burst: 100},
+			Routes: map[string]RateLimit{
+				// uploads store images and decode them, so they are much stricter than reads
+				"POST /items": {Requests: 10, Period: time.Minute, Burst: 5},
+				"GET /search": {Requests: 60, Period: time.Minute, Burst: 20},
+				// registrations from one address are rare, so bursts of them are most likely bots
+				"POST /users": {Requests: 10, Period: time.Hour, Burst: 3},
+				// probes and scrapers poll these from a few addresses
+				"GET /healthz": {},
+				"GET /readyz":  {},
+				"GET /metrics": {},
+			},
+			PerAddress: RateLimit{Requests: 600, Period: time.Minute, Burst: 200},
+		},
+	}
+}
+
+// configField binds a configuration value to a flag and an environment variable.
+type configField struct {
+	flag  string
+	env   string
+	usage string
+	set   func(cfg *Config, value string) error
+}
+
+func stringField(flag, env, usage string, target func(cfg *Config) *string) configField {
+	return configField{flag: flag, env: env, usage: usage, set: func(cfg *Config, value string) error {
+		*target(cfg) = value
+		return nil
+	}}
+}
+
+func boolField(flag, env, usage string, target func(cfg *Config) *bool) configField {
+	return configField{flag: flag, env: env, usage: usage, set: func(cfg *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*target(cfg) = b
+		return nil
+	}}
+}
+
+func durationField(flag, env, usage string, target func(cfg *Config) *time.Duration) configField {
+	return configField{flag: flag, env: env, usage: usage, set: func(cfg *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*target(cfg) = d
+		return nil
+	}}
+}
+
+// configFields lists every setting which can be given by a flag or an environment variable.
+var configFields = []configField{
+	stringField("port", "PORT", "port number to listen on", func(c *Config) *string { return &c.Port }),
+	stringField("image-dir", "IMAGE_DIR", "directory storing images", func(c *Config) *string { return &c.ImageDirPath }),
+	stringField("db", "DB_PATH", "path to the SQLite database", func(c *Config) *string { return &c.DatabasePath }),
+	stringField("log-level", "LOG_LEVEL", "log level: debug, info, warn or error", func(c *Config) *string { return &c.Log.Level }),
+	stringField("log-format", "LOG_FORMAT", "log format: json or text", func(c *Config) *string { return &c.Log.Format }),
+	{
+		flag:  "cors-origins",
+		env:   "FRONT_URL",
+		usage: "comma-separated origins allowed by CORS",
+		set: func(c *Config, value string) error {
+			c.CORS.AllowedOrigins = splitList(value)
+			return nil
+		},
+	},
+	boolField("cors-allow-credentials", "CORS_ALLOW_CREDENTIALS", "allow CORS requests with credentials", func(c *Config) *bool { return &c.CORS.AllowCredentials }),
+	durationField("cors-max-age", "CORS_MAX_AGE", "how long browsers may cache CORS preflight responses", func(c *Config) *time.Duration { return &c.CORS.MaxAge }),
+	{
+		flag:  "max-image-size",
+		env:   "MAX_IMAGE_SIZE",
+		usage: "largest image accepted by POST /items, in bytes",
+		set: func(c *Config, value string) error {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return err
+			}
+			c.Upload.MaxImageSize = n
+			return nil
+		},
+	},
+	stringField("image-store", "IMAGE_STORE", "image store: local or s3", func(c *Config) *string { return &c.ImageStore.Type }),
+	stringField("s3-endpoint", "S3_ENDPOINT", "base URL of the S3-compatible service", func(c *Config) *string { return &c.ImageStore.S3.Endpoint }),
+	stringField("s3-region", "S3_REGION", "region of the S3 bucket", func(c *Config) *string { return &c.ImageStore.S3.Region }),
+	stringField("s3-bucket", "S3_BUCKET", "name of the S3 bucket", func(c *Config) *string { return &c.ImageStore.S3.Bucket }),
+	stringField("s3-access-key-id", "S3_ACCESS_KEY_ID", "access key ID of the S3 bucket", func(c *Config) *string { return &c.ImageStore.S3.AccessKeyID }),
+	// the secret has no flag since command lines are visible to other users of the host
+	stringField("", "S3_SECRET_ACCESS_KEY", "", func(c *Config) *string { return &c.ImageStore.S3.SecretAccessKey }),
+	durationField("sweep-interval", "SWEEP_INTERVAL", "how often unreferenced images are deleted", func(c *Config) *time.Duration { return &c.Sweep.Interval }),
+	durationField("sweep-grace", "SWEEP_GRACE", "how long unreferenced images are kept", func(c *Config) *time.Duration { return &c.Sweep.Grace }),
+	durationField("shutdown-timeout", "SHUTDOWN_TIMEOUT", "how long in-flight requests are drained on shutdown", func(c *Config) *time.Duration { return &c.HTTP.ShutdownTimeout }),
+	stringField("tls-cert", "TLS_CERT_FILE", "PEM certificate file to serve HTTPS with", func(c *Config) *string { return &c.TLS.CertFile }),
+	stringField("tls-key", "TLS_KEY_FILE", "PEM private key file of the certificate", func(c *Config) *string { return &c.TLS.KeyFile }),
+	stringField("tls-redirect-port", "TLS_REDIRECT_PORT", "port of a plain HTTP listener redirecting to HTTPS", func(c *Config) *string { return &c.TLS.RedirectPort }),
+	boolField("rate-limit", "RATE_LIMIT", "limit requests per client", func(c *Config) *bool { return &c.RateLimit.Enabled }),
+	{
+		flag:  "trusted-proxies",
+		env:   "TRUSTED_PROXIES",
+		usage: "comma-separated addresses or CIDR prefixes of proxies whose X-Forwarded-* headers are believed",
+		set: func(c *Config, value string) error {
+			c.HTTP.TrustedProxies = splitList(value)
+			return nil
+		},
+	},
+	// the admin token has no flag for the same reason as the S3 secret
+	stringField("", "ADMIN_TOKEN", "", func(c *Config) *string { return &c.Admin.Token }),
+	stringField("smtp-addr", "SMTP_ADDR", "host:port of the SMTP server to email notifications through", func(c *Config) *string { return &c.Notification.SMTP.Addr }),
+	stringField("smtp-from", "SMTP_FROM", "sender address of notification emails", func(c *Config) *string { return &c.Notification.SMTP.From }),
+	stringField("smtp-username", "SMTP_USERNAME", "username of the SMTP server", func(c *Config) *string { return &c.Notification.SMTP.Username }),
+	// the SMTP password has no flag for the same reason as the S3 secret
+	stringField("", "SMTP_PASSWORD", "", func(c *Config) *string { return &c.Notification.SMTP.Password }),
+	stringField("trace-exporter", "TRACE_EXPORTER", "trace exporter: none, stdout or otlp", func(c *Config) *string { return &c.Tracing.Exporter }),
+	stringField("otlp-endpoint", "OTLP_ENDPOINT", "OTLP/HTTP endpoint URL for the otlp trace exporter", func(c *Config) *string { return &c.Tracing.Endpoint }),
+}
+
+// LoadConfig registers the configuration flags on fs, parses args and builds the validated configuration.
+// Callers may register their own flags on fs beforehand.
+// The config file is given by the -config flag or the CONFIG_FILE environment variable.
+func LoadConfig(fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	configFile := fs.String("config", "", "path to the YAML config file (env CONFIG_FILE)")
+	flagValues := make(map[string]*string, len(configFields))
+	for _, f := range configFields {
+		if f.flag != "" {
+			flagValues[f.flag] = fs.String(f.flag, "", fmt.Sprintf("%s (env %s)", f.usage, f.env))
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := DefaultConfig()
+
+	// config file
+	path := *configFile
+	if path == "" {
+		path, _ = lookupEnv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	// environment variables
+	for _, f := range configFields {
+		value, found := lookupEnv(f.env)
+		if !found {
+			continue
+		}
+		if err := f.set(cfg, value); err != nil {
+			return nil, fmt.Errorf("invalid environment variable %s: %w", f.env, err)
+		}
+	}
+
+	// flags given explicitly
+	var errs []error
+	fs.Visit(func(fl *flag.Flag) {
+		value, ok := flagValues[fl.Name]
+		if !ok {
+			return
+		}
+		for _, f := range configFields {
+			if f.flag == fl.Name {
+				if err := f.set(cfg, *value); err != nil {
+					errs = append(errs, fmt.Errorf("invalid flag -%s: %w", f.flag, err))
+				}
+			}
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile overrides the configuration with the values in a YAML file.
+func (c *Config) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	// reject misspelled keys instead of silently ignoring them
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks the configuration and reports every problem at once.
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		invalid("port: must be a number between 1 and 65535, got %q", c.Port)
+	}
+
+	if info, err := os.Stat(c.ImageDirPath); err != nil || !info.IsDir() {
+		invalid("image_dir: %q is not a directory", c.ImageDirPath)
+	}
+
+	if c.DatabasePath == "" {
+		invalid("database_path: is required")
+	} else if info, err := os.Stat(filepath.Dir(c.DatabasePath)); err != nil || !info.IsDir() {
+		invalid("database_path: directory of %q does not exist", c.DatabasePath)
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		invalid("tls: cert_file and key_file must be set together")
+	}
+	for _, f := range []struct{ name, path string }{{"tls.cert_file", c.TLS.CertFile}, {"tls.key_file", c.TLS.KeyFile}} {
+		if f.path == "" {
+			continue
+		}
+		if info, err := os.Stat(f.path); err != nil || info.IsDir() {
+			invalid("%s: %q is not a file", f.name, f.path)
+		}
+	}
+	if c.TLS.RedirectPort != "" {
+		if port, err := strconv.Atoi(c.TLS.RedirectPort); err != nil || port < 1 || port > 65535 {
+			invalid("tls.redirect_port: must be a number between 1 and 65535, got %q", c.TLS.RedirectPort)
+		} else if !c.TLS.Enabled() {
+			invalid("tls.redirect_port: requires cert_file and key_file")
+		} else if c.TLS.RedirectPort == c.Port {
+			invalid("tls.redirect_port: must differ from port %s", c.Port)
+		}
+	}
+
+	if _, err := parseLogLevel(c.Log.Level); err != nil {
+		invalid("log.level: %v", err)
+	}
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		invalid("log.format: must be json or text, got %q", c.Log.Format)
+	}
+
+	if len(c.CORS.AllowedOrigins) == 0 {
+		invalid("cors.allowed_origins: at least one origin is required")
+	}
+	for _, origin := range c.CORS.AllowedOrigins {
+		if origin == "*" {
+			if c.CORS.AllowCredentials {
+				invalid("cors.allowed_origins: \"*\" can't be used with allow_credentials")
+			}
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" || strings.Contains(strings.TrimPrefix(u.Host, "*."), "*") {
+			invalid("cors.allowed_origins: %q must be a scheme and host such as http://localhost:3000 or https://*.example.com", origin)
+		}
+	}
+	if c.CORS.MaxAge < 0 {
+		invalid("cors.max_age: must not be negative, got %s", c.CORS.MaxAge)
+	}
+
+	if c.Upload.MaxImageSize <= 0 {
+		invalid("upload.max_image_size: must be positive, got %d", c.Upload.MaxImageSize)
+	}
+
+	switch c.ImageStore.Type {
+	case "local":
+	case "s3":
+		s3 := c.ImageStore.S3
+		if s3.Endpoint == "" {
+			invalid("image_store.s3.endpoint: is required for the s3 image store")
+		}
+		if s3.Bucket == "" {
+			invalid("image_store.s3.bucket: is required for the s3 image store")
+		}
+		if s3.AccessKeyID == "" || s3.SecretAccessKey == "" {
+			invalid("image_store.s3: access_key_id and secret_access_key (env S3_SECRET_ACCESS_KEY) are required for the s3 image store")
+		}
+	default:
+		invalid("image_store.type: must be local or s3, got %q", c.ImageStore.Type)
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		if c.Tracing.Endpoint != "" {
+			if u, err := url.Parse(c.Tracing.Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+				invalid("tracing.endpoint: %q must be a URL such as http://localhost:4318", c.Tracing.Endpoint)
+			}
+		}
+	default:
+		invalid("tracing.exporter: must be none, stdout or otlp, got %q", c.Tracing.Exporter)
+	}
+
+	if smtpCfg := c.Notification.SMTP; smtpCfg.Addr != "" {
+		if host, port, err := net.SplitHostPort(smtpCfg.Addr); err != nil || host == "" || port == "" {
+			invalid("notification.smtp.addr: %q must be a host and port such as smtp.example.com:587", smtpCfg.Addr)
+		}
+		if addr, err := mail.ParseAddress(smtpCfg.From); err != nil || addr.Address != smtpCfg.From {
+			invalid("notification.smtp.from: %q must be an email address", smtpCfg.From)
+		}
+	}
+
+	if _, err := parseTrustedProxies(c.HTTP.TrustedProxies); err != nil {
+		invalid("http.trusted_proxies: %v", err)
+	}
+	limits := map[string]RateLimit{"default": c.RateLimit.Default, "per_address": c.RateLimit.PerAddress}
+	for pattern, limit := range c.RateLimit.Routes {
+		if method, path, ok := strings.Cut(pattern, " "); !ok || method == "" || !strings.HasPrefix(path, "/") {
+			invalid("rate_limit.routes: %q must be a route pattern such as \"POST /items\"", pattern)
+		}
+		limits["routes."+pattern] = limit
+	}
+	for name, limit := range limits {
+		if limit.Requests > 0 && limit.Period <= 0 {
+			invalid("rate_limit.%s: period must be positive, got %s", name, limit.Period)
+		}
+		if limit.Burst < 0 {
+			invalid("rate_limit.%s: burst must not be negative, got %d", name, limit.Burst)
+		}
+	}
+
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"sweep.interval", c.Sweep.Interval},
+		{"sweep.grace", c.Sweep.Grace},
+		{"http.read_header_timeout", c.HTTP.ReadHeaderTimeout},
+		{"http.read_timeout", c.HTTP.ReadTimeout},
+		{"http.write_timeout", c.HTTP.WriteTimeout},
+		{"http.idle_timeout", c.HTTP.IdleTimeout},
+		{"http.shutdown_timeout", c.HTTP.ShutdownTimeout},
+	}
+	for _, d := range durations {
+		if d.value <= 0 {
+			invalid("%s: must be positive, got %s", d.name, d.value)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Redacted returns a copy of the configuration with secrets replaced, for printing.
+func (c *Config) Redacted() *Config {
+	redactedCfg := *c
+	redactedCfg.CORS.AllowedOrigins = append([]string(nil), c.CORS.AllowedOrigins...)
+	redactedCfg.CORS.AllowedHeaders = append([]string(nil), c.CORS.AllowedHeaders...)
+	redactedCfg.CORS.ExposedHeaders = append([]string(nil), c.CORS.ExposedHeaders...)
+	redactedCfg.HTTP.TrustedProxies = append([]string(nil), c.HTTP.TrustedProxies...)
+	redactedCfg.RateLimit.Routes = maps.Clone(c.RateLimit.Routes)
+	if redactedCfg.ImageStore.S3.SecretAccessKey != "" {
+		redactedCfg.ImageStore.S3.SecretAccessKey = redacted
+	}
+	if redactedCfg.Admin.Token != "" {
+		redactedCfg.Admin.Token = redacted
+	}
+	if redactedCfg.Notification.SMTP.Password != "" {
+		redactedCfg.Notification.SMTP.Password = redacted
+	}
+	return &redactedCfg
+}
+
+// Print writes the configuration as YAML with secrets redacted.
+func (c *Config) Print(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c.Redacted()); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// parseLogLevel converts a level name in the configuration to a slog.Level.
+func parseLogLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q", level)
+	}
+}
+
+// splitList splits a comma-separated list, dropping empty elements.
+func splitList(value string) []string {
+	var list []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,198 @@
+package app
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// writeConfigFile writes a YAML config file and returns its path.
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	return path
+}
+
+// loadTestConfig loads a configuration with the given args and environment, rooted at a temporary directory.
+func loadTestConfig(t *testing.T, args []string, env map[string]string) (*Config, error) {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "images"), 0o755); err != nil {
+		t.Fatalf("failed to create image dir: %v", err)
+	}
+	base := []string{"-image-dir", filepath.Join(dir, "images"), "-db", filepath.Join(dir, "mercari.sqlite3")}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	lookupEnv := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+	return LoadConfig(fs, append(base, args...), lookupEnv)
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	t.Parallel()
+
+	file := writeConfigFile(t, `
+port: "8000"
+log:
+  level: info
+cors:
+  allowed_origins: [https://a.example.com]
+sweep:
+  grace: 48h
+`)
+
+	type wants struct {
+		port    string
+		level   string
+		origins []string
+		grace   time.Duration
+	}
+	cases := map[string]struct {
+		args []string
+		env  map[string]string
+		wants
+	}{
+		"ok: defaults": {
+			wants: wants{port: "9000", level: "debug", origins: []string{"http://localhost:3000"}, grace: defaultSweepGrace},
+		},
+		"ok: file overrides defaults": {
+			args:  []string{"-config", file},
+			wants: wants{port: "8000", level: "info", origins: []string{"https://a.example.com"}, grace: 48 * time.Hour},
+		},
+		"ok: env overrides file": {
+			env:   map[string]string{"CONFIG_FILE": file, "PORT": "7000", "FRONT_URL": "https://b.example.com, https://c.example.com"},
+			wants: wants{port: "7000", level: "info", origins: []string{"https://b.example.com", "https://c.example.com"}, grace: 48 * time.Hour},
+		},
+		"ok: flags override env": {
+			args:  []string{"-config", file, "-port", "6000", "-sweep-grace", "1h"},
+			env:   map[string]string{"PORT": "7000", "LOG_LEVEL": "warn"},
+			wants: wants{port: "6000", level: "warn", origins: []string{"https://a.example.com"}, grace: time.Hour},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg, err := loadTestConfig(t, tt.args, tt.env)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := wants{port: cfg.Port, level: cfg.Log.Level, origins: cfg.CORS.AllowedOrigins, grace: cfg.Sweep.Grace}
+			if diff := cmp.Diff(tt.wants, got, cmp.AllowUnexported(wants{})); diff != "" {
+				t.Errorf("unexpected config (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		args []string
+		env  map[string]string
+		// wantErrs are substrings which must all appear in the error
+		wantErrs []string
+	}{
+		"ng: unknown key in file": {
+			args:     []string{"-config", writeConfigFile(t, "prot: 9000\n")},
+			wantErrs: []string{"field prot not found"},
+		},
+		"ng: missing file": {
+			args:     []string{"-config", "does-not-exist.yaml"},
+			wantErrs: []string{"failed to open config file"},
+		},
+		"ng: invalid env": {
+			env:      map[string]string{"SWEEP_INTERVAL": "often"},
+			wantErrs: []string{"SWEEP_INTERVAL"},
+		},
+		"ng: every invalid value is reported": {
+			args:     []string{"-port", "0", "-log-level", "verbose", "-log-format", "xml", "-cors-origins", "localhost:3000/app", "-max-image-size", "-1", "-trace-exporter", "zipkin"},
+			wantErrs: []string{"port:", "log.level:", "log.format:", "cors.allowed_origins:", "upload.max_image_size:", "tracing.exporter:"},
+		},
+		"ng: any origin with credentials": {
+			args:     []string{"-cors-origins", "*", "-cors-allow-credentials", "true"},
+			wantErrs: []string{"cors.allowed_origins:"},
+		},
+		"ng: wildcard inside host": {
+			args:     []string{"-cors-origins", "https://shop.*.example.com"},
+			wantErrs: []string{"cors.allowed_origins:"},
+		},
+		"ng: invalid trusted proxy": {
+			env:      map[string]string{"TRUSTED_PROXIES": "10.0.0.0/8, proxy.local"},
+			wantErrs: []string{"http.trusted_proxies:"},
+		},
+		"ng: invalid rate limit": {
+			args:     []string{"-config", writeConfigFile(t, "rate_limit:\n  routes:\n    /items: {requests: 1, period: 1m}\n    GET /search: {requests: 1}\n")},
+			wantErrs: []string{"rate_limit.routes: \"/items\"", "rate_limit.routes.GET /search: period"},
+		},
+		"ng: tls key without certificate": {
+			args:     []string{"-tls-key", "does-not-exist.pem", "-tls-redirect-port", "8080"},
+			wantErrs: []string{"tls: cert_file and key_file", "tls.key_file:", "tls.redirect_port: requires"},
+		},
+		"ng: smtp without sender": {
+			args:     []string{"-smtp-addr", "smtp.example.com", "-smtp-from", "Shop <noreply@example.com>"},
+			wantErrs: []string{"notification.smtp.addr:", "notification.smtp.from:"},
+		},
+		"ng: s3 without credentials": {
+			args:     []string{"-image-store", "s3", "-s3-endpoint", "http://localhost:9000", "-s3-bucket", "images"},
+			wantErrs: []string{"secret_access_key"},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := loadTestConfig(t, tt.args, tt.env)
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("expected error to contain %q, got: %v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigPrintRedactsSecrets(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := loadTestConfig(t, []string{"-image-store", "s3", "-s3-endpoint", "http://localhost:9000", "-s3-bucket", "images", "-s3-access-key-id", "AKID"},
+		map[string]string{"S3_SECRET_ACCESS_KEY": "top-secret", "ADMIN_TOKEN": "admin-secret", "SMTP_PASSWORD": "smtp-secret"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := cfg.Print(&buf); err != nil {
+		t.Fatalf("failed to print config: %v", err)
+	}
+	if strings.Contains(buf.String(), "top-secret") || strings.Contains(buf.String(), "admin-secret") || strings.Contains(buf.String(), "smtp-secret") {
+		t.Errorf("printed config contains the secret:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "secret_access_key: "+redacted) {
+		t.Errorf("printed config does not show the redacted secret:\n%s", buf.String())
+	}
+	if cfg.ImageStore.S3.SecretAccessKey != "top-secret" {
+		t.Error("printing the config modified the secret")
+	}
+}
@@ -0,0 +1,408 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// eventHistorySize is how many recent events are kept for clients resuming with Last-Event-ID.
+	eventHistorySize = 1024
+	// eventBufferSize is how many events a subscriber may fall behind before it is disconnected.
+	eventBufferSize = 64
+	// eventHeartbeatInterval is how often an idle stream gets a comment, so that proxies don't close it
+	// and clients notice dead connections.
+	eventHeartbeatInterval = 15 * time.Second
+	// eventRetry is how long clients wait before reconnecting, in milliseconds.
+	eventRetry = 3000
+)
+
+// types of events.
+const (
+	// EventItemCreated is published when an item is listed. The data is the item.
+	EventItemCreated = "item.created"
+	// EventItemSold is published when an item is purchased. The data is an ItemStatusEvent.
+	EventItemSold = "item.sold"
+	// EventItemUpdated is published when an item changes after it is listed, such as when its transaction completes.
+	// The data is an ItemStatusEvent.
+	EventItemUpdated = "item.updated"
+	// EventTransactionCreated is published to the parties of a transaction when an item is purchased.
+	// The data is the transaction.
+	EventTransactionCreated = "transaction.created"
+	// EventTransactionCompleted is published to the parties of a transaction when the buyer completes it.
+	// The data is the transaction.
+	EventTransactionCompleted = "transaction.completed"
+	// EventMessageCreated is published to the parties of a transaction when one of them sends a message.
+	// The data is the message.
+	EventMessageCreated = "message.created"
+	// EventReset tells a resuming client that the events it missed are no longer available,
+	// so it has to fetch the current state again. Its ID is of the latest event, to resume from afterwards.
+	EventReset = "reset"
+)
+
+// statuses of an item in ItemStatusEvent.
+const (
+	ItemSold      = "sold"
+	ItemCompleted = "completed"
+)
+
+// ItemStatusEvent is the data of EventItemSold and EventItemUpdated.
+type ItemStatusEvent struct {
+	ID     int    `json:"id"`
+	Status string `json:"status"`
+}
+
+// Event is an event published on an EventBus.
+type Event struct {
+	// ID is "<epoch>-<sequence>", where epoch identifies the EventBus, so that IDs from before a restart
+	// are not mistaken for current ones.
+	ID   string
+	Type string
+	Data json.RawMessage
+	// seq is the sequence number of the event in the bus.
+	seq uint64
+	// audience are the users the event is for, or nil if it is for everyone.
+	audience []int
+}
+
+// visibleTo reports whether the event is for the user, who is 0 when anonymous.
+func (e Event) visibleTo(userID int) bool {
+	return e.audience == nil || (userID != 0 && slices.Contains(e.audience, userID))
+}
+
+// EventBus delivers events published by the repositories to the subscribed clients in this process.
+// Publishing never blocks: a subscriber falling more than eventBufferSize events behind is disconnected,
+// and catches up from the history when it reconnects with Last-Event-ID.
+type EventBus struct {
+	epoch string
+
+	mu          sync.Mutex
+	seq         uint64
+	history     []Event
+	subscribers map[*eventSubscription]struct{}
+	closed      bool
+
+	// historySize, bufferSize and heartbeat are eventHistorySize, eventBufferSize and eventHeartbeatInterval
+	// except in tests.
+	historySize int
+	bufferSize  int
+	heartbeat   time.Duration
+}
+
+// NewEventBus creates an EventBus with no events.
+func NewEventBus() *EventBus {
+	return &EventBus{
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		subscribers: make(map[*eventSubscription]struct{}),
+		historySize: eventHistorySize,
+		bufferSize:  eventBufferSize,
+		heartbeat:   eventHeartbeatInterval,
+	}
+}
+
+// eventSubscription is a client receiving events.
+type eventSubscription struct {
+	userID int
+	// all is set for consumers in the server such as Notifier, which receive the events of every user.
+	all bool
+	// events is closed when the subscriber falls behind or the bus is closed.
+	events chan Event
+}
+
+// Publish sends an event with data encoded in JSON to the audience, or to everyone if no audience is given.
+func (b *EventBus) Publish(eventType string, data any, audience ...int) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		// the data are our own types, so this is a bug rather than something to report to the caller
+		slog.Error("failed to encode event: ", "type", eventType, "error", err)
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.seq++
+	e := Event{ID: b.epoch + "-" + strconv.FormatUint(b.seq, 10), Type: eventType, Data: encoded, seq: b.seq}
+	if len(audience) > 0 {
+		e.audience = audience
+	}
+	b.history = append(b.history, e)
+	if len(b.history) > b.historySize {
+		b.history = slices.Delete(b.history, 0, len(b.history)-b.historySize)
+	}
+
+	for sub := range b.subscribers {
+		if !sub.all && !e.visibleTo(sub.userID) {
+			continue
+		}
+		select {
+		case sub.events <- e:
+		default:
+			// the subscriber is too slow; buffering more would let it hold unbounded memory
+			delete(b.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// Subscribe starts delivering the events for the user, who is 0 when anonymous.
+// If lastEventID is not empty, the events after it are returned to be sent first, or an EventReset event
+// if they are no longer in the history. The subscription is nil after the bus is closed.
+func (b *EventBus) Subscribe(userID int, lastEventID string) (*eventSubscription, []Event) {
+	return b.subscribe(&eventSubscription{userID: userID}, lastEventID)
+}
+
+// subscribeAll is Subscribe for a consumer of the events of every user.
+func (b *EventBus) subscribeAll(lastEventID string) (*eventSubscription, []Event) {
+	return b.subscribe(&eventSubscription{all: true}, lastEventID)
+}
+
+func (b *EventBus) subscribe(sub *eventSubscription, lastEventID string) (*eventSubscription, []Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, nil
+	}
+
+	var missed []Event
+	if lastEventID != "" {
+		var ok bool
+		missed, ok = b.since(lastEventID)
+		if !ok {
+			missed = []Event{{ID: b.epoch + "-" + strconv.FormatUint(b.seq, 10), Type: EventReset, Data: json.RawMessage("{}")}}
+		}
+	}
+	var replay []Event
+	for _, e := range missed {
+		if sub.all || e.visibleTo(sub.userID) {
+			replay = append(replay, e)
+		}
+	}
+
+	// subscribing under the same lock as reading the history, so that no event is missed or repeated in between
+	sub.events = make(chan Event, b.bufferSize)
+	b.subscribers[sub] = struct{}{}
+	return sub, replay
+}
+
+// since returns the events in the history after the event with the ID,
+// or false if some of them may have been dropped from the history or the ID is not of this bus.
+// It must be called with b.mu held.
+func (b *EventBus) since(id string) ([]Event, bool) {
+	epoch, seqStr, ok := strings.Cut(id, "-")
+	if !ok || epoch != b.epoch {
+		return nil, false
+	}
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if err != nil || seq > b.seq {
+		return nil, false
+	}
+	if len(b.history) > 0 && seq+1 < b.history[0].seq {
+		return nil, false
+	}
+	i, _ := slices.BinarySearchFunc(b.history, seq+1, func(e Event, seq uint64) int {
+		return cmp.Compare(e.seq, seq)
+	})
+	return slices.Clone(b.history[i:]), true
+}
+
+// Unsubscribe stops delivering events to the subscription.
+func (b *EventBus) Unsubscribe(sub *eventSubscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// Close disconnects every subscriber and drops further events, so that streams don't hold up a shutdown.
+func (b *EventBus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subscribers {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// publishingItemRepository publishes EventItemCreated for every item inserted into the repository.
+type publishingItemRepository struct {
+	ItemRepository
+	bus *EventBus
+}
+
+// publishItemRepository wraps repo to publish its writes on bus.
+func publishItemRepository(repo ItemRepository, bus *EventBus) ItemRepository {
+	return &publishingItemRepository{ItemRepository: repo, bus: bus}
+}
+
+func (p *publishingItemRepository) Insert(ctx context.Context, item *Item) error {
+	if err := p.ItemRepository.Insert(ctx, item); err != nil {
+		return err
+	}
+	p.bus.Publish(EventItemCreated, *item)
+	return nil
+}
+
+// publishingTransactionRepository publishes the transactions to their parties,
+// and the status changes of the items sold by them to everyone.
+type publishingTransactionRepository struct {
+	TransactionRepository
+	bus *EventBus
+}
+
+// publishTransactionRepository wraps repo to publish its writes on bus.
+func publishTransactionRepository(repo TransactionRepository, bus *EventBus) TransactionRepository {
+	return &publishingTransactionRepository{TransactionRepository: repo, bus: bus}
+}
+
+func (p *publishingTransactionRepository) Create(ctx context.Context, itemID, buyerID int) (Transaction, error) {
+	tr, err := p.TransactionRepository.Create(ctx, itemID, buyerID)
+	if err != nil {
+		return Transaction{}, err
+	}
+	p.bus.Publish(EventItemSold, ItemStatusEvent{ID: tr.ItemID, Status: ItemSold})
+	p.bus.Publish(EventTransactionCreated, tr, tr.SellerID, tr.BuyerID)
+	return tr, nil
+}
+
+func (p *publishingTransactionRepository) Complete(ctx context.Context, id int) (Transaction, error) {
+	tr, err := p.TransactionRepository.Complete(ctx, id)
+	if err != nil {
+		return Transaction{}, err
+	}
+	p.bus.Publish(EventItemUpdated, ItemStatusEvent{ID: tr.ItemID, Status: ItemCompleted})
+	p.bus.Publish(EventTransactionCompleted, tr, tr.SellerID, tr.BuyerID)
+	return tr, nil
+}
+
+// publishingMessageRepository publishes EventMessageCreated to the parties of the transaction of every message.
+type publishingMessageRepository struct {
+	MessageRepository
+	transactions TransactionRepository
+	bus          *EventBus
+}
+
+// publishMessageRepository wraps repo to publish its writes on bus. transactions tell who the parties are.
+func publishMessageRepository(repo MessageRepository, transactions TransactionRepository, bus *EventBus) MessageRepository {
+	return &publishingMessageRepository{MessageRepository: repo, transactions: transactions, bus: bus}
+}
+
+func (p *publishingMessageRepository) Create(ctx context.Context, transactionID, senderID int, body, image string) (Message, error) {
+	msg, err := p.MessageRepository.Create(ctx, transactionID, senderID, body, image)
+	if err != nil {
+		return Message{}, err
+	}
+	tr, err := p.transactions.Get(ctx, transactionID)
+	if err != nil {
+		// the message is stored, so the recipient still gets it by polling
+		loggerFromContext(ctx).Error("failed to publish message: ", "error", err)
+		return msg, nil
+	}
+	// the sender gets it too, so that their other clients show it
+	p.bus.Publish(EventMessageCreated, msg, tr.SellerID, tr.BuyerID)
+	return msg, nil
+}
+
+type GetEventsRequest struct {
+	// Types are the comma-separated event types to receive. Every type is received if empty.
+	Types string `query:"types" validate:"trim,max=200,singleline"`
+}
+
+// GetEvents is a handler to stream events as Server-Sent Events for GET /events .
+// Anonymous clients receive the public events, and authenticated users the events for them as well.
+// Clients reconnecting with the Last-Event-ID header receive the events they missed first.
+func (s *Handlers) GetEvents(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req GetEventsRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	var types []string
+	if req.Types != "" {
+		types = strings.Split(req.Types, ",")
+	}
+	wanted := func(e Event) bool {
+		return types == nil || e.Type == EventReset || slices.Contains(types, e.Type)
+	}
+
+	userID, _ := currentUserID(ctx)
+	sub, replay := s.events.Subscribe(userID, r.Header.Get("Last-Event-ID"))
+	if sub == nil {
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer s.events.Unsubscribe(sub)
+
+	// the stream outlives the write timeout of the server
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		loggerFromContext(ctx).Error("failed to clear write deadline: ", "error", err)
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// reverse proxies such as nginx would otherwise buffer the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", eventRetry); err != nil {
+		return
+	}
+	for _, e := range replay {
+		if wanted(e) {
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(s.events.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-sub.events:
+			if !ok {
+				// fell behind or shutting down; the client reconnects with Last-Event-ID
+				return
+			}
+			if !wanted(e) {
+				continue
+			}
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeEvent writes the event in the text/event-stream format. Data never has newlines, since it is compact JSON.
+func writeEvent(w http.ResponseWriter, e Event) error {
+	_, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Type, e.Data)
+	return err
+}
@@ -0,0 +1,262 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventBus(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		types []string
+	}
+	cases := map[string]struct {
+		userID int
+		// lastEventID is the index of the published event to resume after, or -1 not to resume.
+		lastEventID int
+		// rawLastID is used as Last-Event-ID instead if set.
+		rawLastID string
+		wants
+	}{
+		"ok: new subscriber gets no history": {
+			lastEventID: -1,
+			wants:       wants{types: nil},
+		},
+		"ok: anonymous resumes the public events": {
+			lastEventID: 1,
+			wants:       wants{types: []string{EventItemSold, EventItemUpdated}},
+		},
+		"ok: party resumes its message too": {
+			userID:      2,
+			lastEventID: 1,
+			wants:       wants{types: []string{EventItemSold, EventMessageCreated, EventItemUpdated}},
+		},
+		"ok: resumes from the latest event": {
+			lastEventID: 4,
+			wants:       wants{types: nil},
+		},
+		"ng: event dropped from the history": {
+			lastEventID: 0,
+			wants:       wants{types: []string{EventReset}},
+		},
+		"ng: event of another process": {
+			lastEventID: -1,
+			rawLastID:   "abc-1",
+			wants:       wants{types: []string{EventReset}},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			bus := NewEventBus()
+			bus.historySize = 3
+			published := []string{EventItemCreated, EventItemCreated, EventItemSold, EventMessageCreated, EventItemUpdated}
+			var ids []string
+			for _, typ := range published {
+				var audience []int
+				if typ == EventMessageCreated {
+					audience = []int{1, 2}
+				}
+				bus.Publish(typ, struct{}{}, audience...)
+				ids = append(ids, bus.history[len(bus.history)-1].ID)
+			}
+			// the first two events are out of the history of 3, so only the clients which got the second can resume
+			lastID := tt.rawLastID
+			if lastID == "" && tt.lastEventID >= 0 {
+				lastID = ids[tt.lastEventID]
+			}
+			sub, replay := bus.Subscribe(tt.userID, lastID)
+			defer bus.Unsubscribe(sub)
+
+			var got []string
+			for _, e := range replay {
+				got = append(got, e.Type)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.wants.types, ",") {
+				t.Errorf("expected replay %v, got %v", tt.wants.types, got)
+			}
+			if len(replay) > 0 && replay[0].Type == EventReset && replay[0].ID != ids[len(ids)-1] {
+				t.Errorf("expected the reset to resume from the latest event %s, got %s", ids[len(ids)-1], replay[0].ID)
+			}
+		})
+	}
+}
+
+func TestEventBusBackpressure(t *testing.T) {
+	t.Parallel()
+
+	bus := NewEventBus()
+	bus.bufferSize = 2
+	slow, _ := bus.Subscribe(0, "")
+	private, _ := bus.Subscribe(0, "")
+
+	// publishing must not block on the subscriber which doesn't read
+	for i := range 3 {
+		bus.Publish(EventItemCreated, i)
+	}
+	bus.Publish(EventMessageCreated, struct{}{}, 1, 2)
+
+	var received int
+	for range slow.events {
+		received++
+	}
+	if received != 2 {
+		t.Errorf("expected the slow subscriber to get its buffer then be closed, got %d events", received)
+	}
+
+	bus.Close()
+	if _, ok := <-private.events; !ok {
+		t.Fatal("expected the buffered events to be kept until read")
+	}
+	bus.Publish(EventItemCreated, 4)
+	if sub, _ := bus.Subscribe(0, ""); sub != nil {
+		t.Error("expected no subscription after closing")
+	}
+}
+
+// sseEvent is an event or a comment read from a text/event-stream.
+type sseEvent struct {
+	id, event, data, comment string
+}
+
+// readSSE reads the next event or comment from the stream.
+func readSSE(t *testing.T, r *bufio.Reader) sseEvent {
+	t.Helper()
+
+	var e sseEvent
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read event stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if e != (sseEvent{}) {
+				return e
+			}
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "":
+			e.comment = value
+		case "id":
+			e.id = value
+		case "event":
+			e.event = value
+		case "data":
+			e.data = value
+		}
+	}
+}
+
+func TestGetEvents(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sale := newTestSale(t)
+	bus := NewEventBus()
+	bus.heartbeat = 50 * time.Millisecond
+	transactions := publishTransactionRepository(NewTransactionRepository(sale.db), bus)
+	h := &Handlers{
+		userRepo:        NewUserRepository(sale.db),
+		transactionRepo: transactions,
+		messageRepo:     publishMessageRepository(NewMessageRepository(sale.db), transactions, bus),
+		events:          bus,
+	}
+	items := publishItemRepository(&itemRepository{fileName: t.TempDir() + "/items.json", db: sale.db}, bus)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /events", h.GetEvents)
+	// the stream goes through the response writers of the middleware, which must pass flushes through
+	srv := httptest.NewServer(accessLogMiddleware(authMiddleware(mux, h.userRepo)))
+	t.Cleanup(srv.Close)
+	t.Cleanup(bus.Close)
+
+	subscribe := func(t *testing.T, query, token, lastEventID string) *bufio.Reader {
+		t.Helper()
+		req, err := http.NewRequest("GET", srv.URL+"/events"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to subscribe: %v", err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("expected an event stream, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		r := bufio.NewReader(resp.Body)
+		if line, _ := r.ReadString('\n'); !strings.HasPrefix(line, "retry: ") {
+			t.Fatalf("expected the retry interval first, got %q", line)
+		}
+		return r
+	}
+
+	anonymous := subscribe(t, "", "", "")
+	seller := subscribe(t, "?types=message.created", sale.sellerTok, "")
+
+	item := Item{Name: "scarf", Category: "Fashion", Image: "b.jpg", SellerID: sale.seller.ID}
+	if err := items.Insert(ctx, &item); err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+	created := readSSE(t, anonymous)
+	var got Item
+	json.Unmarshal([]byte(created.data), &got)
+	if created.event != EventItemCreated || got.ID != item.ID || got.Name != "scarf" {
+		t.Errorf("expected the new item, got %+v", created)
+	}
+
+	tr, err := transactions.Create(ctx, sale.itemID, sale.buyer.ID)
+	if err != nil {
+		t.Fatalf("failed to create transaction: %v", err)
+	}
+	if e := readSSE(t, anonymous); e.event != EventItemSold || e.data != fmt.Sprintf(`{"id":%d,"status":"sold"}`, sale.itemID) {
+		t.Errorf("expected the item to be sold, got %+v", e)
+	}
+
+	if _, err := h.messageRepo.Create(ctx, tr.ID, sale.buyer.ID, "hello", ""); err != nil {
+		t.Fatalf("failed to create message: %v", err)
+	}
+	// the seller subscribed only to messages, so the item events before are skipped
+	message := readSSE(t, seller)
+	if message.event != EventMessageCreated || !strings.Contains(message.data, `"body":"hello"`) {
+		t.Errorf("expected the message, got %+v", message)
+	}
+
+	// anonymous clients only get heartbeats until the next public event
+	if e := readSSE(t, anonymous); e.comment != "heartbeat" {
+		t.Errorf("expected a heartbeat instead of the private message, got %+v", e)
+	}
+
+	// a reconnecting client gets what it missed after the item was created
+	if _, err := transactions.Complete(ctx, tr.ID); err != nil {
+		t.Fatalf("failed to complete transaction: %v", err)
+	}
+	resumed := subscribe(t, "", sale.buyerTok, created.id)
+	for _, want := range []string{EventItemSold, EventTransactionCreated, EventMessageCreated, EventItemUpdated, EventTransactionCompleted} {
+		if e := readSSE(t, resumed); e.event != want {
+			t.Errorf("expected %s on resume, got %+v", want, e)
+		}
+	}
+	if e := readSSE(t, subscribe(t, "", "", "stale-1")); e.event != EventReset {
+		t.Errorf("expected a reset for an unknown event ID, got %+v", e)
+	}
+}
@@ -0,0 +1,181 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"time"
+)
+
+// schemaVersion is the version of db/items.sql this server works with.
+// db/items.sql stores it with PRAGMA user_version, and it must be bumped whenever the schema changes.
+const schemaVersion = 8
+
+// readinessCheckTimeout bounds each check of GET /readyz, so that a stuck dependency fails the probe instead of hanging it.
+const readinessCheckTimeout = 2 * time.Second
+
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+// Healthz is a handler for the liveness probe GET /healthz .
+// It only tells that the process is serving requests, so it never checks dependencies.
+func (s *Handlers) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	err := json.NewEncoder(w).Encode(HealthResponse{Status: "ok"})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+type CheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type ReadyResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Readyz is a handler for the readiness probe GET /readyz .
+// It returns 503 unless the database is reachable with the expected schema and the image directory is writable.
+func (s *Handlers) Readyz(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFromContext(r.Context())
+	checks := []struct {
+		name  string
+		check func(ctx context.Context) error
+	}{
+		{"database", s.checkDatabase},
+		{"schema", s.checkSchemaVersion},
+		{"image_dir", s.checkImageDir},
+	}
+
+	resp := ReadyResponse{Status: "ok", Checks: make(map[string]CheckResult, len(checks))}
+	for _, c := range checks {
+		if err := runCheck(r.Context(), readinessCheckTimeout, c.check); err != nil {
+			logger.Warn("readiness check failed", "check", c.name, "error", err)
+			resp.Status = "unavailable"
+			resp.Checks[c.name] = CheckResult{Status: "error", Error: err.Error()}
+			continue
+		}
+		resp.Checks[c.name] = CheckResult{Status: "ok"}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if resp.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// runCheck runs check with a timeout.
+// check runs in its own goroutine so that calls which don't take a context, such as file system operations, are bounded too.
+func runCheck(ctx context.Context, timeout time.Duration, check func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out: %w", ctx.Err())
+	}
+}
+
+// checkDatabase checks that the database is reachable.
+func (s *Handlers) checkDatabase(ctx context.Context) error {
+	if s.db == nil {
+		return fmt.Errorf("database is not configured")
+	}
+	return s.db.PingContext(ctx)
+}
+
+// checkSchemaVersion checks that the database was set up with the expected version of db/items.sql.
+func (s *Handlers) checkSchemaVersion(ctx context.Context) error {
+	if s.db == nil {
+		return fmt.Errorf("database is not configured")
+	}
+
+	var version int
+	if err := s.db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version != schemaVersion {
+		return fmt.Errorf("schema version is %d, want %d: apply db/items.sql", version, schemaVersion)
+	}
+	return nil
+}
+
+// checkImageDir checks that a file can be created in the image directory.
+func (s *Handlers) checkImageDir(ctx context.Context) error {
+	file, err := os.CreateTemp(s.imgDirPath, tempImagePrefix+"readyz-*")
+	if err != nil {
+		return err
+	}
+	name := file.Name()
+	file.Close()
+	return os.Remove(name)
+}
+
+type VersionResponse struct {
+	// Module is the path of the main module.
+	Module string `json:"module"`
+	// Version is the module version, which is "(devel)" for builds from a working tree.
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	// Revision, Time and Modified describe the VCS commit the binary was built from, if known.
+	Revision string `json:"revision,omitempty"`
+	Time     string `json:"time,omitempty"`
+	Modified bool   `json:"modified"`
+}
+
+// buildVersion collects the version information embedded by the Go toolchain.
+func buildVersion() VersionResponse {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return VersionResponse{Version: "unknown"}
+	}
+
+	resp := VersionResponse{
+		Module:    info.Main.Path,
+		Version:   info.Main.Version,
+		GoVersion: info.GoVersion,
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			resp.Revision = setting.Value
+		case "vcs.time":
+			resp.Time = setting.Value
+		case "vcs.modified":
+			resp.Modified = setting.Value == "true"
+		}
+	}
+	return resp
+}
+
+// Version is a handler to return build information for GET /version .
+func (s *Handlers) Version(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(buildVersion())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,134 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHealthz(t *testing.T) {
+	t.Parallel()
+
+	req := httptest.NewRequest("GET", "/healthz", nil)
+	rr := httptest.NewRecorder()
+	(&Handlers{}).Healthz(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code   int
+		failed []string
+	}
+	cases := map[string]struct {
+		setup func(t *testing.T) *Handlers
+		wants
+	}{
+		"ok: all checks pass": {
+			setup: func(t *testing.T) *Handlers {
+				return &Handlers{db: newTestDB(t), imgDirPath: t.TempDir()}
+			},
+			wants: wants{code: http.StatusOK},
+		},
+		"ng: schema not applied": {
+			setup: func(t *testing.T) *Handlers {
+				db, err := OpenDatabase(filepath.Join(t.TempDir(), "empty.sqlite3"))
+				if err != nil {
+					t.Fatalf("failed to open database: %v", err)
+				}
+				t.Cleanup(func() { db.Close() })
+				return &Handlers{db: db, imgDirPath: t.TempDir()}
+			},
+			wants: wants{code: http.StatusServiceUnavailable, failed: []string{"schema"}},
+		},
+		"ng: database closed": {
+			setup: func(t *testing.T) *Handlers {
+				db := newTestDB(t)
+				db.Close()
+				return &Handlers{db: db, imgDirPath: t.TempDir()}
+			},
+			wants: wants{code: http.StatusServiceUnavailable, failed: []string{"database", "schema"}},
+		},
+		"ng: image dir missing": {
+			setup: func(t *testing.T) *Handlers {
+				return &Handlers{db: newTestDB(t), imgDirPath: filepath.Join(t.TempDir(), "missing")}
+			},
+			wants: wants{code: http.StatusServiceUnavailable, failed: []string{"image_dir"}},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := tt.setup(t)
+			req := httptest.NewRequest("GET", "/readyz", nil)
+			rr := httptest.NewRecorder()
+			h.Readyz(rr, req)
+
+			if rr.Code != tt.wants.code {
+				t.Errorf("expected status code %d, got %d: %s", tt.wants.code, rr.Code, rr.Body.String())
+			}
+			var resp ReadyResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response body: %v", err)
+			}
+			var failed []string
+			for _, name := range []string{"database", "schema", "image_dir"} {
+				if resp.Checks[name].Status != "ok" {
+					failed = append(failed, name)
+				}
+			}
+			if diff := cmp.Diff(tt.wants.failed, failed); diff != "" {
+				t.Errorf("unexpected failed checks (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRunCheckTimeout(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	defer close(release)
+
+	err := runCheck(context.Background(), 10*time.Millisecond, func(ctx context.Context) error {
+		// ignores ctx like a blocked file system call
+		<-release
+		return nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a deadline error, got %v", err)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	t.Parallel()
+
+	req := httptest.NewRequest("GET", "/version", nil)
+	rr := httptest.NewRecorder()
+	(&Handlers{}).Version(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+	var resp VersionResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response body: %v", err)
+	}
+	if resp.GoVersion == "" {
+		t.Errorf("expected the Go version, got %+v", resp)
+	}
+}
@@ -0,0 +1,176 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// defaultMaxImageSize is the largest image accepted by POST /items unless configured otherwise.
+	defaultMaxImageSize = 10 << 20
+	// imageFetchTimeout bounds the whole fetch of an image URL, including redirects.
+	imageFetchTimeout = 10 * time.Second
+	// maxImageFetchRedirects is how many redirects are followed when fetching an image URL.
+	maxImageFetchRedirects = 3
+)
+
+var errForbiddenImageURL = errors.New("image url is not allowed")
+
+// blockedPrefixes are special-purpose ranges not covered by the netip.Addr predicates.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // "this" network
+	netip.MustParsePrefix("100.64.0.0/10"),   // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),   // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),     // reserved
+	netip.MustParsePrefix("64:ff9b::/96"),    // NAT64, which can reach IPv4 private ranges
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // local-use NAT64
+	netip.MustParsePrefix("2001:db8::/32"),   // documentation
+	netip.MustParsePrefix("fec0::/10"),       // deprecated site-local
+	netip.MustParsePrefix("2002::/16"),       // 6to4, which embeds IPv4 addresses
+	netip.MustParsePrefix("2001::/32"),       // Teredo, which embeds IPv4 addresses
+	netip.MustParsePrefix("100::/64"),        // discard-only
+	netip.MustParsePrefix("192.0.2.0/24"),    // documentation
+	netip.MustParsePrefix("198.51.100.0/24"), // documentation
+	netip.MustParsePrefix("203.0.113.0/24"),  // documentation
+}
+
+// isPublicIP reports whether the server may connect to addr on behalf of a client.
+func isPublicIP(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+	for _, p := range blockedPrefixes {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// imageFetcher downloads images from client-supplied URLs while guarding against SSRF.
+type imageFetcher struct {
+	client  *http.Client
+	maxSize int64
+}
+
+// newImageFetcher creates a new imageFetcher which downloads images up to maxSize bytes.
+// allowIP decides which addresses can be connected to; it is isPublicIP except in tests.
+func newImageFetcher(allowIP func(netip.Addr) bool, maxSize int64) *imageFetcher {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		// the address is checked after DNS resolution and on every connection, so DNS rebinding and
+		// redirects to internal hosts are blocked as well
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return fmt.Errorf("%w: %v", errForbiddenImageURL, err)
+			}
+			if !allowIP(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s is not a public address", errForbiddenImageURL, addrPort.Addr())
+			}
+			return nil
+		},
+	}
+
+	transport := &http.Transport{
+		// proxies from the environment would bypass the address check
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 5 * time.Second,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+
+	return &imageFetcher{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   imageFetchTimeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) > maxImageFetchRedirects {
+					return fmt.Errorf("%w: too many redirects", errForbiddenImageURL)
+				}
+				return validateImageURL(req.URL)
+			},
+		},
+		maxSize: maxSize,
+	}
+}
+
+// validateImageURL checks the parts of an image URL which can be checked before connecting.
+func validateImageURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: scheme must be http or https", errForbiddenImageURL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%w: host is required", errForbiddenImageURL)
+	}
+	if u.User != nil {
+		return fmt.Errorf("%w: credentials are not allowed", errForbiddenImageURL)
+	}
+	return nil
+}
+
+// Fetch downloads the image at rawURL.
+// URLs and addresses which must not be fetched are reported with errForbiddenImageURL.
+func (f *imageFetcher) Fetch(ctx context.Context, rawURL string) (image []byte, err error) {
+	ctx, span := startSpan(ctx, "imageFetcher.Fetch")
+	defer func() {
+		span.SetAttributes(attribute.Int("image.size", len(image)))
+		endSpan(span, err)
+	}()
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image url: %w", err)
+	}
+	if err := validateImageURL(u); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image url: %w", err)
+	}
+	req.Header.Set("Accept", "image/*")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch image: %s", resp.Status)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); !strings.HasPrefix(mediaType, "image/") {
+		return nil, fmt.Errorf("fetched content is not an image: %q", resp.Header.Get("Content-Type"))
+	}
+	if resp.ContentLength > f.maxSize {
+		return nil, fmt.Errorf("image is larger than %d bytes", f.maxSize)
+	}
+
+	image, err = io.ReadAll(io.LimitReader(resp.Body, f.maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	if int64(len(image)) > f.maxSize {
+		return nil, fmt.Errorf("image is larger than %d bytes", f.maxSize)
+	}
+	return image, nil
+}
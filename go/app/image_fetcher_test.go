@@ -0,0 +1,216 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"go.uber.org/mock/gomock"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]bool{
+		"8.8.8.8":                true,
+		"2606:4700:4700::1111":   true,
+		"127.0.0.1":              false,
+		"10.1.2.3":               false,
+		"172.16.0.1":             false,
+		"192.168.1.1":            false,
+		"169.254.169.254":        false,
+		"100.64.0.1":             false,
+		"0.0.0.0":                false,
+		"::1":                    false,
+		"fe80::1":                false,
+		"fd00::1":                false,
+		"::ffff:127.0.0.1":       false,
+		"64:ff9b::a00:1":         false,
+		"224.0.0.1":              false,
+		"255.255.255.255":        false,
+		"2002:a00:1::":           false,
+		"::ffff:169.254.169.254": false,
+	}
+
+	for addr, want := range cases {
+		if got := isPublicIP(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("isPublicIP(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestImageFetcherFetch(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/image.jpg", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(testImage)
+	})
+	mux.HandleFunc("/page.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html></html>"))
+	})
+	mux.HandleFunc("/large.jpg", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(bytes.Repeat([]byte{0}, 2048))
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	allowAll := func(netip.Addr) bool { return true }
+
+	type wants struct {
+		image     []byte
+		forbidden bool
+		err       bool
+	}
+	cases := map[string]struct {
+		url     string
+		allowIP func(netip.Addr) bool
+		wants
+	}{
+		"ok: image": {
+			url:     srv.URL + "/image.jpg",
+			allowIP: allowAll,
+			wants:   wants{image: testImage},
+		},
+		"ng: loopback address": {
+			url:     srv.URL + "/image.jpg",
+			allowIP: isPublicIP,
+			wants:   wants{forbidden: true, err: true},
+		},
+		"ng: unsupported scheme": {
+			url:     "file:///etc/passwd",
+			allowIP: allowAll,
+			wants:   wants{forbidden: true, err: true},
+		},
+		"ng: redirect to unsupported scheme": {
+			url:     srv.URL + "/redirect",
+			allowIP: allowAll,
+			wants:   wants{forbidden: true, err: true},
+		},
+		"ng: not an image": {
+			url:     srv.URL + "/page.html",
+			allowIP: allowAll,
+			wants:   wants{err: true},
+		},
+		"ng: too large": {
+			url:     srv.URL + "/large.jpg",
+			allowIP: allowAll,
+			wants:   wants{err: true},
+		},
+		"ng: not found": {
+			url:     srv.URL + "/missing.jpg",
+			allowIP: allowAll,
+			wants:   wants{err: true},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			f := newImageFetcher(tt.allowIP, 1024)
+
+			got, err := f.Fetch(context.Background(), tt.url)
+			if err != nil {
+				if !tt.err {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if forbidden := errors.Is(err, errForbiddenImageURL); forbidden != tt.forbidden {
+					t.Errorf("expected forbidden=%v, got error %v", tt.forbidden, err)
+				}
+				return
+			}
+			if tt.err {
+				t.Fatalf("expected error, got nil")
+			}
+			if !bytes.Equal(got, tt.image) {
+				t.Errorf("unexpected image: %q", got)
+			}
+		})
+	}
+}
+
+func TestAddItemWithJSONBody(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(testImage)
+	}))
+	t.Cleanup(srv.Close)
+
+	// the test server listens on loopback, so it is let through in addition to public addresses
+	allowTestServer := func(addr netip.Addr) bool {
+		return addr.IsLoopback() || isPublicIP(addr)
+	}
+	encodedImage := base64.StdEncoding.EncodeToString(testImage)
+
+	cases := map[string]struct {
+		body    string
+		allowIP func(netip.Addr) bool
+		code    int
+	}{
+		"ok: base64 image": {
+			body:    `{"name": "used iPhone", "category": "phone", "image": "` + encodedImage + `"}`,
+			allowIP: allowTestServer,
+			code:    http.StatusOK,
+		},
+		"ok: image url": {
+			body:    `{"name": "used iPhone", "category": "phone", "image_url": "` + srv.URL + `/image.jpg"}`,
+			allowIP: allowTestServer,
+			code:    http.StatusOK,
+		},
+		"ng: both image and image url": {
+			body:    `{"name": "used iPhone", "category": "phone", "image": "` + encodedImage + `", "image_url": "` + srv.URL + `"}`,
+			allowIP: allowTestServer,
+			code:    http.StatusUnprocessableEntity,
+		},
+		"ng: invalid base64": {
+			body:    `{"name": "used iPhone", "category": "phone", "image": "not base64!"}`,
+			allowIP: allowTestServer,
+			code:    http.StatusBadRequest,
+		},
+		"ng: private image url": {
+			body:    `{"name": "used iPhone", "category": "phone", "image_url": "` + srv.URL + `/image.jpg"}`,
+			allowIP: isPublicIP,
+			code:    http.StatusBadRequest,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockIR := NewMockItemRepository(ctrl)
+			mockIR.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+
+			h := &Handlers{
+				itemRepo:     mockIR,
+				imageStore:   NewLocalImageStore(t.TempDir()),
+				imageFetcher: newImageFetcher(tt.allowIP, defaultMaxImageSize),
+			}
+
+			req := httptest.NewRequest("POST", "/items", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			h.AddItem(rr, req)
+
+			if rr.Code != tt.code {
+				t.Errorf("expected status code %d, got %d: %s", tt.code, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
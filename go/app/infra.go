@@ -2,34 +2,49 @@ package app
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
-	"encoding/json"
 	"path/filepath"
-	"fmt"
 	// STEP 5-1: uncomment this line
-	_ "github.com/mattn/go-sqlite3"
 	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
 )
 
-var db *sql.DB
-
-//to initialize the database connection
-func init() {
-	var err error
-	db, err = sql.Open("sqlite3", "./db/mercari.sqlite3")
+// OpenDatabase opens the SQLite database at path and checks the connection.
+func OpenDatabase(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", path)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database %s: %w", path, err)
 	}
+	return db, nil
 }
 
 var errImageNotFound = errors.New("image not found")
+var errItemNotFound = errors.New("item not found")
+
+const (
+	// imageFileMode is the permission of stored images. They are readable by anyone, but only the server writes them.
+	imageFileMode = 0o644
+	// tempImagePrefix is the prefix of temporary files written by StoreImage.
+	tempImagePrefix = ".tmp-"
+)
 
 type Item struct {
-	ID   int    `db:"id" json:"-"`
-	Name string `db:"name" json:"name"`
-	Category string `db:"category" json:"category"`
-	Image string `db:"image" json:"image"`
+	ID         int    `db:"id" json:"id"`
+	Name       string `db:"name" json:"name"`
+	Category   string `db:"category" json:"category"`
+	CategoryID int    `db:"category_id" json:"category_id"`
+	Image      string `db:"image" json:"image"`
+	// Price is in yen.
+	Price int `db:"price" json:"price"`
+	// SellerID is the user who listed the item, or 0 if it was listed without signing in.
+	SellerID int `db:"seller_id" json:"seller_id,omitempty"`
 }
 
 //to add items under "items" key
@@ -42,48 +57,63 @@ type ItemList struct {
 //
 //go:generate go run go.uber.org/mock/mockgen -source=$GOFILE -package=${GOPACKAGE} -destination=./mock_$GOFILE
 type ItemRepository interface {
+	// Insert inserts the item into the category with CategoryID, or else the one named Category.
+	// It returns errCategoryNotFound if CategoryID is given and does not exist.
 	Insert(ctx context.Context, item *Item) error
-	LoadFromDatabase() ([]Item, error)
+	LoadFromDatabase(ctx context.Context) ([]Item, error)
+	// ListImageNames returns the image names referenced by any item, user avatar or message attachment.
+	ListImageNames(ctx context.Context) ([]string, error)
+	// SaveImageHash stores the perceptual hash of an image.
+	SaveImageHash(ctx context.Context, imageName string, hash uint64) error
+	// FindItemsByImageHash returns the active listings of sellers other than sellerID whose image hash is within
+	// maxDistance bits of hash. Items which were purchased are not active. A sellerID of 0 compares every seller.
+	FindItemsByImageHash(ctx context.Context, hash uint64, maxDistance, sellerID int) ([]Item, error)
+	// FindSimilarItems returns the other items whose image is near-identical to the image of the item.
+	// It returns errItemNotFound if the item does not exist.
+	FindSimilarItems(ctx context.Context, itemID int, maxDistance int) ([]Item, error)
+	// FindItemsByCategory returns the items in the category and its subcategories.
+	FindItemsByCategory(ctx context.Context, categoryID int) ([]Item, error)
+	// FindItemsBySeller returns at most limit items listed by the user, newest first, skipping the first offset.
+	FindItemsBySeller(ctx context.Context, sellerID, limit, offset int) ([]Item, error)
 }
 
 // itemRepository is an implementation of ItemRepository
 type itemRepository struct {
 	// fileName is the path to the JSON file storing items.
 	fileName string
+	db       *sql.DB
 }
 
 // NewItemRepository creates a new itemRepository.
-func NewItemRepository() ItemRepository {
-	return &itemRepository{fileName: "items.json"}
+func NewItemRepository(db *sql.DB) ItemRepository {
+	return &itemRepository{fileName: "items.json", db: db}
 }
 
 // Insert inserts an item into the repository.
+// The ID and the category of the item are set to the stored ones.
 func (i *itemRepository) Insert(ctx context.Context, item *Item) error {
-	var categoryID int
-
-	//check if the category exists in the database
-	err := db.QueryRow("SELECT id FROM categories WHERE name = ?", item.Category).Scan(&categoryID)
-	//if the category is not found, insert it into the database
-	if err == sql.ErrNoRows {
-		result, err := db.ExecContext(ctx, "INSERT INTO categories (name) VALUES (?)", item.Category)
-		if err != nil {
-			return err
-		}
-		//get the id of the inserted category
-		categoryID64, err := result.LastInsertId()
-		if err != nil {
-			return err
-		}
-		categoryID = int(categoryID64)
-	} else if err != nil {
+	category, err := i.category(ctx, item)
+	if err != nil {
 		return err
 	}
+	item.CategoryID, item.Category = category.ID, category.Name
 
 	//store item to the database
-	_, err = db.ExecContext(ctx, "INSERT INTO items (name, category_id, image_name) VALUES (?, ?, ?)", item.Name, categoryID, item.Image)
+	spanCtx, span := startSpan(ctx, "INSERT items")
+	var sellerID *int
+	if item.SellerID != 0 {
+		sellerID = &item.SellerID
+	}
+	result, err := i.db.ExecContext(spanCtx, "INSERT INTO items (name, category_id, image_name, price, seller_id) VALUES (?, ?, ?, ?, ?)", item.Name, item.CategoryID, item.Image, item.Price, sellerID)
+	endSpan(span, err)
 	if err != nil {
 		return err
 	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	item.ID = int(id)
 
 	// STEP 4-2: add an implementation to store an item
 	// Open the file in read-write mode (if it does't exist create an empty file)
@@ -120,10 +150,45 @@ func (i *itemRepository) Insert(ctx context.Context, item *Item) error {
 	return nil
 }
 
+// category returns the category of the item.
+// A category given by name is looked up by its normalized name, preferring root categories,
+// and created at the root if there is none, so that "Fashion" and "fashion " are the same category.
+func (i *itemRepository) category(ctx context.Context, item *Item) (category Category, err error) {
+	ctx, span := startSpan(ctx, "SELECT categories")
+	defer func() { endSpan(span, err) }()
+
+	if item.CategoryID != 0 {
+		return getCategory(ctx, i.db, item.CategoryID)
+	}
+
+	name, key, err := normalizeCategoryName(item.Category)
+	if err != nil {
+		return Category{}, err
+	}
+	lookup := func() (Category, error) {
+		var category Category
+		err := i.db.QueryRowContext(ctx, "SELECT id, name, parent_id FROM categories WHERE name_key = ? ORDER BY parent_id IS NOT NULL, id LIMIT 1", key).
+			Scan(&category.ID, &category.Name, &category.ParentID)
+		return category, err
+	}
+
+	//check if the category exists in the database
+	category, err = lookup()
+	//if the category is not found, insert it into the database
+	if err == sql.ErrNoRows {
+		category, err = NewCategoryRepository(i.db).Create(ctx, name, nil)
+		if errors.Is(err, errCategoryExists) {
+			// another request created it in the meantime
+			return lookup()
+		}
+	}
+	return category, err
+}
+
 // Step 5-1 LoadFromDatabase loads items from the database.
-func (i *itemRepository) LoadFromDatabase() ([]Item, error) {
-	rows, err := db.Query(`
-		SELECT items.id, items.name, categories.name AS category, items.image_name
+func (i *itemRepository) LoadFromDatabase(ctx context.Context) ([]Item, error) {
+	rows, err := i.db.QueryContext(ctx, `
+		SELECT items.id, items.name, categories.name AS category, items.category_id, items.image_name, items.price, IFNULL(items.seller_id, 0)
 		FROM items
 		JOIN categories ON items.category_id = categories.id
 	`)
@@ -135,7 +200,7 @@ func (i *itemRepository) LoadFromDatabase() ([]Item, error) {
 	var items []Item
 	for rows.Next() {
 		var item Item
-		if err := rows.Scan(&item.ID, &item.Name, &item.Category, &item.Image); err != nil {
+		if err := rows.Scan(&item.ID, &item.Name, &item.Category, &item.CategoryID, &item.Image, &item.Price, &item.SellerID); err != nil {
 			return nil, err
 		}
 		items = append(items, item)
@@ -143,16 +208,200 @@ func (i *itemRepository) LoadFromDatabase() ([]Item, error) {
 	return items, nil
 }
 
+// ListImageNames returns the distinct image names referenced by items, user avatars and message attachments.
+func (i *itemRepository) ListImageNames(ctx context.Context) ([]string, error) {
+	rows, err := i.db.QueryContext(ctx, `
+		SELECT image_name FROM items
+		UNION SELECT avatar_name FROM users WHERE avatar_name != ''
+		UNION SELECT image_name FROM messages WHERE image_name != ''`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// SaveImageHash stores the perceptual hash of an image. Hashes of already known images are kept as is.
+func (i *itemRepository) SaveImageHash(ctx context.Context, imageName string, hash uint64) error {
+	// SQLite integers are signed, so the hash is stored as its two's complement
+	_, err := i.db.ExecContext(ctx, "INSERT OR IGNORE INTO image_hashes (image_name, phash) VALUES (?, ?)", imageName, int64(hash))
+	return err
+}
+
+// FindItemsByImageHash returns the active listings of sellers other than sellerID whose image hash is within
+// maxDistance bits of hash.
+func (i *itemRepository) FindItemsByImageHash(ctx context.Context, hash uint64, maxDistance, sellerID int) ([]Item, error) {
+	// anonymous items have no seller, so sellerID 0 keeps them
+	return i.findItemsByImageHash(ctx, hash, maxDistance, `
+		(? = 0 OR items.seller_id IS NOT ?)
+		AND NOT EXISTS (SELECT 1 FROM transactions WHERE transactions.item_id = items.id)`, sellerID, sellerID)
+}
+
+// findItemsByImageHash returns the items matching the condition whose image hash is within maxDistance bits of hash.
+// SQLite has no popcount, so the distances are computed here.
+func (i *itemRepository) findItemsByImageHash(ctx context.Context, hash uint64, maxDistance int, where string, args ...any) ([]Item, error) {
+	rows, err := i.db.QueryContext(ctx, `
+		SELECT items.id, items.name, categories.name AS category, items.category_id, items.image_name, items.price, IFNULL(items.seller_id, 0), image_hashes.phash
+		FROM items
+		JOIN categories ON items.category_id = categories.id
+		JOIN image_hashes ON items.image_name = image_hashes.image_name
+		WHERE `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []Item
+	for rows.Next() {
+		var item Item
+		var phash int64
+		if err := rows.Scan(&item.ID, &item.Name, &item.Category, &item.CategoryID, &item.Image, &item.Price, &item.SellerID, &phash); err != nil {
+			return nil, err
+		}
+		if hammingDistance(hash, uint64(phash)) <= maxDistance {
+			items = append(items, item)
+		}
+	}
+	return items, rows.Err()
+}
+
+// FindSimilarItems returns the other items whose image is near-identical to the image of the item.
+func (i *itemRepository) FindSimilarItems(ctx context.Context, itemID int, maxDistance int) ([]Item, error) {
+	var phash sql.NullInt64
+	err := i.db.QueryRowContext(ctx, `
+		SELECT image_hashes.phash
+		FROM items
+		LEFT JOIN image_hashes ON items.image_name = image_hashes.image_name
+		WHERE items.id = ?`, itemID).Scan(&phash)
+	if err == sql.ErrNoRows {
+		return nil, errItemNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the image could not be decoded when the item was added
+	if !phash.Valid {
+		return nil, nil
+	}
+
+	candidates, err := i.findItemsByImageHash(ctx, uint64(phash.Int64), maxDistance, "TRUE")
+	if err != nil {
+		return nil, err
+	}
+	var items []Item
+	for _, item := range candidates {
+		if item.ID != itemID {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// FindItemsByCategory returns the items in the category and its subcategories.
+func (i *itemRepository) FindItemsByCategory(ctx context.Context, categoryID int) ([]Item, error) {
+	rows, err := i.db.QueryContext(ctx, `
+		WITH RECURSIVE subtree(id) AS (
+			SELECT ?
+			UNION
+			SELECT categories.id FROM categories JOIN subtree ON categories.parent_id = subtree.id
+		)
+		SELECT items.id, items.name, categories.name AS category, items.category_id, items.image_name, items.price, IFNULL(items.seller_id, 0)
+		FROM items
+		JOIN categories ON items.category_id = categories.id
+		WHERE items.category_id IN (SELECT id FROM subtree)
+		ORDER BY items.id`, categoryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []Item
+	for rows.Next() {
+		var item Item
+		if err := rows.Scan(&item.ID, &item.Name, &item.Category, &item.CategoryID, &item.Image, &item.Price, &item.SellerID); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// FindItemsBySeller returns a page of the items listed by the user, newest first.
+func (i *itemRepository) FindItemsBySeller(ctx context.Context, sellerID, limit, offset int) ([]Item, error) {
+	rows, err := i.db.QueryContext(ctx, `
+		SELECT items.id, items.name, categories.name AS category, items.category_id, items.image_name, items.price, IFNULL(items.seller_id, 0)
+		FROM items
+		JOIN categories ON items.category_id = categories.id
+		WHERE items.seller_id = ?
+		ORDER BY items.id DESC
+		LIMIT ? OFFSET ?`, sellerID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []Item
+	for rows.Next() {
+		var item Item
+		if err := rows.Scan(&item.ID, &item.Name, &item.Category, &item.CategoryID, &item.Image, &item.Price, &item.SellerID); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
 // StoreImage stores an image and returns an error if any.
-// This package doesn't have a related interface for simplicity.
-func StoreImage(dirPath string, fileName string, image []byte) error {
+// It is used by localImageStore, the ImageStore backed by a local directory.
+//
+// The image is written to a temporary file which is synced and then renamed,
+// so a crash never leaves a truncated file under the final name.
+func StoreImage(dirPath string, fileName string, image []byte) (err error) {
 	// STEP 4-4: add an implementation to store an image
 	filePath := filepath.Join(dirPath, fileName)
-	
-	//write image to the file
-	if err := os.WriteFile(filePath, image, 0666); err != nil {
+
+	// write image to a temporary file in the same directory so that rename is atomic
+	tmp, err := os.CreateTemp(dirPath, tempImagePrefix+"*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary image file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err := tmp.Write(image); err != nil {
 		return fmt.Errorf("failed to write image file: %w", err)
 	}
+	if err := tmp.Chmod(imageFileMode); err != nil {
+		return fmt.Errorf("failed to change image file mode: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to sync image file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close image file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filePath); err != nil {
+		return fmt.Errorf("failed to rename image file: %w", err)
+	}
+
+	// sync the directory so that the rename itself survives a crash
+	if dir, err := os.Open(dirPath); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
 
 	// Return nil if everything succeeds
 	return nil
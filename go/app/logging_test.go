@@ -0,0 +1,129 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		cfg     LogConfig
+		prefix  string
+		wantErr bool
+	}{
+		"ok: json":           {cfg: LogConfig{Level: "info", Format: "json"}, prefix: "{"},
+		"ok: text":           {cfg: LogConfig{Level: "info", Format: "text"}, prefix: "time="},
+		"ng: unknown format": {cfg: LogConfig{Level: "info", Format: "xml"}, wantErr: true},
+		"ng: unknown level":  {cfg: LogConfig{Level: "verbose", Format: "json"}, wantErr: true},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			logger, err := newLogger(&buf, tt.cfg)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			logger.Debug("hidden")
+			logger.Info("shown")
+			if strings.Contains(buf.String(), "hidden") {
+				t.Errorf("expected debug logs to be dropped, got %s", buf.String())
+			}
+			if !strings.HasPrefix(buf.String(), tt.prefix) {
+				t.Errorf("expected log to start with %q, got %s", tt.prefix, buf.String())
+			}
+		})
+	}
+}
+
+func TestAccessLogMiddleware(t *testing.T) {
+	// the middleware logs through the default logger, so this test can't run in parallel
+
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	var handlerRequestID string
+	handler := accessLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerRequestID = requestIDFromContext(r.Context())
+		loggerFromContext(r.Context()).Info("in handler")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+
+	cases := map[string]struct {
+		requestID string
+		// keep reports whether the request ID is propagated as is
+		keep bool
+	}{
+		"ok: propagated":           {requestID: "abc-123", keep: true},
+		"ok: generated":            {requestID: ""},
+		"ok: invalid is replaced":  {requestID: "bad id\n"},
+		"ok: too long is replaced": {requestID: strings.Repeat("a", maxRequestIDLength+1)},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			buf.Reset()
+
+			req := httptest.NewRequest("POST", "/items", nil)
+			if tt.requestID != "" {
+				req.Header.Set(requestIDHeader, tt.requestID)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			id := rr.Header().Get(requestIDHeader)
+			if tt.keep && id != tt.requestID {
+				t.Errorf("expected request ID %q, got %q", tt.requestID, id)
+			}
+			if !tt.keep && !validRequestID(id) {
+				t.Errorf("expected a generated request ID, got %q", id)
+			}
+			if handlerRequestID != id {
+				t.Errorf("expected the handler to see request ID %q, got %q", id, handlerRequestID)
+			}
+
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			if len(lines) != 2 {
+				t.Fatalf("expected 2 log lines, got %d: %s", len(lines), buf.String())
+			}
+			var entries []map[string]any
+			for _, line := range lines {
+				var entry map[string]any
+				if err := json.Unmarshal([]byte(line), &entry); err != nil {
+					t.Fatalf("failed to decode log line %q: %v", line, err)
+				}
+				if entry["request_id"] != id {
+					t.Errorf("expected request_id %q in %s", id, line)
+				}
+				entries = append(entries, entry)
+			}
+
+			access := entries[1]
+			if access["msg"] != "request completed" || access["status"] != float64(http.StatusCreated) || access["bytes"] != float64(len("created")) {
+				t.Errorf("unexpected access log: %v", access)
+			}
+			if _, ok := access["duration_ms"].(float64); !ok {
+				t.Errorf("expected duration_ms in access log: %v", access)
+			}
+		})
+	}
+}
@@ -0,0 +1,388 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+const (
+	// defaultMessagesPerPage is how many messages are returned when the client doesn't give a limit.
+	defaultMessagesPerPage = 50
+	// messageImagePrefix starts the names of message attachments, which GET /images/{filename} doesn't serve.
+	messageImagePrefix = "message-"
+)
+
+var errMessageNotFound = errors.New("message not found")
+
+// Message is a private message between the parties of a transaction.
+type Message struct {
+	ID            int    `json:"id"`
+	TransactionID int    `json:"transaction_id"`
+	SenderID      int    `json:"sender_id"`
+	Body          string `json:"body"`
+	// Image is the image name of the attachment, or "" if there is none. It is served to the parties only,
+	// by GET /transactions/{transaction_id}/messages/{message_id}/image.
+	Image     string    `json:"image"`
+	CreatedAt time.Time `json:"created_at"`
+	// ReadAt is when the recipient read the message, or nil while it is unread.
+	ReadAt *time.Time `json:"read_at"`
+}
+
+// UnreadCount is the number of unread messages a user received in a transaction.
+type UnreadCount struct {
+	TransactionID int `json:"transaction_id"`
+	Unread        int `json:"unread"`
+}
+
+// Please run `go generate ./...` to generate the mock implementation
+// MessageRepository is an interface to manage the messages of transactions.
+// It doesn't check that users are parties of the transactions, which is up to the caller.
+//
+//go:generate go run go.uber.org/mock/mockgen -source=$GOFILE -package=${GOPACKAGE} -destination=./mock_$GOFILE
+type MessageRepository interface {
+	// Create sends a message in the transaction.
+	Create(ctx context.Context, transactionID, senderID int, body, image string) (Message, error)
+	// Get returns a message of the transaction, or errMessageNotFound.
+	Get(ctx context.Context, transactionID, id int) (Message, error)
+	// List returns at most limit messages of the transaction after the message afterID, oldest first.
+	List(ctx context.Context, transactionID, afterID, limit int) ([]Message, error)
+	// MarkRead marks the messages the reader received in the transaction up to the message upToID as read,
+	// and returns how many were unread.
+	MarkRead(ctx context.Context, transactionID, readerID, upToID int) (int, error)
+	// UnreadCounts returns the number of unread messages the user received in each transaction with any.
+	UnreadCounts(ctx context.Context, userID int) ([]UnreadCount, error)
+}
+
+// messageRepository is an implementation of MessageRepository
+type messageRepository struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+// NewMessageRepository creates a new messageRepository.
+func NewMessageRepository(db *sql.DB) MessageRepository {
+	return &messageRepository{db: db, now: time.Now}
+}
+
+func (m *messageRepository) Create(ctx context.Context, transactionID, senderID int, body, image string) (Message, error) {
+	msg := Message{
+		TransactionID: transactionID,
+		SenderID:      senderID,
+		Body:          body,
+		Image:         image,
+		CreatedAt:     m.now().UTC(),
+	}
+	result, err := m.db.ExecContext(ctx, "INSERT INTO messages (transaction_id, sender_id, body, image_name, created_at) VALUES (?, ?, ?, ?, ?)",
+		msg.TransactionID, msg.SenderID, msg.Body, msg.Image, msg.CreatedAt)
+	if err != nil {
+		return Message{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Message{}, err
+	}
+	msg.ID = int(id)
+	return msg, nil
+}
+
+func (m *messageRepository) Get(ctx context.Context, transactionID, id int) (Message, error) {
+	var msg Message
+	err := m.db.QueryRowContext(ctx, `
+		SELECT id, transaction_id, sender_id, body, image_name, created_at, read_at
+		FROM messages
+		WHERE transaction_id = ? AND id = ?`, transactionID, id).
+		Scan(&msg.ID, &msg.TransactionID, &msg.SenderID, &msg.Body, &msg.Image, &msg.CreatedAt, &msg.ReadAt)
+	if err == sql.ErrNoRows {
+		return Message{}, errMessageNotFound
+	}
+	return msg, err
+}
+
+func (m *messageRepository) List(ctx context.Context, transactionID, afterID, limit int) ([]Message, error) {
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT id, transaction_id, sender_id, body, image_name, created_at, read_at
+		FROM messages
+		WHERE transaction_id = ? AND id > ?
+		ORDER BY id
+		LIMIT ?`, transactionID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		var msg Message
+		if err := rows.Scan(&msg.ID, &msg.TransactionID, &msg.SenderID, &msg.Body, &msg.Image, &msg.CreatedAt, &msg.ReadAt); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+func (m *messageRepository) MarkRead(ctx context.Context, transactionID, readerID, upToID int) (int, error) {
+	// only messages of the other party are read by the reader, and a read message keeps its first read time
+	result, err := m.db.ExecContext(ctx, `
+		UPDATE messages SET read_at = ?
+		WHERE transaction_id = ? AND sender_id != ? AND id <= ? AND read_at IS NULL`,
+		m.now().UTC(), transactionID, readerID, upToID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+func (m *messageRepository) UnreadCounts(ctx context.Context, userID int) ([]UnreadCount, error) {
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT messages.transaction_id, COUNT(*)
+		FROM messages
+		JOIN transactions ON messages.transaction_id = transactions.id
+		WHERE (transactions.seller_id = ? OR transactions.buyer_id = ?) AND messages.sender_id != ? AND messages.read_at IS NULL
+		GROUP BY messages.transaction_id
+		ORDER BY messages.transaction_id`, userID, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []UnreadCount
+	for rows.Next() {
+		var c UnreadCount
+		if err := rows.Scan(&c.TransactionID, &c.Unread); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+type GetMessagesRequest struct {
+	TransactionID int `path:"transaction_id" validate:"required,min=1"`
+	// AfterID returns only the messages after it, so that clients can poll for new messages.
+	AfterID int  `query:"after_id" validate:"min=0"`
+	Limit   *int `query:"limit" validate:"min=1,max=100"`
+}
+
+type GetMessagesResponse struct {
+	Messages []Message `json:"messages"`
+	// Unread is the number of messages of the other party which the user has not read yet.
+	Unread int `json:"unread"`
+}
+
+// GetMessages is a handler to return the messages of a transaction to its parties
+// for GET /transactions/{transaction_id}/messages .
+func (s *Handlers) GetMessages(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req GetMessagesRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	limit := defaultMessagesPerPage
+	if req.Limit != nil {
+		limit = *req.Limit
+	}
+
+	tr, userID, err := s.partyTransaction(ctx, req.TransactionID)
+	if err != nil {
+		s.writeTransactionError(w, r, err)
+		return
+	}
+	messages, err := s.messageRepo.List(ctx, tr.ID, req.AfterID, limit)
+	if err != nil {
+		s.writeTransactionError(w, r, err)
+		return
+	}
+	counts, err := s.messageRepo.UnreadCounts(ctx, userID)
+	if err != nil {
+		s.writeTransactionError(w, r, err)
+		return
+	}
+
+	resp := GetMessagesResponse{Messages: messages}
+	if resp.Messages == nil {
+		resp.Messages = []Message{}
+	}
+	for _, c := range counts {
+		if c.TransactionID == tr.ID {
+			resp.Unread = c.Unread
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type PostMessageRequest struct {
+	TransactionID int    `json:"-" path:"transaction_id" validate:"required,min=1"`
+	Body          string `form:"body" json:"body" validate:"trim,max=2000,multiline"`
+	// Image is base64-encoded in JSON bodies.
+	Image []byte `form:"image" json:"image"`
+}
+
+func (req *PostMessageRequest) validate() []FieldError {
+	if req.Body == "" && len(req.Image) == 0 {
+		return []FieldError{{Field: "body", Message: "is required unless image is given"}}
+	}
+	return nil
+}
+
+// PostMessage is a handler for a party of a transaction to message the other party
+// for POST /transactions/{transaction_id}/messages .
+// Attached images are stored like the images of items, but under messageImagePrefix so that they stay private.
+func (s *Handlers) PostMessage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	maxImageSize := s.imageSizeLimit()
+	r.Body = http.MaxBytesReader(w, r.Body, maxImageSize*4/3+1<<20)
+	var req PostMessageRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	if int64(len(req.Image)) > maxImageSize {
+		http.Error(w, fmt.Sprintf("image is larger than %d bytes", maxImageSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// the access is checked before the image is stored, so that others can't store images through this
+	tr, userID, err := s.partyTransaction(ctx, req.TransactionID)
+	if err != nil {
+		s.writeTransactionError(w, r, err)
+		return
+	}
+
+	var image string
+	if len(req.Image) > 0 {
+		image, err = s.storeImageWithPrefix(ctx, messageImagePrefix, req.Image)
+		if err != nil {
+			loggerFromContext(ctx).Error("failed to store message image: ", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	msg, err := s.messageRepo.Create(ctx, tr.ID, userID, req.Body, image)
+	if err != nil {
+		s.writeTransactionError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, msg)
+}
+
+type GetMessageImageRequest struct {
+	TransactionID int `path:"transaction_id" validate:"required,min=1"`
+	MessageID     int `path:"message_id" validate:"required,min=1"`
+}
+
+// GetMessageImage is a handler to return the attachment of a message to the parties of its transaction
+// for GET /transactions/{transaction_id}/messages/{message_id}/image .
+func (s *Handlers) GetMessageImage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req GetMessageImageRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+
+	tr, _, err := s.partyTransaction(ctx, req.TransactionID)
+	if err != nil {
+		s.writeTransactionError(w, r, err)
+		return
+	}
+	msg, err := s.messageRepo.Get(ctx, tr.ID, req.MessageID)
+	if err != nil {
+		s.writeTransactionError(w, r, err)
+		return
+	}
+	if msg.Image == "" {
+		http.Error(w, "message has no image", http.StatusNotFound)
+		return
+	}
+
+	img, err := s.imageStore.Get(ctx, msg.Image)
+	if errors.Is(err, errImageNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		loggerFromContext(ctx).Error("failed to get message image: ", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer img.Close()
+
+	// attachments are named after their content like other images, but must not be kept by shared caches
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	http.ServeContent(rec, r, msg.Image, img.ModTime, img)
+	s.metrics.observeImageServed(rec.bytes)
+}
+
+type ReadMessagesRequest struct {
+	TransactionID int `json:"-" path:"transaction_id" validate:"required,min=1"`
+	// UpToID is the last message the user has seen. Omit it to read every message.
+	UpToID *int `json:"up_to_id" validate:"min=1"`
+}
+
+type ReadMessagesResponse struct {
+	// Read is the number of messages which were unread.
+	Read int `json:"read"`
+}
+
+// ReadMessages is a handler for a party of a transaction to mark the messages of the other party read,
+// which the other party sees as their read_at, for POST /transactions/{transaction_id}/messages/read .
+func (s *Handlers) ReadMessages(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req ReadMessagesRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	upToID := math.MaxInt
+	if req.UpToID != nil {
+		upToID = *req.UpToID
+	}
+
+	tr, userID, err := s.partyTransaction(ctx, req.TransactionID)
+	if err != nil {
+		s.writeTransactionError(w, r, err)
+		return
+	}
+	n, err := s.messageRepo.MarkRead(ctx, tr.ID, userID, upToID)
+	if err != nil {
+		s.writeTransactionError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ReadMessagesResponse{Read: n})
+}
+
+type GetUnreadMessagesResponse struct {
+	// Total is the number of unread messages in every transaction.
+	Total        int           `json:"total"`
+	Transactions []UnreadCount `json:"transactions"`
+}
+
+// GetUnreadMessages is a handler to return the unread message counts of the authenticated user
+// for GET /messages/unread .
+func (s *Handlers) GetUnreadMessages(w http.ResponseWriter, r *http.Request) {
+	userID, _ := currentUserID(r.Context())
+	counts, err := s.messageRepo.UnreadCounts(r.Context(), userID)
+	if err != nil {
+		s.writeTransactionError(w, r, err)
+		return
+	}
+
+	resp := GetUnreadMessagesResponse{Transactions: counts}
+	if resp.Transactions == nil {
+		resp.Transactions = []UnreadCount{}
+	}
+	for _, c := range counts {
+		resp.Total += c.Unread
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,174 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMessageRepository(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sale := newTestSale(t)
+	now := time.Date(2026, 4, 1, 12, 0, 0, 0, time.UTC)
+	repo := &messageRepository{db: sale.db, now: func() time.Time { return now }}
+	tr, err := NewTransactionRepository(sale.db).Create(ctx, sale.itemID, sale.buyer.ID)
+	if err != nil {
+		t.Fatalf("failed to create transaction: %v", err)
+	}
+
+	first, err := repo.Create(ctx, tr.ID, sale.buyer.ID, "when can you ship?", "")
+	if err != nil {
+		t.Fatalf("failed to create message: %v", err)
+	}
+	if _, err := repo.Create(ctx, tr.ID, sale.buyer.ID, "", "photo.jpg"); err != nil {
+		t.Fatalf("failed to create message: %v", err)
+	}
+	reply, err := repo.Create(ctx, tr.ID, sale.seller.ID, "tomorrow", "")
+	if err != nil {
+		t.Fatalf("failed to create message: %v", err)
+	}
+
+	messages, err := repo.List(ctx, tr.ID, first.ID, 10)
+	if err != nil || len(messages) != 2 || messages[0].Image != "photo.jpg" || messages[1].ID != reply.ID {
+		t.Errorf("expected the messages after the first, oldest first, got %+v, %v", messages, err)
+	}
+	if counts, err := repo.UnreadCounts(ctx, sale.seller.ID); err != nil || !slices.Equal(counts, []UnreadCount{{TransactionID: tr.ID, Unread: 2}}) {
+		t.Errorf("expected the 2 messages of the buyer unread by the seller, got %+v, %v", counts, err)
+	}
+	if got, err := repo.Get(ctx, tr.ID, reply.ID); err != nil || got.Body != "tomorrow" {
+		t.Errorf("expected the reply, got %+v, %v", got, err)
+	}
+	if _, err := repo.Get(ctx, tr.ID+1, reply.ID); !errors.Is(err, errMessageNotFound) {
+		t.Errorf("expected errMessageNotFound for a message of another transaction, got %v", err)
+	}
+
+	// the seller reads up to the first message only, and reading doesn't touch the own messages
+	now = now.Add(time.Hour)
+	if n, err := repo.MarkRead(ctx, tr.ID, sale.seller.ID, first.ID); err != nil || n != 1 {
+		t.Errorf("expected 1 message read, got %d, %v", n, err)
+	}
+	if n, err := repo.MarkRead(ctx, tr.ID, sale.seller.ID, first.ID); err != nil || n != 0 {
+		t.Errorf("expected reading twice to be a no-op, got %d, %v", n, err)
+	}
+	messages, err = repo.List(ctx, tr.ID, 0, 10)
+	if err != nil || len(messages) != 3 {
+		t.Fatalf("failed to list messages: %+v, %v", messages, err)
+	}
+	if messages[0].ReadAt == nil || !messages[0].ReadAt.Equal(now) || messages[1].ReadAt != nil || messages[2].ReadAt != nil {
+		t.Errorf("expected only the first message to be read, got %+v", messages)
+	}
+	if counts, err := repo.UnreadCounts(ctx, sale.seller.ID); err != nil || !slices.Equal(counts, []UnreadCount{{TransactionID: tr.ID, Unread: 1}}) {
+		t.Errorf("expected 1 message unread by the seller, got %+v, %v", counts, err)
+	}
+	if counts, err := repo.UnreadCounts(ctx, 999); err != nil || len(counts) != 0 {
+		t.Errorf("expected no unread messages for a stranger, got %+v, %v", counts, err)
+	}
+
+	// attachments must survive the image sweeper
+	names, err := NewItemRepository(sale.db).ListImageNames(ctx)
+	if err != nil || !slices.Contains(names, "photo.jpg") {
+		t.Errorf("expected the attachment among the image names, got %v, %v", names, err)
+	}
+}
+
+func TestMessageHandlers(t *testing.T) {
+	t.Parallel()
+
+	sale := newTestSale(t)
+	handler := sale.handler()
+	_, strangerTok, err := NewUserRepository(sale.db).Create(context.Background(), "stranger")
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	rr := doWithToken(t, handler, "POST", fmt.Sprintf("/items/%d/purchase", sale.itemID), "", sale.buyerTok)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("failed to purchase: %d %s", rr.Code, rr.Body.String())
+	}
+	messages := rr.Header().Get("Location") + "/messages"
+
+	image := base64.StdEncoding.EncodeToString(testImage)
+	cases := []struct {
+		name, method, target, body, token string
+		wantCode                          int
+	}{
+		{"ng: anonymous can't message", "POST", messages, `{"body":"hi"}`, "", http.StatusUnauthorized},
+		{"ng: stranger can't message", "POST", messages, `{"body":"hi"}`, strangerTok, http.StatusNotFound},
+		{"ng: stranger can't read messages", "GET", messages, "", strangerTok, http.StatusNotFound},
+		{"ng: empty message", "POST", messages, `{"body":"  "}`, sale.buyerTok, http.StatusUnprocessableEntity},
+		{"ok: buyer messages", "POST", messages, `{"body":"when can you ship?"}`, sale.buyerTok, http.StatusCreated},
+		{"ok: buyer attaches an image", "POST", messages, `{"image":"` + image + `"}`, sale.buyerTok, http.StatusCreated},
+		{"ok: seller replies", "POST", messages, `{"body":"tomorrow"}`, sale.sellerTok, http.StatusCreated},
+	}
+	// the cases depend on each other, so they run in order
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := doWithToken(t, handler, tt.method, tt.target, tt.body, tt.token); rr.Code != tt.wantCode {
+				t.Errorf("expected status code %d, got %d: %s", tt.wantCode, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	rr = doWithToken(t, handler, "GET", "/messages/unread", "", sale.sellerTok)
+	var unread GetUnreadMessagesResponse
+	json.Unmarshal(rr.Body.Bytes(), &unread)
+	if rr.Code != http.StatusOK || unread.Total != 2 {
+		t.Errorf("expected 2 unread messages for the seller, got %d %+v", rr.Code, unread)
+	}
+
+	rr = doWithToken(t, handler, "GET", messages, "", sale.sellerTok)
+	var got GetMessagesResponse
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if rr.Code != http.StatusOK || len(got.Messages) != 3 || got.Unread != 2 {
+		t.Fatalf("expected 3 messages with 2 unread, got %d %+v", rr.Code, got)
+	}
+	attachment := got.Messages[1].Image
+	if _, err := os.Stat(filepath.Join(sale.imgDir, attachment)); attachment == "" || err != nil {
+		t.Errorf("expected the attachment to be stored, got %q: %v", attachment, err)
+	}
+
+	// the attachment is served to the parties only
+	imageCases := []struct {
+		name, target, token string
+		wantCode            int
+	}{
+		{"ok: seller gets the attachment", fmt.Sprintf("%s/%d/image", messages, got.Messages[1].ID), sale.sellerTok, http.StatusOK},
+		{"ng: stranger can't get the attachment", fmt.Sprintf("%s/%d/image", messages, got.Messages[1].ID), strangerTok, http.StatusNotFound},
+		{"ng: message without attachment", fmt.Sprintf("%s/%d/image", messages, got.Messages[0].ID), sale.sellerTok, http.StatusNotFound},
+		{"ng: public image route", "/images/" + attachment, "", http.StatusNotFound},
+	}
+	for _, tt := range imageCases {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := doWithToken(t, handler, "GET", tt.target, "", tt.token)
+			if rr.Code != tt.wantCode {
+				t.Errorf("expected status code %d, got %d: %s", tt.wantCode, rr.Code, rr.Body.String())
+			}
+			if tt.wantCode == http.StatusOK && !bytes.Equal(rr.Body.Bytes(), testImage) {
+				t.Errorf("expected the attached image, got %q", rr.Body.Bytes())
+			}
+		})
+	}
+
+	if rr := doWithToken(t, handler, "POST", messages+"/read", "", sale.sellerTok); rr.Code != http.StatusOK || rr.Body.String() != `{"read":2}`+"\n" {
+		t.Errorf("expected 2 messages read, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	// the buyer sees the read receipts of the own messages, and polls for new ones
+	rr = doWithToken(t, handler, "GET", fmt.Sprintf("%s?after_id=%d", messages, got.Messages[0].ID), "", sale.buyerTok)
+	got = GetMessagesResponse{}
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if rr.Code != http.StatusOK || len(got.Messages) != 2 || got.Messages[0].ReadAt == nil || got.Messages[1].ReadAt != nil || got.Unread != 1 {
+		t.Errorf("expected the read attachment and the unread reply, got %d %+v", rr.Code, got)
+	}
+}
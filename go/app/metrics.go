@@ -0,0 +1,697 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultBuckets are the upper bounds of latency histograms in seconds, the same as the Prometheus client defaults.
+var defaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics collects the server metrics and exposes them in the Prometheus text format.
+// A nil *Metrics is valid and records nothing, so handlers can be tested without it.
+type Metrics struct {
+	httpRequests *counterVec
+	httpDuration *histogramVec
+	dbDuration   *histogramVec
+
+	imageBytesStored *counterVec
+	imageBytesServed *counterVec
+	imageUploads     *counterVec
+	imageDedupeHits  *counterVec
+
+	// db is read at scrape time for connection pool statistics.
+	db *sql.DB
+}
+
+// NewMetrics creates a new Metrics. db may be nil.
+func NewMetrics(db *sql.DB) *Metrics {
+	return &Metrics{
+		httpRequests: newCounterVec("http_requests_total", "Number of HTTP requests by route pattern and status.", "method", "route", "code"),
+		httpDuration: newHistogramVec("http_request_duration_seconds", "Latency of HTTP requests by route pattern and status.", defaultBuckets, "method", "route", "code"),
+		dbDuration:   newHistogramVec("db_query_duration_seconds", "Latency of database operations.", defaultBuckets, "operation", "result"),
+
+		imageBytesStored: newCounterVec("image_stored_bytes_total", "Bytes of new images written to the image store."),
+		imageBytesServed: newCounterVec("image_served_bytes_total", "Bytes of images written to HTTP responses."),
+		imageUploads:     newCounterVec("image_uploads_total", "Number of images passed to storeImage."),
+		imageDedupeHits:  newCounterVec("image_dedupe_hits_total", "Number of uploaded images which were already stored."),
+
+		db: db,
+	}
+}
+
+// observeHTTP records a completed HTTP request.
+func (m *Metrics) observeHTTP(method, route string, code int, d time.Duration) {
+	if m == nil {
+		return
+	}
+	status := strconv.Itoa(code)
+	m.httpRequests.add(1, method, route, status)
+	m.httpDuration.observe(d.Seconds(), method, route, status)
+}
+
+// observeDB records a database operation.
+func (m *Metrics) observeDB(operation string, err error, d time.Duration) {
+	if m == nil {
+		return
+	}
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.dbDuration.observe(d.Seconds(), operation, result)
+}
+
+// observeImageUpload records an image passed to storeImage and whether it was deduplicated.
+func (m *Metrics) observeImageUpload(size int, deduped bool) {
+	if m == nil {
+		return
+	}
+	m.imageUploads.add(1)
+	if deduped {
+		m.imageDedupeHits.add(1)
+		return
+	}
+	m.imageBytesStored.add(float64(size))
+}
+
+// observeImageServed records bytes of an image written to a response.
+func (m *Metrics) observeImageServed(size int64) {
+	if m == nil {
+		return
+	}
+	m.imageBytesServed.add(float64(size))
+}
+
+// ServeHTTP is a handler to expose the metrics for GET /metrics .
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.write(w)
+}
+
+// write writes every metric in the Prometheus text exposition format.
+func (m *Metrics) write(w io.Writer) {
+	m.httpRequests.write(w)
+	m.httpDuration.write(w)
+	m.dbDuration.write(w)
+	m.imageBytesStored.write(w)
+	m.imageBytesServed.write(w)
+	m.imageUploads.write(w)
+	m.imageDedupeHits.write(w)
+
+	uploads := m.imageUploads.value()
+	ratio := 0.0
+	if uploads > 0 {
+		ratio = m.imageDedupeHits.value() / uploads
+	}
+	writeGauge(w, "image_dedupe_hit_ratio", "Ratio of uploaded images which were already stored, since the process started.", ratio)
+
+	if m.db != nil {
+		stats := m.db.Stats()
+		writeGauge(w, "db_max_open_connections", "Maximum number of open connections to the database.", float64(stats.MaxOpenConnections))
+		writeGauge(w, "db_open_connections", "Number of established connections to the database.", float64(stats.OpenConnections))
+		writeGauge(w, "db_in_use_connections", "Number of connections currently in use.", float64(stats.InUse))
+		writeGauge(w, "db_idle_connections", "Number of idle connections.", float64(stats.Idle))
+		writeCounter(w, "db_wait_count_total", "Number of connections waited for.", float64(stats.WaitCount))
+		writeCounter(w, "db_wait_duration_seconds_total", "Time blocked waiting for a new connection.", stats.WaitDuration.Seconds())
+		writeCounter(w, "db_max_idle_closed_total", "Number of connections closed due to SetMaxIdleConns.", float64(stats.MaxIdleClosed))
+		writeCounter(w, "db_max_lifetime_closed_total", "Number of connections closed due to SetConnMaxLifetime.", float64(stats.MaxLifetimeClosed))
+	}
+}
+
+// metricsMiddleware records the count and latency of requests by the route pattern they match on mux,
+// so that paths such as /items/1 and /items/2 share one series.
+func metricsMiddleware(next http.Handler, mux *http.ServeMux, m *Metrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		m.observeHTTP(r.Method, routeOf(mux, r), rec.status, time.Since(start))
+	})
+}
+
+// routeOf returns the pattern of the route r matches on mux without the method, or "other" for unmatched requests.
+func routeOf(mux *http.ServeMux, r *http.Request) string {
+	_, pattern := mux.Handler(r)
+	if pattern == "" {
+		return "other"
+	}
+	if _, path, found := strings.Cut(pattern, " "); found {
+		return path
+	}
+	return pattern
+}
+
+// statusRecorder remembers the status code and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.status = code
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// repositoryMetrics is embedded by the instrumented repositories to record their operations.
+type repositoryMetrics struct {
+	metrics *Metrics
+}
+
+// observe records the operation since start under the prefix of its repository, such as "Category", so that
+// operations of the same name on different repositories are not mixed up. The item operations have no prefix.
+func (r repositoryMetrics) observe(prefix, operation string, start time.Time, err error) {
+	r.metrics.observeDB(prefix+operation, err, time.Since(start))
+}
+
+// instrumentedItemRepository records the latency of every ItemRepository operation.
+type instrumentedItemRepository struct {
+	next ItemRepository
+	repositoryMetrics
+}
+
+// instrumentItemRepository wraps repo to record database metrics.
+func instrumentItemRepository(repo ItemRepository, m *Metrics) ItemRepository {
+	return &instrumentedItemRepository{next: repo, repositoryMetrics: repositoryMetrics{m}}
+}
+
+func (i *instrumentedItemRepository) Insert(ctx context.Context, item *Item) (err error) {
+	defer func(start time.Time) { i.observe("", "Insert", start, err) }(time.Now())
+	return i.next.Insert(ctx, item)
+}
+
+func (i *instrumentedItemRepository) LoadFromDatabase(ctx context.Context) (items []Item, err error) {
+	defer func(start time.Time) { i.observe("", "LoadFromDatabase", start, err) }(time.Now())
+	return i.next.LoadFromDatabase(ctx)
+}
+
+func (i *instrumentedItemRepository) ListImageNames(ctx context.Context) (names []string, err error) {
+	defer func(start time.Time) { i.observe("", "ListImageNames", start, err) }(time.Now())
+	return i.next.ListImageNames(ctx)
+}
+
+func (i *instrumentedItemRepository) SaveImageHash(ctx context.Context, imageName string, hash uint64) (err error) {
+	defer func(start time.Time) { i.observe("", "SaveImageHash", start, err) }(time.Now())
+	return i.next.SaveImageHash(ctx, imageName, hash)
+}
+
+func (i *instrumentedItemRepository) FindItemsByImageHash(ctx context.Context, hash uint64, maxDistance, sellerID int) (items []Item, err error) {
+	defer func(start time.Time) { i.observe("", "FindItemsByImageHash", start, err) }(time.Now())
+	return i.next.FindItemsByImageHash(ctx, hash, maxDistance, sellerID)
+}
+
+func (i *instrumentedItemRepository) FindSimilarItems(ctx context.Context, itemID int, maxDistance int) (items []Item, err error) {
+	defer func(start time.Time) { i.observe("", "FindSimilarItems", start, err) }(time.Now())
+	return i.next.FindSimilarItems(ctx, itemID, maxDistance)
+}
+
+func (i *instrumentedItemRepository) FindItemsByCategory(ctx context.Context, categoryID int) (items []Item, err error) {
+	defer func(start time.Time) { i.observe("", "FindItemsByCategory", start, err) }(time.Now())
+	return i.next.FindItemsByCategory(ctx, categoryID)
+}
+
+func (i *instrumentedItemRepository) FindItemsBySeller(ctx context.Context, sellerID, limit, offset int) (items []Item, err error) {
+	defer func(start time.Time) { i.observe("", "FindItemsBySeller", start, err) }(time.Now())
+	return i.next.FindItemsBySeller(ctx, sellerID, limit, offset)
+}
+
+// instrumentedCategoryRepository records the latency of every CategoryRepository operation.
+type instrumentedCategoryRepository struct {
+	next CategoryRepository
+	repositoryMetrics
+}
+
+// instrumentCategoryRepository wraps repo to record database metrics.
+func instrumentCategoryRepository(repo CategoryRepository, m *Metrics) CategoryRepository {
+	return &instrumentedCategoryRepository{next: repo, repositoryMetrics: repositoryMetrics{m}}
+}
+
+func (i *instrumentedCategoryRepository) List(ctx context.Context) (categories []Category, err error) {
+	defer func(start time.Time) { i.observe("Category", "List", start, err) }(time.Now())
+	return i.next.List(ctx)
+}
+
+func (i *instrumentedCategoryRepository) Get(ctx context.Context, id int) (category Category, err error) {
+	defer func(start time.Time) { i.observe("Category", "Get", start, err) }(time.Now())
+	return i.next.Get(ctx, id)
+}
+
+func (i *instrumentedCategoryRepository) Create(ctx context.Context, name string, parentID *int) (category Category, err error) {
+	defer func(start time.Time) { i.observe("Category", "Create", start, err) }(time.Now())
+	return i.next.Create(ctx, name, parentID)
+}
+
+func (i *instrumentedCategoryRepository) Rename(ctx context.Context, id int, name string) (category Category, err error) {
+	defer func(start time.Time) { i.observe("Category", "Rename", start, err) }(time.Now())
+	return i.next.Rename(ctx, id, name)
+}
+
+func (i *instrumentedCategoryRepository) Move(ctx context.Context, id int, parentID *int) (category Category, err error) {
+	defer func(start time.Time) { i.observe("Category", "Move", start, err) }(time.Now())
+	return i.next.Move(ctx, id, parentID)
+}
+
+func (i *instrumentedCategoryRepository) Merge(ctx context.Context, sourceID, targetID int) (err error) {
+	defer func(start time.Time) { i.observe("Category", "Merge", start, err) }(time.Now())
+	return i.next.Merge(ctx, sourceID, targetID)
+}
+
+// instrumentedUserRepository records the latency of every UserRepository operation.
+type instrumentedUserRepository struct {
+	next UserRepository
+	repositoryMetrics
+}
+
+// instrumentUserRepository wraps repo to record database metrics.
+func instrumentUserRepository(repo UserRepository, m *Metrics) UserRepository {
+	return &instrumentedUserRepository{next: repo, repositoryMetrics: repositoryMetrics{m}}
+}
+
+func (i *instrumentedUserRepository) Create(ctx context.Context, name string) (user User, token string, err error) {
+	defer func(start time.Time) { i.observe("User", "Create", start, err) }(time.Now())
+	return i.next.Create(ctx, name)
+}
+
+func (i *instrumentedUserRepository) Get(ctx context.Context, id int) (user User, err error) {
+	defer func(start time.Time) { i.observe("User", "Get", start, err) }(time.Now())
+	return i.next.Get(ctx, id)
+}
+
+func (i *instrumentedUserRepository) FindByToken(ctx context.Context, token string) (user User, err error) {
+	defer func(start time.Time) { i.observe("User", "FindByToken", start, err) }(time.Now())
+	return i.next.FindByToken(ctx, token)
+}
+
+func (i *instrumentedUserRepository) Profile(ctx context.Context, id int) (profile UserProfile, err error) {
+	defer func(start time.Time) { i.observe("User", "Profile", start, err) }(time.Now())
+	return i.next.Profile(ctx, id)
+}
+
+func (i *instrumentedUserRepository) Update(ctx context.Context, id int, name, avatar *string) (user User, err error) {
+	defer func(start time.Time) { i.observe("User", "Update", start, err) }(time.Now())
+	return i.next.Update(ctx, id, name, avatar)
+}
+
+// instrumentedTransactionRepository records the latency of every TransactionRepository operation.
+type instrumentedTransactionRepository struct {
+	next TransactionRepository
+	repositoryMetrics
+}
+
+// instrumentTransactionRepository wraps repo to record database metrics.
+func instrumentTransactionRepository(repo TransactionRepository, m *Metrics) TransactionRepository {
+	return &instrumentedTransactionRepository{next: repo, repositoryMetrics: repositoryMetrics{m}}
+}
+
+func (i *instrumentedTransactionRepository) Create(ctx context.Context, itemID, buyerID int) (tr Transaction, err error) {
+	defer func(start time.Time) { i.observe("Transaction", "Create", start, err) }(time.Now())
+	return i.next.Create(ctx, itemID, buyerID)
+}
+
+func (i *instrumentedTransactionRepository) Get(ctx context.Context, id int) (tr Transaction, err error) {
+	defer func(start time.Time) { i.observe("Transaction", "Get", start, err) }(time.Now())
+	return i.next.Get(ctx, id)
+}
+
+func (i *instrumentedTransactionRepository) Complete(ctx context.Context, id int) (tr Transaction, err error) {
+	defer func(start time.Time) { i.observe("Transaction", "Complete", start, err) }(time.Now())
+	return i.next.Complete(ctx, id)
+}
+
+// instrumentedReviewRepository records the latency of every ReviewRepository operation.
+type instrumentedReviewRepository struct {
+	next ReviewRepository
+	repositoryMetrics
+}
+
+// instrumentReviewRepository wraps repo to record database metrics.
+func instrumentReviewRepository(repo ReviewRepository, m *Metrics) ReviewRepository {
+	return &instrumentedReviewRepository{next: repo, repositoryMetrics: repositoryMetrics{m}}
+}
+
+func (i *instrumentedReviewRepository) Create(ctx context.Context, transactionID, reviewerID int, rating, comment string) (review Review, err error) {
+	defer func(start time.Time) { i.observe("Review", "Create", start, err) }(time.Now())
+	return i.next.Create(ctx, transactionID, reviewerID, rating, comment)
+}
+
+func (i *instrumentedReviewRepository) Update(ctx context.Context, id, reviewerID int, rating, comment *string) (review Review, err error) {
+	defer func(start time.Time) { i.observe("Review", "Update", start, err) }(time.Now())
+	return i.next.Update(ctx, id, reviewerID, rating, comment)
+}
+
+func (i *instrumentedReviewRepository) ListByReviewee(ctx context.Context, userID, limit, offset int) (reviews []Review, err error) {
+	defer func(start time.Time) { i.observe("Review", "ListByReviewee", start, err) }(time.Now())
+	return i.next.ListByReviewee(ctx, userID, limit, offset)
+}
+
+// instrumentedMessageRepository records the latency of every MessageRepository operation.
+type instrumentedMessageRepository struct {
+	next MessageRepository
+	repositoryMetrics
+}
+
+// instrumentMessageRepository wraps repo to record database metrics.
+func instrumentMessageRepository(repo MessageRepository, m *Metrics) MessageRepository {
+	return &instrumentedMessageRepository{next: repo, repositoryMetrics: repositoryMetrics{m}}
+}
+
+func (i *instrumentedMessageRepository) Create(ctx context.Context, transactionID, senderID int, body, image string) (msg Message, err error) {
+	defer func(start time.Time) { i.observe("Message", "Create", start, err) }(time.Now())
+	return i.next.Create(ctx, transactionID, senderID, body, image)
+}
+
+func (i *instrumentedMessageRepository) Get(ctx context.Context, transactionID, id int) (msg Message, err error) {
+	defer func(start time.Time) { i.observe("Message", "Get", start, err) }(time.Now())
+	return i.next.Get(ctx, transactionID, id)
+}
+
+func (i *instrumentedMessageRepository) List(ctx context.Context, transactionID, afterID, limit int) (messages []Message, err error) {
+	defer func(start time.Time) { i.observe("Message", "List", start, err) }(time.Now())
+	return i.next.List(ctx, transactionID, afterID, limit)
+}
+
+func (i *instrumentedMessageRepository) MarkRead(ctx context.Context, transactionID, readerID, upToID int) (n int, err error) {
+	defer func(start time.Time) { i.observe("Message", "MarkRead", start, err) }(time.Now())
+	return i.next.MarkRead(ctx, transactionID, readerID, upToID)
+}
+
+func (i *instrumentedMessageRepository) UnreadCounts(ctx context.Context, userID int) (counts []UnreadCount, err error) {
+	defer func(start time.Time) { i.observe("Message", "UnreadCounts", start, err) }(time.Now())
+	return i.next.UnreadCounts(ctx, userID)
+}
+
+// instrumentedNotificationRepository records the latency of every NotificationRepository operation.
+type instrumentedNotificationRepository struct {
+	next NotificationRepository
+	repositoryMetrics
+}
+
+// instrumentNotificationRepository wraps repo to record database metrics.
+func instrumentNotificationRepository(repo NotificationRepository, m *Metrics) NotificationRepository {
+	return &instrumentedNotificationRepository{next: repo, repositoryMetrics: repositoryMetrics{m}}
+}
+
+func (i *instrumentedNotificationRepository) Create(ctx context.Context, userID int, notificationType string, data NotificationData) (notification Notification, err error) {
+	defer func(start time.Time) { i.observe("Notification", "Create", start, err) }(time.Now())
+	return i.next.Create(ctx, userID, notificationType, data)
+}
+
+func (i *instrumentedNotificationRepository) List(ctx context.Context, userID int, unreadOnly bool, limit, offset int) (notifications []Notification, err error) {
+	defer func(start time.Time) { i.observe("Notification", "List", start, err) }(time.Now())
+	return i.next.List(ctx, userID, unreadOnly, limit, offset)
+}
+
+func (i *instrumentedNotificationRepository) CountUnread(ctx context.Context, userID int) (count int, err error) {
+	defer func(start time.Time) { i.observe("Notification", "CountUnread", start, err) }(time.Now())
+	return i.next.CountUnread(ctx, userID)
+}
+
+func (i *instrumentedNotificationRepository) MarkRead(ctx context.Context, userID, upToID int) (count int, err error) {
+	defer func(start time.Time) { i.observe("Notification", "MarkRead", start, err) }(time.Now())
+	return i.next.MarkRead(ctx, userID, upToID)
+}
+
+func (i *instrumentedNotificationRepository) Preferences(ctx context.Context, userID int) (prefs NotificationPreferences, err error) {
+	defer func(start time.Time) { i.observe("Notification", "Preferences", start, err) }(time.Now())
+	return i.next.Preferences(ctx, userID)
+}
+
+func (i *instrumentedNotificationRepository) SetPreferences(ctx context.Context, userID int, prefs NotificationPreferences) (err error) {
+	defer func(start time.Time) { i.observe("Notification", "SetPreferences", start, err) }(time.Now())
+	return i.next.SetPreferences(ctx, userID, prefs)
+}
+
+func (i *instrumentedNotificationRepository) Cursor(ctx context.Context) (cursor NotificationCursor, err error) {
+	defer func(start time.Time) { i.observe("Notification", "Cursor", start, err) }(time.Now())
+	return i.next.Cursor(ctx)
+}
+
+func (i *instrumentedNotificationRepository) Missed(ctx context.Context, since NotificationCursor) (events []Event, err error) {
+	defer func(start time.Time) { i.observe("Notification", "Missed", start, err) }(time.Now())
+	return i.next.Missed(ctx, since)
+}
+
+// instrumentedSavedSearchRepository records the latency of every SavedSearchRepository operation.
+type instrumentedSavedSearchRepository struct {
+	next SavedSearchRepository
+	repositoryMetrics
+}
+
+// instrumentSavedSearchRepository wraps repo to record database metrics.
+func instrumentSavedSearchRepository(repo SavedSearchRepository, m *Metrics) SavedSearchRepository {
+	return &instrumentedSavedSearchRepository{next: repo, repositoryMetrics: repositoryMetrics{m}}
+}
+
+func (i *instrumentedSavedSearchRepository) Create(ctx context.Context, userID int, criteria SearchCriteria, notify bool) (search SavedSearch, err error) {
+	defer func(start time.Time) { i.observe("SavedSearch", "Create", start, err) }(time.Now())
+	return i.next.Create(ctx, userID, criteria, notify)
+}
+
+func (i *instrumentedSavedSearchRepository) List(ctx context.Context, userID int) (searches []SavedSearch, err error) {
+	defer func(start time.Time) { i.observe("SavedSearch", "List", start, err) }(time.Now())
+	return i.next.List(ctx, userID)
+}
+
+func (i *instrumentedSavedSearchRepository) Delete(ctx context.Context, userID, id int) (err error) {
+	defer func(start time.Time) { i.observe("SavedSearch", "Delete", start, err) }(time.Now())
+	return i.next.Delete(ctx, userID, id)
+}
+
+func (i *instrumentedSavedSearchRepository) NewItems(ctx context.Context, userID, id, limit int) (items []Item, more bool, err error) {
+	defer func(start time.Time) { i.observe("SavedSearch", "NewItems", start, err) }(time.Now())
+	return i.next.NewItems(ctx, userID, id, limit)
+}
+
+func (i *instrumentedSavedSearchRepository) Match(ctx context.Context, item Item) (searches []SavedSearch, err error) {
+	defer func(start time.Time) { i.observe("SavedSearch", "Match", start, err) }(time.Now())
+	return i.next.Match(ctx, item)
+}
+
+// instrumentedOfferRepository records the latency of every OfferRepository operation.
+type instrumentedOfferRepository struct {
+	next OfferRepository
+	repositoryMetrics
+}
+
+// instrumentOfferRepository wraps repo to record database metrics.
+func instrumentOfferRepository(repo OfferRepository, m *Metrics) OfferRepository {
+	return &instrumentedOfferRepository{next: repo, repositoryMetrics: repositoryMetrics{m}}
+}
+
+func (i *instrumentedOfferRepository) Create(ctx context.Context, itemID, buyerID, price int) (offer Offer, err error) {
+	defer func(start time.Time) { i.observe("Offer", "Create", start, err) }(time.Now())
+	return i.next.Create(ctx, itemID, buyerID, price)
+}
+
+func (i *instrumentedOfferRepository) Get(ctx context.Context, id int) (offer Offer, err error) {
+	defer func(start time.Time) { i.observe("Offer", "Get", start, err) }(time.Now())
+	return i.next.Get(ctx, id)
+}
+
+func (i *instrumentedOfferRepository) ListByItem(ctx context.Context, itemID, userID int) (offers []Offer, err error) {
+	defer func(start time.Time) { i.observe("Offer", "ListByItem", start, err) }(time.Now())
+	return i.next.ListByItem(ctx, itemID, userID)
+}
+
+func (i *instrumentedOfferRepository) Accept(ctx context.Context, id, userID int) (offer Offer, err error) {
+	defer func(start time.Time) { i.observe("Offer", "Accept", start, err) }(time.Now())
+	return i.next.Accept(ctx, id, userID)
+}
+
+func (i *instrumentedOfferRepository) Decline(ctx context.Context, id, userID int) (offer Offer, err error) {
+	defer func(start time.Time) { i.observe("Offer", "Decline", start, err) }(time.Now())
+	return i.next.Decline(ctx, id, userID)
+}
+
+func (i *instrumentedOfferRepository) Counter(ctx context.Context, id, userID, price int) (offer Offer, err error) {
+	defer func(start time.Time) { i.observe("Offer", "Counter", start, err) }(time.Now())
+	return i.next.Counter(ctx, id, userID, price)
+}
+
+// counterVec is a counter partitioned by label values.
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+}
+
+func (c *counterVec) add(v float64, labelValues ...string) {
+	key := formatLabels(c.labels, labelValues)
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+// value returns the sum over all label values.
+func (c *counterVec) value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var sum float64
+	for _, v := range c.values {
+		sum += v
+	}
+	return sum
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		// unlabelled counters are exposed from the start
+		fmt.Fprintf(w, "%s 0\n", c.name)
+		return
+	}
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, key, formatFloat(c.values[key]))
+	}
+}
+
+// histogram is a single histogram series.
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// histogramVec is a histogram partitioned by label values.
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+	// labelValues keeps the values of each series to add the le label on output.
+	labelValues map[string][]string
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{
+		name:        name,
+		help:        help,
+		labels:      labels,
+		buckets:     buckets,
+		series:      map[string]*histogram{},
+		labelValues: map[string][]string{},
+	}
+}
+
+func (h *histogramVec) observe(v float64, labelValues ...string) {
+	key := formatLabels(h.labels, labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+		h.labelValues[key] = labelValues
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	labels := append(append([]string(nil), h.labels...), "le")
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		values := h.labelValues[key]
+		for i, upper := range h.buckets {
+			le := formatLabels(labels, append(append([]string(nil), values...), formatFloat(upper)))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, le, s.counts[i])
+		}
+		inf := formatLabels(labels, append(append([]string(nil), values...), "+Inf"))
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, inf, s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, key, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, key, s.count)
+	}
+}
+
+func writeGauge(w io.Writer, name, help string, v float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, formatFloat(v))
+}
+
+func writeCounter(w io.Writer, name, help string, v float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %s\n", name, help, name, name, formatFloat(v))
+}
+
+// formatLabels formats label pairs as {a="1",b="2"}, escaping values as the text format requires.
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		v = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+		pairs[i] = name + `="` + v + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,125 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	t.Parallel()
+
+	m := NewMetrics(nil)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{item_id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("item_id") == "0" {
+			http.Error(w, "item not found", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	handler := metricsMiddleware(mux, mux, m)
+
+	for _, path := range []string{"/items/1", "/items/2", "/items/0", "/unknown"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	body := scrape(t, m)
+	for _, want := range []string{
+		`http_requests_total{method="GET",route="/items/{item_id}",code="200"} 2`,
+		`http_requests_total{method="GET",route="/items/{item_id}",code="404"} 1`,
+		`http_requests_total{method="GET",route="other",code="404"} 1`,
+		`http_request_duration_seconds_count{method="GET",route="/items/{item_id}",code="200"} 2`,
+		`http_request_duration_seconds_bucket{method="GET",route="/items/{item_id}",code="200",le="+Inf"} 2`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetricsImageStorage(t *testing.T) {
+	t.Parallel()
+
+	m := NewMetrics(newTestDB(t))
+	h := &Handlers{imageStore: NewLocalImageStore(t.TempDir()), metrics: m}
+
+	ctx := context.Background()
+	fileName, err := h.storeImage(ctx, testImage)
+	if err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+	if _, err := h.storeImage(ctx, testImage); err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/images/"+fileName, nil)
+	req.SetPathValue("filename", fileName)
+	h.GetImage(httptest.NewRecorder(), req)
+
+	body := scrape(t, m)
+	for _, want := range []string{
+		"image_uploads_total 2",
+		"image_dedupe_hits_total 1",
+		"image_dedupe_hit_ratio 0.5",
+		"image_stored_bytes_total " + strconv.Itoa(len(testImage)),
+		"image_served_bytes_total " + strconv.Itoa(len(testImage)),
+		"db_max_open_connections 0",
+		"# TYPE db_open_connections gauge",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestInstrumentedItemRepository(t *testing.T) {
+	t.Parallel()
+
+	m := NewMetrics(nil)
+	repo := instrumentItemRepository(NewItemRepository(newTestDB(t)), m)
+	if _, err := repo.LoadFromDatabase(context.Background()); err != nil {
+		t.Fatalf("failed to load items: %v", err)
+	}
+	if _, err := repo.FindSimilarItems(context.Background(), 1, similarImageMaxDistance); err == nil {
+		t.Fatal("expected an error for a missing item")
+	}
+
+	body := scrape(t, m)
+	for _, want := range []string{
+		`db_query_duration_seconds_count{operation="LoadFromDatabase",result="ok"} 1`,
+		`db_query_duration_seconds_count{operation="FindSimilarItems",result="error"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestFormatLabels(t *testing.T) {
+	t.Parallel()
+
+	got := formatLabels([]string{"a", "b"}, []string{`x"y`, "1\\2\n"})
+	want := `{a="x\"y",b="1\\2\n"}`
+	if got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+// scrape returns the exposition of m as served by GET /metrics .
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	m.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	return rr.Body.String()
+}
@@ -1,32 +1,351 @@
 package app
 
 import (
-	"log/slog"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
+	"runtime/debug"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // This file provides some utility functions for middleware.
-// You do not have to modify this file.
 
-func simpleCORSMiddleware(next http.Handler, origin string, methods []string) http.Handler {
+// defaultCORSMaxAge is how long browsers cache preflight responses unless configured otherwise.
+const defaultCORSMaxAge = 10 * time.Minute
+
+// corsMethods are the methods offered to preflight requests, if the route of the request has them on the mux.
+var corsMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// corsMiddleware applies the CORS policy of cfg.
+// Preflight requests are answered here, with the methods the requested route is registered with on mux.
+// Requests from origins which are not allowed get no CORS headers, so browsers block their responses.
+func corsMiddleware(next http.Handler, mux *http.ServeMux, cfg CORSConfig) http.Handler {
+	allowedHeaders := make(map[string]bool, len(cfg.AllowedHeaders))
+	for _, h := range cfg.AllowedHeaders {
+		allowedHeaders[http.CanonicalHeaderKey(h)] = true
+	}
+	exposedHeaders := strings.Join(cfg.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", origin)
-		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ","))
-		w.Header().Set("Access-Control-Allow-Headers", "*")
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && origin != "" && r.Header.Get("Access-Control-Request-Method") != ""
 
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
+		// responses vary by origin even when the origin is rejected, so that caches don't mix them up
+		w.Header().Add("Vary", "Origin")
+		if preflight {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		allowed := origin != "" && corsOriginAllowed(cfg.AllowedOrigins, origin)
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if cfg.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+
+		if !preflight {
+			if allowed && exposedHeaders != "" {
+				w.Header().Set("Access-Control-Expose-Headers", exposedHeaders)
+			}
+			next.ServeHTTP(w, r)
 			return
 		}
 
+		methods := routeMethods(mux, r)
+		if len(methods) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		if !allowed {
+			http.Error(w, "origin is not allowed", http.StatusForbidden)
+			return
+		}
+		if !slices.Contains(methods, r.Header.Get("Access-Control-Request-Method")) {
+			w.Header().Set("Allow", strings.Join(methods, ", "))
+			http.Error(w, "method is not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		requested := splitList(r.Header.Get("Access-Control-Request-Headers"))
+		for _, h := range requested {
+			if !allowedHeaders[http.CanonicalHeaderKey(h)] {
+				http.Error(w, "header "+h+" is not allowed", http.StatusForbidden)
+				return
+			}
+		}
+
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		if len(requested) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+		}
+		if cfg.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", maxAge)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// corsOriginAllowed reports whether origin matches one of the allowed origins.
+// An allowed origin with a "*." host prefix matches the subdomains of the rest, with the same scheme and port.
+func corsOriginAllowed(allowedOrigins []string, origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return false
+	}
+	for _, allowed := range allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+
+		a, err := url.Parse(allowed)
+		if err != nil || !strings.HasPrefix(a.Host, "*.") || !strings.EqualFold(a.Scheme, u.Scheme) || a.Port() != u.Port() {
+			continue
+		}
+		suffix := strings.ToLower(strings.TrimPrefix(a.Hostname(), "*"))
+		host := strings.ToLower(u.Hostname())
+		if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// routeMethods returns the methods of corsMethods which the path of r is registered with on mux.
+func routeMethods(mux *http.ServeMux, r *http.Request) []string {
+	var methods []string
+	for _, method := range corsMethods {
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		if _, pattern := mux.Handler(probe); pattern != "" {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// trustedProxies are the reverse proxies whose X-Forwarded-* headers are believed.
+type trustedProxies []netip.Prefix
+
+// parseTrustedProxies parses addresses and CIDR prefixes.
+func parseTrustedProxies(proxies []string) (trustedProxies, error) {
+	prefixes := make(trustedProxies, 0, len(proxies))
+	for _, p := range proxies {
+		if strings.Contains(p, "/") {
+			prefix, err := netip.ParsePrefix(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", p, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", p, err)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+func (t trustedProxies) contains(addr netip.Addr) bool {
+	for _, p := range t {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// peer returns the address of the peer of the connection, which is a proxy or the client.
+func peer(r *http.Request) (netip.Addr, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	return addr.Unmap(), err
+}
+
+// clientIP returns the address of the client.
+// X-Forwarded-For is only believed when the request comes from a trusted proxy, and then the client is
+// the rightmost address which is not a trusted proxy, since anything left of it can be forged.
+func (t trustedProxies) clientIP(r *http.Request) string {
+	remote, err := peer(r)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	if !t.contains(remote) {
+		return remote.String()
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = addr.Unmap()
+		if !t.contains(client) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// isHTTPS reports whether the client connected with TLS, either to this server or to a trusted proxy.
+func (t trustedProxies) isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	remote, err := peer(r)
+	if err != nil || !t.contains(remote) {
+		return false
+	}
+	// the proxy nearest to the client is the leftmost one
+	proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+	return strings.EqualFold(strings.TrimSpace(proto), "https")
+}
+
+const (
+	// contentSecurityPolicy forbids everything, since the API serves JSON and images, never documents.
+	// Uploaded images are opened directly by users, so this also keeps a crafted file from running scripts.
+	contentSecurityPolicy = "default-src 'none'; frame-ancestors 'none'; base-uri 'none'; form-action 'none'"
+	// strictTransportSecurity makes browsers use HTTPS for a year once they have seen the API over HTTPS.
+	strictTransportSecurity = "max-age=31536000"
+)
+
+// securityHeadersMiddleware sets headers hardening every response.
+// HSTS is only sent over HTTPS, since browsers ignore it on plain HTTP.
+func securityHeadersMiddleware(next http.Handler, proxies trustedProxies) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Content-Security-Policy", contentSecurityPolicy)
+		// images are user uploads, so browsers must not sniff them into HTML or scripts
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "no-referrer")
+		h.Set("X-Frame-Options", "DENY")
+		if proxies.isHTTPS(r) {
+			h.Set("Strict-Transport-Security", strictTransportSecurity)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminMiddleware lets requests through only if they carry the admin token as a bearer token.
+// An empty token disables the admin API altogether.
+func adminMiddleware(next http.Handler, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.Error(w, "admin API is disabled", http.StatusForbidden)
+			return
+		}
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		// compare in constant time so that the token can't be guessed byte by byte from response times
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "admin token is required", http.StatusUnauthorized)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
-func simpleLoggerMiddleware(next http.Handler) http.Handler {
+// authMiddleware authenticates the user of a request by the bearer token issued by POST /users.
+// Requests without a token, or with another kind of token such as the admin token, are passed on anonymously,
+// and handlers which need a user are wrapped with requireUser.
+func authMiddleware(next http.Handler, users UserRepository) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		user, err := users.FindByToken(ctx, token)
+		if errors.Is(err, errUserNotFound) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			loggerFromContext(ctx).Error("failed to authenticate user: ", "error", err)
+			http.Error(w, "failed to authenticate user", http.StatusInternalServerError)
+			return
+		}
+
+		ctx = context.WithValue(ctx, authUserKey{}, user.ID)
+		ctx = context.WithValue(ctx, loggerKey{}, loggerFromContext(ctx).With("user_id", user.ID))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireUser lets requests through only if authMiddleware authenticated their user.
+func requireUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		slog.Info("request received", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr, "user_agent", r.UserAgent())
+		if _, ok := currentUserID(r.Context()); !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="users"`)
+			http.Error(w, "user token is required", http.StatusUnauthorized)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+type ErrorResponse struct {
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+	// Errors are the problems with each field of an invalid request.
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// recoveryMiddleware turns a panic in a handler into a JSON 500 carrying the request ID,
+// so that the client gets a response it can report instead of a dropped connection.
+func recoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				// the handler deliberately aborted the response
+				panic(p)
+			}
+
+			loggerFromContext(r.Context()).Error("panic in handler", "panic", p, "stack", string(debug.Stack()))
+			if rec.wroteHeader {
+				// part of the response is already sent, so the connection is aborted to show it is broken
+				panic(http.ErrAbortHandler)
+			}
+
+			h := w.Header()
+			for _, key := range []string{"Content-Length", "Content-Encoding", "ETag", "Last-Modified", "Cache-Control"} {
+				h.Del(key)
+			}
+			h.Set("Content-Type", "application/json")
+			h.Set("Cache-Control", "no-store")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ErrorResponse{
+				Message:   "internal server error",
+				RequestID: requestIDFromContext(r.Context()),
+			})
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
@@ -0,0 +1,408 @@
+package app
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"go.uber.org/mock/gomock"
+)
+
+func TestCORSOriginAllowed(t *testing.T) {
+	t.Parallel()
+
+	allowed := []string{"http://localhost:3000", "https://*.example.com", "https://*.example.org:8443"}
+	cases := map[string]struct {
+		origin string
+		want   bool
+	}{
+		"ok: exact":                   {origin: "http://localhost:3000", want: true},
+		"ok: subdomain":               {origin: "https://shop.example.com", want: true},
+		"ok: nested subdomain":        {origin: "https://a.b.example.com", want: true},
+		"ok: subdomain with port":     {origin: "https://shop.example.org:8443", want: true},
+		"ng: apex of wildcard":        {origin: "https://example.com"},
+		"ng: other scheme":            {origin: "http://shop.example.com"},
+		"ng: other port":              {origin: "https://shop.example.org"},
+		"ng: suffix without dot":      {origin: "https://evilexample.com"},
+		"ng: allowed domain as label": {origin: "https://shop.example.com.evil.test"},
+		"ng: other port of exact":     {origin: "http://localhost:3001"},
+		"ng: null":                    {origin: "null"},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := corsOriginAllowed(allowed, tt.origin); got != tt.want {
+				t.Errorf("expected %v for %s, got %v", tt.want, tt.origin, got)
+			}
+		})
+	}
+
+	if !corsOriginAllowed([]string{"*"}, "https://anything.test") {
+		t.Error("expected * to allow any origin")
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	t.Parallel()
+
+	cfg := DefaultConfig().CORS
+	cfg.AllowedOrigins = []string{"https://*.example.com"}
+	cfg.ExposedHeaders = []string{"ETag", "Location", requestIDHeader}
+	cfg.AllowCredentials = true
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("POST /items", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("GET /items/{item_id}", func(w http.ResponseWriter, r *http.Request) {})
+	handler := corsMiddleware(mux, mux, cfg)
+
+	type wants struct {
+		code    int
+		headers map[string]string
+	}
+	cases := map[string]struct {
+		method  string
+		path    string
+		headers map[string]string
+		wants
+	}{
+		"ok: simple request": {
+			method:  "GET",
+			path:    "/items",
+			headers: map[string]string{"Origin": "https://shop.example.com"},
+			wants: wants{code: http.StatusOK, headers: map[string]string{
+				"Access-Control-Allow-Origin":      "https://shop.example.com",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Expose-Headers":    "ETag, Location, X-Request-ID",
+				"Vary":                             "Origin",
+			}},
+		},
+		"ok: preflight with methods of the route": {
+			method: "OPTIONS",
+			path:   "/items",
+			headers: map[string]string{
+				"Origin":                         "https://shop.example.com",
+				"Access-Control-Request-Method":  "POST",
+				"Access-Control-Request-Headers": "content-type, x-request-id",
+			},
+			wants: wants{code: http.StatusNoContent, headers: map[string]string{
+				"Access-Control-Allow-Origin":  "https://shop.example.com",
+				"Access-Control-Allow-Methods": "GET, HEAD, POST",
+				"Access-Control-Allow-Headers": "content-type, x-request-id",
+				"Access-Control-Max-Age":       "600",
+			}},
+		},
+		"ng: simple request from another origin": {
+			method:  "GET",
+			path:    "/items",
+			headers: map[string]string{"Origin": "https://evil.test"},
+			wants: wants{code: http.StatusOK, headers: map[string]string{
+				"Access-Control-Allow-Origin": "",
+				"Vary":                        "Origin",
+			}},
+		},
+		"ng: preflight from another origin": {
+			method:  "OPTIONS",
+			path:    "/items",
+			headers: map[string]string{"Origin": "https://evil.test", "Access-Control-Request-Method": "POST"},
+			wants:   wants{code: http.StatusForbidden, headers: map[string]string{"Access-Control-Allow-Origin": ""}},
+		},
+		"ng: preflight for a method the route lacks": {
+			method:  "OPTIONS",
+			path:    "/items/1",
+			headers: map[string]string{"Origin": "https://shop.example.com", "Access-Control-Request-Method": "POST"},
+			wants:   wants{code: http.StatusMethodNotAllowed, headers: map[string]string{"Allow": "GET, HEAD"}},
+		},
+		"ng: preflight for an unknown route": {
+			method:  "OPTIONS",
+			path:    "/unknown",
+			headers: map[string]string{"Origin": "https://shop.example.com", "Access-Control-Request-Method": "GET"},
+			wants:   wants{code: http.StatusNotFound},
+		},
+		"ng: preflight with a header which is not allowed": {
+			method:  "OPTIONS",
+			path:    "/items",
+			headers: map[string]string{"Origin": "https://shop.example.com", "Access-Control-Request-Method": "POST", "Access-Control-Request-Headers": "X-Secret"},
+			wants:   wants{code: http.StatusForbidden},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.code {
+				t.Errorf("expected status code %d, got %d", tt.code, rr.Code)
+			}
+			got := map[string]string{}
+			for k := range tt.wants.headers {
+				got[k] = rr.Header().Get(k)
+			}
+			if diff := cmp.Diff(tt.wants.headers, got, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("unexpected headers (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	t.Parallel()
+
+	proxies, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatalf("failed to parse trusted proxies: %v", err)
+	}
+
+	cases := map[string]struct {
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		"ok: direct client":                   {remoteAddr: "203.0.113.5:1234", want: "203.0.113.5"},
+		"ok: forwarded by a trusted proxy":    {remoteAddr: "10.0.0.2:1234", forwarded: []string{"203.0.113.5"}, want: "203.0.113.5"},
+		"ok: through several trusted proxies": {remoteAddr: "10.0.0.2:1234", forwarded: []string{"203.0.113.5, 192.0.2.1", "10.1.1.1"}, want: "203.0.113.5"},
+		"ok: forged hops left of the client":  {remoteAddr: "10.0.0.2:1234", forwarded: []string{"198.51.100.1, 203.0.113.5"}, want: "203.0.113.5"},
+		"ng: forwarded by an untrusted peer":  {remoteAddr: "203.0.113.9:1234", forwarded: []string{"198.51.100.1"}, want: "203.0.113.9"},
+		"ng: invalid hop stops the walk":      {remoteAddr: "10.0.0.2:1234", forwarded: []string{"unknown"}, want: "10.0.0.2"},
+		"ok: ipv4-mapped peer":                {remoteAddr: "[::ffff:203.0.113.5]:1234", want: "203.0.113.5"},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			if got := proxies.clientIP(req); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSecurityHeadersMiddleware(t *testing.T) {
+	t.Parallel()
+
+	proxies, err := parseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("failed to parse trusted proxies: %v", err)
+	}
+	handler := securityHeadersMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), proxies)
+
+	cases := map[string]struct {
+		remoteAddr string
+		tls        bool
+		proto      string
+		wantHSTS   bool
+	}{
+		"ok: plain http":                  {remoteAddr: "203.0.113.5:1234"},
+		"ok: tls":                         {remoteAddr: "203.0.113.5:1234", tls: true, wantHSTS: true},
+		"ok: https at a trusted proxy":    {remoteAddr: "10.0.0.2:1234", proto: "https", wantHSTS: true},
+		"ok: http at a trusted proxy":     {remoteAddr: "10.0.0.2:1234", proto: "http"},
+		"ng: forged by an untrusted peer": {remoteAddr: "203.0.113.5:1234", proto: "https"},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest("GET", "/images/a.jpg", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			if tt.proto != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if got := rr.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+				t.Errorf("expected X-Content-Type-Options nosniff, got %q", got)
+			}
+			if got := rr.Header().Get("Content-Security-Policy"); got != contentSecurityPolicy {
+				t.Errorf("expected Content-Security-Policy %q, got %q", contentSecurityPolicy, got)
+			}
+			if got := rr.Header().Get("Referrer-Policy"); got != "no-referrer" {
+				t.Errorf("expected Referrer-Policy no-referrer, got %q", got)
+			}
+			if got := rr.Header().Get("Strict-Transport-Security") != ""; got != tt.wantHSTS {
+				t.Errorf("expected HSTS %v, got %v", tt.wantHSTS, got)
+			}
+		})
+	}
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	t.Parallel()
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	cases := map[string]struct {
+		token         string
+		authorization string
+		wantCode      int
+	}{
+		"ok: valid token":      {token: "secret", authorization: "Bearer secret", wantCode: http.StatusOK},
+		"ng: no token":         {token: "secret", wantCode: http.StatusUnauthorized},
+		"ng: wrong token":      {token: "secret", authorization: "Bearer guess", wantCode: http.StatusUnauthorized},
+		"ng: basic auth":       {token: "secret", authorization: "Basic secret", wantCode: http.StatusUnauthorized},
+		"ng: admin API is off": {authorization: "Bearer ", wantCode: http.StatusForbidden},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest("POST", "/categories", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rr := httptest.NewRecorder()
+			adminMiddleware(next, tt.token).ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantCode {
+				t.Errorf("expected status code %d, got %d", tt.wantCode, rr.Code)
+			}
+		})
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		authorization string
+		injector      func(m *MockUserRepository)
+		wantCode      int
+		wantUserID    int
+	}{
+		"ok: user token": {
+			authorization: "Bearer user-token",
+			injector: func(m *MockUserRepository) {
+				m.EXPECT().FindByToken(gomock.Any(), "user-token").Return(User{ID: 7}, nil)
+			},
+			wantCode:   http.StatusOK,
+			wantUserID: 7,
+		},
+		"ok: anonymous": {
+			injector: func(m *MockUserRepository) {},
+			wantCode: http.StatusOK,
+		},
+		"ok: other token is passed on anonymously": {
+			authorization: "Bearer admin-token",
+			injector: func(m *MockUserRepository) {
+				m.EXPECT().FindByToken(gomock.Any(), "admin-token").Return(User{}, errUserNotFound)
+			},
+			wantCode: http.StatusOK,
+		},
+		"ng: database error": {
+			authorization: "Bearer user-token",
+			injector: func(m *MockUserRepository) {
+				m.EXPECT().FindByToken(gomock.Any(), "user-token").Return(User{}, errors.New("database is locked"))
+			},
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockUR := NewMockUserRepository(ctrl)
+			tt.injector(mockUR)
+
+			var gotUserID int
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotUserID, _ = currentUserID(r.Context())
+			})
+			req := httptest.NewRequest("GET", "/items", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rr := httptest.NewRecorder()
+			authMiddleware(next, mockUR).ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantCode {
+				t.Errorf("expected status code %d, got %d", tt.wantCode, rr.Code)
+			}
+			if gotUserID != tt.wantUserID {
+				t.Errorf("expected user %d, got %d", tt.wantUserID, gotUserID)
+			}
+		})
+	}
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ok: panic becomes a JSON 500 with the request ID", func(t *testing.T) {
+		t.Parallel()
+
+		handler := accessLogMiddleware(recoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"stale"`)
+			var repo ItemRepository
+			repo.LoadFromDatabase(r.Context())
+		})))
+		req := httptest.NewRequest("GET", "/items", nil)
+		req.Header.Set(requestIDHeader, "req-1")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusInternalServerError {
+			t.Fatalf("expected status code %d, got %d", http.StatusInternalServerError, rr.Code)
+		}
+		if got := rr.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("expected a JSON response, got %q", got)
+		}
+		if rr.Header().Get("ETag") != "" {
+			t.Error("expected headers of the failed response to be dropped")
+		}
+		var got ErrorResponse
+		if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+			t.Fatalf("failed to decode response body: %v", err)
+		}
+		if diff := cmp.Diff(ErrorResponse{Message: "internal server error", RequestID: "req-1"}, got); diff != "" {
+			t.Errorf("unexpected response (-want +got):\n%s", diff)
+		}
+	})
+
+	cases := map[string]http.HandlerFunc{
+		"ng: deliberate abort is passed on": func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		},
+		"ng: panic after the response started aborts it": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("partial"))
+			panic("boom")
+		},
+	}
+	for name, h := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			defer func() {
+				if p := recover(); p != http.ErrAbortHandler {
+					t.Errorf("expected http.ErrAbortHandler, got %v", p)
+				}
+			}()
+			recoveryMiddleware(h).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		})
+	}
+}
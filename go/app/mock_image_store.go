@@ -0,0 +1,159 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: image_store.go
+//
+// Generated by this command:
+//
+//	mockgen -source=image_store.go -package=app -destination=./mock_image_store.go
+//
+
+// Package app is a generated GoMock package.
+package app
+
+import (
+	context "context"
+	reflect "reflect"
+	time "time"
+
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockImageStore is a mock of ImageStore interface.
+type MockImageStore struct {
+	ctrl     *gomock.Controller
+	recorder *MockImageStoreMockRecorder
+	isgomock struct{}
+}
+
+// MockImageStoreMockRecorder is the mock recorder for MockImageStore.
+type MockImageStoreMockRecorder struct {
+	mock *MockImageStore
+}
+
+// NewMockImageStore creates a new mock instance.
+func NewMockImageStore(ctrl *gomock.Controller) *MockImageStore {
+	mock := &MockImageStore{ctrl: ctrl}
+	mock.recorder = &MockImageStoreMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockImageStore) EXPECT() *MockImageStoreMockRecorder {
+	return m.recorder
+}
+
+// Delete mocks base method.
+func (m *MockImageStore) Delete(ctx context.Context, name string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", ctx, name)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockImageStoreMockRecorder) Delete(ctx, name any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockImageStore)(nil).Delete), ctx, name)
+}
+
+// Exists mocks base method.
+func (m *MockImageStore) Exists(ctx context.Context, name string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Exists", ctx, name)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Exists indicates an expected call of Exists.
+func (mr *MockImageStoreMockRecorder) Exists(ctx, name any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Exists", reflect.TypeOf((*MockImageStore)(nil).Exists), ctx, name)
+}
+
+// Get mocks base method.
+func (m *MockImageStore) Get(ctx context.Context, name string) (*ImageObject, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", ctx, name)
+	ret0, _ := ret[0].(*ImageObject)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockImageStoreMockRecorder) Get(ctx, name any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockImageStore)(nil).Get), ctx, name)
+}
+
+// List mocks base method.
+func (m *MockImageStore) List(ctx context.Context) ([]ImageInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", ctx)
+	ret0, _ := ret[0].([]ImageInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockImageStoreMockRecorder) List(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockImageStore)(nil).List), ctx)
+}
+
+// Put mocks base method.
+func (m *MockImageStore) Put(ctx context.Context, name string, image []byte) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Put", ctx, name, image)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Put indicates an expected call of Put.
+func (mr *MockImageStoreMockRecorder) Put(ctx, name, image any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Put", reflect.TypeOf((*MockImageStore)(nil).Put), ctx, name, image)
+}
+
+// SignedURL mocks base method.
+func (m *MockImageStore) SignedURL(ctx context.Context, name string, expiry time.Duration) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SignedURL", ctx, name, expiry)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SignedURL indicates an expected call of SignedURL.
+func (mr *MockImageStoreMockRecorder) SignedURL(ctx, name, expiry any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SignedURL", reflect.TypeOf((*MockImageStore)(nil).SignedURL), ctx, name, expiry)
+}
+
+// Touch mocks base method.
+func (m *MockImageStore) Touch(ctx context.Context, name string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Touch", ctx, name)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Touch indicates an expected call of Touch.
+func (mr *MockImageStoreMockRecorder) Touch(ctx, name any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Touch", reflect.TypeOf((*MockImageStore)(nil).Touch), ctx, name)
+}
+
+// Verify mocks base method.
+func (m *MockImageStore) Verify(ctx context.Context, name string, image []byte) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Verify", ctx, name, image)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Verify indicates an expected call of Verify.
+func (mr *MockImageStoreMockRecorder) Verify(ctx, name, image any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Verify", reflect.TypeOf((*MockImageStore)(nil).Verify), ctx, name, image)
+}
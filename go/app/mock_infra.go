@@ -40,6 +40,66 @@ func (m *MockItemRepository) EXPECT() *MockItemRepositoryMockRecorder {
 	return m.recorder
 }
 
+// FindItemsByCategory mocks base method.
+func (m *MockItemRepository) FindItemsByCategory(ctx context.Context, categoryID int) ([]Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindItemsByCategory", ctx, categoryID)
+	ret0, _ := ret[0].([]Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindItemsByCategory indicates an expected call of FindItemsByCategory.
+func (mr *MockItemRepositoryMockRecorder) FindItemsByCategory(ctx, categoryID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindItemsByCategory", reflect.TypeOf((*MockItemRepository)(nil).FindItemsByCategory), ctx, categoryID)
+}
+
+// FindItemsByImageHash mocks base method.
+func (m *MockItemRepository) FindItemsByImageHash(ctx context.Context, hash uint64, maxDistance, sellerID int) ([]Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindItemsByImageHash", ctx, hash, maxDistance, sellerID)
+	ret0, _ := ret[0].([]Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindItemsByImageHash indicates an expected call of FindItemsByImageHash.
+func (mr *MockItemRepositoryMockRecorder) FindItemsByImageHash(ctx, hash, maxDistance, sellerID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindItemsByImageHash", reflect.TypeOf((*MockItemRepository)(nil).FindItemsByImageHash), ctx, hash, maxDistance, sellerID)
+}
+
+// FindItemsBySeller mocks base method.
+func (m *MockItemRepository) FindItemsBySeller(ctx context.Context, sellerID, limit, offset int) ([]Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindItemsBySeller", ctx, sellerID, limit, offset)
+	ret0, _ := ret[0].([]Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindItemsBySeller indicates an expected call of FindItemsBySeller.
+func (mr *MockItemRepositoryMockRecorder) FindItemsBySeller(ctx, sellerID, limit, offset any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindItemsBySeller", reflect.TypeOf((*MockItemRepository)(nil).FindItemsBySeller), ctx, sellerID, limit, offset)
+}
+
+// FindSimilarItems mocks base method.
+func (m *MockItemRepository) FindSimilarItems(ctx context.Context, itemID, maxDistance int) ([]Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindSimilarItems", ctx, itemID, maxDistance)
+	ret0, _ := ret[0].([]Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindSimilarItems indicates an expected call of FindSimilarItems.
+func (mr *MockItemRepositoryMockRecorder) FindSimilarItems(ctx, itemID, maxDistance any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindSimilarItems", reflect.TypeOf((*MockItemRepository)(nil).FindSimilarItems), ctx, itemID, maxDistance)
+}
+
 // Insert mocks base method.
 func (m *MockItemRepository) Insert(ctx context.Context, item *Item) error {
 	m.ctrl.T.Helper()
@@ -53,3 +113,47 @@ func (mr *MockItemRepositoryMockRecorder) Insert(ctx, item any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Insert", reflect.TypeOf((*MockItemRepository)(nil).Insert), ctx, item)
 }
+
+// ListImageNames mocks base method.
+func (m *MockItemRepository) ListImageNames(ctx context.Context) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListImageNames", ctx)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListImageNames indicates an expected call of ListImageNames.
+func (mr *MockItemRepositoryMockRecorder) ListImageNames(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListImageNames", reflect.TypeOf((*MockItemRepository)(nil).ListImageNames), ctx)
+}
+
+// LoadFromDatabase mocks base method.
+func (m *MockItemRepository) LoadFromDatabase(ctx context.Context) ([]Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LoadFromDatabase", ctx)
+	ret0, _ := ret[0].([]Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LoadFromDatabase indicates an expected call of LoadFromDatabase.
+func (mr *MockItemRepositoryMockRecorder) LoadFromDatabase(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadFromDatabase", reflect.TypeOf((*MockItemRepository)(nil).LoadFromDatabase), ctx)
+}
+
+// SaveImageHash mocks base method.
+func (m *MockItemRepository) SaveImageHash(ctx context.Context, imageName string, hash uint64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveImageHash", ctx, imageName, hash)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveImageHash indicates an expected call of SaveImageHash.
+func (mr *MockItemRepositoryMockRecorder) SaveImageHash(ctx, imageName, hash any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveImageHash", reflect.TypeOf((*MockItemRepository)(nil).SaveImageHash), ctx, imageName, hash)
+}
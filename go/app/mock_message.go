@@ -0,0 +1,116 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: message.go
+//
+// Generated by this command:
+//
+//	mockgen -source=message.go -package=app -destination=./mock_message.go
+//
+
+// Package app is a generated GoMock package.
+package app
+
+import (
+	context "context"
+	reflect "reflect"
+
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockMessageRepository is a mock of MessageRepository interface.
+type MockMessageRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockMessageRepositoryMockRecorder
+	isgomock struct{}
+}
+
+// MockMessageRepositoryMockRecorder is the mock recorder for MockMessageRepository.
+type MockMessageRepositoryMockRecorder struct {
+	mock *MockMessageRepository
+}
+
+// NewMockMessageRepository creates a new mock instance.
+func NewMockMessageRepository(ctrl *gomock.Controller) *MockMessageRepository {
+	mock := &MockMessageRepository{ctrl: ctrl}
+	mock.recorder = &MockMessageRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMessageRepository) EXPECT() *MockMessageRepositoryMockRecorder {
+	return m.recorder
+}
+
+// Create mocks base method.
+func (m *MockMessageRepository) Create(ctx context.Context, transactionID, senderID int, body, image string) (Message, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", ctx, transactionID, senderID, body, image)
+	ret0, _ := ret[0].(Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockMessageRepositoryMockRecorder) Create(ctx, transactionID, senderID, body, image any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockMessageRepository)(nil).Create), ctx, transactionID, senderID, body, image)
+}
+
+// Get mocks base method.
+func (m *MockMessageRepository) Get(ctx context.Context, transactionID, id int) (Message, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", ctx, transactionID, id)
+	ret0, _ := ret[0].(Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockMessageRepositoryMockRecorder) Get(ctx, transactionID, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockMessageRepository)(nil).Get), ctx, transactionID, id)
+}
+
+// List mocks base method.
+func (m *MockMessageRepository) List(ctx context.Context, transactionID, afterID, limit int) ([]Message, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", ctx, transactionID, afterID, limit)
+	ret0, _ := ret[0].([]Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockMessageRepositoryMockRecorder) List(ctx, transactionID, afterID, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockMessageRepository)(nil).List), ctx, transactionID, afterID, limit)
+}
+
+// MarkRead mocks base method.
+func (m *MockMessageRepository) MarkRead(ctx context.Context, transactionID, readerID, upToID int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkRead", ctx, transactionID, readerID, upToID)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MarkRead indicates an expected call of MarkRead.
+func (mr *MockMessageRepositoryMockRecorder) MarkRead(ctx, transactionID, readerID, upToID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkRead", reflect.TypeOf((*MockMessageRepository)(nil).MarkRead), ctx, transactionID, readerID, upToID)
+}
+
+// UnreadCounts mocks base method.
+func (m *MockMessageRepository) UnreadCounts(ctx context.Context, userID int) ([]UnreadCount, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UnreadCounts", ctx, userID)
+	ret0, _ := ret[0].([]UnreadCount)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UnreadCounts indicates an expected call of UnreadCounts.
+func (mr *MockMessageRepositoryMockRecorder) UnreadCounts(ctx, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnreadCounts", reflect.TypeOf((*MockMessageRepository)(nil).UnreadCounts), ctx, userID)
+}
@@ -0,0 +1,160 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: notification.go
+//
+// Generated by this command:
+//
+//	mockgen -source=notification.go -package=app -destination=./mock_notification.go
+//
+
+// Package app is a generated GoMock package.
+package app
+
+import (
+	context "context"
+	reflect "reflect"
+
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockNotificationRepository is a mock of NotificationRepository interface.
+type MockNotificationRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockNotificationRepositoryMockRecorder
+	isgomock struct{}
+}
+
+// MockNotificationRepositoryMockRecorder is the mock recorder for MockNotificationRepository.
+type MockNotificationRepositoryMockRecorder struct {
+	mock *MockNotificationRepository
+}
+
+// NewMockNotificationRepository creates a new mock instance.
+func NewMockNotificationRepository(ctrl *gomock.Controller) *MockNotificationRepository {
+	mock := &MockNotificationRepository{ctrl: ctrl}
+	mock.recorder = &MockNotificationRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockNotificationRepository) EXPECT() *MockNotificationRepositoryMockRecorder {
+	return m.recorder
+}
+
+// CountUnread mocks base method.
+func (m *MockNotificationRepository) CountUnread(ctx context.Context, userID int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountUnread", ctx, userID)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CountUnread indicates an expected call of CountUnread.
+func (mr *MockNotificationRepositoryMockRecorder) CountUnread(ctx, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountUnread", reflect.TypeOf((*MockNotificationRepository)(nil).CountUnread), ctx, userID)
+}
+
+// Create mocks base method.
+func (m *MockNotificationRepository) Create(ctx context.Context, userID int, notificationType string, data NotificationData) (Notification, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", ctx, userID, notificationType, data)
+	ret0, _ := ret[0].(Notification)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockNotificationRepositoryMockRecorder) Create(ctx, userID, notificationType, data any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockNotificationRepository)(nil).Create), ctx, userID, notificationType, data)
+}
+
+// Cursor mocks base method.
+func (m *MockNotificationRepository) Cursor(ctx context.Context) (NotificationCursor, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Cursor", ctx)
+	ret0, _ := ret[0].(NotificationCursor)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Cursor indicates an expected call of Cursor.
+func (mr *MockNotificationRepositoryMockRecorder) Cursor(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Cursor", reflect.TypeOf((*MockNotificationRepository)(nil).Cursor), ctx)
+}
+
+// List mocks base method.
+func (m *MockNotificationRepository) List(ctx context.Context, userID int, unreadOnly bool, limit, offset int) ([]Notification, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", ctx, userID, unreadOnly, limit, offset)
+	ret0, _ := ret[0].([]Notification)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockNotificationRepositoryMockRecorder) List(ctx, userID, unreadOnly, limit, offset any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockNotificationRepository)(nil).List), ctx, userID, unreadOnly, limit, offset)
+}
+
+// MarkRead mocks base method.
+func (m *MockNotificationRepository) MarkRead(ctx context.Context, userID, upToID int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkRead", ctx, userID, upToID)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MarkRead indicates an expected call of MarkRead.
+func (mr *MockNotificationRepositoryMockRecorder) MarkRead(ctx, userID, upToID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkRead", reflect.TypeOf((*MockNotificationRepository)(nil).MarkRead), ctx, userID, upToID)
+}
+
+// Missed mocks base method.
+func (m *MockNotificationRepository) Missed(ctx context.Context, since NotificationCursor) ([]Event, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Missed", ctx, since)
+	ret0, _ := ret[0].([]Event)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Missed indicates an expected call of Missed.
+func (mr *MockNotificationRepositoryMockRecorder) Missed(ctx, since any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Missed", reflect.TypeOf((*MockNotificationRepository)(nil).Missed), ctx, since)
+}
+
+// Preferences mocks base method.
+func (m *MockNotificationRepository) Preferences(ctx context.Context, userID int) (NotificationPreferences, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Preferences", ctx, userID)
+	ret0, _ := ret[0].(NotificationPreferences)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Preferences indicates an expected call of Preferences.
+func (mr *MockNotificationRepositoryMockRecorder) Preferences(ctx, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Preferences", reflect.TypeOf((*MockNotificationRepository)(nil).Preferences), ctx, userID)
+}
+
+// SetPreferences mocks base method.
+func (m *MockNotificationRepository) SetPreferences(ctx context.Context, userID int, prefs NotificationPreferences) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetPreferences", ctx, userID, prefs)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetPreferences indicates an expected call of SetPreferences.
+func (mr *MockNotificationRepositoryMockRecorder) SetPreferences(ctx, userID, prefs any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetPreferences", reflect.TypeOf((*MockNotificationRepository)(nil).SetPreferences), ctx, userID, prefs)
+}
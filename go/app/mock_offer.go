@@ -0,0 +1,131 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: offer.go
+//
+// Generated by this command:
+//
+//	mockgen -source=offer.go -package=app -destination=./mock_offer.go
+//
+
+// Package app is a generated GoMock package.
+package app
+
+import (
+	context "context"
+	reflect "reflect"
+
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockOfferRepository is a mock of OfferRepository interface.
+type MockOfferRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockOfferRepositoryMockRecorder
+	isgomock struct{}
+}
+
+// MockOfferRepositoryMockRecorder is the mock recorder for MockOfferRepository.
+type MockOfferRepositoryMockRecorder struct {
+	mock *MockOfferRepository
+}
+
+// NewMockOfferRepository creates a new mock instance.
+func NewMockOfferRepository(ctrl *gomock.Controller) *MockOfferRepository {
+	mock := &MockOfferRepository{ctrl: ctrl}
+	mock.recorder = &MockOfferRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockOfferRepository) EXPECT() *MockOfferRepositoryMockRecorder {
+	return m.recorder
+}
+
+// Accept mocks base method.
+func (m *MockOfferRepository) Accept(ctx context.Context, id, userID int) (Offer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Accept", ctx, id, userID)
+	ret0, _ := ret[0].(Offer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Accept indicates an expected call of Accept.
+func (mr *MockOfferRepositoryMockRecorder) Accept(ctx, id, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Accept", reflect.TypeOf((*MockOfferRepository)(nil).Accept), ctx, id, userID)
+}
+
+// Counter mocks base method.
+func (m *MockOfferRepository) Counter(ctx context.Context, id, userID, price int) (Offer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Counter", ctx, id, userID, price)
+	ret0, _ := ret[0].(Offer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Counter indicates an expected call of Counter.
+func (mr *MockOfferRepositoryMockRecorder) Counter(ctx, id, userID, price any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Counter", reflect.TypeOf((*MockOfferRepository)(nil).Counter), ctx, id, userID, price)
+}
+
+// Create mocks base method.
+func (m *MockOfferRepository) Create(ctx context.Context, itemID, buyerID, price int) (Offer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", ctx, itemID, buyerID, price)
+	ret0, _ := ret[0].(Offer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockOfferRepositoryMockRecorder) Create(ctx, itemID, buyerID, price any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockOfferRepository)(nil).Create), ctx, itemID, buyerID, price)
+}
+
+// Decline mocks base method.
+func (m *MockOfferRepository) Decline(ctx context.Context, id, userID int) (Offer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Decline", ctx, id, userID)
+	ret0, _ := ret[0].(Offer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Decline indicates an expected call of Decline.
+func (mr *MockOfferRepositoryMockRecorder) Decline(ctx, id, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Decline", reflect.TypeOf((*MockOfferRepository)(nil).Decline), ctx, id, userID)
+}
+
+// Get mocks base method.
+func (m *MockOfferRepository) Get(ctx context.Context, id int) (Offer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", ctx, id)
+	ret0, _ := ret[0].(Offer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockOfferRepositoryMockRecorder) Get(ctx, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockOfferRepository)(nil).Get), ctx, id)
+}
+
+// ListByItem mocks base method.
+func (m *MockOfferRepository) ListByItem(ctx context.Context, itemID, userID int) ([]Offer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListByItem", ctx, itemID, userID)
+	ret0, _ := ret[0].([]Offer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListByItem indicates an expected call of ListByItem.
+func (mr *MockOfferRepositoryMockRecorder) ListByItem(ctx, itemID, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListByItem", reflect.TypeOf((*MockOfferRepository)(nil).ListByItem), ctx, itemID, userID)
+}
@@ -0,0 +1,86 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: review.go
+//
+// Generated by this command:
+//
+//	mockgen -source=review.go -package=app -destination=./mock_review.go
+//
+
+// Package app is a generated GoMock package.
+package app
+
+import (
+	context "context"
+	reflect "reflect"
+
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockReviewRepository is a mock of ReviewRepository interface.
+type MockReviewRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockReviewRepositoryMockRecorder
+	isgomock struct{}
+}
+
+// MockReviewRepositoryMockRecorder is the mock recorder for MockReviewRepository.
+type MockReviewRepositoryMockRecorder struct {
+	mock *MockReviewRepository
+}
+
+// NewMockReviewRepository creates a new mock instance.
+func NewMockReviewRepository(ctrl *gomock.Controller) *MockReviewRepository {
+	mock := &MockReviewRepository{ctrl: ctrl}
+	mock.recorder = &MockReviewRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockReviewRepository) EXPECT() *MockReviewRepositoryMockRecorder {
+	return m.recorder
+}
+
+// Create mocks base method.
+func (m *MockReviewRepository) Create(ctx context.Context, transactionID, reviewerID int, rating, comment string) (Review, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", ctx, transactionID, reviewerID, rating, comment)
+	ret0, _ := ret[0].(Review)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockReviewRepositoryMockRecorder) Create(ctx, transactionID, reviewerID, rating, comment any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockReviewRepository)(nil).Create), ctx, transactionID, reviewerID, rating, comment)
+}
+
+// ListByReviewee mocks base method.
+func (m *MockReviewRepository) ListByReviewee(ctx context.Context, userID, limit, offset int) ([]Review, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListByReviewee", ctx, userID, limit, offset)
+	ret0, _ := ret[0].([]Review)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListByReviewee indicates an expected call of ListByReviewee.
+func (mr *MockReviewRepositoryMockRecorder) ListByReviewee(ctx, userID, limit, offset any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListByReviewee", reflect.TypeOf((*MockReviewRepository)(nil).ListByReviewee), ctx, userID, limit, offset)
+}
+
+// Update mocks base method.
+func (m *MockReviewRepository) Update(ctx context.Context, id, reviewerID int, rating, comment *string) (Review, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Update", ctx, id, reviewerID, rating, comment)
+	ret0, _ := ret[0].(Review)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Update indicates an expected call of Update.
+func (mr *MockReviewRepositoryMockRecorder) Update(ctx, id, reviewerID, rating, comment any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockReviewRepository)(nil).Update), ctx, id, reviewerID, rating, comment)
+}
@@ -0,0 +1,116 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: saved_search.go
+//
+// Generated by this command:
+//
+//	mockgen -source=saved_search.go -package=app -destination=./mock_saved_search.go
+//
+
+// Package app is a generated GoMock package.
+package app
+
+import (
+	context "context"
+	reflect "reflect"
+
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockSavedSearchRepository is a mock of SavedSearchRepository interface.
+type MockSavedSearchRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockSavedSearchRepositoryMockRecorder
+	isgomock struct{}
+}
+
+// MockSavedSearchRepositoryMockRecorder is the mock recorder for MockSavedSearchRepository.
+type MockSavedSearchRepositoryMockRecorder struct {
+	mock *MockSavedSearchRepository
+}
+
+// NewMockSavedSearchRepository creates a new mock instance.
+func NewMockSavedSearchRepository(ctrl *gomock.Controller) *MockSavedSearchRepository {
+	mock := &MockSavedSearchRepository{ctrl: ctrl}
+	mock.recorder = &MockSavedSearchRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockSavedSearchRepository) EXPECT() *MockSavedSearchRepositoryMockRecorder {
+	return m.recorder
+}
+
+// Create mocks base method.
+func (m *MockSavedSearchRepository) Create(ctx context.Context, userID int, criteria SearchCriteria, notify bool) (SavedSearch, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", ctx, userID, criteria, notify)
+	ret0, _ := ret[0].(SavedSearch)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockSavedSearchRepositoryMockRecorder) Create(ctx, userID, criteria, notify any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockSavedSearchRepository)(nil).Create), ctx, userID, criteria, notify)
+}
+
+// Delete mocks base method.
+func (m *MockSavedSearchRepository) Delete(ctx context.Context, userID, id int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", ctx, userID, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockSavedSearchRepositoryMockRecorder) Delete(ctx, userID, id any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockSavedSearchRepository)(nil).Delete), ctx, userID, id)
+}
+
+// List mocks base method.
+func (m *MockSavedSearchRepository) List(ctx context.Context, userID int) ([]SavedSearch, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", ctx, userID)
+	ret0, _ := ret[0].([]SavedSearch)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockSavedSearchRepositoryMockRecorder) List(ctx, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockSavedSearchRepository)(nil).List), ctx, userID)
+}
+
+// Match mocks base method.
+func (m *MockSavedSearchRepository) Match(ctx context.Context, item Item) ([]SavedSearch, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Match", ctx, item)
+	ret0, _ := ret[0].([]SavedSearch)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Match indicates an expected call of Match.
+func (mr *MockSavedSearchRepositoryMockRecorder) Match(ctx, item any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Match", reflect.TypeOf((*MockSavedSearchRepository)(nil).Match), ctx, item)
+}
+
+// NewItems mocks base method.
+func (m *MockSavedSearchRepository) NewItems(ctx context.Context, userID, id, limit int) ([]Item, bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NewItems", ctx, userID, id, limit)
+	ret0, _ := ret[0].([]Item)
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// NewItems indicates an expected call of NewItems.
+func (mr *MockSavedSearchRepositoryMockRecorder) NewItems(ctx, userID, id, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewItems", reflect.TypeOf((*MockSavedSearchRepository)(nil).NewItems), ctx, userID, id, limit)
+}
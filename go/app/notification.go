@@ -0,0 +1,750 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+)
+
+const (
+	// notificationSendTimeout is how long sending one email may take.
+	notificationSendTimeout = 30 * time.Second
+	// notificationEmailQueueSize is how many emails wait for the sender before new ones are dropped.
+	notificationEmailQueueSize = 256
+)
+
+// types of notifications. The app has no likes or comments on items, so there are no notifications of them.
+const (
+	// NotificationPurchased is sent to the seller when an item is purchased.
+	NotificationPurchased = "purchased"
+	// NotificationCompleted is sent to the seller when the buyer completes the transaction.
+	NotificationCompleted = "completed"
+	// NotificationMessage is sent to a party of a transaction when the other party sends a message.
+	NotificationMessage = "message"
+	// NotificationSavedSearch is sent to a user when an item matching one of their saved searches is listed.
+	NotificationSavedSearch = "saved_search"
+)
+
+// Notification tells a user about something which happened to them.
+type Notification struct {
+	ID        int              `json:"id"`
+	Type      string           `json:"type"`
+	Data      NotificationData `json:"data"`
+	CreatedAt time.Time        `json:"created_at"`
+	// ReadAt is when the user read the notification, or nil while it is unread.
+	ReadAt *time.Time `json:"read_at"`
+}
+
+// NotificationData are the IDs of what a notification is about. Which are set depends on the type.
+type NotificationData struct {
+	ItemID        int `json:"item_id,omitempty"`
+	TransactionID int `json:"transaction_id,omitempty"`
+	MessageID     int `json:"message_id,omitempty"`
+	SavedSearchID int `json:"saved_search_id,omitempty"`
+	// UserID is the user who caused the notification, such as the buyer.
+	UserID int `json:"user_id,omitempty"`
+}
+
+// notificationSubject returns the email subject of a notification type.
+func notificationSubject(notificationType string) string {
+	switch notificationType {
+	case NotificationPurchased:
+		return "Your item was purchased"
+	case NotificationCompleted:
+		return "The buyer received your item"
+	case NotificationMessage:
+		return "You have a new message"
+	case NotificationSavedSearch:
+		return "A new item matches your saved search"
+	}
+	return "You have a new notification"
+}
+
+// ChannelPreference is how a user receives a type of notification.
+type ChannelPreference struct {
+	// InApp stores the notification for GET /notifications.
+	InApp bool `json:"in_app"`
+	// Email emails the notification if the user has an email address.
+	Email bool `json:"email"`
+}
+
+// NotificationPreferences are how a user receives each type of notification.
+type NotificationPreferences struct {
+	// Email is the address notifications are emailed to. Nothing is emailed if it is empty.
+	Email       string            `json:"email" validate:"trim,max=254,email"`
+	Purchased   ChannelPreference `json:"purchased"`
+	Completed   ChannelPreference `json:"completed"`
+	Message     ChannelPreference `json:"message"`
+	SavedSearch ChannelPreference `json:"saved_search"`
+}
+
+// defaultNotificationPreferences are the preferences of users who never changed them:
+// everything is stored in the app, and nothing is emailed until the user opts in.
+var defaultNotificationPreferences = NotificationPreferences{
+	Purchased:   ChannelPreference{InApp: true},
+	Completed:   ChannelPreference{InApp: true},
+	Message:     ChannelPreference{InApp: true},
+	SavedSearch: ChannelPreference{InApp: true},
+}
+
+// channel returns the preference of the notification type, or nil for an unknown type.
+func (p *NotificationPreferences) channel(notificationType string) *ChannelPreference {
+	switch notificationType {
+	case NotificationPurchased:
+		return &p.Purchased
+	case NotificationCompleted:
+		return &p.Completed
+	case NotificationMessage:
+		return &p.Message
+	case NotificationSavedSearch:
+		return &p.SavedSearch
+	}
+	return nil
+}
+
+// notificationTypes are the types with a preference.
+var notificationTypes = []string{NotificationPurchased, NotificationCompleted, NotificationMessage, NotificationSavedSearch}
+
+// Please run `go generate ./...` to generate the mock implementation
+// NotificationRepository is an interface to manage notifications and the preferences of users about them.
+//
+//go:generate go run go.uber.org/mock/mockgen -source=$GOFILE -package=${GOPACKAGE} -destination=./mock_$GOFILE
+type NotificationRepository interface {
+	// Create stores a notification for the user.
+	Create(ctx context.Context, userID int, notificationType string, data NotificationData) (Notification, error)
+	// List returns at most limit notifications of the user, newest first, skipping the first offset.
+	// Read notifications are skipped as well if unreadOnly is set.
+	List(ctx context.Context, userID int, unreadOnly bool, limit, offset int) ([]Notification, error)
+	// CountUnread returns the number of unread notifications of the user.
+	CountUnread(ctx context.Context, userID int) (int, error)
+	// MarkRead marks the notifications of the user up to the notification upToID as read, and returns how many were unread.
+	MarkRead(ctx context.Context, userID, upToID int) (int, error)
+	// Preferences returns the preferences of the user, or errUserNotFound.
+	Preferences(ctx context.Context, userID int) (NotificationPreferences, error)
+	// SetPreferences replaces the preferences of the user, or returns errUserNotFound.
+	SetPreferences(ctx context.Context, userID int, prefs NotificationPreferences) error
+	// Cursor returns the position of the latest purchase, completion, message and item stored.
+	Cursor(ctx context.Context) (NotificationCursor, error)
+	// Missed returns the events of the purchases, completions, messages and items stored after the cursor,
+	// as they were published, so that users can be notified of them after their events were lost.
+	// The events are grouped by kind, oldest first in each.
+	Missed(ctx context.Context, since NotificationCursor) ([]Event, error)
+}
+
+// NotificationCursor is the position of the latest purchase, completion, message and item which users were notified of.
+type NotificationCursor struct {
+	TransactionID int
+	MessageID     int
+	ItemID        int
+	// CompletedAt is when the latest completed transaction was completed. Completion times only have a second's
+	// precision, so Completed are the IDs of the transactions completed at that time.
+	CompletedAt time.Time
+	Completed   []int
+}
+
+// advance moves the cursor past the event, if it is of something the cursor tracks.
+func (c *NotificationCursor) advance(e Event) {
+	switch e.Type {
+	case EventTransactionCreated:
+		var tr Transaction
+		if json.Unmarshal(e.Data, &tr) == nil {
+			c.TransactionID = max(c.TransactionID, tr.ID)
+		}
+	case EventTransactionCompleted:
+		var tr Transaction
+		if json.Unmarshal(e.Data, &tr) != nil || tr.CompletedAt == nil {
+			return
+		}
+		switch {
+		case tr.CompletedAt.After(c.CompletedAt):
+			c.CompletedAt, c.Completed = *tr.CompletedAt, []int{tr.ID}
+		case tr.CompletedAt.Equal(c.CompletedAt):
+			c.Completed = append(c.Completed, tr.ID)
+		}
+	case EventMessageCreated:
+		var msg Message
+		if json.Unmarshal(e.Data, &msg) == nil {
+			c.MessageID = max(c.MessageID, msg.ID)
+		}
+	case EventItemCreated:
+		var item Item
+		if json.Unmarshal(e.Data, &item) == nil {
+			c.ItemID = max(c.ItemID, item.ID)
+		}
+	}
+}
+
+// notificationRepository is an implementation of NotificationRepository
+type notificationRepository struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+// NewNotificationRepository creates a new notificationRepository.
+func NewNotificationRepository(db *sql.DB) NotificationRepository {
+	return &notificationRepository{db: db, now: time.Now}
+}
+
+func (n *notificationRepository) Create(ctx context.Context, userID int, notificationType string, data NotificationData) (Notification, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return Notification{}, err
+	}
+	notification := Notification{Type: notificationType, Data: data, CreatedAt: n.now().UTC()}
+	result, err := n.db.ExecContext(ctx, "INSERT INTO notifications (user_id, type, data, created_at) VALUES (?, ?, ?, ?)",
+		userID, notification.Type, string(encoded), notification.CreatedAt)
+	if err != nil {
+		return Notification{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Notification{}, err
+	}
+	notification.ID = int(id)
+	return notification, nil
+}
+
+func (n *notificationRepository) List(ctx context.Context, userID int, unreadOnly bool, limit, offset int) ([]Notification, error) {
+	rows, err := n.db.QueryContext(ctx, `
+		SELECT id, type, data, created_at, read_at
+		FROM notifications
+		WHERE user_id = ? AND (NOT ? OR read_at IS NULL)
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?`, userID, unreadOnly, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []Notification
+	for rows.Next() {
+		var notification Notification
+		var data string
+		if err := rows.Scan(&notification.ID, &notification.Type, &data, &notification.CreatedAt, &notification.ReadAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(data), &notification.Data); err != nil {
+			return nil, fmt.Errorf("invalid data of notification %d: %w", notification.ID, err)
+		}
+		notifications = append(notifications, notification)
+	}
+	return notifications, rows.Err()
+}
+
+func (n *notificationRepository) CountUnread(ctx context.Context, userID int) (int, error) {
+	var count int
+	err := n.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM notifications WHERE user_id = ? AND read_at IS NULL", userID).Scan(&count)
+	return count, err
+}
+
+func (n *notificationRepository) MarkRead(ctx context.Context, userID, upToID int) (int, error) {
+	result, err := n.db.ExecContext(ctx, "UPDATE notifications SET read_at = ? WHERE user_id = ? AND id <= ? AND read_at IS NULL",
+		n.now().UTC(), userID, upToID)
+	if err != nil {
+		return 0, err
+	}
+	count, err := result.RowsAffected()
+	return int(count), err
+}
+
+func (n *notificationRepository) Preferences(ctx context.Context, userID int) (NotificationPreferences, error) {
+	prefs := defaultNotificationPreferences
+	err := n.db.QueryRowContext(ctx, "SELECT email FROM users WHERE id = ?", userID).Scan(&prefs.Email)
+	if err == sql.ErrNoRows {
+		return NotificationPreferences{}, errUserNotFound
+	}
+	if err != nil {
+		return NotificationPreferences{}, err
+	}
+
+	rows, err := n.db.QueryContext(ctx, "SELECT type, in_app, email FROM notification_preferences WHERE user_id = ?", userID)
+	if err != nil {
+		return NotificationPreferences{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var notificationType string
+		var pref ChannelPreference
+		if err := rows.Scan(&notificationType, &pref.InApp, &pref.Email); err != nil {
+			return NotificationPreferences{}, err
+		}
+		// rows of types which no longer exist are ignored
+		if ch := prefs.channel(notificationType); ch != nil {
+			*ch = pref
+		}
+	}
+	return prefs, rows.Err()
+}
+
+func (n *notificationRepository) SetPreferences(ctx context.Context, userID int, prefs NotificationPreferences) (err error) {
+	tx, err := n.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	result, err := tx.ExecContext(ctx, "UPDATE users SET email = ? WHERE id = ?", prefs.Email, userID)
+	if err != nil {
+		return err
+	}
+	if count, err := result.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return errUserNotFound
+	}
+	for _, notificationType := range notificationTypes {
+		ch := prefs.channel(notificationType)
+		if _, err := tx.ExecContext(ctx, "INSERT OR REPLACE INTO notification_preferences (user_id, type, in_app, email) VALUES (?, ?, ?, ?)",
+			userID, notificationType, ch.InApp, ch.Email); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (n *notificationRepository) Cursor(ctx context.Context) (NotificationCursor, error) {
+	var c NotificationCursor
+	err := n.db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT IFNULL(MAX(id), 0) FROM transactions),
+			(SELECT IFNULL(MAX(id), 0) FROM messages),
+			(SELECT IFNULL(MAX(id), 0) FROM items)`).
+		Scan(&c.TransactionID, &c.MessageID, &c.ItemID)
+	if err != nil {
+		return NotificationCursor{}, err
+	}
+
+	rows, err := n.db.QueryContext(ctx, `
+		SELECT id, completed_at
+		FROM transactions
+		WHERE completed_at = (SELECT MAX(completed_at) FROM transactions)`)
+	if err != nil {
+		return NotificationCursor{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id, &c.CompletedAt); err != nil {
+			return NotificationCursor{}, err
+		}
+		c.Completed = append(c.Completed, id)
+	}
+	return c, rows.Err()
+}
+
+func (n *notificationRepository) Missed(ctx context.Context, since NotificationCursor) ([]Event, error) {
+	var events []Event
+	add := func(eventType string, data any, audience ...int) error {
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		events = append(events, Event{Type: eventType, Data: encoded, audience: audience})
+		return nil
+	}
+
+	// completed_at is stored by CURRENT_TIMESTAMP, so it is compared in the same format
+	rows, err := n.db.QueryContext(ctx, `
+		SELECT id, item_id, seller_id, buyer_id, status, price, created_at, completed_at
+		FROM transactions
+		WHERE id > ? OR completed_at >= ?
+		ORDER BY id`, since.TransactionID, since.CompletedAt.UTC().Format(time.DateTime))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var completed []Transaction
+	for rows.Next() {
+		var tr Transaction
+		if err := rows.Scan(&tr.ID, &tr.ItemID, &tr.SellerID, &tr.BuyerID, &tr.Status, &tr.Price, &tr.CreatedAt, &tr.CompletedAt); err != nil {
+			return nil, err
+		}
+		if tr.ID > since.TransactionID {
+			// the purchase event has the transaction as it was created
+			created := tr
+			created.Status, created.CompletedAt = TransactionTrading, nil
+			if err := add(EventTransactionCreated, created, tr.SellerID, tr.BuyerID); err != nil {
+				return nil, err
+			}
+		}
+		if tr.CompletedAt != nil && !tr.CompletedAt.Before(since.CompletedAt) &&
+			!(tr.CompletedAt.Equal(since.CompletedAt) && slices.Contains(since.Completed, tr.ID)) {
+			completed = append(completed, tr)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(completed, func(a, b Transaction) int { return a.CompletedAt.Compare(*b.CompletedAt) })
+	for _, tr := range completed {
+		if err := add(EventTransactionCompleted, tr, tr.SellerID, tr.BuyerID); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err = n.db.QueryContext(ctx, `
+		SELECT messages.id, messages.transaction_id, messages.sender_id, messages.body, messages.image_name,
+			messages.created_at, messages.read_at, transactions.seller_id, transactions.buyer_id
+		FROM messages
+		JOIN transactions ON messages.transaction_id = transactions.id
+		WHERE messages.id > ?
+		ORDER BY messages.id`, since.MessageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var msg Message
+		var sellerID, buyerID int
+		if err := rows.Scan(&msg.ID, &msg.TransactionID, &msg.SenderID, &msg.Body, &msg.Image, &msg.CreatedAt, &msg.ReadAt, &sellerID, &buyerID); err != nil {
+			return nil, err
+		}
+		if err := add(EventMessageCreated, msg, sellerID, buyerID); err != nil {
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = n.db.QueryContext(ctx, `
+		SELECT items.id, items.name, categories.name AS category, items.category_id, items.image_name, items.price, IFNULL(items.seller_id, 0)
+		FROM items
+		JOIN categories ON items.category_id = categories.id
+		WHERE items.id > ?
+		ORDER BY items.id`, since.ItemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item Item
+		if err := rows.Scan(&item.ID, &item.Name, &item.Category, &item.CategoryID, &item.Image, &item.Price, &item.SellerID); err != nil {
+			return nil, err
+		}
+		if err := add(EventItemCreated, item); err != nil {
+			return nil, err
+		}
+	}
+	return events, rows.Err()
+}
+
+// Notifier turns the events published on an EventBus into notifications of the users concerned,
+// stored for GET /notifications and emailed to the users who opted in.
+type Notifier struct {
+	bus           *EventBus
+	repo          NotificationRepository
+	savedSearches SavedSearchRepository
+	// sender is nil if emails are disabled.
+	sender Sender
+	// emails are sent by their own goroutine, so that a slow mail server doesn't hold up the events.
+	emails chan notificationEmail
+	sub    *eventSubscription
+	// cursor is the latest of what the users were notified of, to catch up from when events are lost.
+	cursor NotificationCursor
+}
+
+// notificationEmail is an email waiting to be sent by the Notifier.
+type notificationEmail struct {
+	userID           int
+	notificationType string
+	to               string
+	subject          string
+	body             string
+}
+
+// NewNotifier creates a Notifier receiving the events published from now on. sender may be nil to disable emails.
+func NewNotifier(ctx context.Context, bus *EventBus, repo NotificationRepository, savedSearches SavedSearchRepository, sender Sender) (*Notifier, error) {
+	// subscribing before Start, so that the events published before the goroutine runs are not missed
+	sub, _ := bus.subscribeAll("")
+	// and before taking the cursor, so that whatever is stored after it is either received or caught up on
+	cursor, err := repo.Cursor(ctx)
+	if err != nil {
+		bus.Unsubscribe(sub)
+		return nil, err
+	}
+	return &Notifier{
+		bus:           bus,
+		repo:          repo,
+		savedSearches: savedSearches,
+		sender:        sender,
+		emails:        make(chan notificationEmail, notificationEmailQueueSize),
+		sub:           sub,
+		cursor:        cursor,
+	}, nil
+}
+
+// Start notifies the users of the events until ctx is done or the bus is closed.
+func (n *Notifier) Start(ctx context.Context) {
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		n.sendEmails(ctx)
+	}()
+	defer func() {
+		close(n.emails)
+		<-sent
+	}()
+
+	var lastEventID string
+	for n.sub != nil {
+		for {
+			var e Event
+			var ok bool
+			select {
+			case <-ctx.Done():
+				n.bus.Unsubscribe(n.sub)
+				return
+			case e, ok = <-n.sub.events:
+			}
+			if !ok {
+				break
+			}
+			n.handle(ctx, e)
+			lastEventID = e.ID
+		}
+
+		// the subscription was closed because we fell behind, or because the bus was closed
+		var missed []Event
+		n.sub, missed = n.bus.subscribeAll(lastEventID)
+		if n.sub != nil && lastEventID == "" {
+			// there is no event to resume after, so what was missed is only in the database
+			n.catchUp(ctx)
+		}
+		for _, e := range missed {
+			n.handle(ctx, e)
+			lastEventID = e.ID
+		}
+	}
+}
+
+// handle notifies the users concerned by the event.
+func (n *Notifier) handle(ctx context.Context, e Event) {
+	defer n.cursor.advance(e)
+	switch e.Type {
+	case EventTransactionCreated, EventTransactionCompleted:
+		var tr Transaction
+		if err := json.Unmarshal(e.Data, &tr); err != nil {
+			slog.Error("failed to decode event: ", "type", e.Type, "error", err)
+			return
+		}
+		notificationType := NotificationPurchased
+		if e.Type == EventTransactionCompleted {
+			notificationType = NotificationCompleted
+		}
+		n.notify(ctx, tr.SellerID, notificationType, NotificationData{ItemID: tr.ItemID, TransactionID: tr.ID, UserID: tr.BuyerID})
+	case EventMessageCreated:
+		var msg Message
+		if err := json.Unmarshal(e.Data, &msg); err != nil {
+			slog.Error("failed to decode event: ", "type", e.Type, "error", err)
+			return
+		}
+		// the audience are the parties of the transaction, and the recipient is the one who didn't send it
+		for _, userID := range e.audience {
+			if userID != msg.SenderID {
+				n.notify(ctx, userID, NotificationMessage, NotificationData{TransactionID: msg.TransactionID, MessageID: msg.ID, UserID: msg.SenderID})
+			}
+		}
+	case EventItemCreated:
+		var item Item
+		if err := json.Unmarshal(e.Data, &item); err != nil {
+			slog.Error("failed to decode event: ", "type", e.Type, "error", err)
+			return
+		}
+		searches, err := n.savedSearches.Match(ctx, item)
+		if err != nil {
+			slog.Error("failed to match saved searches: ", "item_id", item.ID, "error", err)
+			return
+		}
+		// a user is notified once per item, even if several of their searches match it
+		notified := make(map[int]bool)
+		for _, search := range searches {
+			if !notified[search.UserID] {
+				notified[search.UserID] = true
+				n.notify(ctx, search.UserID, NotificationSavedSearch, NotificationData{ItemID: item.ID, SavedSearchID: search.ID, UserID: item.SellerID})
+			}
+		}
+	case EventReset:
+		n.catchUp(ctx)
+	}
+}
+
+// catchUp notifies the users of what was stored after the cursor, when the events of it are no longer available.
+func (n *Notifier) catchUp(ctx context.Context) {
+	missed, err := n.repo.Missed(ctx, n.cursor)
+	if err != nil {
+		slog.Error("failed to catch up on missed notifications: ", "error", err)
+		return
+	}
+	slog.Warn("the notifier fell too far behind; catching up from the database", "events", len(missed))
+	for _, e := range missed {
+		n.handle(ctx, e)
+	}
+}
+
+// notify stores a notification and queues its email as the user prefers.
+func (n *Notifier) notify(ctx context.Context, userID int, notificationType string, data NotificationData) {
+	prefs, err := n.repo.Preferences(ctx, userID)
+	if err != nil {
+		slog.Error("failed to get notification preferences: ", "user_id", userID, "error", err)
+		return
+	}
+	ch := prefs.channel(notificationType)
+	if ch.InApp {
+		if _, err := n.repo.Create(ctx, userID, notificationType, data); err != nil {
+			slog.Error("failed to store notification: ", "user_id", userID, "type", notificationType, "error", err)
+		}
+	}
+	if !ch.Email || prefs.Email == "" || n.sender == nil {
+		return
+	}
+
+	subject := notificationSubject(notificationType)
+	body := subject + ".\n"
+	if data.TransactionID != 0 {
+		body += fmt.Sprintf("\nSee /transactions/%d for the details.\n", data.TransactionID)
+	} else if data.ItemID != 0 {
+		body += fmt.Sprintf("\nSee /items/%d for the details.\n", data.ItemID)
+	}
+	select {
+	case n.emails <- notificationEmail{userID: userID, notificationType: notificationType, to: prefs.Email, subject: subject, body: body}:
+	default:
+		// the mail server is too slow or down; the in-app notification is still stored
+		slog.Error("failed to email notification: ", "user_id", userID, "type", notificationType, "error", "email queue is full")
+	}
+}
+
+// sendEmails sends the queued emails until the queue is closed. Emails still queued when ctx is done are dropped.
+func (n *Notifier) sendEmails(ctx context.Context) {
+	for email := range n.emails {
+		if ctx.Err() != nil {
+			continue
+		}
+		sendCtx, cancel := context.WithTimeout(ctx, notificationSendTimeout)
+		err := n.sender.Send(sendCtx, email.to, email.subject, email.body)
+		cancel()
+		if err != nil {
+			slog.Error("failed to email notification: ", "user_id", email.userID, "type", email.notificationType, "error", err)
+		}
+	}
+}
+
+type GetNotificationsRequest struct {
+	// Unread returns only the unread notifications if set.
+	Unread bool `query:"unread"`
+	// Page is the 1-based page number, 1 if not given.
+	Page    *int `query:"page" validate:"min=1"`
+	PerPage *int `query:"per_page" validate:"min=1,max=100"`
+}
+
+type GetNotificationsResponse struct {
+	Notifications []Notification `json:"notifications"`
+	// Unread is the number of every unread notification, not only on this page.
+	Unread  int `json:"unread"`
+	Page    int `json:"page"`
+	PerPage int `json:"per_page"`
+}
+
+// GetNotifications is a handler to return the notifications of the authenticated user, newest first,
+// for GET /notifications .
+func (s *Handlers) GetNotifications(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req GetNotificationsRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	page, perPage := 1, defaultItemsPerPage
+	if req.Page != nil {
+		page = *req.Page
+	}
+	if req.PerPage != nil {
+		perPage = *req.PerPage
+	}
+	userID, _ := currentUserID(ctx)
+
+	notifications, err := s.notificationRepo.List(ctx, userID, req.Unread, perPage, (page-1)*perPage)
+	if err != nil {
+		s.writeUserError(w, r, err)
+		return
+	}
+	unread, err := s.notificationRepo.CountUnread(ctx, userID)
+	if err != nil {
+		s.writeUserError(w, r, err)
+		return
+	}
+	if notifications == nil {
+		notifications = []Notification{}
+	}
+	writeJSON(w, http.StatusOK, GetNotificationsResponse{Notifications: notifications, Unread: unread, Page: page, PerPage: perPage})
+}
+
+type ReadNotificationsRequest struct {
+	// UpToID is the last notification the user has seen. Omit it to read every notification.
+	UpToID *int `json:"up_to_id" validate:"min=1"`
+}
+
+type ReadNotificationsResponse struct {
+	// Read is the number of notifications which were unread.
+	Read int `json:"read"`
+}
+
+// ReadNotifications is a handler to mark the notifications of the authenticated user read for POST /notifications/read .
+func (s *Handlers) ReadNotifications(w http.ResponseWriter, r *http.Request) {
+	var req ReadNotificationsRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	upToID := math.MaxInt
+	if req.UpToID != nil {
+		upToID = *req.UpToID
+	}
+	userID, _ := currentUserID(r.Context())
+
+	count, err := s.notificationRepo.MarkRead(r.Context(), userID, upToID)
+	if err != nil {
+		s.writeUserError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ReadNotificationsResponse{Read: count})
+}
+
+// GetNotificationPreferences is a handler to return the notification preferences of the authenticated user
+// for GET /notifications/preferences .
+func (s *Handlers) GetNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	userID, _ := currentUserID(r.Context())
+	prefs, err := s.notificationRepo.Preferences(r.Context(), userID)
+	if err != nil {
+		s.writeUserError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, prefs)
+}
+
+// UpdateNotificationPreferences is a handler to replace the notification preferences of the authenticated user
+// for PUT /notifications/preferences . Types left out of the request receive nothing.
+func (s *Handlers) UpdateNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	var prefs NotificationPreferences
+	if err := bind(r, &prefs); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	userID, _ := currentUserID(r.Context())
+
+	if err := s.notificationRepo.SetPreferences(r.Context(), userID, prefs); err != nil {
+		s.writeUserError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, prefs)
+}
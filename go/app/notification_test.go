@@ -0,0 +1,338 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotificationRepository(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sale := newTestSale(t)
+	now := time.Date(2026, 4, 1, 12, 0, 0, 0, time.UTC)
+	repo := &notificationRepository{db: sale.db, now: func() time.Time { return now }}
+
+	purchased, err := repo.Create(ctx, sale.seller.ID, NotificationPurchased, NotificationData{ItemID: sale.itemID, TransactionID: 1, UserID: sale.buyer.ID})
+	if err != nil {
+		t.Fatalf("failed to create notification: %v", err)
+	}
+	message, err := repo.Create(ctx, sale.seller.ID, NotificationMessage, NotificationData{TransactionID: 1, MessageID: 1})
+	if err != nil {
+		t.Fatalf("failed to create notification: %v", err)
+	}
+	if _, err := repo.Create(ctx, sale.buyer.ID, NotificationMessage, NotificationData{TransactionID: 1, MessageID: 2}); err != nil {
+		t.Fatalf("failed to create notification: %v", err)
+	}
+
+	notifications, err := repo.List(ctx, sale.seller.ID, false, 10, 0)
+	if err != nil || len(notifications) != 2 || notifications[0].ID != message.ID || notifications[1].Data != purchased.Data {
+		t.Errorf("expected the notifications of the seller, newest first, got %+v, %v", notifications, err)
+	}
+	if n, err := repo.MarkRead(ctx, sale.seller.ID, purchased.ID); err != nil || n != 1 {
+		t.Errorf("expected 1 notification read, got %d, %v", n, err)
+	}
+	notifications, err = repo.List(ctx, sale.seller.ID, true, 10, 0)
+	if err != nil || len(notifications) != 1 || notifications[0].ID != message.ID || notifications[0].ReadAt != nil {
+		t.Errorf("expected only the unread notification, got %+v, %v", notifications, err)
+	}
+	if count, err := repo.CountUnread(ctx, sale.seller.ID); err != nil || count != 1 {
+		t.Errorf("expected 1 unread notification, got %d, %v", count, err)
+	}
+
+	prefs, err := repo.Preferences(ctx, sale.seller.ID)
+	if err != nil || prefs != defaultNotificationPreferences {
+		t.Errorf("expected the default preferences, got %+v, %v", prefs, err)
+	}
+	prefs.Email = "seller@example.com"
+	prefs.Purchased.Email = true
+	prefs.Message.InApp = false
+	if err := repo.SetPreferences(ctx, sale.seller.ID, prefs); err != nil {
+		t.Fatalf("failed to set preferences: %v", err)
+	}
+	if got, err := repo.Preferences(ctx, sale.seller.ID); err != nil || got != prefs {
+		t.Errorf("expected the preferences to be stored, got %+v, %v", got, err)
+	}
+	if got, err := repo.Preferences(ctx, sale.buyer.ID); err != nil || got != defaultNotificationPreferences {
+		t.Errorf("expected the preferences of others to be unchanged, got %+v, %v", got, err)
+	}
+	if _, err := repo.Preferences(ctx, 999); !errors.Is(err, errUserNotFound) {
+		t.Errorf("expected errUserNotFound, got %v", err)
+	}
+	if err := repo.SetPreferences(ctx, 999, prefs); !errors.Is(err, errUserNotFound) {
+		t.Errorf("expected errUserNotFound, got %v", err)
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sale := newTestSale(t)
+	bus := NewEventBus()
+	t.Cleanup(bus.Close)
+	transactions := publishTransactionRepository(NewTransactionRepository(sale.db), bus)
+	messages := publishMessageRepository(NewMessageRepository(sale.db), transactions, bus)
+	repo := NewNotificationRepository(sale.db)
+	smtpServer := newFakeSMTPServer(t)
+
+	// the seller gets purchases by email too, but no messages at all
+	prefs := defaultNotificationPreferences
+	prefs.Email = "seller@example.com"
+	prefs.Purchased.Email = true
+	prefs.Message = ChannelPreference{}
+	if err := repo.SetPreferences(ctx, sale.seller.ID, prefs); err != nil {
+		t.Fatalf("failed to set preferences: %v", err)
+	}
+	// the buyer wants emails of messages, but has no address
+	prefs = defaultNotificationPreferences
+	prefs.Message.Email = true
+	if err := repo.SetPreferences(ctx, sale.buyer.ID, prefs); err != nil {
+		t.Fatalf("failed to set preferences: %v", err)
+	}
+
+	notifier, err := NewNotifier(ctx, bus, repo, NewSavedSearchRepository(sale.db), NewSMTPSender(SMTPConfig{Addr: smtpServer.addr, From: "noreply@example.com"}))
+	if err != nil {
+		t.Fatalf("failed to create notifier: %v", err)
+	}
+	notifierCtx, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		notifier.Start(notifierCtx)
+	}()
+
+	tr, err := transactions.Create(ctx, sale.itemID, sale.buyer.ID)
+	if err != nil {
+		t.Fatalf("failed to create transaction: %v", err)
+	}
+	if _, err := messages.Create(ctx, tr.ID, sale.buyer.ID, "when can you ship?", ""); err != nil {
+		t.Fatalf("failed to create message: %v", err)
+	}
+	if _, err := messages.Create(ctx, tr.ID, sale.seller.ID, "tomorrow", ""); err != nil {
+		t.Fatalf("failed to create message: %v", err)
+	}
+	m := smtpServer.receive(t)
+	if strings.Join(m.to, ",") != "seller@example.com" || !strings.Contains(m.data, "Subject: Your item was purchased") ||
+		!strings.Contains(m.data, fmt.Sprintf("/transactions/%d", tr.ID)) {
+		t.Errorf("expected the purchase emailed to the seller, got %+v", m)
+	}
+
+	// events are handled in order, so everything before the completion is done when its notification is stored
+	if _, err := transactions.Complete(ctx, tr.ID); err != nil {
+		t.Fatalf("failed to complete transaction: %v", err)
+	}
+	stopAfter := time.Now().Add(5 * time.Second)
+	for {
+		if count, _ := repo.CountUnread(ctx, sale.seller.ID); count == 2 || time.Now().After(stopAfter) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stop()
+	<-done
+
+	seller, err := repo.List(ctx, sale.seller.ID, false, 10, 0)
+	if err != nil || len(seller) != 2 || seller[0].Type != NotificationCompleted || seller[1].Type != NotificationPurchased ||
+		seller[1].Data != (NotificationData{ItemID: sale.itemID, TransactionID: tr.ID, UserID: sale.buyer.ID}) {
+		t.Errorf("expected the purchase and completion without messages for the seller, got %+v, %v", seller, err)
+	}
+	buyer, err := repo.List(ctx, sale.buyer.ID, false, 10, 0)
+	if err != nil || len(buyer) != 1 || buyer[0].Type != NotificationMessage || buyer[0].Data.UserID != sale.seller.ID {
+		t.Errorf("expected the message of the seller for the buyer, got %+v, %v", buyer, err)
+	}
+	select {
+	case m := <-smtpServer.mails:
+		t.Errorf("expected no other email, got %+v", m)
+	default:
+	}
+}
+
+// blockingSender is a Sender whose mail server never answers.
+type blockingSender struct{}
+
+func (blockingSender) Send(ctx context.Context, to, subject, body string) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestNotifierWithSlowSender(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sale := newTestSale(t)
+	bus := NewEventBus()
+	t.Cleanup(bus.Close)
+	transactions := publishTransactionRepository(NewTransactionRepository(sale.db), bus)
+	messages := publishMessageRepository(NewMessageRepository(sale.db), transactions, bus)
+	repo := NewNotificationRepository(sale.db)
+
+	prefs := defaultNotificationPreferences
+	prefs.Email = "seller@example.com"
+	prefs.Message.Email = true
+	if err := repo.SetPreferences(ctx, sale.seller.ID, prefs); err != nil {
+		t.Fatalf("failed to set preferences: %v", err)
+	}
+
+	notifier, err := NewNotifier(ctx, bus, repo, NewSavedSearchRepository(sale.db), blockingSender{})
+	if err != nil {
+		t.Fatalf("failed to create notifier: %v", err)
+	}
+	notifierCtx, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		notifier.Start(notifierCtx)
+	}()
+
+	tr, err := transactions.Create(ctx, sale.itemID, sale.buyer.ID)
+	if err != nil {
+		t.Fatalf("failed to create transaction: %v", err)
+	}
+	// more events than a subscription buffers, each waiting for an email
+	const count = eventBufferSize * 2
+	for i := range count {
+		if _, err := messages.Create(ctx, tr.ID, sale.buyer.ID, fmt.Sprintf("message %d", i), ""); err != nil {
+			t.Fatalf("failed to create message: %v", err)
+		}
+	}
+
+	var unread int
+	stopAfter := time.Now().Add(5 * time.Second)
+	for {
+		if unread, _ = repo.CountUnread(ctx, sale.seller.ID); unread == count+1 || time.Now().After(stopAfter) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stop()
+	<-done
+
+	if unread != count+1 {
+		t.Errorf("expected the purchase and %d messages in the inbox despite the mail server, got %d", count, unread)
+	}
+}
+
+func TestNotifierCatchesUpAfterReset(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sale := newTestSale(t)
+	bus := NewEventBus()
+	t.Cleanup(bus.Close)
+	// the notifier falls behind on the second event, and the first is all the history there is to resume after
+	bus.bufferSize, bus.historySize = 1, 1
+	transactions := publishTransactionRepository(NewTransactionRepository(sale.db), bus)
+	messages := publishMessageRepository(NewMessageRepository(sale.db), transactions, bus)
+	repo := NewNotificationRepository(sale.db)
+
+	notifier, err := NewNotifier(ctx, bus, repo, NewSavedSearchRepository(sale.db), nil)
+	if err != nil {
+		t.Fatalf("failed to create notifier: %v", err)
+	}
+	tr, err := transactions.Create(ctx, sale.itemID, sale.buyer.ID)
+	if err != nil {
+		t.Fatalf("failed to create transaction: %v", err)
+	}
+	for _, senderID := range []int{sale.buyer.ID, sale.seller.ID} {
+		if _, err := messages.Create(ctx, tr.ID, senderID, "hello", ""); err != nil {
+			t.Fatalf("failed to create message: %v", err)
+		}
+	}
+	if _, err := transactions.Complete(ctx, tr.ID); err != nil {
+		t.Fatalf("failed to complete transaction: %v", err)
+	}
+
+	notifierCtx, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		notifier.Start(notifierCtx)
+	}()
+	stopAfter := time.Now().Add(5 * time.Second)
+	for {
+		if count, _ := repo.CountUnread(ctx, sale.buyer.ID); count == 1 || time.Now().After(stopAfter) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stop()
+	<-done
+	// what was caught up on is not notified again
+	notifier.catchUp(ctx)
+
+	seller, err := repo.List(ctx, sale.seller.ID, false, 10, 0)
+	var types []string
+	for _, n := range seller {
+		types = append(types, n.Type)
+	}
+	if err != nil || !slices.Equal(types, []string{NotificationMessage, NotificationCompleted, NotificationPurchased}) {
+		t.Errorf("expected the purchase, completion and message once each for the seller, got %+v, %v", seller, err)
+	}
+	buyer, err := repo.List(ctx, sale.buyer.ID, false, 10, 0)
+	if err != nil || len(buyer) != 1 || buyer[0].Type != NotificationMessage || buyer[0].Data.UserID != sale.seller.ID {
+		t.Errorf("expected the message of the seller for the buyer, got %+v, %v", buyer, err)
+	}
+}
+
+func TestNotificationHandlers(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sale := newTestSale(t)
+	handler := sale.handler()
+	repo := NewNotificationRepository(sale.db)
+	for i := range 3 {
+		if _, err := repo.Create(ctx, sale.seller.ID, NotificationMessage, NotificationData{TransactionID: 1, MessageID: i + 1}); err != nil {
+			t.Fatalf("failed to create notification: %v", err)
+		}
+	}
+
+	if rr := doWithToken(t, handler, "GET", "/notifications", "", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status code %d for anonymous, got %d", http.StatusUnauthorized, rr.Code)
+	}
+	if rr := doWithToken(t, handler, "POST", "/notifications/read", `{"up_to_id":2}`, sale.sellerTok); rr.Code != http.StatusOK || rr.Body.String() != `{"read":2}`+"\n" {
+		t.Errorf("expected 2 notifications read, got %d %s", rr.Code, rr.Body.String())
+	}
+	rr := doWithToken(t, handler, "GET", "/notifications?unread=true&per_page=10", "", sale.sellerTok)
+	var got GetNotificationsResponse
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if rr.Code != http.StatusOK || len(got.Notifications) != 1 || got.Notifications[0].Data.MessageID != 3 || got.Unread != 1 || got.PerPage != 10 {
+		t.Errorf("expected the unread notification, got %d %+v", rr.Code, got)
+	}
+	rr = doWithToken(t, handler, "GET", "/notifications", "", sale.buyerTok)
+	got = GetNotificationsResponse{}
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if rr.Code != http.StatusOK || len(got.Notifications) != 0 || got.Notifications == nil {
+		t.Errorf("expected no notifications for the buyer, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	cases := []struct {
+		name, body string
+		wantCode   int
+	}{
+		{"ng: display name in email", `{"email":"Seller <seller@example.com>"}`, http.StatusUnprocessableEntity},
+		{"ok: opt in to emails", `{"email":" seller@example.com ","purchased":{"in_app":true,"email":true}}`, http.StatusOK},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := doWithToken(t, handler, "PUT", "/notifications/preferences", tt.body, sale.sellerTok); rr.Code != tt.wantCode {
+				t.Errorf("expected status code %d, got %d: %s", tt.wantCode, rr.Code, rr.Body.String())
+			}
+		})
+	}
+	rr = doWithToken(t, handler, "GET", "/notifications/preferences", "", sale.sellerTok)
+	var prefs NotificationPreferences
+	json.Unmarshal(rr.Body.Bytes(), &prefs)
+	want := NotificationPreferences{Email: "seller@example.com", Purchased: ChannelPreference{InApp: true, Email: true}}
+	if rr.Code != http.StatusOK || prefs != want {
+		t.Errorf("expected preferences %+v, got %d %+v", want, rr.Code, prefs)
+	}
+}
@@ -0,0 +1,469 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// offerResponseWindow is how long the other party has to answer an offer or a counter-offer before it expires.
+	offerResponseWindow = 48 * time.Hour
+	// acceptedOfferWindow is how long the buyer has to purchase the item at the price of an accepted offer.
+	acceptedOfferWindow = 24 * time.Hour
+)
+
+var (
+	errOfferNotFound = errors.New("offer not found")
+	// errOfferExists is returned when the buyer already has an open offer on the item.
+	errOfferExists = errors.New("an offer on the item is already open")
+	// errOfferState is returned when the status of the offer doesn't allow the operation.
+	errOfferState = errors.New("offer is not in a state allowing this")
+	// errInvalidOffer is returned for offers which can't be made, such as on one's own item.
+	errInvalidOffer = errors.New("invalid offer")
+)
+
+// statuses of an offer.
+const (
+	// OfferPending is the status while the seller has to answer the price offered by the buyer.
+	OfferPending = "pending"
+	// OfferCountered is the status while the buyer has to answer the price countered by the seller.
+	OfferCountered = "countered"
+	// OfferAccepted is the status after the price was accepted, until the buyer purchases the item at it.
+	OfferAccepted = "accepted"
+	// OfferDeclined is the status after either party declined the price.
+	OfferDeclined = "declined"
+	// OfferExpired is the status of an offer which was not answered, or not purchased once accepted, in time.
+	OfferExpired = "expired"
+	// OfferPurchased is the status after the buyer purchased the item at the price of the offer.
+	OfferPurchased = "purchased"
+)
+
+// Offer is the negotiation of the price of an item between a buyer and its seller.
+// The buyer and the seller take turns to counter the price of the other until one of them accepts or declines it.
+type Offer struct {
+	ID       int `json:"id"`
+	ItemID   int `json:"item_id"`
+	SellerID int `json:"seller_id"`
+	BuyerID  int `json:"buyer_id"`
+	// Price is the latest price, offered by the buyer or countered by the seller.
+	Price     int       `json:"price"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// ExpiresAt is when an open offer expires, or when an accepted offer can no longer be purchased.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// isParty reports whether the user is the seller or the buyer.
+func (o Offer) isParty(userID int) bool {
+	return userID == o.SellerID || userID == o.BuyerID
+}
+
+// turn returns the party who has to answer the offer, or 0 if it can't be answered.
+func (o Offer) turn() int {
+	switch o.Status {
+	case OfferPending:
+		return o.SellerID
+	case OfferCountered:
+		return o.BuyerID
+	}
+	return 0
+}
+
+// expire sets the status of an offer past its expiry to OfferExpired. Expiry is not stored, but applied when offers are read.
+func (o *Offer) expire(now time.Time) {
+	switch o.Status {
+	case OfferPending, OfferCountered, OfferAccepted:
+		if !now.Before(o.ExpiresAt) {
+			o.Status = OfferExpired
+		}
+	}
+}
+
+// Please run `go generate ./...` to generate the mock implementation
+// OfferRepository is an interface to manage the offers of buyers on items.
+//
+//go:generate go run go.uber.org/mock/mockgen -source=$GOFILE -package=${GOPACKAGE} -destination=./mock_$GOFILE
+type OfferRepository interface {
+	// Create makes an offer of the buyer on the item at the price, which the seller has offerResponseWindow to answer.
+	// It returns errItemNotFound, errItemSold, errInvalidOffer if the item has no seller or the buyer is the seller,
+	// or errOfferExists if the buyer already has an open or accepted offer on the item.
+	Create(ctx context.Context, itemID, buyerID, price int) (Offer, error)
+	// Get returns errOfferNotFound if the offer does not exist.
+	Get(ctx context.Context, id int) (Offer, error)
+	// ListByItem returns the offers on the item which the user is a party of, newest first.
+	ListByItem(ctx context.Context, itemID, userID int) ([]Offer, error)
+	// Accept accepts the price of the other party as the user, so that the buyer can purchase the item at it.
+	// Accept, Decline and Counter return errOfferNotFound if the user is not a party, errForbidden if it is the turn
+	// of the other party, errOfferState if the offer can't be answered anymore, or errItemSold if the item was sold.
+	Accept(ctx context.Context, id, userID int) (Offer, error)
+	// Decline declines the price of the other party as the user, which ends the negotiation.
+	Decline(ctx context.Context, id, userID int) (Offer, error)
+	// Counter answers the price of the other party with another price, which the other party has offerResponseWindow to answer.
+	Counter(ctx context.Context, id, userID, price int) (Offer, error)
+}
+
+// offerRepository is an implementation of OfferRepository
+type offerRepository struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+// NewOfferRepository creates a new offerRepository.
+func NewOfferRepository(db *sql.DB) OfferRepository {
+	return &offerRepository{db: db, now: time.Now}
+}
+
+// itemSold reports whether the item has a transaction.
+func itemSold(ctx context.Context, q queryer, itemID int) (bool, error) {
+	var sold bool
+	err := q.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM transactions WHERE item_id = ?)", itemID).Scan(&sold)
+	return sold, err
+}
+
+func (o *offerRepository) Create(ctx context.Context, itemID, buyerID, price int) (Offer, error) {
+	tx, err := o.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Offer{}, err
+	}
+	defer tx.Rollback()
+
+	var sellerID sql.NullInt64
+	err = tx.QueryRowContext(ctx, "SELECT seller_id FROM items WHERE id = ?", itemID).Scan(&sellerID)
+	if err == sql.ErrNoRows {
+		return Offer{}, errItemNotFound
+	}
+	if err != nil {
+		return Offer{}, err
+	}
+	if !sellerID.Valid {
+		return Offer{}, fmt.Errorf("%w: item %d has no seller", errInvalidOffer, itemID)
+	}
+	if int(sellerID.Int64) == buyerID {
+		return Offer{}, fmt.Errorf("%w: sellers can't make offers on their own items", errInvalidOffer)
+	}
+	if sold, err := itemSold(ctx, tx, itemID); err != nil {
+		return Offer{}, err
+	} else if sold {
+		return Offer{}, errItemSold
+	}
+
+	// one negotiation at a time per buyer and item, so that the seller answers the latest price only
+	now := o.now().UTC()
+	offers, err := queryOffers(ctx, tx, now, "SELECT "+offerColumns+" FROM offers WHERE item_id = ? AND buyer_id = ? AND status IN (?, ?, ?)",
+		itemID, buyerID, OfferPending, OfferCountered, OfferAccepted)
+	if err != nil {
+		return Offer{}, err
+	}
+	for _, offer := range offers {
+		if offer.Status != OfferExpired {
+			return Offer{}, errOfferExists
+		}
+	}
+
+	offer := Offer{
+		ItemID:    itemID,
+		SellerID:  int(sellerID.Int64),
+		BuyerID:   buyerID,
+		Price:     price,
+		Status:    OfferPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+		ExpiresAt: now.Add(offerResponseWindow),
+	}
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO offers (item_id, seller_id, buyer_id, price, status, created_at, updated_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		offer.ItemID, offer.SellerID, offer.BuyerID, offer.Price, offer.Status, offer.CreatedAt, offer.UpdatedAt, offer.ExpiresAt)
+	if err != nil {
+		return Offer{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Offer{}, err
+	}
+	offer.ID = int(id)
+	return offer, tx.Commit()
+}
+
+// offerColumns are the columns scanned by scanOffer.
+const offerColumns = "id, item_id, seller_id, buyer_id, price, status, created_at, updated_at, expires_at"
+
+// scanOffer scans a row of offerColumns.
+func scanOffer(row interface{ Scan(dest ...any) error }) (Offer, error) {
+	var offer Offer
+	err := row.Scan(&offer.ID, &offer.ItemID, &offer.SellerID, &offer.BuyerID, &offer.Price, &offer.Status, &offer.CreatedAt, &offer.UpdatedAt, &offer.ExpiresAt)
+	return offer, err
+}
+
+// queryOffers returns the offers of a query selecting offerColumns, expired as of now.
+func queryOffers(ctx context.Context, q queryer, now time.Time, query string, args ...any) ([]Offer, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var offers []Offer
+	for rows.Next() {
+		offer, err := scanOffer(rows)
+		if err != nil {
+			return nil, err
+		}
+		offer.expire(now)
+		offers = append(offers, offer)
+	}
+	return offers, rows.Err()
+}
+
+// getOffer returns the offer expired as of now, or errOfferNotFound.
+func getOffer(ctx context.Context, q queryer, id int, now time.Time) (Offer, error) {
+	offer, err := scanOffer(q.QueryRowContext(ctx, "SELECT "+offerColumns+" FROM offers WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return Offer{}, errOfferNotFound
+	}
+	if err != nil {
+		return Offer{}, err
+	}
+	offer.expire(now)
+	return offer, nil
+}
+
+// acceptedOffer returns the offer of the buyer on the item which was accepted and can still be purchased,
+// or errOfferNotFound.
+func acceptedOffer(ctx context.Context, q queryer, itemID, buyerID int, now time.Time) (Offer, error) {
+	offers, err := queryOffers(ctx, q, now, "SELECT "+offerColumns+" FROM offers WHERE item_id = ? AND buyer_id = ? AND status = ?",
+		itemID, buyerID, OfferAccepted)
+	if err != nil {
+		return Offer{}, err
+	}
+	for _, offer := range offers {
+		if offer.Status == OfferAccepted {
+			return offer, nil
+		}
+	}
+	return Offer{}, errOfferNotFound
+}
+
+func (o *offerRepository) Get(ctx context.Context, id int) (Offer, error) {
+	return getOffer(ctx, o.db, id, o.now().UTC())
+}
+
+func (o *offerRepository) ListByItem(ctx context.Context, itemID, userID int) ([]Offer, error) {
+	return queryOffers(ctx, o.db, o.now().UTC(),
+		"SELECT "+offerColumns+" FROM offers WHERE item_id = ? AND (seller_id = ? OR buyer_id = ?) ORDER BY id DESC",
+		itemID, userID, userID)
+}
+
+func (o *offerRepository) Accept(ctx context.Context, id, userID int) (Offer, error) {
+	return o.answer(ctx, id, userID, func(offer *Offer, now time.Time) {
+		offer.Status = OfferAccepted
+		offer.ExpiresAt = now.Add(acceptedOfferWindow)
+	})
+}
+
+func (o *offerRepository) Decline(ctx context.Context, id, userID int) (Offer, error) {
+	return o.answer(ctx, id, userID, func(offer *Offer, now time.Time) {
+		offer.Status = OfferDeclined
+	})
+}
+
+func (o *offerRepository) Counter(ctx context.Context, id, userID, price int) (Offer, error) {
+	return o.answer(ctx, id, userID, func(offer *Offer, now time.Time) {
+		// the turn passes to the other party
+		if offer.Status == OfferPending {
+			offer.Status = OfferCountered
+		} else {
+			offer.Status = OfferPending
+		}
+		offer.Price = price
+		offer.ExpiresAt = now.Add(offerResponseWindow)
+	})
+}
+
+// answer applies the answer of the user to the offer if it is the turn of the user.
+func (o *offerRepository) answer(ctx context.Context, id, userID int, apply func(offer *Offer, now time.Time)) (Offer, error) {
+	tx, err := o.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Offer{}, err
+	}
+	defer tx.Rollback()
+
+	now := o.now().UTC()
+	offer, err := getOffer(ctx, tx, id, now)
+	if err != nil {
+		return Offer{}, err
+	}
+	if !offer.isParty(userID) {
+		return Offer{}, errOfferNotFound
+	}
+	switch offer.turn() {
+	case 0:
+		return Offer{}, fmt.Errorf("%w: offer %d is %s", errOfferState, id, offer.Status)
+	case userID:
+	default:
+		return Offer{}, fmt.Errorf("%w: the offer is waiting for the other party", errForbidden)
+	}
+	if sold, err := itemSold(ctx, tx, offer.ItemID); err != nil {
+		return Offer{}, err
+	} else if sold {
+		return Offer{}, errItemSold
+	}
+
+	status := offer.Status
+	apply(&offer, now)
+	offer.UpdatedAt = now
+	// the status is checked again, so that an answer which raced this one is not overwritten
+	result, err := tx.ExecContext(ctx, "UPDATE offers SET price = ?, status = ?, updated_at = ?, expires_at = ? WHERE id = ? AND status = ?",
+		offer.Price, offer.Status, offer.UpdatedAt, offer.ExpiresAt, id, status)
+	if err != nil {
+		return Offer{}, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return Offer{}, err
+	}
+	if n == 0 {
+		return Offer{}, fmt.Errorf("%w: offer %d was answered meanwhile", errOfferState, id)
+	}
+	return offer, tx.Commit()
+}
+
+type MakeOfferRequest struct {
+	ItemID int `json:"-" path:"item_id" validate:"required,min=1"`
+	// Price is in yen.
+	Price int `json:"price" validate:"required,min=1,max=9999999"`
+}
+
+// MakeOffer is a handler for a buyer to offer a price for an item for POST /items/{item_id}/offers .
+func (s *Handlers) MakeOffer(w http.ResponseWriter, r *http.Request) {
+	var req MakeOfferRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	buyerID, _ := currentUserID(r.Context())
+
+	offer, err := s.offerRepo.Create(r.Context(), req.ItemID, buyerID, req.Price)
+	if err != nil {
+		s.writeTransactionError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/offers/%d", offer.ID))
+	writeJSON(w, http.StatusCreated, offer)
+}
+
+type GetItemOffersResponse struct {
+	Offers []Offer `json:"offers"`
+}
+
+// GetItemOffers is a handler to return the offers on an item for GET /items/{item_id}/offers .
+// The seller gets every offer, and others only their own.
+func (s *Handlers) GetItemOffers(w http.ResponseWriter, r *http.Request) {
+	var req ItemIDRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	userID, _ := currentUserID(r.Context())
+
+	offers, err := s.offerRepo.ListByItem(r.Context(), req.ID, userID)
+	if err != nil {
+		s.writeTransactionError(w, r, err)
+		return
+	}
+	resp := GetItemOffersResponse{Offers: offers}
+	if resp.Offers == nil {
+		resp.Offers = []Offer{}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// OfferIDRequest is the request of the handlers for /offers/{offer_id} and below.
+type OfferIDRequest struct {
+	ID int `path:"offer_id" validate:"required,min=1"`
+}
+
+// GetOffer is a handler to return an offer to its parties for GET /offers/{offer_id} .
+// Offers of others are reported as not found, so that their existence is not revealed.
+func (s *Handlers) GetOffer(w http.ResponseWriter, r *http.Request) {
+	var req OfferIDRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	userID, _ := currentUserID(r.Context())
+
+	offer, err := s.offerRepo.Get(r.Context(), req.ID)
+	if err == nil && !offer.isParty(userID) {
+		err = errOfferNotFound
+	}
+	if err != nil {
+		s.writeTransactionError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, offer)
+}
+
+// AcceptOffer is a handler to accept the price of the other party for POST /offers/{offer_id}/accept .
+// The buyer can then purchase the item at the price with POST /items/{item_id}/purchase .
+func (s *Handlers) AcceptOffer(w http.ResponseWriter, r *http.Request) {
+	var req OfferIDRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	userID, _ := currentUserID(r.Context())
+
+	offer, err := s.offerRepo.Accept(r.Context(), req.ID, userID)
+	if err != nil {
+		s.writeTransactionError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, offer)
+}
+
+// DeclineOffer is a handler to decline the price of the other party for POST /offers/{offer_id}/decline .
+func (s *Handlers) DeclineOffer(w http.ResponseWriter, r *http.Request) {
+	var req OfferIDRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	userID, _ := currentUserID(r.Context())
+
+	offer, err := s.offerRepo.Decline(r.Context(), req.ID, userID)
+	if err != nil {
+		s.writeTransactionError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, offer)
+}
+
+type CounterOfferRequest struct {
+	ID int `json:"-" path:"offer_id" validate:"required,min=1"`
+	// Price is in yen.
+	Price int `json:"price" validate:"required,min=1,max=9999999"`
+}
+
+// CounterOffer is a handler to answer the price of the other party with another price
+// for POST /offers/{offer_id}/counter .
+func (s *Handlers) CounterOffer(w http.ResponseWriter, r *http.Request) {
+	var req CounterOfferRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	userID, _ := currentUserID(r.Context())
+
+	offer, err := s.offerRepo.Counter(r.Context(), req.ID, userID, req.Price)
+	if err != nil {
+		s.writeTransactionError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, offer)
+}
@@ -0,0 +1,245 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestOfferRepository(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sale := newTestSale(t)
+	if _, err := sale.db.Exec("UPDATE items SET price = 5000 WHERE id = ?", sale.itemID); err != nil {
+		t.Fatalf("failed to set price: %v", err)
+	}
+	other, _, err := NewUserRepository(sale.db).Create(ctx, "other")
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	now := time.Date(2026, 4, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	repo := &offerRepository{db: sale.db, now: clock}
+	transactions := &transactionRepository{db: sale.db, now: clock}
+
+	if _, err := repo.Create(ctx, sale.itemID, sale.seller.ID, 4000); !errors.Is(err, errInvalidOffer) {
+		t.Errorf("expected errInvalidOffer for an offer on one's own item, got %v", err)
+	}
+	if _, err := repo.Create(ctx, 999, sale.buyer.ID, 4000); !errors.Is(err, errItemNotFound) {
+		t.Errorf("expected errItemNotFound, got %v", err)
+	}
+
+	// an offer which is not answered in time expires, and the buyer can offer again
+	late, err := repo.Create(ctx, sale.itemID, other.ID, 2000)
+	if err != nil {
+		t.Fatalf("failed to create offer: %v", err)
+	}
+	now = now.Add(offerResponseWindow)
+	if got, err := repo.Get(ctx, late.ID); err != nil || got.Status != OfferExpired {
+		t.Errorf("expected the offer to expire, got %+v, %v", got, err)
+	}
+	if _, err := repo.Accept(ctx, late.ID, sale.seller.ID); !errors.Is(err, errOfferState) {
+		t.Errorf("expected errOfferState for an expired offer, got %v", err)
+	}
+	retry, err := repo.Create(ctx, sale.itemID, other.ID, 2500)
+	if err != nil {
+		t.Fatalf("failed to offer again after expiry: %v", err)
+	}
+
+	offer, err := repo.Create(ctx, sale.itemID, sale.buyer.ID, 3000)
+	if err != nil {
+		t.Fatalf("failed to create offer: %v", err)
+	}
+	if offer.Status != OfferPending || offer.SellerID != sale.seller.ID || !offer.ExpiresAt.Equal(now.Add(offerResponseWindow)) {
+		t.Errorf("unexpected offer %+v", offer)
+	}
+	if _, err := repo.Create(ctx, sale.itemID, sale.buyer.ID, 3500); !errors.Is(err, errOfferExists) {
+		t.Errorf("expected errOfferExists for a second open offer, got %v", err)
+	}
+
+	type step struct {
+		name   string
+		answer func() (Offer, error)
+		// wantStatus and wantPrice are checked if wantErr is nil.
+		wantStatus string
+		wantPrice  int
+		wantErr    error
+	}
+	steps := []step{
+		{"ng: buyer answers its own offer", func() (Offer, error) { return repo.Accept(ctx, offer.ID, sale.buyer.ID) }, "", 0, errForbidden},
+		{"ng: stranger answers", func() (Offer, error) { return repo.Decline(ctx, offer.ID, other.ID) }, "", 0, errOfferNotFound},
+		{"ok: seller counters", func() (Offer, error) { return repo.Counter(ctx, offer.ID, sale.seller.ID, 4500) }, OfferCountered, 4500, nil},
+		{"ng: seller answers its own counter", func() (Offer, error) { return repo.Accept(ctx, offer.ID, sale.seller.ID) }, "", 0, errForbidden},
+		{"ok: buyer counters back", func() (Offer, error) { return repo.Counter(ctx, offer.ID, sale.buyer.ID, 4000) }, OfferPending, 4000, nil},
+		{"ok: seller accepts", func() (Offer, error) { return repo.Accept(ctx, offer.ID, sale.seller.ID) }, OfferAccepted, 4000, nil},
+		{"ng: seller declines after accepting", func() (Offer, error) { return repo.Decline(ctx, offer.ID, sale.seller.ID) }, "", 0, errOfferState},
+	}
+	// the steps depend on each other, so they run in order
+	for _, tt := range steps {
+		got, err := tt.answer()
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s: expected %v, got %v", tt.name, tt.wantErr, err)
+			}
+			continue
+		}
+		if err != nil || got.Status != tt.wantStatus || got.Price != tt.wantPrice || !got.UpdatedAt.Equal(now) {
+			t.Errorf("%s: expected %s at %d, got %+v, %v", tt.name, tt.wantStatus, tt.wantPrice, got, err)
+		}
+	}
+	if got, _ := repo.Get(ctx, offer.ID); !got.ExpiresAt.Equal(now.Add(acceptedOfferWindow)) {
+		t.Errorf("expected the accepted offer to be purchasable for %v, got %+v", acceptedOfferWindow, got)
+	}
+
+	offers, err := repo.ListByItem(ctx, sale.itemID, sale.seller.ID)
+	if err != nil || len(offers) != 3 || offers[0].ID != offer.ID || offers[2].ID != late.ID || offers[2].Status != OfferExpired {
+		t.Errorf("expected every offer for the seller, newest first, got %+v, %v", offers, err)
+	}
+	if offers, err := repo.ListByItem(ctx, sale.itemID, other.ID); err != nil || len(offers) != 2 || offers[0].ID != retry.ID {
+		t.Errorf("expected only the own offers for a buyer, got %+v, %v", offers, err)
+	}
+
+	// the purchase uses the accepted offer up
+	tr, err := transactions.Create(ctx, sale.itemID, sale.buyer.ID)
+	if err != nil || tr.Price != 4000 {
+		t.Fatalf("expected the purchase at the negotiated price, got %+v, %v", tr, err)
+	}
+	if got, _ := repo.Get(ctx, offer.ID); got.Status != OfferPurchased {
+		t.Errorf("expected the offer to be purchased, got %+v", got)
+	}
+	if _, err := repo.Accept(ctx, retry.ID, sale.seller.ID); !errors.Is(err, errItemSold) {
+		t.Errorf("expected errItemSold for an offer on a sold item, got %v", err)
+	}
+	if _, err := repo.Create(ctx, sale.itemID, other.ID, 3000); !errors.Is(err, errItemSold) {
+		t.Errorf("expected errItemSold for a new offer on a sold item, got %v", err)
+	}
+
+	// an accepted offer which is not purchased in time expires, and the item is sold at its price
+	items := &itemRepository{fileName: t.TempDir() + "/items.json", db: sale.db}
+	item := &Item{Name: "scarf", Category: "Fashion", Image: "b.jpg", Price: 1000, SellerID: sale.seller.ID}
+	if err := items.Insert(ctx, item); err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+	scarf, err := repo.Create(ctx, item.ID, sale.buyer.ID, 800)
+	if err != nil {
+		t.Fatalf("failed to create offer: %v", err)
+	}
+	if _, err := repo.Accept(ctx, scarf.ID, sale.seller.ID); err != nil {
+		t.Fatalf("failed to accept offer: %v", err)
+	}
+	now = now.Add(acceptedOfferWindow)
+	if got, _ := repo.Get(ctx, scarf.ID); got.Status != OfferExpired {
+		t.Errorf("expected the accepted offer to expire, got %+v", got)
+	}
+	if tr, err := transactions.Create(ctx, item.ID, sale.buyer.ID); err != nil || tr.Price != 1000 {
+		t.Errorf("expected the purchase at the price of the item, got %+v, %v", tr, err)
+	}
+}
+
+func TestOfferHandlers(t *testing.T) {
+	t.Parallel()
+
+	sale := newTestSale(t)
+	if _, err := sale.db.Exec("UPDATE items SET price = 5000 WHERE id = ?", sale.itemID); err != nil {
+		t.Fatalf("failed to set price: %v", err)
+	}
+	_, strangerTok, err := NewUserRepository(sale.db).Create(context.Background(), "stranger")
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	handler := sale.handler()
+
+	offers := fmt.Sprintf("/items/%d/offers", sale.itemID)
+	if rr := doWithToken(t, handler, "POST", offers, `{"price":3000}`, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status code %d for an anonymous offer, got %d", http.StatusUnauthorized, rr.Code)
+	}
+	rr := doWithToken(t, handler, "POST", offers, `{"price":3000}`, sale.buyerTok)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	location := rr.Header().Get("Location")
+
+	cases := []struct {
+		name, method, target, body, token string
+		wantCode                          int
+	}{
+		{"ng: no price", "POST", offers, `{}`, strangerTok, http.StatusUnprocessableEntity},
+		{"ng: negative price", "POST", offers, `{"price":-1}`, strangerTok, http.StatusUnprocessableEntity},
+		{"ng: offer on one's own item", "POST", offers, `{"price":3000}`, sale.sellerTok, http.StatusBadRequest},
+		{"ng: second open offer", "POST", offers, `{"price":3500}`, sale.buyerTok, http.StatusConflict},
+		{"ng: unknown item", "POST", "/items/999/offers", `{"price":3000}`, sale.buyerTok, http.StatusNotFound},
+		{"ok: seller sees the offer", "GET", location, "", sale.sellerTok, http.StatusOK},
+		{"ng: stranger can't see the offer", "GET", location, "", strangerTok, http.StatusNotFound},
+		{"ng: stranger can't answer the offer", "POST", location + "/accept", "", strangerTok, http.StatusNotFound},
+		{"ng: buyer can't accept its own offer", "POST", location + "/accept", "", sale.buyerTok, http.StatusForbidden},
+		{"ng: counter without price", "POST", location + "/counter", `{}`, sale.sellerTok, http.StatusUnprocessableEntity},
+		{"ok: seller counters", "POST", location + "/counter", `{"price":4500}`, sale.sellerTok, http.StatusOK},
+		{"ok: buyer accepts the counter", "POST", location + "/accept", "", sale.buyerTok, http.StatusOK},
+		{"ng: buyer declines after accepting", "POST", location + "/decline", "", sale.buyerTok, http.StatusConflict},
+	}
+	// the cases depend on each other, so they run in order
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := doWithToken(t, handler, tt.method, tt.target, tt.body, tt.token); rr.Code != tt.wantCode {
+				t.Errorf("expected status code %d, got %d: %s", tt.wantCode, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	rr = doWithToken(t, handler, "GET", offers, "", strangerTok)
+	if rr.Code != http.StatusOK || rr.Body.String() != `{"offers":[]}`+"\n" {
+		t.Errorf("expected no offers for a stranger, got %d %s", rr.Code, rr.Body.String())
+	}
+	rr = doWithToken(t, handler, "GET", offers, "", sale.sellerTok)
+	var list GetItemOffersResponse
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if rr.Code != http.StatusOK || len(list.Offers) != 1 || list.Offers[0].Status != OfferAccepted || list.Offers[0].Price != 4500 {
+		t.Errorf("expected the accepted offer for the seller, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = doWithToken(t, handler, "POST", fmt.Sprintf("/items/%d/purchase", sale.itemID), "", sale.buyerTok)
+	var tr Transaction
+	json.Unmarshal(rr.Body.Bytes(), &tr)
+	if rr.Code != http.StatusCreated || tr.Price != 4500 {
+		t.Errorf("expected the purchase at the negotiated price, got %d %s", rr.Code, rr.Body.String())
+	}
+	rr = doWithToken(t, handler, "GET", location, "", sale.buyerTok)
+	var offer Offer
+	json.Unmarshal(rr.Body.Bytes(), &offer)
+	if offer.Status != OfferPurchased {
+		t.Errorf("expected the offer to be purchased, got %s", rr.Body.String())
+	}
+}
+
+func TestOfferOnSearchedItem(t *testing.T) {
+	t.Parallel()
+
+	sale := newTestSale(t)
+	if _, err := sale.db.Exec("UPDATE items SET price = 5000 WHERE id = ?", sale.itemID); err != nil {
+		t.Fatalf("failed to set price: %v", err)
+	}
+	handler := sale.handler()
+
+	// a buyer only knows the items from the search results
+	rr := doWithToken(t, handler, "GET", "/search?keyword=jacket", "", sale.buyerTok)
+	var found []struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&found); err != nil || len(found) != 1 || found[0].ID == 0 {
+		t.Fatalf("expected the found item with its ID, got %+v, %v", found, err)
+	}
+
+	rr = doWithToken(t, handler, "POST", fmt.Sprintf("/items/%d/offers", found[0].ID), `{"price":3000}`, sale.buyerTok)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	var offer Offer
+	if err := json.NewDecoder(rr.Body).Decode(&offer); err != nil || offer.ItemID != sale.itemID {
+		t.Errorf("expected an offer on item %d, got %+v, %v", sale.itemID, offer, err)
+	}
+}
@@ -0,0 +1,94 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"math/bits"
+)
+
+// similarImageMaxDistance is the largest Hamming distance between two dHashes
+// for which the images are considered near-identical, out of 64 bits.
+const similarImageMaxDistance = 10
+
+// maxImagePixels is the largest width times height of an image which is decoded.
+// Decoding allocates for every pixel, so a small file of huge dimensions would take gigabytes.
+const maxImagePixels = 40_000_000
+
+// errImageTooLarge is returned for images with more than maxImagePixels.
+var errImageTooLarge = errors.New("image has too many pixels")
+
+// imageDHash decodes an image and returns its difference hash.
+// It returns errImageTooLarge without decoding the pixels if the image has more than maxImagePixels.
+func imageDHash(data []byte) (uint64, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode image: %w", err)
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxImagePixels {
+		return 0, fmt.Errorf("%w: %dx%d is more than %d", errImageTooLarge, cfg.Width, cfg.Height, maxImagePixels)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return dHash(img), nil
+}
+
+// dHash computes the difference hash of an image.
+// The image is shrunk to 9x8 grayscale pixels and each bit records whether a pixel is brighter than its right neighbour,
+// so the hash survives re-encoding, resizing and small crops or color adjustments, unlike sha256.
+func dHash(img image.Image) uint64 {
+	const w, h = 9, 8
+	var gray [h][w]float64
+
+	bounds := img.Bounds()
+	for y := 0; y < h; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/h
+		y1 := max(bounds.Min.Y+(y+1)*bounds.Dy()/h, y0+1)
+		for x := 0; x < w; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/w
+			x1 := max(bounds.Min.X+(x+1)*bounds.Dx()/w, x0+1)
+			gray[y][x] = averageLuminance(img, image.Rect(x0, y0, x1, y1))
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w-1; x++ {
+			hash <<= 1
+			if gray[y][x] > gray[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// averageLuminance returns the mean luminance of the pixels in r, sampling at most 8x8 of them for speed.
+func averageLuminance(img image.Image, r image.Rectangle) float64 {
+	stepX := max(r.Dx()/8, 1)
+	stepY := max(r.Dy()/8, 1)
+
+	var sum float64
+	var n int
+	for y := r.Min.Y; y < r.Max.Y; y += stepY {
+		for x := r.Min.X; x < r.Max.X; x += stepX {
+			cr, cg, cb, _ := img.At(x, y).RGBA()
+			sum += 0.299*float64(cr) + 0.587*float64(cg) + 0.114*float64(cb)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+// hammingDistance returns the number of differing bits between two hashes.
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -0,0 +1,155 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// gradientImage draws a diagonal gradient with a bright square, shifted in brightness by offset.
+func gradientImage(w, h int, offset uint8, flip bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8((x*200/w + y*50/h) / 2)
+			if flip {
+				v = 125 - v
+			}
+			if x > w/3 && x < w/2 && y > h/3 && y < h/2 {
+				v = 230
+			}
+			img.Set(x, y, color.RGBA{R: v + offset, G: v + offset, B: v + offset, A: 255})
+		}
+	}
+	return img
+}
+
+func encodeJPEG(t *testing.T, img image.Image, quality int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatalf("failed to encode jpeg: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestImageDHash(t *testing.T) {
+	t.Parallel()
+
+	original, err := imageDHash(encodeJPEG(t, gradientImage(320, 240, 0, false), 90))
+	if err != nil {
+		t.Fatalf("failed to hash image: %v", err)
+	}
+
+	cases := map[string]struct {
+		image   []byte
+		similar bool
+	}{
+		"ok: re-encoded with lower quality": {
+			image:   encodeJPEG(t, gradientImage(320, 240, 0, false), 40),
+			similar: true,
+		},
+		"ok: resized and brightened": {
+			image:   encodeJPEG(t, gradientImage(640, 480, 20, false), 90),
+			similar: true,
+		},
+		"ok: slightly cropped": {
+			image:   encodeJPEG(t, gradientImage(320, 240, 0, false).(*image.RGBA).SubImage(image.Rect(4, 4, 316, 236)), 90),
+			similar: true,
+		},
+		"ng: different image": {
+			image:   encodeJPEG(t, gradientImage(320, 240, 0, true), 90),
+			similar: false,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := imageDHash(tt.image)
+			if err != nil {
+				t.Fatalf("failed to hash image: %v", err)
+			}
+			distance := hammingDistance(original, got)
+			if similar := distance <= similarImageMaxDistance; similar != tt.similar {
+				t.Errorf("expected similar=%v, got distance %d", tt.similar, distance)
+			}
+		})
+	}
+}
+
+func TestImageDHashInvalidImage(t *testing.T) {
+	t.Parallel()
+
+	if _, err := imageDHash([]byte("not an image")); err == nil {
+		t.Error("expected error for invalid image")
+	}
+}
+
+// pngHeader returns the start of a PNG of the size, which has no pixel data.
+func pngHeader(w, h uint32) []byte {
+	ihdr := make([]byte, 0, 17)
+	ihdr = append(ihdr, "IHDR"...)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, w)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, h)
+	// 8-bit RGBA, no interlace
+	ihdr = append(ihdr, 8, 6, 0, 0, 0)
+
+	data := []byte("\x89PNG\r\n\x1a\n")
+	data = binary.BigEndian.AppendUint32(data, uint32(len(ihdr)-4))
+	data = append(data, ihdr...)
+	return binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(ihdr))
+}
+
+func TestImageDHashTooManyPixels(t *testing.T) {
+	t.Parallel()
+
+	if _, err := imageDHash(pngHeader(12000, 12000)); !errors.Is(err, errImageTooLarge) {
+		t.Errorf("expected errImageTooLarge, got %v", err)
+	}
+}
+
+func TestFindItemsByImageHash(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sale := newTestSale(t)
+	items := &itemRepository{fileName: t.TempDir() + "/items.json", db: sale.db}
+	hash, err := imageDHash(encodeJPEG(t, gradientImage(320, 240, 0, false), 90))
+	if err != nil {
+		t.Fatalf("failed to hash image: %v", err)
+	}
+	if err := items.SaveImageHash(ctx, "a.jpg", hash); err != nil {
+		t.Fatalf("failed to save image hash: %v", err)
+	}
+
+	cases := []struct {
+		name     string
+		sellerID int
+		want     int
+	}{
+		{"ok: listing of another seller", sale.buyer.ID, 1},
+		{"ok: anonymous upload", 0, 1},
+		{"ng: own listing", sale.seller.ID, 0},
+	}
+	for _, tt := range cases {
+		if got, err := items.FindItemsByImageHash(ctx, hash, similarImageMaxDistance, tt.sellerID); err != nil || len(got) != tt.want {
+			t.Errorf("%s: expected %d items, got %+v, %v", tt.name, tt.want, got, err)
+		}
+	}
+
+	if _, err := NewTransactionRepository(sale.db).Create(ctx, sale.itemID, sale.buyer.ID); err != nil {
+		t.Fatalf("failed to create transaction: %v", err)
+	}
+	if got, err := items.FindItemsByImageHash(ctx, hash, similarImageMaxDistance, sale.buyer.ID); err != nil || len(got) != 0 {
+		t.Errorf("expected no items once the listing is sold, got %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,216 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimitCleanupInterval is how often the in-memory store drops buckets which have refilled completely.
+const rateLimitCleanupInterval = time.Minute
+
+// RateLimit is a token bucket policy: Requests tokens are refilled every Period, and up to Burst tokens are kept.
+// Requests of zero or less disables limiting.
+type RateLimit struct {
+	Requests int           `yaml:"requests"`
+	Period   time.Duration `yaml:"period"`
+	// Burst is the size of the bucket. Zero means Requests.
+	Burst int `yaml:"burst"`
+}
+
+// capacity returns the size of the bucket.
+func (l RateLimit) capacity() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.Requests)
+}
+
+// rate returns the refill rate in tokens per second.
+func (l RateLimit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// RateLimitResult is the state of a bucket after a request took a token from it.
+type RateLimitResult struct {
+	Allowed bool
+	// Remaining is the number of requests which can be made right now.
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until the next request is allowed, if this one was not.
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps the token buckets.
+// The in-memory store works for a single server; a shared store such as Redis can implement this interface
+// to enforce the limits across replicas.
+type RateLimitStore interface {
+	// Take takes a token from the bucket under key, creating a full bucket if there is none.
+	Take(ctx context.Context, key string, limit RateLimit, now time.Time) (RateLimitResult, error)
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	// fullAt is when the bucket has refilled completely, after which it can be dropped.
+	fullAt time.Time
+}
+
+// memoryRateLimitStore is a RateLimitStore in memory.
+type memoryRateLimitStore struct {
+	mu          sync.Mutex
+	buckets     map[string]*tokenBucket
+	lastCleanup time.Time
+}
+
+// NewMemoryRateLimitStore creates a new RateLimitStore in memory.
+func NewMemoryRateLimitStore() RateLimitStore {
+	return &memoryRateLimitStore{buckets: map[string]*tokenBucket{}}
+}
+
+func (s *memoryRateLimitStore) Take(ctx context.Context, key string, limit RateLimit, now time.Time) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastCleanup) >= rateLimitCleanupInterval {
+		s.cleanup(now)
+	}
+
+	capacity, rate := limit.capacity(), limit.rate()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: capacity, last: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	result := RateLimitResult{}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsToDuration((1 - b.tokens) / rate)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = secondsToDuration((capacity - b.tokens) / rate)
+	b.fullAt = now.Add(result.Reset)
+	return result, nil
+}
+
+// cleanup drops the buckets which have refilled completely.
+// A dropped bucket is recreated full, so this doesn't change any result.
+func (s *memoryRateLimitStore) cleanup(now time.Time) {
+	for key, b := range s.buckets {
+		if !now.Before(b.fullAt) {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastCleanup = now
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// rateLimiter limits requests per client and route.
+type rateLimiter struct {
+	store        RateLimitStore
+	defaultLimit RateLimit
+	routes       map[string]RateLimit
+	perAddress   RateLimit
+	proxies      trustedProxies
+	now          func() time.Time
+}
+
+// newRateLimiter creates a new rateLimiter with the limits of cfg kept in store.
+// Clients behind proxies are identified by X-Forwarded-For.
+func newRateLimiter(cfg RateLimitConfig, proxies trustedProxies, store RateLimitStore) *rateLimiter {
+	return &rateLimiter{
+		store:        store,
+		defaultLimit: cfg.Default,
+		routes:       cfg.Routes,
+		perAddress:   cfg.PerAddress,
+		proxies:      proxies,
+		now:          time.Now,
+	}
+}
+
+// limitFor returns the limit of the route pattern.
+func (l *rateLimiter) limitFor(pattern string) RateLimit {
+	if limit, ok := l.routes[pattern]; ok {
+		return limit
+	}
+	return l.defaultLimit
+}
+
+// rateLimitMiddleware limits requests per client and per route pattern on mux, answering 429 when a bucket is empty.
+// The state of the bucket is reported with the RateLimit-* headers of the IETF draft.
+func rateLimitMiddleware(next http.Handler, mux *http.ServeMux, l *rateLimiter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		limit := l.limitFor(pattern)
+		if pattern == "" || limit.Requests <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// requests of users authenticated by authMiddleware are limited per user instead of per address
+		client := "ip:" + l.proxies.clientIP(r)
+		if id, ok := currentUserID(r.Context()); ok {
+			client = "user:" + strconv.Itoa(id)
+		}
+		if l.allow(w, r, pattern+"|"+client, limit, client, pattern) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// addressRateLimitMiddleware limits all the requests per address, whatever their token, before authMiddleware looks it up.
+// Routes whose limit is disabled, such as probes, are exempt.
+func addressRateLimitMiddleware(next http.Handler, mux *http.ServeMux, l *rateLimiter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		if l.perAddress.Requests <= 0 || pattern == "" || l.limitFor(pattern).Requests <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client := "ip:" + l.proxies.clientIP(r)
+		if l.allow(w, r, "address|"+client, l.perAddress, client, pattern) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// allow takes a token from the bucket under key and reports it in the headers, answering 429 if the bucket is empty.
+func (l *rateLimiter) allow(w http.ResponseWriter, r *http.Request, key string, limit RateLimit, client, pattern string) bool {
+	result, err := l.store.Take(r.Context(), key, limit, l.now())
+	if err != nil {
+		// an unavailable store must not take the API down with it
+		loggerFromContext(r.Context()).Error("failed to check rate limit: ", "error", err)
+		return true
+	}
+
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(int(limit.capacity())))
+	h.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d;burst=%d", limit.Requests, ceilSeconds(limit.Period), int(limit.capacity())))
+	if !result.Allowed {
+		h.Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+		loggerFromContext(r.Context()).Warn("rate limit exceeded", "client", client, "route", pattern)
+		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,170 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/mock/gomock"
+)
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryRateLimitStore()
+	limit := RateLimit{Requests: 1, Period: time.Second, Burst: 2}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	steps := []struct {
+		after      time.Duration
+		allowed    bool
+		remaining  int
+		retryAfter time.Duration
+	}{
+		{allowed: true, remaining: 1},
+		{allowed: true, remaining: 0},
+		{allowed: false, remaining: 0, retryAfter: time.Second},
+		{after: 500 * time.Millisecond, allowed: false, remaining: 0, retryAfter: 500 * time.Millisecond},
+		{after: 500 * time.Millisecond, allowed: true, remaining: 0},
+		// the bucket never holds more than the burst
+		{after: time.Hour, allowed: true, remaining: 1},
+	}
+	for i, step := range steps {
+		now = now.Add(step.after)
+		got, err := store.Take(ctx, "key", limit, now)
+		if err != nil {
+			t.Fatalf("step %d: unexpected error: %v", i, err)
+		}
+		if got.Allowed != step.allowed || got.Remaining != step.remaining || got.RetryAfter != step.retryAfter {
+			t.Errorf("step %d: expected allowed=%v remaining=%d retry_after=%s, got %+v", i, step.allowed, step.remaining, step.retryAfter, got)
+		}
+	}
+
+	// other keys have their own buckets
+	if got, _ := store.Take(ctx, "other", limit, now); !got.Allowed {
+		t.Error("expected another key to be allowed")
+	}
+
+	// full buckets are dropped
+	store.Take(ctx, "key", limit, now.Add(time.Hour))
+	if n := len(store.(*memoryRateLimitStore).buckets); n != 1 {
+		t.Errorf("expected full buckets to be dropped, got %d buckets", n)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	t.Parallel()
+
+	cfg := RateLimitConfig{
+		Default: RateLimit{Requests: 100, Period: time.Minute},
+		Routes: map[string]RateLimit{
+			"POST /items":  {Requests: 1, Period: time.Minute},
+			"GET /healthz": {},
+		},
+	}
+	limiter := newRateLimiter(cfg, nil, NewMemoryRateLimitStore())
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+
+	mux := http.NewServeMux()
+	for _, pattern := range []string{"POST /items", "GET /items", "GET /healthz"} {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {})
+	}
+	handler := rateLimitMiddleware(mux, mux, limiter)
+
+	do := func(method, path, remoteAddr string, userID int) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = remoteAddr
+		if userID != 0 {
+			req = req.WithContext(context.WithValue(req.Context(), authUserKey{}, userID))
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do("POST", "/items", "203.0.113.5:1", 0)
+	if rr.Code != http.StatusOK || rr.Header().Get("RateLimit-Remaining") != "0" || rr.Header().Get("RateLimit-Policy") != "1;w=60;burst=1" {
+		t.Errorf("expected the first upload to be allowed, got %d %v", rr.Code, rr.Header())
+	}
+
+	rr = do("POST", "/items", "203.0.113.5:2", 0)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status code %d, got %d", http.StatusTooManyRequests, rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("expected Retry-After 60, got %q", got)
+	}
+
+	// reads have their own, looser bucket
+	if rr := do("GET", "/items", "203.0.113.5:3", 0); rr.Code != http.StatusOK || rr.Header().Get("RateLimit-Limit") != "100" {
+		t.Errorf("expected reads to be allowed under the default limit, got %d %v", rr.Code, rr.Header())
+	}
+	// other clients and users have their own buckets
+	if rr := do("POST", "/items", "203.0.113.6:1", 0); rr.Code != http.StatusOK {
+		t.Errorf("expected another address to be allowed, got %d", rr.Code)
+	}
+	if rr := do("POST", "/items", "203.0.113.5:4", 1); rr.Code != http.StatusOK {
+		t.Errorf("expected an authenticated user to be limited separately, got %d", rr.Code)
+	}
+	// unlimited routes get no headers
+	if rr := do("GET", "/healthz", "203.0.113.5:5", 0); rr.Code != http.StatusOK || rr.Header().Get("RateLimit-Limit") != "" {
+		t.Errorf("expected /healthz to be unlimited, got %d %v", rr.Code, rr.Header())
+	}
+
+	now = now.Add(time.Minute)
+	if rr := do("POST", "/items", "203.0.113.5:6", 0); rr.Code != http.StatusOK {
+		t.Errorf("expected the upload to be allowed after the bucket refilled, got %d", rr.Code)
+	}
+}
+
+func TestAddressRateLimitMiddleware(t *testing.T) {
+	t.Parallel()
+
+	cfg := RateLimitConfig{
+		Default:    RateLimit{Requests: 100, Period: time.Minute},
+		Routes:     map[string]RateLimit{"GET /healthz": {}},
+		PerAddress: RateLimit{Requests: 2, Period: time.Minute},
+	}
+	limiter := newRateLimiter(cfg, nil, NewMemoryRateLimitStore())
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+
+	mux := http.NewServeMux()
+	for _, pattern := range []string{"GET /items", "GET /healthz"} {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {})
+	}
+	// tokens are only looked up for the requests within the address limit
+	users := NewMockUserRepository(gomock.NewController(t))
+	users.EXPECT().FindByToken(gomock.Any(), gomock.Any()).Return(User{}, errUserNotFound).Times(4)
+	handler := addressRateLimitMiddleware(authMiddleware(rateLimitMiddleware(mux, mux, limiter), users), mux, limiter)
+
+	do := func(path, remoteAddr, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i, token := range []string{"guess1", "guess2"} {
+		if rr := do("/items", "203.0.113.5:1", token); rr.Code != http.StatusOK {
+			t.Fatalf("expected request %d to be allowed, got %d", i, rr.Code)
+		}
+	}
+	// a new token doesn't get a new bucket
+	rr := do("/items", "203.0.113.5:2", "guess3")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "30" {
+		t.Errorf("expected status code %d after 30 seconds, got %d %v", http.StatusTooManyRequests, rr.Code, rr.Header())
+	}
+	if rr := do("/items", "203.0.113.6:1", "guess4"); rr.Code != http.StatusOK {
+		t.Errorf("expected another address to be allowed, got %d", rr.Code)
+	}
+	if rr := do("/healthz", "203.0.113.5:3", "guess5"); rr.Code != http.StatusOK {
+		t.Errorf("expected /healthz to be exempt, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,299 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// reviewEditWindow is how long a review can be edited after it is left. Afterwards it is immutable,
+// so that ratings can't be changed to pressure the other party long after the transaction.
+const reviewEditWindow = 24 * time.Hour
+
+var (
+	errReviewNotFound = errors.New("review not found")
+	// errReviewExists is returned when the party already reviewed the transaction.
+	errReviewExists = errors.New("transaction is already reviewed")
+	// errReviewLocked is returned when a review is edited after reviewEditWindow.
+	errReviewLocked = errors.New("review can no longer be edited")
+)
+
+// ratings of a review.
+const (
+	RatingGood   = "good"
+	RatingNormal = "normal"
+	RatingBad    = "bad"
+)
+
+// Review is the rating a party of a completed transaction gave the other party.
+type Review struct {
+	ID            int       `json:"id"`
+	TransactionID int       `json:"transaction_id"`
+	ReviewerID    int       `json:"reviewer_id"`
+	RevieweeID    int       `json:"reviewee_id"`
+	Rating        string    `json:"rating"`
+	Comment       string    `json:"comment"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	// EditableUntil is when the review becomes immutable, so that clients know whether to offer editing.
+	EditableUntil time.Time `json:"editable_until"`
+}
+
+// Please run `go generate ./...` to generate the mock implementation
+// ReviewRepository is an interface to manage reviews.
+//
+//go:generate go run go.uber.org/mock/mockgen -source=$GOFILE -package=${GOPACKAGE} -destination=./mock_$GOFILE
+type ReviewRepository interface {
+	// Create leaves the review of the reviewer on the other party of the transaction.
+	// It returns errTransactionNotFound if the reviewer is not a party, errTransactionState if the transaction
+	// is not completed, and errReviewExists if the reviewer already reviewed it.
+	Create(ctx context.Context, transactionID, reviewerID int, rating, comment string) (Review, error)
+	// Update changes the rating and the comment of a review. Nil values are left as they are.
+	// It returns errReviewNotFound, errForbidden if the user is not the reviewer,
+	// and errReviewLocked after reviewEditWindow.
+	Update(ctx context.Context, id, reviewerID int, rating, comment *string) (Review, error)
+	// ListByReviewee returns at most limit reviews the user received, newest first, skipping the first offset.
+	ListByReviewee(ctx context.Context, userID, limit, offset int) ([]Review, error)
+}
+
+// reviewRepository is an implementation of ReviewRepository
+type reviewRepository struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+// NewReviewRepository creates a new reviewRepository.
+func NewReviewRepository(db *sql.DB) ReviewRepository {
+	return &reviewRepository{db: db, now: time.Now}
+}
+
+func (rr *reviewRepository) Create(ctx context.Context, transactionID, reviewerID int, rating, comment string) (Review, error) {
+	tr, err := getTransaction(ctx, rr.db, transactionID)
+	if err != nil {
+		return Review{}, err
+	}
+	if !tr.isParty(reviewerID) {
+		return Review{}, errTransactionNotFound
+	}
+	if tr.Status != TransactionCompleted {
+		return Review{}, fmt.Errorf("%w: reviews can only be left on completed transactions", errTransactionState)
+	}
+
+	now := rr.now().UTC()
+	review := Review{
+		TransactionID: transactionID,
+		ReviewerID:    reviewerID,
+		RevieweeID:    tr.counterparty(reviewerID),
+		Rating:        rating,
+		Comment:       comment,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		EditableUntil: now.Add(reviewEditWindow),
+	}
+	// the unique (transaction_id, reviewer_id) lets each party review only once, even concurrently
+	result, err := rr.db.ExecContext(ctx, `
+		INSERT INTO reviews (transaction_id, reviewer_id, reviewee_id, rating, comment, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		review.TransactionID, review.ReviewerID, review.RevieweeID, review.Rating, review.Comment, review.CreatedAt, review.UpdatedAt)
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return Review{}, errReviewExists
+	}
+	if err != nil {
+		return Review{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Review{}, err
+	}
+	review.ID = int(id)
+	return review, nil
+}
+
+func (rr *reviewRepository) Update(ctx context.Context, id, reviewerID int, rating, comment *string) (review Review, err error) {
+	tx, err := rr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Review{}, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	review, err = getReview(ctx, tx, id)
+	if err != nil {
+		return Review{}, err
+	}
+	if review.ReviewerID != reviewerID {
+		return Review{}, fmt.Errorf("%w: only the reviewer can edit the review", errForbidden)
+	}
+	now := rr.now().UTC()
+	if !now.Before(review.EditableUntil) {
+		return Review{}, errReviewLocked
+	}
+
+	if rating != nil {
+		review.Rating = *rating
+	}
+	if comment != nil {
+		review.Comment = *comment
+	}
+	review.UpdatedAt = now
+	if _, err := tx.ExecContext(ctx, "UPDATE reviews SET rating = ?, comment = ?, updated_at = ? WHERE id = ?",
+		review.Rating, review.Comment, review.UpdatedAt, id); err != nil {
+		return Review{}, err
+	}
+	return review, tx.Commit()
+}
+
+// reviewColumns are the columns scanned by scanReview.
+const reviewColumns = "id, transaction_id, reviewer_id, reviewee_id, rating, comment, created_at, updated_at"
+
+// scanReview scans a row of reviewColumns.
+func scanReview(row interface{ Scan(dest ...any) error }) (Review, error) {
+	var review Review
+	err := row.Scan(&review.ID, &review.TransactionID, &review.ReviewerID, &review.RevieweeID, &review.Rating, &review.Comment, &review.CreatedAt, &review.UpdatedAt)
+	review.EditableUntil = review.CreatedAt.Add(reviewEditWindow)
+	return review, err
+}
+
+func getReview(ctx context.Context, q queryer, id int) (Review, error) {
+	review, err := scanReview(q.QueryRowContext(ctx, "SELECT "+reviewColumns+" FROM reviews WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return Review{}, errReviewNotFound
+	}
+	return review, err
+}
+
+func (rr *reviewRepository) ListByReviewee(ctx context.Context, userID, limit, offset int) ([]Review, error) {
+	rows, err := rr.db.QueryContext(ctx, `
+		SELECT `+reviewColumns+`
+		FROM reviews
+		WHERE reviewee_id = ?
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?`, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reviews []Review
+	for rows.Next() {
+		review, err := scanReview(rows)
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, review)
+	}
+	return reviews, rows.Err()
+}
+
+type CreateReviewRequest struct {
+	TransactionID int    `json:"-" path:"transaction_id" validate:"required,min=1"`
+	Rating        string `json:"rating" validate:"trim,required,oneof=good normal bad"`
+	Comment       string `json:"comment" validate:"trim,max=1000,multiline"`
+}
+
+// CreateReview is a handler for a party of a completed transaction to review the other party
+// for POST /transactions/{transaction_id}/reviews .
+func (s *Handlers) CreateReview(w http.ResponseWriter, r *http.Request) {
+	var req CreateReviewRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	userID, _ := currentUserID(r.Context())
+
+	review, err := s.reviewRepo.Create(r.Context(), req.TransactionID, userID, req.Rating, req.Comment)
+	if err != nil {
+		s.writeReviewError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, review)
+}
+
+type UpdateReviewRequest struct {
+	ID      int     `json:"-" path:"review_id" validate:"required,min=1"`
+	Rating  *string `json:"rating" validate:"trim,oneof=good normal bad"`
+	Comment *string `json:"comment" validate:"trim,max=1000,multiline"`
+}
+
+// UpdateReview is a handler for the reviewer to edit a review within reviewEditWindow for PATCH /reviews/{review_id} .
+func (s *Handlers) UpdateReview(w http.ResponseWriter, r *http.Request) {
+	var req UpdateReviewRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	userID, _ := currentUserID(r.Context())
+
+	review, err := s.reviewRepo.Update(r.Context(), req.ID, userID, req.Rating, req.Comment)
+	if err != nil {
+		s.writeReviewError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, review)
+}
+
+type GetUserReviewsRequest struct {
+	ID int `path:"user_id" validate:"required,min=1"`
+	// Page is the 1-based page number, 1 if not given.
+	Page    *int `query:"page" validate:"min=1"`
+	PerPage *int `query:"per_page" validate:"min=1,max=100"`
+}
+
+type GetUserReviewsResponse struct {
+	Reviews []Review      `json:"reviews"`
+	Rating  RatingSummary `json:"rating"`
+	Page    int           `json:"page"`
+	PerPage int           `json:"per_page"`
+}
+
+// GetUserReviews is a handler to return the reviews a user received, newest first, for GET /users/{user_id}/reviews .
+func (s *Handlers) GetUserReviews(w http.ResponseWriter, r *http.Request) {
+	var req GetUserReviewsRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	page, perPage := 1, defaultItemsPerPage
+	if req.Page != nil {
+		page = *req.Page
+	}
+	if req.PerPage != nil {
+		perPage = *req.PerPage
+	}
+
+	profile, err := s.userRepo.Profile(r.Context(), req.ID)
+	if err != nil {
+		s.writeUserError(w, r, err)
+		return
+	}
+	reviews, err := s.reviewRepo.ListByReviewee(r.Context(), req.ID, perPage, (page-1)*perPage)
+	if err != nil {
+		s.writeReviewError(w, r, err)
+		return
+	}
+	if reviews == nil {
+		reviews = []Review{}
+	}
+	writeJSON(w, http.StatusOK, GetUserReviewsResponse{Reviews: reviews, Rating: profile.Rating, Page: page, PerPage: perPage})
+}
+
+// writeReviewError writes the status code of a ReviewRepository error.
+func (s *Handlers) writeReviewError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errReviewNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errReviewExists), errors.Is(err, errReviewLocked):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		s.writeTransactionError(w, r, err)
+	}
+}
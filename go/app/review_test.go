@@ -0,0 +1,128 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestReviewRepository(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sale := newTestSale(t)
+	transactions := NewTransactionRepository(sale.db)
+	now := time.Date(2026, 4, 1, 12, 0, 0, 0, time.UTC)
+	repo := &reviewRepository{db: sale.db, now: func() time.Time { return now }}
+
+	tr, err := transactions.Create(ctx, sale.itemID, sale.buyer.ID)
+	if err != nil {
+		t.Fatalf("failed to create transaction: %v", err)
+	}
+	if _, err := repo.Create(ctx, tr.ID, sale.buyer.ID, RatingGood, ""); !errors.Is(err, errTransactionState) {
+		t.Errorf("expected errTransactionState before completion, got %v", err)
+	}
+	if _, err := transactions.Complete(ctx, tr.ID); err != nil {
+		t.Fatalf("failed to complete transaction: %v", err)
+	}
+
+	review, err := repo.Create(ctx, tr.ID, sale.buyer.ID, RatingGood, "fast shipping")
+	if err != nil {
+		t.Fatalf("failed to create review: %v", err)
+	}
+	if review.RevieweeID != sale.seller.ID || !review.EditableUntil.Equal(now.Add(reviewEditWindow)) {
+		t.Errorf("expected a review of the seller editable for the window, got %+v", review)
+	}
+	if _, err := repo.Create(ctx, tr.ID, sale.buyer.ID, RatingBad, "changed my mind"); !errors.Is(err, errReviewExists) {
+		t.Errorf("expected errReviewExists for a second review, got %v", err)
+	}
+	if _, err := repo.Create(ctx, tr.ID, 999, RatingBad, ""); !errors.Is(err, errTransactionNotFound) {
+		t.Errorf("expected errTransactionNotFound for a stranger, got %v", err)
+	}
+	if _, err := repo.Create(ctx, tr.ID, sale.seller.ID, RatingNormal, ""); err != nil {
+		t.Errorf("expected the seller to review the buyer too, got %v", err)
+	}
+
+	profile, err := NewUserRepository(sale.db).Profile(ctx, sale.seller.ID)
+	if err != nil || profile.Rating != (RatingSummary{Good: 1}) {
+		t.Errorf("expected one good rating on the seller, got %+v, %v", profile.Rating, err)
+	}
+
+	comment := "fast shipping, well packed"
+	if _, err := repo.Update(ctx, review.ID, sale.seller.ID, nil, &comment); !errors.Is(err, errForbidden) {
+		t.Errorf("expected errForbidden for editing another's review, got %v", err)
+	}
+	now = now.Add(reviewEditWindow - time.Minute)
+	updated, err := repo.Update(ctx, review.ID, sale.buyer.ID, nil, &comment)
+	if err != nil || updated.Comment != comment || updated.Rating != RatingGood || !updated.UpdatedAt.Equal(now) {
+		t.Errorf("expected the comment to change within the window, got %+v, %v", updated, err)
+	}
+	now = now.Add(time.Minute)
+	rating := RatingBad
+	if _, err := repo.Update(ctx, review.ID, sale.buyer.ID, &rating, nil); !errors.Is(err, errReviewLocked) {
+		t.Errorf("expected errReviewLocked after the window, got %v", err)
+	}
+
+	reviews, err := repo.ListByReviewee(ctx, sale.seller.ID, 10, 0)
+	if err != nil || len(reviews) != 1 || reviews[0].Comment != comment {
+		t.Errorf("expected the edited review of the seller, got %+v, %v", reviews, err)
+	}
+}
+
+func TestReviewHandlers(t *testing.T) {
+	t.Parallel()
+
+	sale := newTestSale(t)
+	handler := sale.handler()
+
+	rr := doWithToken(t, handler, "POST", fmt.Sprintf("/items/%d/purchase", sale.itemID), "", sale.buyerTok)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("failed to purchase: %d %s", rr.Code, rr.Body.String())
+	}
+	transaction := rr.Header().Get("Location")
+
+	if rr := doWithToken(t, handler, "POST", transaction+"/reviews", `{"rating":"good"}`, sale.buyerTok); rr.Code != http.StatusConflict {
+		t.Errorf("expected status code %d before completion, got %d", http.StatusConflict, rr.Code)
+	}
+	if rr := doWithToken(t, handler, "POST", transaction+"/complete", "", sale.buyerTok); rr.Code != http.StatusOK {
+		t.Fatalf("failed to complete: %d %s", rr.Code, rr.Body.String())
+	}
+
+	if rr := doWithToken(t, handler, "POST", transaction+"/reviews", `{"rating":"great"}`, sale.buyerTok); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status code %d for an unknown rating, got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+	rr = doWithToken(t, handler, "POST", transaction+"/reviews", `{"rating":"good","comment":" Thanks! "}`, sale.buyerTok)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	var review Review
+	json.Unmarshal(rr.Body.Bytes(), &review)
+	if review.Comment != "Thanks!" || review.RevieweeID != sale.seller.ID {
+		t.Errorf("unexpected review %+v", review)
+	}
+	if rr := doWithToken(t, handler, "POST", transaction+"/reviews", `{"rating":"bad"}`, sale.buyerTok); rr.Code != http.StatusConflict {
+		t.Errorf("expected status code %d for a second review, got %d", http.StatusConflict, rr.Code)
+	}
+
+	target := fmt.Sprintf("/reviews/%d", review.ID)
+	if rr := doWithToken(t, handler, "PATCH", target, `{"rating":"bad"}`, sale.sellerTok); rr.Code != http.StatusForbidden {
+		t.Errorf("expected status code %d for editing another's review, got %d", http.StatusForbidden, rr.Code)
+	}
+	if rr := doWithToken(t, handler, "PATCH", target, `{"rating":"normal"}`, sale.buyerTok); rr.Code != http.StatusOK {
+		t.Errorf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	rr = doWithToken(t, handler, "GET", fmt.Sprintf("/users/%d/reviews", sale.seller.ID), "", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var got GetUserReviewsResponse
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if len(got.Reviews) != 1 || got.Rating != (RatingSummary{Normal: 1}) {
+		t.Errorf("expected the edited review in the summary, got %+v", got)
+	}
+}
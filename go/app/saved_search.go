@@ -0,0 +1,416 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxSavedSearches is how many searches a user can save, which bounds the work of matching each new item.
+const maxSavedSearches = 20
+
+var (
+	// errSavedSearchNotFound is returned for saved searches which don't exist or belong to other users.
+	errSavedSearchNotFound = errors.New("saved search not found")
+	// errTooManySavedSearches is returned when a user already has maxSavedSearches.
+	errTooManySavedSearches = errors.New("too many saved searches")
+)
+
+// SearchCriteria are the conditions of GET /search, which a saved search stores.
+// Criteria which are not given match every item.
+type SearchCriteria struct {
+	// Keyword matches items whose name contains it.
+	Keyword string `json:"keyword"`
+	// CategoryID matches the items in the category and its subcategories.
+	CategoryID *int `json:"category_id"`
+	MinPrice   *int `json:"min_price"`
+	MaxPrice   *int `json:"max_price"`
+}
+
+// validate checks the criteria involving several fields.
+func (c SearchCriteria) validate() []FieldError {
+	if c.MinPrice != nil && c.MaxPrice != nil && *c.MaxPrice < *c.MinPrice {
+		return []FieldError{{Field: "max_price", Message: "must be at least min_price"}}
+	}
+	return nil
+}
+
+// likeEscaper escapes the wildcards of LIKE, so that a keyword matches itself only. The escape character is \.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// where returns the SQL condition on items matching the criteria, and its arguments.
+func (c SearchCriteria) where() (string, []any) {
+	conds := []string{"TRUE"}
+	var args []any
+	if c.Keyword != "" {
+		conds = append(conds, `items.name LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+likeEscaper.Replace(c.Keyword)+"%")
+	}
+	if c.CategoryID != nil {
+		conds = append(conds, `items.category_id IN (
+			WITH RECURSIVE subtree(id) AS (
+				SELECT ?
+				UNION
+				SELECT categories.id FROM categories JOIN subtree ON categories.parent_id = subtree.id
+			)
+			SELECT id FROM subtree)`)
+		args = append(args, *c.CategoryID)
+	}
+	if c.MinPrice != nil {
+		conds = append(conds, "items.price >= ?")
+		args = append(args, *c.MinPrice)
+	}
+	if c.MaxPrice != nil {
+		conds = append(conds, "items.price <= ?")
+		args = append(args, *c.MaxPrice)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// SavedSearch is a search a user saved to find the items listed after it.
+type SavedSearch struct {
+	ID     int `json:"id"`
+	UserID int `json:"user_id"`
+	SearchCriteria
+	// Notify is set if the user is notified of each new matching item.
+	Notify    bool      `json:"notify"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Please run `go generate ./...` to generate the mock implementation
+// SavedSearchRepository is an interface to manage the saved searches of users.
+//
+//go:generate go run go.uber.org/mock/mockgen -source=$GOFILE -package=${GOPACKAGE} -destination=./mock_$GOFILE
+type SavedSearchRepository interface {
+	// Create saves a search of the user. The items already listed are not new to it.
+	// It returns errCategoryNotFound if the category of the criteria doesn't exist,
+	// or errTooManySavedSearches if the user already has maxSavedSearches.
+	Create(ctx context.Context, userID int, criteria SearchCriteria, notify bool) (SavedSearch, error)
+	// List returns the saved searches of the user, oldest first.
+	List(ctx context.Context, userID int) ([]SavedSearch, error)
+	// Delete deletes a saved search of the user, or returns errSavedSearchNotFound.
+	Delete(ctx context.Context, userID, id int) error
+	// NewItems returns at most limit items matching a saved search of the user which were listed since the last call,
+	// oldest first, and whether there are more. The returned items are no longer new.
+	// It returns errSavedSearchNotFound if the user has no such saved search.
+	NewItems(ctx context.Context, userID, id, limit int) ([]Item, bool, error)
+	// Match returns the saved searches to notify of the item. The searches of its seller are skipped.
+	Match(ctx context.Context, item Item) ([]SavedSearch, error)
+}
+
+// savedSearchRepository is an implementation of SavedSearchRepository
+type savedSearchRepository struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+// NewSavedSearchRepository creates a new savedSearchRepository.
+func NewSavedSearchRepository(db *sql.DB) SavedSearchRepository {
+	return &savedSearchRepository{db: db, now: time.Now}
+}
+
+func (s *savedSearchRepository) Create(ctx context.Context, userID int, criteria SearchCriteria, notify bool) (SavedSearch, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return SavedSearch{}, err
+	}
+	defer tx.Rollback()
+
+	if criteria.CategoryID != nil {
+		if _, err := getCategory(ctx, tx, *criteria.CategoryID); err != nil {
+			return SavedSearch{}, err
+		}
+	}
+	var count int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM saved_searches WHERE user_id = ?", userID).Scan(&count); err != nil {
+		return SavedSearch{}, err
+	}
+	if count >= maxSavedSearches {
+		return SavedSearch{}, errTooManySavedSearches
+	}
+
+	search := SavedSearch{UserID: userID, SearchCriteria: criteria, Notify: notify, CreatedAt: s.now().UTC()}
+	// the newest item is checked already, so that only the items listed from now on are new
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO saved_searches (user_id, keyword, category_id, min_price, max_price, notify, checked_item_id, created_at)
+		SELECT ?, ?, ?, ?, ?, ?, IFNULL(MAX(id), 0), ? FROM items`,
+		search.UserID, search.Keyword, search.CategoryID, search.MinPrice, search.MaxPrice, search.Notify, search.CreatedAt)
+	if err != nil {
+		return SavedSearch{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return SavedSearch{}, err
+	}
+	search.ID = int(id)
+	return search, tx.Commit()
+}
+
+// savedSearchColumns are the columns scanned by scanSavedSearch.
+const savedSearchColumns = "id, user_id, keyword, category_id, min_price, max_price, notify, created_at"
+
+// scanSavedSearch scans a row of savedSearchColumns.
+func scanSavedSearch(row interface{ Scan(dest ...any) error }) (SavedSearch, error) {
+	var search SavedSearch
+	err := row.Scan(&search.ID, &search.UserID, &search.Keyword, &search.CategoryID, &search.MinPrice, &search.MaxPrice, &search.Notify, &search.CreatedAt)
+	return search, err
+}
+
+// listSavedSearches returns the saved searches of a query selecting savedSearchColumns.
+func (s *savedSearchRepository) listSavedSearches(ctx context.Context, query string, args ...any) ([]SavedSearch, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var searches []SavedSearch
+	for rows.Next() {
+		search, err := scanSavedSearch(rows)
+		if err != nil {
+			return nil, err
+		}
+		searches = append(searches, search)
+	}
+	return searches, rows.Err()
+}
+
+func (s *savedSearchRepository) List(ctx context.Context, userID int) ([]SavedSearch, error) {
+	return s.listSavedSearches(ctx, "SELECT "+savedSearchColumns+" FROM saved_searches WHERE user_id = ? ORDER BY id", userID)
+}
+
+func (s *savedSearchRepository) Delete(ctx context.Context, userID, id int) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM saved_searches WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errSavedSearchNotFound
+	}
+	return nil
+}
+
+func (s *savedSearchRepository) NewItems(ctx context.Context, userID, id, limit int) ([]Item, bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	search, err := scanSavedSearch(tx.QueryRowContext(ctx, "SELECT "+savedSearchColumns+" FROM saved_searches WHERE id = ? AND user_id = ?", id, userID))
+	if err == sql.ErrNoRows {
+		return nil, false, errSavedSearchNotFound
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var checkedItemID, latestItemID int
+	err = tx.QueryRowContext(ctx, "SELECT checked_item_id, (SELECT IFNULL(MAX(id), 0) FROM items) FROM saved_searches WHERE id = ?", id).
+		Scan(&checkedItemID, &latestItemID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// the items listed while this runs are left for the next call
+	where, args := search.where()
+	rows, err := tx.QueryContext(ctx, `
+		SELECT items.id, items.name, categories.name AS category, items.category_id, items.image_name, items.price, IFNULL(items.seller_id, 0)
+		FROM items
+		JOIN categories ON items.category_id = categories.id
+		WHERE items.id > ? AND items.id <= ? AND `+where+`
+		ORDER BY items.id
+		LIMIT ?`, append(append([]any{checkedItemID, latestItemID}, args...), limit+1)...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var items []Item
+	for rows.Next() {
+		var item Item
+		if err := rows.Scan(&item.ID, &item.Name, &item.Category, &item.CategoryID, &item.Image, &item.Price, &item.SellerID); err != nil {
+			return nil, false, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	// every item up to the latest is checked, unless the rest are left for the next call
+	more := len(items) > limit
+	if more {
+		items = items[:limit]
+		latestItemID = items[len(items)-1].ID
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE saved_searches SET checked_item_id = ? WHERE id = ?", latestItemID, id); err != nil {
+		return nil, false, err
+	}
+	return items, more, tx.Commit()
+}
+
+func (s *savedSearchRepository) Match(ctx context.Context, item Item) ([]SavedSearch, error) {
+	// the item is in the category of a search if the category is the item's or one of its ancestors
+	// and the keywords are escaped as by likeEscaper, so that their wildcards match themselves
+	return s.listSavedSearches(ctx, `
+		WITH RECURSIVE ancestors(id) AS (
+			SELECT ?
+			UNION
+			SELECT categories.parent_id FROM categories JOIN ancestors ON categories.id = ancestors.id
+			WHERE categories.parent_id IS NOT NULL
+		)
+		SELECT `+savedSearchColumns+`
+		FROM saved_searches
+		WHERE notify AND user_id != ?
+			AND (keyword = '' OR ? LIKE '%' || REPLACE(REPLACE(REPLACE(keyword, '\', '\\'), '%', '\%'), '_', '\_') || '%' ESCAPE '\')
+			AND (category_id IS NULL OR category_id IN (SELECT id FROM ancestors))
+			AND (min_price IS NULL OR min_price <= ?)
+			AND (max_price IS NULL OR max_price >= ?)
+		ORDER BY id`, item.CategoryID, item.SellerID, item.Name, item.Price, item.Price)
+}
+
+// writeSavedSearchError writes the response to a failure of a saved search operation.
+func writeSavedSearchError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errSavedSearchNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errCategoryNotFound):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, errTooManySavedSearches):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		loggerFromContext(r.Context()).Error("failed to manage saved searches: ", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+type CreateSavedSearchRequest struct {
+	Keyword    string `json:"keyword" validate:"trim,max=100,singleline"`
+	CategoryID *int   `json:"category_id" validate:"min=1"`
+	MinPrice   *int   `json:"min_price" validate:"min=0"`
+	MaxPrice   *int   `json:"max_price" validate:"min=0"`
+	// Notify notifies the user of each new matching item, as the preferences of the user for saved_search allow.
+	Notify bool `json:"notify"`
+}
+
+func (req *CreateSavedSearchRequest) validate() []FieldError {
+	criteria := req.criteria()
+	errs := criteria.validate()
+	// a search matching everything would be an alert for every item
+	if criteria == (SearchCriteria{}) {
+		errs = append(errs, FieldError{Field: "keyword", Message: "is required unless category_id, min_price or max_price is given"})
+	}
+	return errs
+}
+
+// criteria returns the criteria of the search.
+func (req *CreateSavedSearchRequest) criteria() SearchCriteria {
+	return SearchCriteria{Keyword: req.Keyword, CategoryID: req.CategoryID, MinPrice: req.MinPrice, MaxPrice: req.MaxPrice}
+}
+
+// CreateSavedSearch is a handler to save a search of the authenticated user for POST /saved-searches .
+func (s *Handlers) CreateSavedSearch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req CreateSavedSearchRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	userID, _ := currentUserID(ctx)
+
+	search, err := s.savedSearchRepo.Create(ctx, userID, req.criteria(), req.Notify)
+	if err != nil {
+		writeSavedSearchError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, search)
+}
+
+type GetSavedSearchesResponse struct {
+	SavedSearches []SavedSearch `json:"saved_searches"`
+}
+
+// GetSavedSearches is a handler to return the saved searches of the authenticated user for GET /saved-searches .
+func (s *Handlers) GetSavedSearches(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, _ := currentUserID(ctx)
+
+	searches, err := s.savedSearchRepo.List(ctx, userID)
+	if err != nil {
+		writeSavedSearchError(w, r, err)
+		return
+	}
+	resp := GetSavedSearchesResponse{SavedSearches: searches}
+	if resp.SavedSearches == nil {
+		resp.SavedSearches = []SavedSearch{}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// SavedSearchIDRequest is the request of the handlers for /saved-searches/{saved_search_id} and below.
+type SavedSearchIDRequest struct {
+	ID int `path:"saved_search_id" validate:"required,min=1"`
+}
+
+// DeleteSavedSearch is a handler to delete a saved search of the authenticated user
+// for DELETE /saved-searches/{saved_search_id} .
+func (s *Handlers) DeleteSavedSearch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req SavedSearchIDRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	userID, _ := currentUserID(ctx)
+
+	if err := s.savedSearchRepo.Delete(ctx, userID, req.ID); err != nil {
+		writeSavedSearchError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type GetSavedSearchNewItemsRequest struct {
+	ID    int  `path:"saved_search_id" validate:"required,min=1"`
+	Limit *int `query:"limit" validate:"min=1,max=100"`
+}
+
+type GetSavedSearchNewItemsResponse struct {
+	Items []Item `json:"items"`
+	// More is set if there are more new items, which the next request returns.
+	More bool `json:"more"`
+}
+
+// GetSavedSearchNewItems is a handler to return the items matching a saved search of the authenticated user
+// which were listed since the last request, for GET /saved-searches/{saved_search_id}/new .
+func (s *Handlers) GetSavedSearchNewItems(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req GetSavedSearchNewItemsRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	limit := defaultItemsPerPage
+	if req.Limit != nil {
+		limit = *req.Limit
+	}
+	userID, _ := currentUserID(ctx)
+
+	items, more, err := s.savedSearchRepo.NewItems(ctx, userID, req.ID, limit)
+	if err != nil {
+		writeSavedSearchError(w, r, err)
+		return
+	}
+	resp := GetSavedSearchNewItemsResponse{Items: items, More: more}
+	if resp.Items == nil {
+		resp.Items = []Item{}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
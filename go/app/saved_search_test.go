@@ -0,0 +1,329 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// intPtr returns a pointer to n, for the optional criteria.
+func intPtr(n int) *int {
+	return &n
+}
+
+func TestSavedSearchRepository(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sale := newTestSale(t)
+	var fashionID int
+	sale.db.QueryRow("SELECT category_id FROM items WHERE id = ?", sale.itemID).Scan(&fashionID)
+	categories := NewCategoryRepository(sale.db)
+	shoes, err := categories.Create(ctx, "Shoes", &fashionID)
+	if err != nil {
+		t.Fatalf("failed to create category: %v", err)
+	}
+	books, err := categories.Create(ctx, "Books", nil)
+	if err != nil {
+		t.Fatalf("failed to create category: %v", err)
+	}
+	repo := NewSavedSearchRepository(sale.db)
+
+	if _, err := repo.Create(ctx, sale.buyer.ID, SearchCriteria{CategoryID: intPtr(999)}, true); !errors.Is(err, errCategoryNotFound) {
+		t.Errorf("expected errCategoryNotFound, got %v", err)
+	}
+	boots, err := repo.Create(ctx, sale.buyer.ID, SearchCriteria{Keyword: "boots", CategoryID: &fashionID, MaxPrice: intPtr(5000)}, true)
+	if err != nil {
+		t.Fatalf("failed to create saved search: %v", err)
+	}
+	cheap, err := repo.Create(ctx, sale.buyer.ID, SearchCriteria{MaxPrice: intPtr(1000)}, false)
+	if err != nil {
+		t.Fatalf("failed to create saved search: %v", err)
+	}
+	own, err := repo.Create(ctx, sale.seller.ID, SearchCriteria{Keyword: "BOOTS"}, true)
+	if err != nil {
+		t.Fatalf("failed to create saved search: %v", err)
+	}
+
+	items := &itemRepository{fileName: t.TempDir() + "/items.json", db: sale.db}
+	cases := []struct {
+		name string
+		item Item
+		// wantMatches are the saved searches to notify of the item.
+		wantMatches []int
+	}{
+		{"ok: subcategory within the price range", Item{Name: "Rain Boots", CategoryID: shoes.ID, Price: 3000, SellerID: sale.seller.ID}, []int{boots.ID}},
+		{"ng: other category", Item{Name: "boots catalog", CategoryID: books.ID, Price: 3000, SellerID: sale.seller.ID}, nil},
+		{"ng: too expensive", Item{Name: "leather boots", CategoryID: shoes.ID, Price: 8000, SellerID: sale.seller.ID}, nil},
+		{"ok: listed without signing in", Item{Name: "kids boots", CategoryID: shoes.ID, Price: 500}, []int{boots.ID, own.ID}},
+	}
+	for _, tt := range cases {
+		// the items are inserted in order, since the new items depend on them
+		if err := items.Insert(ctx, &tt.item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+		searches, err := repo.Match(ctx, tt.item)
+		var got []int
+		for _, s := range searches {
+			got = append(got, s.ID)
+		}
+		if err != nil || fmt.Sprint(got) != fmt.Sprint(tt.wantMatches) {
+			t.Errorf("%s: expected matches %v, got %v, %v", tt.name, tt.wantMatches, got, err)
+		}
+	}
+
+	// the jacket was listed before the search was saved, so it is not new
+	newItems, more, err := repo.NewItems(ctx, sale.buyer.ID, boots.ID, 1)
+	if err != nil || len(newItems) != 1 || newItems[0].Name != "Rain Boots" || newItems[0].Price != 3000 || !more {
+		t.Errorf("expected the first new item and more, got %+v, %v, %v", newItems, more, err)
+	}
+	newItems, more, err = repo.NewItems(ctx, sale.buyer.ID, boots.ID, 10)
+	if err != nil || len(newItems) != 1 || newItems[0].Name != "kids boots" || more {
+		t.Errorf("expected the rest of the new items, got %+v, %v, %v", newItems, more, err)
+	}
+	if newItems, more, err := repo.NewItems(ctx, sale.buyer.ID, boots.ID, 10); err != nil || len(newItems) != 0 || more {
+		t.Errorf("expected no new items after checking, got %+v, %v, %v", newItems, more, err)
+	}
+	if newItems, _, err := repo.NewItems(ctx, sale.buyer.ID, cheap.ID, 10); err != nil || len(newItems) != 1 || newItems[0].Name != "kids boots" {
+		t.Errorf("expected the new items of a search without notifications, got %+v, %v", newItems, err)
+	}
+	if _, _, err := repo.NewItems(ctx, sale.seller.ID, boots.ID, 10); !errors.Is(err, errSavedSearchNotFound) {
+		t.Errorf("expected errSavedSearchNotFound for the search of another user, got %v", err)
+	}
+
+	for range maxSavedSearches - 1 {
+		if _, err := repo.Create(ctx, sale.seller.ID, SearchCriteria{Keyword: "jacket"}, false); err != nil {
+			t.Fatalf("failed to create saved search: %v", err)
+		}
+	}
+	if _, err := repo.Create(ctx, sale.seller.ID, SearchCriteria{Keyword: "jacket"}, false); !errors.Is(err, errTooManySavedSearches) {
+		t.Errorf("expected errTooManySavedSearches, got %v", err)
+	}
+
+	if err := repo.Delete(ctx, sale.seller.ID, boots.ID); !errors.Is(err, errSavedSearchNotFound) {
+		t.Errorf("expected errSavedSearchNotFound for the search of another user, got %v", err)
+	}
+	if err := repo.Delete(ctx, sale.buyer.ID, boots.ID); err != nil {
+		t.Errorf("failed to delete saved search: %v", err)
+	}
+	searches, err := repo.List(ctx, sale.buyer.ID)
+	if err != nil || len(searches) != 1 || searches[0].ID != cheap.ID || *searches[0].MaxPrice != 1000 || searches[0].MinPrice != nil {
+		t.Errorf("expected the remaining saved search, got %+v, %v", searches, err)
+	}
+}
+
+func TestSavedSearchWildcardKeywords(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sale := newTestSale(t)
+	handler := sale.handler()
+	repo := NewSavedSearchRepository(sale.db)
+	var fashionID int
+	sale.db.QueryRow("SELECT category_id FROM items WHERE id = ?", sale.itemID).Scan(&fashionID)
+
+	keywords := []string{"50%", "a_b", `c:\`}
+	ids := make(map[string]int)
+	for _, keyword := range keywords {
+		search, err := repo.Create(ctx, sale.buyer.ID, SearchCriteria{Keyword: keyword}, true)
+		if err != nil {
+			t.Fatalf("failed to create saved search: %v", err)
+		}
+		ids[keyword] = search.ID
+	}
+
+	items := &itemRepository{fileName: t.TempDir() + "/items.json", db: sale.db}
+	cases := []struct {
+		name string
+		// wantKeyword is the keyword matching the item, or "" if none does.
+		wantKeyword string
+	}{
+		{"50% off", "50%"},
+		{"500 off", ""},
+		{"a_b box", "a_b"},
+		{"axb box", ""},
+		{`c:\ drive`, `c:\`},
+		{"c: drive", ""},
+	}
+	for _, tt := range cases {
+		item := Item{Name: tt.name, CategoryID: fashionID, SellerID: sale.seller.ID}
+		if err := items.Insert(ctx, &item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+		searches, err := repo.Match(ctx, item)
+		var got []int
+		for _, s := range searches {
+			got = append(got, s.ID)
+		}
+		var want []int
+		if tt.wantKeyword != "" {
+			want = []int{ids[tt.wantKeyword]}
+		}
+		if err != nil || fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s: expected matches %v, got %v, %v", tt.name, want, got, err)
+		}
+	}
+
+	// GET /search escapes the keyword the same way
+	for _, keyword := range keywords {
+		rr := doWithToken(t, handler, "GET", "/search?keyword="+url.QueryEscape(keyword), "", "")
+		var found []Item
+		json.Unmarshal(rr.Body.Bytes(), &found)
+		if rr.Code != http.StatusOK || len(found) != 1 || !strings.Contains(found[0].Name, keyword) {
+			t.Errorf("expected only the item containing %q, got %d %s", keyword, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestSavedSearchHandlers(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sale := newTestSale(t)
+	handler := sale.handler()
+
+	cases := []struct {
+		name, body, token string
+		wantCode          int
+	}{
+		{"ng: anonymous", `{"keyword":"boots"}`, "", http.StatusUnauthorized},
+		{"ng: no criteria", `{"keyword":" ","notify":true}`, sale.buyerTok, http.StatusUnprocessableEntity},
+		{"ng: reversed price range", `{"min_price":2000,"max_price":1000}`, sale.buyerTok, http.StatusUnprocessableEntity},
+		{"ng: unknown category", `{"category_id":999}`, sale.buyerTok, http.StatusBadRequest},
+		{"ok: keyword and price", `{"keyword":" boots ","min_price":500,"notify":true}`, sale.buyerTok, http.StatusCreated},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := doWithToken(t, handler, "POST", "/saved-searches", tt.body, tt.token); rr.Code != tt.wantCode {
+				t.Errorf("expected status code %d, got %d: %s", tt.wantCode, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	rr := doWithToken(t, handler, "GET", "/saved-searches", "", sale.buyerTok)
+	var list GetSavedSearchesResponse
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if rr.Code != http.StatusOK || len(list.SavedSearches) != 1 || list.SavedSearches[0].Keyword != "boots" || !list.SavedSearches[0].Notify {
+		t.Fatalf("expected the saved search, got %d %s", rr.Code, rr.Body.String())
+	}
+	id := list.SavedSearches[0].ID
+
+	items := &itemRepository{fileName: t.TempDir() + "/items.json", db: sale.db}
+	for _, item := range []Item{{Name: "boots", Category: "Fashion", Price: 300}, {Name: "rain boots", Category: "Fashion", Price: 1200}} {
+		if err := items.Insert(ctx, &item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+	newItems := fmt.Sprintf("/saved-searches/%d/new", id)
+	rr = doWithToken(t, handler, "GET", newItems, "", sale.buyerTok)
+	var got GetSavedSearchNewItemsResponse
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if rr.Code != http.StatusOK || len(got.Items) != 1 || got.Items[0].Name != "rain boots" || got.More {
+		t.Errorf("expected the new item within the price range, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := doWithToken(t, handler, "GET", newItems, "", sale.buyerTok); rr.Code != http.StatusOK || rr.Body.String() != `{"items":[],"more":false}`+"\n" {
+		t.Errorf("expected no new items after checking, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := doWithToken(t, handler, "GET", newItems, "", sale.sellerTok); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status code %d for the search of another user, got %d", http.StatusNotFound, rr.Code)
+	}
+
+	// GET /search takes the same criteria
+	rr = doWithToken(t, handler, "GET", "/search?keyword=boots&max_price=1000", "", "")
+	var found []Item
+	json.Unmarshal(rr.Body.Bytes(), &found)
+	if rr.Code != http.StatusOK || len(found) != 1 || found[0].Price != 300 {
+		t.Errorf("expected the search to filter by price, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := doWithToken(t, handler, "GET", "/search?keyword=boots&min_price=1000&max_price=500", "", ""); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status code %d for a reversed price range, got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+
+	if rr := doWithToken(t, handler, "DELETE", fmt.Sprintf("/saved-searches/%d", id), "", sale.sellerTok); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status code %d for the search of another user, got %d", http.StatusNotFound, rr.Code)
+	}
+	if rr := doWithToken(t, handler, "DELETE", fmt.Sprintf("/saved-searches/%d", id), "", sale.buyerTok); rr.Code != http.StatusNoContent {
+		t.Errorf("expected status code %d, got %d", http.StatusNoContent, rr.Code)
+	}
+	if rr := doWithToken(t, handler, "GET", "/saved-searches", "", sale.buyerTok); rr.Body.String() != `{"saved_searches":[]}`+"\n" {
+		t.Errorf("expected no saved searches after deleting, got %s", rr.Body.String())
+	}
+}
+
+func TestSavedSearchNotifications(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sale := newTestSale(t)
+	bus := NewEventBus()
+	t.Cleanup(bus.Close)
+	items := publishItemRepository(&itemRepository{fileName: t.TempDir() + "/items.json", db: sale.db}, bus)
+	notifications := NewNotificationRepository(sale.db)
+	searches := NewSavedSearchRepository(sale.db)
+
+	boots, err := searches.Create(ctx, sale.buyer.ID, SearchCriteria{Keyword: "boots"}, true)
+	if err != nil {
+		t.Fatalf("failed to create saved search: %v", err)
+	}
+	cheap, err := searches.Create(ctx, sale.buyer.ID, SearchCriteria{MaxPrice: intPtr(1000)}, true)
+	if err != nil {
+		t.Fatalf("failed to create saved search: %v", err)
+	}
+
+	notifier, err := NewNotifier(ctx, bus, notifications, searches, nil)
+	if err != nil {
+		t.Fatalf("failed to create notifier: %v", err)
+	}
+	notifierCtx, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		notifier.Start(notifierCtx)
+	}()
+
+	// the first matches nothing, the second both searches and the third the cheap one
+	var listed []Item
+	for _, item := range []Item{
+		{Name: "coat", Category: "Fashion", Price: 5000, SellerID: sale.seller.ID},
+		{Name: "boots", Category: "Fashion", Price: 800, SellerID: sale.seller.ID},
+		{Name: "socks", Category: "Fashion", Price: 300, SellerID: sale.seller.ID},
+	} {
+		if err := items.Insert(ctx, &item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+		listed = append(listed, item)
+	}
+	// events are handled in order, so the first two are done when the third is notified
+	stopAfter := time.Now().Add(5 * time.Second)
+	for {
+		if count, _ := notifications.CountUnread(ctx, sale.buyer.ID); count >= 2 || time.Now().After(stopAfter) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stop()
+	<-done
+
+	got, err := notifications.List(ctx, sale.buyer.ID, false, 10, 0)
+	want := []NotificationData{
+		{ItemID: listed[2].ID, SavedSearchID: cheap.ID, UserID: sale.seller.ID},
+		{ItemID: listed[1].ID, SavedSearchID: boots.ID, UserID: sale.seller.ID},
+	}
+	if err != nil || len(got) != len(want) {
+		t.Fatalf("expected %d notifications, got %+v, %v", len(want), got, err)
+	}
+	for i, n := range got {
+		if n.Type != NotificationSavedSearch || n.Data != want[i] {
+			t.Errorf("expected notification %+v, got %+v", want[i], n)
+		}
+	}
+	if count, _ := notifications.CountUnread(ctx, sale.seller.ID); count != 0 {
+		t.Errorf("expected the seller not to be notified, got %d notifications", count)
+	}
+}
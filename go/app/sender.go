@@ -0,0 +1,106 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// Sender delivers notifications outside the app.
+type Sender interface {
+	// Send sends a message with the subject and the plain text body to the address.
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// smtpSender is a Sender emailing through an SMTP server.
+type smtpSender struct {
+	addr string
+	from string
+	// auth is nil if the server needs no authentication.
+	auth smtp.Auth
+	now  func() time.Time
+}
+
+// NewSMTPSender creates a Sender emailing through the SMTP server of the configuration.
+// STARTTLS is used whenever the server offers it, and the credentials are sent only over TLS or to localhost.
+func NewSMTPSender(cfg SMTPConfig) Sender {
+	s := &smtpSender{addr: cfg.Addr, from: cfg.From, now: time.Now}
+	if cfg.Username != "" {
+		host, _, _ := net.SplitHostPort(cfg.Addr)
+		s.auth = smtp.PlainAuth("", cfg.Username, cfg.Password, host)
+	}
+	return s
+}
+
+func (s *smtpSender) Send(ctx context.Context, to, subject, body string) error {
+	host, _, err := net.SplitHostPort(s.addr)
+	if err != nil {
+		return err
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	// net/smtp has no context, so the deadline of ctx bounds the whole conversation
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+	if s.auth != nil {
+		if err := c.Auth(s.auth); err != nil {
+			return fmt.Errorf("failed to authenticate: %w", err)
+		}
+	}
+	if err := c.Mail(s.from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(s.message(to, subject, body)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// message formats an email. The body is quoted-printable, so that any text passes servers without 8BITMIME.
+// Line endings are left to the DATA writer of net/smtp, which converts them to CRLF.
+func (s *smtpSender) message(to, subject, body string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\n", s.from)
+	fmt.Fprintf(&buf, "To: %s\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\n", s.now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\n\n")
+	qp := quotedprintable.NewWriter(&buf)
+	qp.Write([]byte(body))
+	qp.Close()
+	return buf.Bytes()
+}
@@ -0,0 +1,174 @@
+package app
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeMail is an email received by fakeSMTPServer.
+type fakeMail struct {
+	// auth is the decoded AUTH PLAIN response, or "" if the client didn't authenticate.
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// fakeSMTPServer is an SMTP server on localhost accepting every email, without TLS.
+type fakeSMTPServer struct {
+	addr  string
+	mails chan fakeMail
+}
+
+// newFakeSMTPServer starts a fakeSMTPServer which is stopped at the end of the test.
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &fakeSMTPServer{addr: ln.Addr().String(), mails: make(chan fakeMail, 10)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP fake")
+
+	var m fakeMail
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			tp.PrintfLine("250-localhost")
+			tp.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			_, resp, _ := strings.Cut(arg, " ")
+			decoded, _ := base64.StdEncoding.DecodeString(resp)
+			m.auth = string(decoded)
+			tp.PrintfLine("235 authenticated")
+		case "MAIL":
+			m.from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			m.to = append(m.to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			m.data = string(data)
+			s.mails <- m
+			m = fakeMail{}
+			tp.PrintfLine("250 queued")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+// receive returns the next email received, or fails the test after a while.
+func (s *fakeSMTPServer) receive(t *testing.T) fakeMail {
+	t.Helper()
+
+	select {
+	case m := <-s.mails:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email received")
+		return fakeMail{}
+	}
+}
+
+func TestSMTPSender(t *testing.T) {
+	t.Parallel()
+
+	server := newFakeSMTPServer(t)
+	sender := NewSMTPSender(SMTPConfig{Addr: server.addr, From: "noreply@example.com", Username: "shop", Password: "secret"})
+
+	body := "Your item was purchased.\nSee /transactions/1 for the details.\n"
+	if err := sender.Send(context.Background(), "alice@example.com", "ご購入ありがとうございます", body); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+
+	m := server.receive(t)
+	if m.auth != "\x00shop\x00secret" || m.from != "noreply@example.com" || strings.Join(m.to, ",") != "alice@example.com" {
+		t.Errorf("unexpected envelope %+v", m)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(m.data))
+	if err != nil {
+		t.Fatalf("failed to parse email: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "ご購入ありがとうございます" {
+		t.Errorf("expected the subject to be encoded, got %q: %v", msg.Header.Get("Subject"), err)
+	}
+	if msg.Header.Get("To") != "alice@example.com" || msg.Header.Get("Date") == "" {
+		t.Errorf("unexpected headers %v", msg.Header)
+	}
+	got, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	if err != nil || strings.ReplaceAll(string(got), "\r\n", "\n") != body {
+		t.Errorf("expected body %q, got %q: %v", body, got, err)
+	}
+}
+
+func TestSMTPSenderTimeout(t *testing.T) {
+	t.Parallel()
+
+	// a server which accepts connections but never greets
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			// the connection is held until the client gives up
+			go func() {
+				defer conn.Close()
+				io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	sender := NewSMTPSender(SMTPConfig{Addr: ln.Addr().String(), From: "noreply@example.com"})
+	if err := sender.Send(ctx, "alice@example.com", "subject", "body"); err == nil {
+		t.Error("expected an error from a server which doesn't respond")
+	}
+}
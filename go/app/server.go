@@ -1,75 +1,404 @@
 package app
 
 import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"strings"
-	"crypto/sha256"
-	"encoding/hex"
-	"io"
 	"strconv"
-	"database/sql"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Server struct {
-	// Port is the port number to listen on.
-	Port string
-	// ImageDirPath is the path to the directory storing images.
-	ImageDirPath string
+	// Config is the validated configuration, see LoadConfig.
+	Config *Config
 }
 
+// default timeouts of the http.Server, see HTTPConfig.
+const (
+	// readHeaderTimeout bounds how long a client may take to send request headers.
+	readHeaderTimeout = 10 * time.Second
+	// readTimeout and writeTimeout are generous enough for uploading and downloading the largest image on a slow link.
+	readTimeout  = 60 * time.Second
+	writeTimeout = 60 * time.Second
+	// idleTimeout is how long a keep-alive connection is kept without requests.
+	idleTimeout = 120 * time.Second
+	// shutdownTimeout is how long in-flight requests are drained after SIGINT or SIGTERM.
+	shutdownTimeout = 30 * time.Second
+)
+
 // Run is a method to start the server.
-// This method returns 0 if the server started successfully, and 1 otherwise.
+// It serves until SIGINT or SIGTERM is received, then drains in-flight requests and stops background workers.
+// This method returns 0 if the server shut down gracefully, and 1 otherwise.
 func (s Server) Run() int {
+	cfg := s.Config
+
 	// set up logger
 	// STEP 4-6: set the log level to DEBUG
-	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
-		Level: slog.LevelDebug, //display log messages at the debug level and above.
-	}))
-	slog.SetDefault(logger)
-	
-	// set up CORS settings
-	frontURL, found := os.LookupEnv("FRONT_URL")
-	if !found {
-		frontURL = "http://localhost:3000"
+	logger, err := newLogger(os.Stderr, cfg.Log)
+	if err != nil {
+		slog.Error("failed to set up logger: ", "error", err)
+		return 1
 	}
+	slog.SetDefault(logger)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// STEP 5-1: set up the database connection
+	db, err := OpenDatabase(cfg.DatabasePath)
+	if err != nil {
+		slog.Error("failed to open database: ", "error", err)
+		return 1
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			slog.Error("failed to close database: ", "error", err)
+		}
+	}()
+
+	// set up tracing
+	tp, shutdownTracing, err := newTracerProvider(ctx, cfg.Tracing, os.Stdout)
+	if err != nil {
+		slog.Error("failed to set up tracing: ", "error", err)
+		return 1
+	}
+	defer func() {
+		// flush the spans of the last requests with a fresh context, since ctx is canceled by then
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			slog.Error("failed to shut down tracing: ", "error", err)
+		}
+	}()
+	setGlobalTracing(tp)
+
+	// set up image storage
+	imageStore, err := NewImageStore(cfg.ImageStore, cfg.ImageDirPath)
+	if err != nil {
+		slog.Error("failed to set up image store: ", "error", err)
+		return 1
+	}
+	imageStore = traceImageStore(imageStore, tp)
 
 	// set up handlers
-	itemRepo := NewItemRepository()
-	h := &Handlers{imgDirPath: s.ImageDirPath, itemRepo: itemRepo, db: db}
+	metrics := NewMetrics(db)
+	// writes are published to the clients streaming GET /events
+	events := NewEventBus()
+	itemRepo := publishItemRepository(traceItemRepository(instrumentItemRepository(NewItemRepository(db), metrics), tp), events)
+	userRepo := traceUserRepository(instrumentUserRepository(NewUserRepository(db), metrics), tp)
+	transactionRepo := publishTransactionRepository(traceTransactionRepository(instrumentTransactionRepository(NewTransactionRepository(db), metrics), tp), events)
+	h := &Handlers{
+		imgDirPath:       cfg.ImageDirPath,
+		imageStore:       imageStore,
+		imageFetcher:     newImageFetcher(isPublicIP, cfg.Upload.MaxImageSize),
+		maxImageSize:     cfg.Upload.MaxImageSize,
+		itemRepo:         itemRepo,
+		categoryRepo:     traceCategoryRepository(instrumentCategoryRepository(NewCategoryRepository(db), metrics), tp),
+		userRepo:         userRepo,
+		transactionRepo:  transactionRepo,
+		reviewRepo:       traceReviewRepository(instrumentReviewRepository(NewReviewRepository(db), metrics), tp),
+		messageRepo:      publishMessageRepository(traceMessageRepository(instrumentMessageRepository(NewMessageRepository(db), metrics), tp), transactionRepo, events),
+		notificationRepo: traceNotificationRepository(instrumentNotificationRepository(NewNotificationRepository(db), metrics), tp),
+		savedSearchRepo:  traceSavedSearchRepository(instrumentSavedSearchRepository(NewSavedSearchRepository(db), metrics), tp),
+		offerRepo:        traceOfferRepository(instrumentOfferRepository(NewOfferRepository(db), metrics), tp),
+		events:           events,
+		db:               db,
+		metrics:          metrics,
+	}
+
+	// sweep unreferenced images in the background
+	// workers are stopped after the HTTP server, since in-flight requests may still need them
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	defer func() {
+		stopWorkers()
+		workers.Wait()
+	}()
+	sweeper := newImageSweeper(imageStore, itemRepo, cfg.Sweep.Grace)
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		sweeper.Start(workerCtx, cfg.Sweep.Interval)
+	}()
+
+	// notify users of the events of the repositories in the background
+	var sender Sender
+	if cfg.Notification.SMTP.Addr != "" {
+		sender = NewSMTPSender(cfg.Notification.SMTP)
+	}
+	notifier, err := NewNotifier(workerCtx, events, h.notificationRepo, h.savedSearchRepo, sender)
+	if err != nil {
+		slog.Error("failed to set up notifications: ", "error", err)
+		return 1
+	}
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		notifier.Start(workerCtx)
+	}()
 
 	// set up routes
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /", h.Hello)
+	mux.HandleFunc("GET /healthz", h.Healthz)
+	mux.HandleFunc("GET /readyz", h.Readyz)
+	mux.HandleFunc("GET /version", h.Version)
+	mux.Handle("GET /metrics", metrics)
 	mux.HandleFunc("POST /items", h.AddItem)
 	mux.HandleFunc("GET /items", h.GetItem) // STEP 4-3 implement the GET /items endpoint
 	mux.HandleFunc("GET /images/{filename}", h.GetImage)
 	mux.HandleFunc("GET /items/{item_id}", h.GetItemByID) //STEP 4-5: implement the GET /items/{item_id} endpoint
+	mux.HandleFunc("GET /items/{item_id}/similar", h.GetSimilarItems)
 	mux.HandleFunc("GET /search", h.SearchItem) //STEP 5-2: implement the GET /search/{keyword} endpoint
+	mux.HandleFunc("GET /categories", h.GetCategories)
+	mux.HandleFunc("GET /categories/{category_id}/items", h.GetCategoryItems)
+	mux.Handle("POST /categories", adminMiddleware(http.HandlerFunc(h.CreateCategory), cfg.Admin.Token))
+	mux.Handle("POST /categories/{category_id}/rename", adminMiddleware(http.HandlerFunc(h.RenameCategory), cfg.Admin.Token))
+	mux.Handle("POST /categories/{category_id}/move", adminMiddleware(http.HandlerFunc(h.MoveCategory), cfg.Admin.Token))
+	mux.Handle("POST /categories/{category_id}/merge", adminMiddleware(http.HandlerFunc(h.MergeCategory), cfg.Admin.Token))
+	mux.HandleFunc("POST /users", h.CreateUser)
+	mux.Handle("GET /users/me", requireUser(http.HandlerFunc(h.GetMe)))
+	mux.Handle("PATCH /users/me", requireUser(http.HandlerFunc(h.UpdateMe)))
+	mux.HandleFunc("GET /users/{user_id}", h.GetUser)
+	mux.HandleFunc("GET /users/{user_id}/items", h.GetUserItems)
+	mux.HandleFunc("GET /users/{user_id}/reviews", h.GetUserReviews)
+	mux.Handle("POST /items/{item_id}/purchase", requireUser(http.HandlerFunc(h.PurchaseItem)))
+	mux.Handle("POST /items/{item_id}/offers", requireUser(http.HandlerFunc(h.MakeOffer)))
+	mux.Handle("GET /items/{item_id}/offers", requireUser(http.HandlerFunc(h.GetItemOffers)))
+	mux.Handle("GET /offers/{offer_id}", requireUser(http.HandlerFunc(h.GetOffer)))
+	mux.Handle("POST /offers/{offer_id}/accept", requireUser(http.HandlerFunc(h.AcceptOffer)))
+	mux.Handle("POST /offers/{offer_id}/decline", requireUser(http.HandlerFunc(h.DeclineOffer)))
+	mux.Handle("POST /offers/{offer_id}/counter", requireUser(http.HandlerFunc(h.CounterOffer)))
+	mux.Handle("GET /transactions/{transaction_id}", requireUser(http.HandlerFunc(h.GetTransaction)))
+	mux.Handle("POST /transactions/{transaction_id}/complete", requireUser(http.HandlerFunc(h.CompleteTransaction)))
+	mux.Handle("POST /transactions/{transaction_id}/reviews", requireUser(http.HandlerFunc(h.CreateReview)))
+	mux.Handle("PATCH /reviews/{review_id}", requireUser(http.HandlerFunc(h.UpdateReview)))
+	mux.Handle("GET /transactions/{transaction_id}/messages", requireUser(http.HandlerFunc(h.GetMessages)))
+	mux.Handle("POST /transactions/{transaction_id}/messages", requireUser(http.HandlerFunc(h.PostMessage)))
+	mux.Handle("POST /transactions/{transaction_id}/messages/read", requireUser(http.HandlerFunc(h.ReadMessages)))
+	mux.Handle("GET /transactions/{transaction_id}/messages/{message_id}/image", requireUser(http.HandlerFunc(h.GetMessageImage)))
+	mux.Handle("GET /messages/unread", requireUser(http.HandlerFunc(h.GetUnreadMessages)))
+	mux.HandleFunc("GET /events", h.GetEvents)
+	mux.Handle("GET /notifications", requireUser(http.HandlerFunc(h.GetNotifications)))
+	mux.Handle("POST /notifications/read", requireUser(http.HandlerFunc(h.ReadNotifications)))
+	mux.Handle("GET /notifications/preferences", requireUser(http.HandlerFunc(h.GetNotificationPreferences)))
+	mux.Handle("PUT /notifications/preferences", requireUser(http.HandlerFunc(h.UpdateNotificationPreferences)))
+	mux.Handle("POST /saved-searches", requireUser(http.HandlerFunc(h.CreateSavedSearch)))
+	mux.Handle("GET /saved-searches", requireUser(http.HandlerFunc(h.GetSavedSearches)))
+	mux.Handle("DELETE /saved-searches/{saved_search_id}", requireUser(http.HandlerFunc(h.DeleteSavedSearch)))
+	mux.Handle("GET /saved-searches/{saved_search_id}/new", requireUser(http.HandlerFunc(h.GetSavedSearchNewItems)))
+
+	// set up middleware, from the innermost
+	proxies, err := parseTrustedProxies(cfg.HTTP.TrustedProxies)
+	if err != nil {
+		slog.Error("failed to set up trusted proxies: ", "error", err)
+		return 1
+	}
+	var handler http.Handler = mux
+	limiter := newRateLimiter(cfg.RateLimit, proxies, NewMemoryRateLimitStore())
+	if cfg.RateLimit.Enabled {
+		handler = rateLimitMiddleware(handler, mux, limiter)
+	}
+	// users are authenticated before the route limits, so that they are limited per user
+	handler = authMiddleware(handler, userRepo)
+	// addresses are limited before authentication, so that made-up tokens can't flood the user lookups
+	if cfg.RateLimit.Enabled {
+		handler = addressRateLimitMiddleware(handler, mux, limiter)
+	}
+	// preflight requests are answered before rate limiting, and rejected requests still get CORS headers
+	handler = corsMiddleware(handler, mux, cfg.CORS)
+	handler = securityHeadersMiddleware(handler, proxies)
+	// panics are recovered inside the access log, so that they are logged as 500 with the request ID
+	handler = recoveryMiddleware(handler)
+	handler = accessLogMiddleware(handler)
+	handler = tracingMiddleware(handler, mux, tp)
+	handler = metricsMiddleware(handler, mux, metrics)
+
+	srv := newHTTPServer(handler, cfg.HTTP)
+	// event streams never end by themselves, so they are closed for the shutdown to drain them
+	srv.RegisterOnShutdown(events.Close)
+	if cfg.TLS.Enabled() {
+		certs, err := newCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			slog.Error("failed to set up TLS: ", "error", err)
+			return 1
+		}
+		srv.TLSConfig = newTLSConfig(certs)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			certs.Start(workerCtx, certReloadInterval)
+		}()
+	}
 
 	// start the server
-	slog.Info("http server started on", "port", s.Port)
-	err := http.ListenAndServe(":"+s.Port, simpleCORSMiddleware(simpleLoggerMiddleware(mux), frontURL, []string{"GET", "HEAD", "POST", "OPTIONS"}))
+	ln, err := net.Listen("tcp", ":"+cfg.Port)
 	if err != nil {
 		slog.Error("failed to start server: ", "error", err)
 		return 1
 	}
+	slog.Info("http server started on", "port", cfg.Port, "tls", cfg.TLS.Enabled())
+	type listener struct {
+		srv *http.Server
+		ln  net.Listener
+	}
+	listeners := []listener{{srv, ln}}
+
+	if cfg.TLS.RedirectPort != "" {
+		redirectLn, err := net.Listen("tcp", ":"+cfg.TLS.RedirectPort)
+		if err != nil {
+			ln.Close()
+			slog.Error("failed to start redirect server: ", "error", err)
+			return 1
+		}
+		slog.Info("https redirect server started on", "port", cfg.TLS.RedirectPort)
+		listeners = append(listeners, listener{newHTTPServer(httpsRedirectHandler(cfg.Port), cfg.HTTP), redirectLn})
+	}
+
+	// if one server fails, the others are shut down too
+	serveCtx, cancelServe := context.WithCancel(ctx)
+	defer cancelServe()
+	serveErrs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() {
+			err := serve(serveCtx, l.srv, l.ln, cfg.HTTP.ShutdownTimeout)
+			if err != nil {
+				cancelServe()
+			}
+			serveErrs <- err
+		}()
+	}
+	var errs []error
+	for range listeners {
+		if err := <-serveErrs; err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		slog.Error("failed to serve: ", "error", err)
+		return 1
+	}
 
+	slog.Info("http server stopped")
 	return 0
 }
 
+// newHTTPServer creates an http.Server with timeouts, so that slow or idle clients can't hold connections forever.
+func newHTTPServer(handler http.Handler, cfg HTTPConfig) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+}
+
+// serve serves HTTP on ln, or HTTPS if srv has a TLSConfig, until ctx is done,
+// then stops accepting connections and waits up to shutdownTimeout for in-flight requests to complete.
+// It returns nil if the server shut down gracefully.
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, shutdownTimeout time.Duration) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			// the certificate comes from TLSConfig.GetCertificate, and HTTP/2 is enabled for TLS connections
+			serveErr <- srv.ServeTLS(ln, "", "")
+			return
+		}
+		serveErr <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-serveErr:
+		// Serve never returns nil, and it returns http.ErrServerClosed only after Shutdown
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down http server", "timeout", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		// force the remaining connections to close
+		srv.Close()
+		return fmt.Errorf("failed to drain in-flight requests: %w", err)
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
 type Handlers struct {
-	// imgDirPath is the path to the directory storing images.
-	imgDirPath string
-	itemRepo   ItemRepository
-	db         *sql.DB
+	// imgDirPath is the path to the directory storing the default image.
+	imgDirPath   string
+	imageStore   ImageStore
+	imageFetcher *imageFetcher
+	// maxImageSize is the largest image accepted by POST /items. Zero means defaultMaxImageSize.
+	maxImageSize     int64
+	itemRepo         ItemRepository
+	categoryRepo     CategoryRepository
+	userRepo         UserRepository
+	transactionRepo  TransactionRepository
+	reviewRepo       ReviewRepository
+	messageRepo      MessageRepository
+	notificationRepo NotificationRepository
+	savedSearchRepo  SavedSearchRepository
+	offerRepo        OfferRepository
+	events           *EventBus
+	db               *sql.DB
+	// metrics may be nil, in which case nothing is recorded.
+	metrics *Metrics
+}
+
+// writeRequestError writes the response to a request which could not be parsed or is invalid.
+func writeRequestError(w http.ResponseWriter, r *http.Request, err error) {
+	var validationErr *ValidationError
+	var bindErr *BindError
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &validationErr):
+		writeValidationError(w, r, validationErr)
+	case errors.As(err, &bindErr):
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{
+			Message:   "malformed request",
+			RequestID: requestIDFromContext(r.Context()),
+			Errors:    bindErr.Errors,
+		})
+	case errors.As(err, &maxBytesErr), errors.Is(err, errUploadNotAccepted):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, errUnsupportedMediaType):
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// writeJSON writes v as the JSON body of a response with the status code.
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		// the status code is already sent, so the error can only be logged
+		slog.Error("failed to encode response: ", "error", err)
+	}
 }
 
 type HelloResponse struct {
@@ -87,90 +416,166 @@ func (s *Handlers) Hello(w http.ResponseWriter, r *http.Request) {
 }
 
 type AddItemRequest struct {
-	Name string `form:"name"`
-	Category string `form:"category"` // STEP 4-2: add a category field
-	Image []byte `form:"image"` // STEP 4-4: add an image field
+	Name     string `form:"name" json:"name" validate:"trim,required,max=100,singleline"`
+	Category string `form:"category" json:"category" validate:"trim,max=50,singleline"` // STEP 4-2: add a category field
+	// CategoryID selects an existing category instead of Category.
+	CategoryID int `form:"category_id" json:"category_id" validate:"min=1"`
+	// Image is base64-encoded in JSON bodies.
+	Image []byte `form:"image" json:"image"` // STEP 4-4: add an image field
+	// ImageURL is fetched by the server when Image is not given.
+	ImageURL string `form:"image_url" json:"image_url" validate:"trim,max=2048,url"`
+	// Price is in yen, 0 if not given.
+	Price int `form:"price" json:"price" validate:"min=0,max=9999999"`
+}
+
+func (req *AddItemRequest) validate() []FieldError {
+	var errs []FieldError
+	// STEP 4-2: validate the category field
+	if req.Category == "" && req.CategoryID == 0 {
+		errs = append(errs, FieldError{Field: "category", Message: "is required unless category_id is given"})
+	}
+	if req.Category != "" && req.CategoryID != 0 {
+		errs = append(errs, FieldError{Field: "category_id", Message: "can't be given together with category"})
+	}
+	// STEP 4-4: validate the image field
+	if len(req.Image) == 0 && req.ImageURL == "" {
+		errs = append(errs, FieldError{Field: "image", Message: "is required unless image_url is given"})
+	}
+	if len(req.Image) > 0 && req.ImageURL != "" {
+		errs = append(errs, FieldError{Field: "image_url", Message: "can't be given together with image"})
+	}
+	return errs
 }
 
 type AddItemResponse struct {
 	Message string `json:"message"`
+	Item    Item   `json:"item"`
+	// PossibleDuplicate is true when the image is near-identical to the image of another listing.
+	PossibleDuplicate bool   `json:"possible_duplicate"`
+	SimilarItems      []Item `json:"similar_items,omitempty"`
 }
 
 // parseAddItemRequest parses and validates the request to add an item.
+// It accepts multipart or urlencoded forms and application/json bodies.
 func parseAddItemRequest(r *http.Request) (*AddItemRequest, error) {
-	req := &AddItemRequest{
-		Name: r.FormValue("name"),
-		Category: r.FormValue("category"), // STEP 4-2: add a category field
-	}
-
-	// STEP 4-4: add an image field
-	file, _, err := r.FormFile("image")
- 	if err != nil {
- 		return nil, fmt.Errorf("failed to get image: %w", err)
- 	}
-	defer file.Close()
-
-	imageData, err := io.ReadAll(file)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read image: %w", err)
-	}
-	req.Image = imageData
-
-	// validate the request
-	if req.Name == "" {
-		return nil, errors.New("name is required")
+	req := &AddItemRequest{}
+	if err := bind(r, req); err != nil {
+		return nil, err
 	}
+	return req, nil
+}
 
-	// STEP 4-2: validate the category field
-	if req.Category == "" {
-		return nil, errors.New("category is requred")
+// imageSizeLimit returns the largest image accepted by POST /items.
+func (s *Handlers) imageSizeLimit() int64 {
+	if s.maxImageSize > 0 {
+		return s.maxImageSize
 	}
-
-	// STEP 4-4: validate the image field
-	if len(req.Image) == 0 {
-		return nil, errors.New("image is requred")
-	}
-
-	return req, nil
+	return defaultMaxImageSize
 }
 
 // AddItem is a handler to add a new item for POST /items .
 func (s *Handlers) AddItem(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFromContext(r.Context())
 	ctx := r.Context()
 
+	// leave room for the base64 encoding of an image in JSON bodies and for the other fields
+	maxImageSize := s.imageSizeLimit()
+	r.Body = http.MaxBytesReader(w, r.Body, maxImageSize*4/3+1<<20)
+	_, span := startSpan(ctx, "parseAddItemRequest")
 	req, err := parseAddItemRequest(r)
+	endSpan(span, err)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeRequestError(w, r, err)
 		return
 	}
 
+	if req.ImageURL != "" {
+		req.Image, err = s.imageFetcher.Fetch(ctx, req.ImageURL)
+		if err != nil {
+			logger.Warn("failed to fetch image: ", "url", req.ImageURL, "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if int64(len(req.Image)) > maxImageSize {
+		http.Error(w, fmt.Sprintf("image is larger than %d bytes", maxImageSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// sha256 only dedupes byte-identical images, so re-saved or cropped photos are found by a perceptual hash
+	_, span = startSpan(ctx, "imageDHash")
+	phash, err := imageDHash(req.Image)
+	endSpan(span, err)
+	if errors.Is(err, errImageTooLarge) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	hasPHash := err == nil
+	if err != nil {
+		// the item is still accepted, only duplicate detection is skipped
+		logger.Warn("failed to compute perceptual hash: ", "error", err)
+	}
+
 	// STEP 4-4: uncomment on adding an implementation to store an image
-	fileName, err := s.storeImage(req.Image)
+	fileName, err := s.storeImage(ctx, req.Image)
 	if err != nil {
-		slog.Error("failed to store image: ", "error", err)
+		logger.Error("failed to store image: ", "error", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	imageFileName := filepath.Base(fileName)
+	// items listed by a signed-in user are shown on their profile
+	sellerID, _ := currentUserID(ctx)
+	var similarItems []Item
+	if hasPHash {
+		similarItems, err = s.itemRepo.FindItemsByImageHash(ctx, phash, similarImageMaxDistance, sellerID)
+		if err != nil {
+			logger.Error("failed to find similar items: ", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 
 	item := &Item{
-		Name: req.Name,
-		Category: req.Category, // STEP 4-2: add a category field
-		Image: imageFileName, // STEP 4-4: add an image field
+		Name:       req.Name,
+		Category:   req.Category, // STEP 4-2: add a category field
+		CategoryID: req.CategoryID,
+		Image:      fileName, // STEP 4-4: add an image field
+		Price:      req.Price,
+		SellerID:   sellerID,
 	}
 	message := fmt.Sprintf("item received: %s", item.Name)
-	slog.Info(message)
+	logger.Info(message)
 
 	// STEP 4-2: add an implementation to store an item
 	err = s.itemRepo.Insert(ctx, item)
+	if errors.Is(err, errCategoryNotFound) || errors.Is(err, errInvalidCategory) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
-		slog.Error("failed to store item: ", "error", err)
+		logger.Error("failed to store item: ", "error", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	resp := AddItemResponse{Message: message}
+	if hasPHash {
+		if err := s.itemRepo.SaveImageHash(ctx, fileName, phash); err != nil {
+			logger.Error("failed to store perceptual hash: ", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if len(similarItems) > 0 {
+		logger.Warn("item image is near-identical to other listings", "image", fileName, "similar_items", len(similarItems))
+	}
+
+	resp := AddItemResponse{
+		Message:           message,
+		Item:              *item,
+		PossibleDuplicate: len(similarItems) > 0,
+		SimilarItems:      similarItems,
+	}
 	err = json.NewEncoder(w).Encode(resp)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -184,10 +589,12 @@ type GetItemResponse struct {
 
 // GetItem is a handler to show items stored in images.json for GET /items .
 func (s *Handlers) GetItem(w http.ResponseWriter, r *http.Request) {
-	items, err := s.itemRepo.LoadFromDatabase() //use ItemRepository
+	logger := loggerFromContext(r.Context())
+	items, err := s.itemRepo.LoadFromDatabase(r.Context()) //use ItemRepository
 	if err != nil {
-		slog.Error("Failed to load items: ", "error", err)
+		logger.Error("Failed to load items: ", "error", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	resp := GetItemResponse{Items: items} //this is the data returned as the response
@@ -198,23 +605,29 @@ func (s *Handlers) GetItem(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// ItemIDRequest is the request of the handlers for GET /items/{item_id} and below.
+type ItemIDRequest struct {
+	ID int `path:"item_id" validate:"required,min=1"`
+}
+
 //only returns one item
 type GetItemByIDResponse struct {
 	Item Item `json:"item"`
 }
 
 func (s *Handlers) GetItemByID(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFromContext(r.Context())
 	//get the item_id from the path parameter
-	idStr := r.PathValue("item_id")
-	id, err := strconv.Atoi(idStr)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	var req ItemIDRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
 		return
 	}
+	id := req.ID
 
-	items, err := s.itemRepo.LoadFromDatabase()
+	items, err := s.itemRepo.LoadFromDatabase(r.Context())
 	if err != nil {
-		slog.Error("failed to load items: ", "error", err)
+		logger.Error("failed to load items: ", "error", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}	
@@ -240,27 +653,85 @@ func (s *Handlers) GetItemByID(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+type GetSimilarItemsResponse struct {
+	Items []Item `json:"items"`
+}
+
+// GetSimilarItems is a handler to return items whose image is near-identical to the item's for GET /items/{item_id}/similar .
+func (s *Handlers) GetSimilarItems(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFromContext(r.Context())
+	var req ItemIDRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+
+	items, err := s.itemRepo.FindSimilarItems(r.Context(), req.ID, similarImageMaxDistance)
+	if err != nil {
+		if errors.Is(err, errItemNotFound) {
+			http.Error(w, "Item not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("failed to find similar items: ", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := GetSimilarItemsResponse{Items: items}
+	if resp.Items == nil {
+		resp.Items = []Item{}
+	}
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 var SearchItemResponse struct {
 	Item Item `json:"item"`
 }
 
+type SearchItemRequest struct {
+	Keyword string `query:"keyword" validate:"trim,required,max=100,singleline"`
+	// CategoryID narrows the search to the category and its subcategories.
+	CategoryID *int `query:"category_id" validate:"min=1"`
+	MinPrice   *int `query:"min_price" validate:"min=0"`
+	MaxPrice   *int `query:"max_price" validate:"min=0"`
+}
+
+func (req *SearchItemRequest) validate() []FieldError {
+	return req.criteria().validate()
+}
+
+// criteria returns the criteria of the search.
+func (req *SearchItemRequest) criteria() SearchCriteria {
+	return SearchCriteria{Keyword: req.Keyword, CategoryID: req.CategoryID, MinPrice: req.MinPrice, MaxPrice: req.MaxPrice}
+}
+
 func (s *Handlers) SearchItem(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFromContext(r.Context())
 	//get the keyword from the query parameter
-	keyword := r.URL.Query().Get("keyword")
-	if keyword == "" {
-		http.Error(w, "Keyword is required", http.StatusBadRequest)
+	req := &SearchItemRequest{}
+	if err := bind(r, req); err != nil {
+		writeRequestError(w, r, err)
 		return
 	}
+	where, args := req.criteria().where()
 
 	//use "LIKE" to search for items that contain the keyword
-	rows, err := s.db.Query(`
-		SELECT items.id, items.name, categories.name AS category, items.image_name
+	start := time.Now()
+	ctx, span := startSpan(r.Context(), "SELECT items")
+	defer span.End()
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT items.id, items.name, categories.name AS category, items.category_id, items.image_name, items.price, IFNULL(items.seller_id, 0)
 		FROM items
 		JOIN categories ON items.category_id = categories.id
-		WHERE items.name LIKE ?`, "%"+keyword+"%")
-	
+		WHERE `+where, args...)
+	s.metrics.observeDB("SearchItem", err, time.Since(start))
+
 	if err != nil {
-		slog.Error("items not found: ", "error", err)
+		logger.Error("items not found: ", "error", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -269,9 +740,9 @@ func (s *Handlers) SearchItem(w http.ResponseWriter, r *http.Request) {
 	var items []Item
 	for rows.Next() {
 		var item Item
-		err := rows.Scan(&item.ID, &item.Name, &item.Category, &item.Image)
+		err := rows.Scan(&item.ID, &item.Name, &item.Category, &item.CategoryID, &item.Image, &item.Price, &item.SellerID)
 		if err != nil {
-			slog.Error("failed to scan item: ", "error", err)
+			logger.Error("failed to scan item: ", "error", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -285,99 +756,160 @@ func (s *Handlers) SearchItem(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// storeImage stores an image and returns the file path and an error if any.
+// storeImage stores an image and returns the file name and an error if any.
 // this method calculates the hash sum of the image as a file name to avoid the duplication of a same file
-// and stores it in the image directory.
-func (s *Handlers) storeImage(image []byte) (filePath string, err error) {
+// and stores it in the image store.
+func (s *Handlers) storeImage(ctx context.Context, image []byte) (fileName string, err error) {
+	return s.storeImageWithPrefix(ctx, "", image)
+}
+
+// storeImageWithPrefix is storeImage with the prefix prepended to the file name,
+// which keeps images such as message attachments apart from the public images.
+func (s *Handlers) storeImageWithPrefix(ctx context.Context, prefix string, image []byte) (fileName string, err error) {
+	ctx, span := startSpan(ctx, "storeImage", attribute.Int("image.size", len(image)))
+	defer func() { endSpan(span, err) }()
+	logger := loggerFromContext(ctx)
 	// STEP 4-4: add an implementation to store an image
-	// TODO:
 	// - calc hash sum
 	hash := sha256.Sum256(image)
 	hashedValue := hex.EncodeToString(hash[:])
-	fileName := hashedValue + ".jpg"
-
-	// - build image file path
-	filePath = filepath.Join(s.imgDirPath, fileName)
+	fileName = prefix + hashedValue + ".jpg"
 
 	// - check if the image already exists
-	if _, err := os.Stat(filePath); err == nil {
-		return filePath, nil
+	// an existing file may be corrupted, e.g. written by an older version without atomic writes
+	valid, err := s.imageStore.Verify(ctx, fileName, image)
+	switch {
+	case errors.Is(err, errImageNotFound):
+	case err != nil:
+		return "", err
+	case valid:
+		// the file may be older than the grace period of the sweeper, which would delete it before the new item refers to it
+		if err := s.imageStore.Touch(ctx, fileName); err != nil {
+			return "", err
+		}
+		s.metrics.observeImageUpload(len(image), true)
+		return fileName, nil
+	default:
+		logger.Warn("stored image does not match its hash, overwriting", "filename", fileName)
 	}
 
 	// - store image
-	if err := StoreImage(s.imgDirPath,fileName, image); err != nil {
+	if err := s.imageStore.Put(ctx, fileName, image); err != nil {
 		return "", err
 	}
-	
-	// - return the image file path
-	return filePath, nil
+	s.metrics.observeImageUpload(len(image), false)
+
+	// - return the image file name
+	return fileName, nil
 }
 
 type GetImageRequest struct {
-	FileName string // path value
+	FileName string `path:"filename" validate:"required"`
 }
 
 // parseGetImageRequest parses and validates the request to get an image.
 func parseGetImageRequest(r *http.Request) (*GetImageRequest, error) {
-	req := &GetImageRequest{
-		FileName: r.PathValue("filename"), // from path parameter
+	req := &GetImageRequest{}
+	if err := bind(r, req); err != nil {
+		return nil, err
 	}
-
-	// validate the request
-	if req.FileName == "" {
-		return nil, errors.New("filename is required")
-	}
-
 	return req, nil
 }
 
 // GetImage is a handler to return an image for GET /images/{filename} .
-// If the specified image is not found, it returns the default image.
+// If the specified image is not found, it returns the default image with 404 status.
+//
+// Images stored by storeImage are named after the sha256 of their content, so they never change
+// and are served with an immutable cache policy and a strong ETag.
+// Conditional and Range requests are handled by http.ServeContent.
 func (s *Handlers) GetImage(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFromContext(r.Context())
+	ctx := r.Context()
+
 	req, err := parseGetImageRequest(r)
 	if err != nil {
-		slog.Warn("failed to parse get image request: ", "error", err)
+		logger.Warn("failed to parse get image request: ", "error", err)
+		writeRequestError(w, r, err)
+		return
+	}
+
+	if err := validateImageFileName(req.FileName); err != nil {
+		logger.Warn("invalid image file name: ", "error", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	// message attachments are private to the parties of the transaction, see GetMessageImage
+	if strings.HasPrefix(req.FileName, messageImagePrefix) {
+		s.serveDefaultImage(ctx, w)
+		return
+	}
 
-	imgPath, err := s.buildImagePath(req.FileName)
+	img, err := s.imageStore.Get(ctx, req.FileName)
 	if err != nil {
 		if !errors.Is(err, errImageNotFound) {
-			slog.Warn("failed to build image path: ", "error", err)
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			logger.Error("failed to get image: ", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
 		// when the image is not found, it returns the default image without an error.
-		slog.Debug("image not found", "filename", imgPath)
-		imgPath = filepath.Join(s.imgDirPath, "default.jpg")
+		logger.Debug("image not found", "filename", req.FileName)
+		s.serveDefaultImage(ctx, w)
+		return
+	}
+	defer img.Close()
+
+	if hashedImageName.MatchString(req.FileName) {
+		w.Header().Set("Cache-Control", immutableCacheControl)
+		w.Header().Set("ETag", `"`+strings.TrimSuffix(req.FileName, ".jpg")+`"`)
+	} else {
+		// other images may be replaced under the same name, so they are revalidated with Last-Modified
+		w.Header().Set("Cache-Control", "no-cache")
 	}
 
-	slog.Info("returned image", "path", imgPath)
-	http.ServeFile(w, r, imgPath)
+	logger.Info("returned image", "filename", req.FileName)
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	http.ServeContent(rec, r, req.FileName, img.ModTime, img)
+	s.metrics.observeImageServed(rec.bytes)
 }
 
-// buildImagePath builds the image path and validates it.
-func (s *Handlers) buildImagePath(imageFileName string) (string, error) {
-	imgPath := filepath.Join(s.imgDirPath, filepath.Clean(imageFileName))
+const (
+	// immutableCacheControl is the cache policy of content-addressed images.
+	immutableCacheControl = "public, max-age=31536000, immutable"
+	// defaultImageCacheControl is the cache policy of the default image served for unknown names.
+	// It is short because the requested image may be uploaded soon.
+	defaultImageCacheControl = "public, max-age=60"
+)
 
-	// to prevent directory traversal attacks
-	rel, err := filepath.Rel(s.imgDirPath, imgPath)
-	if err != nil || strings.HasPrefix(rel, "..") {
-		return "", fmt.Errorf("invalid image path: %s", imgPath)
+// serveDefaultImage writes the default image with 404 status.
+// http.ServeContent can't be used since it always responds with 200 or 206.
+func (s *Handlers) serveDefaultImage(ctx context.Context, w http.ResponseWriter) {
+	logger := loggerFromContext(ctx)
+	image, err := os.ReadFile(filepath.Join(s.imgDirPath, "default.jpg"))
+	if err != nil {
+		logger.Error("failed to read default image: ", "error", err)
+		http.Error(w, "image not found", http.StatusNotFound)
+		return
 	}
 
-	// validate the image suffix
-	if !strings.HasSuffix(imgPath, ".jpg") && !strings.HasSuffix(imgPath, ".jpeg") {
-		return "", fmt.Errorf("image path does not end with .jpg or .jpeg: %s", imgPath)
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Content-Length", strconv.Itoa(len(image)))
+	w.Header().Set("Cache-Control", defaultImageCacheControl)
+	w.WriteHeader(http.StatusNotFound)
+	w.Write(image)
+}
+
+// validateImageFileName validates the file name of an image.
+func validateImageFileName(imageFileName string) error {
+	// to prevent directory traversal attacks
+	if err := validateImageName(imageFileName); err != nil {
+		return err
 	}
 
-	// check if the image exists
-	_, err = os.Stat(imgPath)
-	if err != nil {
-		return imgPath, errImageNotFound
+	// validate the image suffix
+	if !strings.HasSuffix(imageFileName, ".jpg") && !strings.HasSuffix(imageFileName, ".jpeg") {
+		return fmt.Errorf("image path does not end with .jpg or .jpeg: %s", imageFileName)
 	}
 
-	return imgPath, nil
+	return nil
 }
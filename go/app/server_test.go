@@ -1,11 +1,21 @@
 package app
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
-	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"go.uber.org/mock/gomock"
@@ -26,17 +36,82 @@ func TestParseAddItemRequest(t *testing.T) {
 	}{
 		"ok: valid request": {
 			args: map[string]string{
-				"name":     "", // fill here
-				"category": "", // fill here
+				"name":     "used iPhone 16e",
+				"category": "phone",
 			},
 			wants: wants{
 				req: &AddItemRequest{
-					Name: "", // fill here
-					// Category: "", // fill here
+					Name:     "used iPhone 16e",
+					Category: "phone",
+					Image:    testImage,
 				},
 				err: false,
 			},
 		},
+		"ok: existing category": {
+			args: map[string]string{
+				"name":        "used iPhone 16e",
+				"category_id": "3",
+			},
+			wants: wants{
+				req: &AddItemRequest{
+					Name:       "used iPhone 16e",
+					CategoryID: 3,
+					Image:      testImage,
+				},
+			},
+		},
+		"ok: values are trimmed": {
+			args: map[string]string{
+				"name":     "  used iPhone 16e\t",
+				"category": " phone ",
+			},
+			wants: wants{
+				req: &AddItemRequest{
+					Name:     "used iPhone 16e",
+					Category: "phone",
+					Image:    testImage,
+				},
+			},
+		},
+		"ok: with price": {
+			args: map[string]string{
+				"name":     "used iPhone 16e",
+				"category": "phone",
+				"price":    "45000",
+			},
+			wants: wants{
+				req: &AddItemRequest{
+					Name:     "used iPhone 16e",
+					Category: "phone",
+					Image:    testImage,
+					Price:    45000,
+				},
+			},
+		},
+		"ng: negative price": {
+			args: map[string]string{
+				"name":     "used iPhone 16e",
+				"category": "phone",
+				"price":    "-1",
+			},
+			wants: wants{err: true},
+		},
+		"ng: name too long": {
+			args: map[string]string{
+				"name":     strings.Repeat("あ", 101),
+				"category": "phone",
+			},
+			wants: wants{err: true},
+		},
+		"ng: both category and category_id": {
+			args: map[string]string{
+				"name":        "used iPhone 16e",
+				"category":    "phone",
+				"category_id": "3",
+			},
+			wants: wants{err: true},
+		},
 		"ng: empty request": {
 			args: map[string]string{},
 			wants: wants{
@@ -50,18 +125,12 @@ func TestParseAddItemRequest(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
 
-			// prepare request body
-			values := url.Values{}
-			for k, v := range tt.args {
-				values.Set(k, v)
-			}
-
 			// prepare HTTP request
-			req, err := http.NewRequest("POST", "http://localhost:9000/items", strings.NewReader(values.Encode()))
-			if err != nil {
-				t.Fatalf("failed to create request: %v", err)
+			var image []byte
+			if len(tt.args) > 0 {
+				image = testImage
 			}
-			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req := newAddItemRequest(t, tt.args, image)
 
 			// execute test target
 			got, err := parseAddItemRequest(req)
@@ -73,6 +142,9 @@ func TestParseAddItemRequest(t *testing.T) {
 				}
 				return
 			}
+			if tt.err {
+				t.Fatalf("expected error, got nil")
+			}
 			if diff := cmp.Diff(tt.wants.req, got); diff != "" {
 				t.Errorf("unexpected request (-want +got):\n%s", diff)
 			}
@@ -83,16 +155,15 @@ func TestParseAddItemRequest(t *testing.T) {
 func TestHelloHandler(t *testing.T) {
 	t.Parallel()
 
-	// Please comment out for STEP 6-2
 	// predefine what we want
-	// type wants struct {
-	// 	code int               // desired HTTP status code
-	// 	body map[string]string // desired body
-	// }
-	// want := wants{
-	// 	code: http.StatusOK,
-	// 	body: map[string]string{"message": "Hello, world!"},
-	// }
+	type wants struct {
+		code int               // desired HTTP status code
+		body map[string]string // desired body
+	}
+	want := wants{
+		code: http.StatusOK,
+		body: map[string]string{"message": "Hello, world!"},
+	}
 
 	// set up test
 	req := httptest.NewRequest("GET", "/hello", nil)
@@ -102,8 +173,18 @@ func TestHelloHandler(t *testing.T) {
 	h.Hello(res, req)
 
 	// STEP 6-2: confirm the status code
+	if res.Code != want.code {
+		t.Errorf("expected status code %d, got %d", want.code, res.Code)
+	}
 
 	// STEP 6-2: confirm response body
+	var got map[string]string
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response body: %v", err)
+	}
+	if diff := cmp.Diff(want.body, got); diff != "" {
+		t.Errorf("unexpected response body (-want +got):\n%s", diff)
+	}
 }
 
 func TestAddItem(t *testing.T) {
@@ -125,6 +206,7 @@ func TestAddItem(t *testing.T) {
 			injector: func(m *MockItemRepository) {
 				// STEP 6-3: define mock expectation
 				// succeeded to insert
+				m.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(nil)
 			},
 			wants: wants{
 				code: http.StatusOK,
@@ -138,6 +220,7 @@ func TestAddItem(t *testing.T) {
 			injector: func(m *MockItemRepository) {
 				// STEP 6-3: define mock expectation
 				// failed to insert
+				m.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(errors.New("failed to insert"))
 			},
 			wants: wants{
 				code: http.StatusInternalServerError,
@@ -153,14 +236,9 @@ func TestAddItem(t *testing.T) {
 
 			mockIR := NewMockItemRepository(ctrl)
 			tt.injector(mockIR)
-			h := &Handlers{itemRepo: mockIR}
+			h := &Handlers{itemRepo: mockIR, imageStore: NewLocalImageStore(t.TempDir())}
 
-			values := url.Values{}
-			for k, v := range tt.args {
-				values.Set(k, v)
-			}
-			req := httptest.NewRequest("POST", "/items", strings.NewReader(values.Encode()))
-			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req := newAddItemRequest(t, tt.args, testImage)
 
 			rr := httptest.NewRecorder()
 			h.AddItem(rr, req)
@@ -181,6 +259,481 @@ func TestAddItem(t *testing.T) {
 	}
 }
 
+func TestAddItemFlagsSimilarImage(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	mockIR := NewMockItemRepository(ctrl)
+	similar := []Item{{ID: 1, Name: "iPhone", Category: "phone", Image: "a.jpg"}}
+	mockIR.EXPECT().FindItemsByImageHash(gomock.Any(), gomock.Any(), similarImageMaxDistance, 0).Return(similar, nil)
+	mockIR.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(nil)
+	mockIR.EXPECT().SaveImageHash(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+
+	h := &Handlers{itemRepo: mockIR, imageStore: NewLocalImageStore(t.TempDir())}
+	image := encodeJPEG(t, gradientImage(64, 48, 0, false), 90)
+	req := newAddItemRequest(t, map[string]string{"name": "used iPhone", "category": "phone"}, image)
+	rr := httptest.NewRecorder()
+	h.AddItem(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+	var got AddItemResponse
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response body: %v", err)
+	}
+	if !got.PossibleDuplicate || len(got.SimilarItems) != 1 {
+		t.Fatalf("expected the item to be flagged as a possible duplicate, got %+v", got)
+	}
+	// the similar listings are identified by their IDs, for GET /items/{item_id}
+	if got.SimilarItems[0].ID != 1 {
+		t.Errorf("expected the ID of the similar item, got %+v", got.SimilarItems[0])
+	}
+}
+
+func TestAddItemRejectsTooManyPixels(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	h := &Handlers{itemRepo: NewMockItemRepository(ctrl), imageStore: NewLocalImageStore(t.TempDir())}
+	req := newAddItemRequest(t, map[string]string{"name": "poster", "category": "art"}, pngHeader(12000, 12000))
+	rr := httptest.NewRecorder()
+	h.AddItem(rr, req)
+
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status code %d, got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+}
+
+func TestGetItemDatabaseError(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	mockIR := NewMockItemRepository(ctrl)
+	mockIR.EXPECT().LoadFromDatabase(gomock.Any()).Return(nil, errors.New("database is locked"))
+	h := &Handlers{itemRepo: mockIR}
+	rr := httptest.NewRecorder()
+	h.GetItem(rr, httptest.NewRequest("GET", "/items", nil))
+
+	// the error is the whole response, without an empty item list after it
+	if rr.Code != http.StatusInternalServerError || rr.Body.String() != "database is locked\n" {
+		t.Errorf("expected status code %d with the error only, got %d %q", http.StatusInternalServerError, rr.Code, rr.Body.String())
+	}
+}
+
+func TestGetSimilarItems(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code int
+	}
+	cases := map[string]struct {
+		itemID   string
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: similar items found": {
+			itemID: "1",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().FindSimilarItems(gomock.Any(), 1, similarImageMaxDistance).Return([]Item{{ID: 2, Name: "iPhone"}}, nil)
+			},
+			wants: wants{code: http.StatusOK},
+		},
+		"ng: item not found": {
+			itemID: "1",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().FindSimilarItems(gomock.Any(), 1, similarImageMaxDistance).Return(nil, errItemNotFound)
+			},
+			wants: wants{code: http.StatusNotFound},
+		},
+		"ng: invalid item id": {
+			itemID:   "abc",
+			injector: func(m *MockItemRepository) {},
+			wants:    wants{code: http.StatusBadRequest},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+			h := &Handlers{itemRepo: mockIR}
+
+			req := httptest.NewRequest("GET", "/items/"+tt.itemID+"/similar", nil)
+			req.SetPathValue("item_id", tt.itemID)
+			rr := httptest.NewRecorder()
+			h.GetSimilarItems(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+		})
+	}
+}
+
+func TestStoreImageReplacesCorruptedImage(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	h := &Handlers{imageStore: NewLocalImageStore(dir)}
+
+	fileName, err := h.storeImage(context.Background(), testImage)
+	if err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+
+	// simulate a write truncated by a crash
+	if err := os.WriteFile(filepath.Join(dir, fileName), testImage[:3], 0o644); err != nil {
+		t.Fatalf("failed to truncate image: %v", err)
+	}
+
+	if _, err := h.storeImage(context.Background(), testImage); err != nil {
+		t.Fatalf("failed to store image again: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, fileName))
+	if err != nil {
+		t.Fatalf("failed to read image: %v", err)
+	}
+	if !bytes.Equal(got, testImage) {
+		t.Errorf("expected corrupted image to be replaced, got %q", got)
+	}
+}
+
+func TestStoreImageRefreshesExistingImage(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	h := &Handlers{imageStore: NewLocalImageStore(dir)}
+
+	fileName, err := h.storeImage(context.Background(), testImage)
+	if err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+	// an image uploaded long ago, which the sweeper deletes once no item refers to it
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, fileName), old, old); err != nil {
+		t.Fatalf("failed to age image: %v", err)
+	}
+
+	if _, err := h.storeImage(context.Background(), testImage); err != nil {
+		t.Fatalf("failed to store image again: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(dir, fileName))
+	if err != nil {
+		t.Fatalf("failed to stat image: %v", err)
+	}
+	if !info.ModTime().After(old.Add(time.Hour)) {
+		t.Errorf("expected the reused image to be refreshed, got modification time %v", info.ModTime())
+	}
+}
+
+func TestStoreImageReusesS3ImageWithoutDownload(t *testing.T) {
+	t.Parallel()
+
+	fake, srv := newFakeS3(t, "images")
+	store, err := NewS3ImageStore(S3Config{Endpoint: srv.URL, Bucket: "images", AccessKeyID: "AKID", SecretAccessKey: "secret"})
+	if err != nil {
+		t.Fatalf("failed to create s3 image store: %v", err)
+	}
+	h := &Handlers{imageStore: store}
+
+	for range 2 {
+		if _, err := h.storeImage(context.Background(), testImage); err != nil {
+			t.Fatalf("failed to store image: %v", err)
+		}
+	}
+	// the checksum kept by S3 is compared instead of the image
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.gets != 0 || len(fake.objects) != 1 {
+		t.Errorf("expected one image stored without downloads, got %d downloads of %d objects", fake.gets, len(fake.objects))
+	}
+}
+
+func TestGetImage(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	defaultImage := []byte("default image")
+	if err := os.WriteFile(filepath.Join(dir, "default.jpg"), defaultImage, 0o644); err != nil {
+		t.Fatalf("failed to write default image: %v", err)
+	}
+	h := &Handlers{imgDirPath: dir, imageStore: NewLocalImageStore(dir)}
+	fileName, err := h.storeImage(context.Background(), testImage)
+	if err != nil {
+		t.Fatalf("failed to store image: %v", err)
+	}
+	etag := `"` + strings.TrimSuffix(fileName, ".jpg") + `"`
+
+	type wants struct {
+		code         int
+		body         []byte
+		cacheControl string
+		etag         string
+	}
+	cases := map[string]struct {
+		fileName string
+		headers  map[string]string
+		wants
+	}{
+		"ok: hashed image": {
+			fileName: fileName,
+			wants: wants{
+				code:         http.StatusOK,
+				body:         testImage,
+				cacheControl: immutableCacheControl,
+				etag:         etag,
+			},
+		},
+		"ok: not modified": {
+			fileName: fileName,
+			headers:  map[string]string{"If-None-Match": etag},
+			wants: wants{
+				code:         http.StatusNotModified,
+				body:         []byte{},
+				cacheControl: immutableCacheControl,
+				etag:         etag,
+			},
+		},
+		"ok: modified": {
+			fileName: fileName,
+			headers:  map[string]string{"If-None-Match": `"other"`},
+			wants: wants{
+				code:         http.StatusOK,
+				body:         testImage,
+				cacheControl: immutableCacheControl,
+				etag:         etag,
+			},
+		},
+		"ok: range": {
+			fileName: fileName,
+			headers:  map[string]string{"Range": "bytes=0-3"},
+			wants: wants{
+				code:         http.StatusPartialContent,
+				body:         testImage[:4],
+				cacheControl: immutableCacheControl,
+				etag:         etag,
+			},
+		},
+		"ng: unknown image returns default image": {
+			fileName: strings.Repeat("0", 64) + ".jpg",
+			wants: wants{
+				code:         http.StatusNotFound,
+				body:         defaultImage,
+				cacheControl: defaultImageCacheControl,
+			},
+		},
+		"ng: invalid suffix": {
+			fileName: "image.png",
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest("GET", "/images/"+tt.fileName, nil)
+			req.SetPathValue("filename", tt.fileName)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+			h.GetImage(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if tt.wants.code == http.StatusBadRequest {
+				return
+			}
+			if !bytes.Equal(tt.wants.body, rr.Body.Bytes()) {
+				t.Errorf("unexpected body: %q", rr.Body.Bytes())
+			}
+			if got := rr.Header().Get("Cache-Control"); got != tt.wants.cacheControl {
+				t.Errorf("expected Cache-Control %q, got %q", tt.wants.cacheControl, got)
+			}
+			if got := rr.Header().Get("ETag"); got != tt.wants.etag {
+				t.Errorf("expected ETag %q, got %q", tt.wants.etag, got)
+			}
+		})
+	}
+}
+
+func TestServeDrainsInFlightUpload(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	mockIR := NewMockItemRepository(ctrl)
+	mockIR.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(nil)
+	h := &Handlers{itemRepo: mockIR, imageStore: NewLocalImageStore(t.TempDir())}
+
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		h.AddItem(w, r)
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	ctx, shutdown := context.WithCancel(context.Background())
+	defer shutdown()
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- serve(ctx, newHTTPServer(handler, DefaultConfig().HTTP), ln, 5*time.Second)
+	}()
+
+	// stream the upload so that it is still in flight when the shutdown begins
+	body := newAddItemRequest(t, map[string]string{"name": "used iPhone 16e", "category": "phone"}, testImage)
+	payload, err := io.ReadAll(body.Body)
+	if err != nil {
+		t.Fatalf("failed to read request body: %v", err)
+	}
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest("POST", "http://"+ln.Addr().String()+"/items", pr)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", body.Header.Get("Content-Type"))
+
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		done <- result{resp, err}
+	}()
+
+	half := len(payload) / 2
+	if _, err := pw.Write(payload[:half]); err != nil {
+		t.Fatalf("failed to write first half: %v", err)
+	}
+	<-started
+	shutdown()
+
+	// new connections are refused once the listener is closed
+	for i := 0; ; i++ {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if i > 100 {
+			t.Fatal("listener was not closed on shutdown")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := pw.Write(payload[half:]); err != nil {
+		t.Fatalf("failed to write second half: %v", err)
+	}
+	pw.Close()
+
+	res := <-done
+	if res.err != nil {
+		t.Fatalf("in-flight upload failed: %v", res.err)
+	}
+	defer res.resp.Body.Close()
+	if res.resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status code %d, got %d", http.StatusOK, res.resp.StatusCode)
+	}
+	if err := <-serveErr; err != nil {
+		t.Errorf("expected graceful shutdown, got %v", err)
+	}
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	ctx, shutdown := context.WithCancel(context.Background())
+	defer shutdown()
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- serve(ctx, newHTTPServer(handler, DefaultConfig().HTTP), ln, 100*time.Millisecond)
+	}()
+
+	go http.Get("http://" + ln.Addr().String() + "/")
+	<-started
+	shutdown()
+
+	if err := <-serveErr; err == nil {
+		t.Error("expected an error when in-flight requests outlive the shutdown timeout")
+	}
+}
+
+// newTestDB creates a temporary SQLite database with the schema in db/items.sql.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	schema, err := os.ReadFile("../db/items.sql")
+	if err != nil {
+		t.Fatalf("failed to read schema: %v", err)
+	}
+	db, err := OpenDatabase(filepath.Join(t.TempDir(), "mercari.sqlite3"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatalf("failed to apply schema: %v", err)
+	}
+	return db
+}
+
+// testImage is the content of an image file used in tests.
+var testImage = []byte("\xff\xd8\xff\xe0test image\xff\xd9")
+
+// newAddItemRequest builds a multipart POST /items request with the given form values and image.
+func newAddItemRequest(t *testing.T, args map[string]string, image []byte) *http.Request {
+	t.Helper()
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	for k, v := range args {
+		if err := mw.WriteField(k, v); err != nil {
+			t.Fatalf("failed to write field: %v", err)
+		}
+	}
+	if image != nil {
+		fw, err := mw.CreateFormFile("image", "image.jpg")
+		if err != nil {
+			t.Fatalf("failed to create form file: %v", err)
+		}
+		if _, err := fw.Write(image); err != nil {
+			t.Fatalf("failed to write image: %v", err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("failed to close multipart writer: %v", err)
+	}
+
+	req := httptest.NewRequest("POST", "/items", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
 // STEP 6-4: uncomment this test
 // func TestAddItemE2e(t *testing.T) {
 // 	if testing.Short() {
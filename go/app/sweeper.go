@@ -0,0 +1,153 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultSweepGrace is how long an unreferenced image is kept by default.
+	// It must be long enough for an upload to be stored and then inserted as an item.
+	defaultSweepGrace = 24 * time.Hour
+	// defaultSweepInterval is how often the server sweeps images by default.
+	defaultSweepInterval = time.Hour
+)
+
+// hashedImageName matches the names given to images by storeImage.
+// Other files such as default.jpg are never swept.
+var hashedImageName = regexp.MustCompile(`^[0-9a-f]{64}\.jpg$`)
+
+// hashedMessageImageName matches the names given to message attachments by storeImageWithPrefix.
+var hashedMessageImageName = regexp.MustCompile(`^` + messageImagePrefix + `[0-9a-f]{64}\.jpg$`)
+
+// Sweeper is a one-shot command deleting images which are not referenced by any item.
+type Sweeper struct {
+	// Config is the validated configuration, see LoadConfig. Sweep.Grace is used as the grace period.
+	Config *Config
+	// DryRun only reports the images which would be deleted.
+	DryRun bool
+}
+
+// Run is a method to sweep images once.
+// This method returns 0 if the sweep succeeded, and 1 otherwise.
+func (s Sweeper) Run() int {
+	cfg := s.Config
+
+	logger, err := newLogger(os.Stderr, cfg.Log)
+	if err != nil {
+		slog.Error("failed to set up logger: ", "error", err)
+		return 1
+	}
+	slog.SetDefault(logger)
+
+	db, err := OpenDatabase(cfg.DatabasePath)
+	if err != nil {
+		slog.Error("failed to open database: ", "error", err)
+		return 1
+	}
+	defer db.Close()
+
+	imageStore, err := NewImageStore(cfg.ImageStore, cfg.ImageDirPath)
+	if err != nil {
+		slog.Error("failed to set up image store: ", "error", err)
+		return 1
+	}
+
+	sweeper := newImageSweeper(imageStore, NewItemRepository(db), cfg.Sweep.Grace)
+	sweeper.dryRun = s.DryRun
+
+	deleted, err := sweeper.Sweep(context.Background())
+	for _, name := range deleted {
+		fmt.Fprintln(os.Stdout, name)
+	}
+	if err != nil {
+		slog.Error("failed to sweep images: ", "error", err)
+		return 1
+	}
+	return 0
+}
+
+// imageSweeper deletes stored images which no item refers to.
+type imageSweeper struct {
+	imageStore ImageStore
+	itemRepo   ItemRepository
+	grace      time.Duration
+	dryRun     bool
+	// now is replaced in tests.
+	now func() time.Time
+}
+
+func newImageSweeper(imageStore ImageStore, itemRepo ItemRepository, grace time.Duration) *imageSweeper {
+	if grace <= 0 {
+		grace = defaultSweepGrace
+	}
+	return &imageSweeper{imageStore: imageStore, itemRepo: itemRepo, grace: grace, now: time.Now}
+}
+
+// Sweep deletes unreferenced images older than the grace period, and temporary files left by crashed writes.
+// It returns the names of the deleted images, or the ones which would be deleted in dry-run mode.
+func (s *imageSweeper) Sweep(ctx context.Context) ([]string, error) {
+	// list the store first: images stored after this point are left for the next sweep
+	infos, err := s.imageStore.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+
+	names, err := s.itemRepo.ListImageNames(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list referenced images: %w", err)
+	}
+	referenced := make(map[string]bool, len(names))
+	for _, name := range names {
+		referenced[name] = true
+	}
+
+	deadline := s.now().Add(-s.grace)
+	var deleted []string
+	for _, info := range infos {
+		if !hashedImageName.MatchString(info.Name) && !hashedMessageImageName.MatchString(info.Name) && !strings.HasPrefix(info.Name, tempImagePrefix) {
+			continue
+		}
+		if referenced[info.Name] || info.ModTime.After(deadline) {
+			continue
+		}
+
+		if !s.dryRun {
+			if err := s.imageStore.Delete(ctx, info.Name); err != nil {
+				return deleted, fmt.Errorf("failed to delete image %s: %w", info.Name, err)
+			}
+		}
+		deleted = append(deleted, info.Name)
+	}
+	return deleted, nil
+}
+
+// Start sweeps images every interval until ctx is done.
+func (s *imageSweeper) Start(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultSweepInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := s.Sweep(ctx)
+			if err != nil {
+				slog.Error("failed to sweep images: ", "error", err)
+			}
+			if len(deleted) > 0 {
+				slog.Info("swept unreferenced images", "count", len(deleted))
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/mock/gomock"
+)
+
+func TestImageSweeperSweep(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	referenced := strings.Repeat("a", 64) + ".jpg"
+	orphan := strings.Repeat("b", 64) + ".jpg"
+	recentOrphan := strings.Repeat("c", 64) + ".jpg"
+	messageOrphan := messageImagePrefix + strings.Repeat("d", 64) + ".jpg"
+	staleTemp := tempImagePrefix + "123"
+
+	files := map[string]time.Time{
+		referenced:    now.Add(-48 * time.Hour),
+		orphan:        now.Add(-48 * time.Hour),
+		recentOrphan:  now.Add(-time.Hour),
+		messageOrphan: now.Add(-48 * time.Hour),
+		staleTemp:     now.Add(-48 * time.Hour),
+		"default.jpg": now.Add(-48 * time.Hour),
+		".gitignore":  now.Add(-48 * time.Hour),
+	}
+
+	cases := map[string]struct {
+		dryRun bool
+		wants  []string
+	}{
+		"ok: deletes old unreferenced images": {
+			dryRun: false,
+			wants:  []string{staleTemp, orphan, messageOrphan},
+		},
+		"ok: dry run keeps files": {
+			dryRun: true,
+			wants:  []string{staleTemp, orphan, messageOrphan},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			for name, modTime := range files {
+				path := filepath.Join(dir, name)
+				if err := os.WriteFile(path, testImage, 0o644); err != nil {
+					t.Fatalf("failed to write file: %v", err)
+				}
+				if err := os.Chtimes(path, modTime, modTime); err != nil {
+					t.Fatalf("failed to change file times: %v", err)
+				}
+			}
+
+			ctrl := gomock.NewController(t)
+			mockIR := NewMockItemRepository(ctrl)
+			mockIR.EXPECT().ListImageNames(gomock.Any()).Return([]string{referenced}, nil)
+
+			sweeper := newImageSweeper(NewLocalImageStore(dir), mockIR, 24*time.Hour)
+			sweeper.dryRun = tt.dryRun
+			sweeper.now = func() time.Time { return now }
+
+			deleted, err := sweeper.Sweep(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.wants, deleted); diff != "" {
+				t.Errorf("unexpected deleted images (-want +got):\n%s", diff)
+			}
+
+			for name := range files {
+				_, err := os.Stat(filepath.Join(dir, name))
+				wantExists := tt.dryRun || (name != orphan && name != staleTemp && name != messageOrphan)
+				if exists := err == nil; exists != wantExists {
+					t.Errorf("expected %s to exist=%v, got %v", name, wantExists, exists)
+				}
+			}
+		})
+	}
+}
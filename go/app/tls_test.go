@@ -0,0 +1,185 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeDevCertificate generates a certificate for localhost and writes it to dir.
+func writeDevCertificate(t *testing.T, dir string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+
+	certPEM, keyPEM, err := GenerateDevCertificate([]string{"localhost", "127.0.0.1"}, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to generate certificate: %v", err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, certPEM, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("generated an invalid key pair: %v", err)
+	}
+	cert, err = x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert
+}
+
+func TestGenerateDevCertificate(t *testing.T) {
+	t.Parallel()
+
+	_, _, cert := writeDevCertificate(t, t.TempDir())
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	for _, host := range []string{"localhost", "127.0.0.1"} {
+		if _, err := cert.Verify(x509.VerifyOptions{Roots: pool, DNSName: host}); err != nil {
+			t.Errorf("expected the certificate to be valid for %s: %v", host, err)
+		}
+	}
+	if err := cert.VerifyHostname("example.com"); err == nil {
+		t.Error("expected the certificate to be invalid for other hosts")
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	certFile, keyFile, first := writeDevCertificate(t, dir)
+	certs, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("failed to load certificate: %v", err)
+	}
+
+	serial := func() string {
+		c, _ := certs.GetCertificate(nil)
+		leaf, err := x509.ParseCertificate(c.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.SerialNumber.String()
+	}
+
+	if reloaded, err := certs.reload(); err != nil || reloaded {
+		t.Errorf("expected unchanged files not to be reloaded, got %v, %v", reloaded, err)
+	}
+
+	// a broken file keeps the previous certificate
+	if err := os.WriteFile(keyFile, []byte("broken"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := certs.reload(); err == nil {
+		t.Error("expected an error for a broken key")
+	}
+	if got := serial(); got != first.SerialNumber.String() {
+		t.Errorf("expected the previous certificate to stay, got serial %s", got)
+	}
+
+	_, _, second := writeDevCertificate(t, dir)
+	if reloaded, err := certs.reload(); err != nil || !reloaded {
+		t.Fatalf("expected renewed files to be reloaded, got %v, %v", reloaded, err)
+	}
+	if got := serial(); got != second.SerialNumber.String() {
+		t.Errorf("expected the renewed certificate, got serial %s", got)
+	}
+}
+
+func TestServeTLS(t *testing.T) {
+	t.Parallel()
+
+	certFile, keyFile, cert := writeDevCertificate(t, t.TempDir())
+	certs, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("failed to load certificate: %v", err)
+	}
+
+	srv := newHTTPServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}), DefaultConfig().HTTP)
+	srv.TLSConfig = newTLSConfig(certs)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- serve(ctx, srv, ln, time.Second)
+	}()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: pool},
+		ForceAttemptHTTP2: true,
+	}}
+	resp, err := client.Get("https://" + ln.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to request over TLS: %v", err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Errorf("expected HTTP/2, got %s", resp.Proto)
+	}
+
+	cancel()
+	if err := <-serveErr; err != nil {
+		t.Errorf("expected graceful shutdown, got %v", err)
+	}
+}
+
+func TestHTTPSRedirectHandler(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		port     string
+		method   string
+		target   string
+		wantCode int
+		wantURL  string
+	}{
+		"ok: GET keeps the path and query": {
+			port: "9443", method: http.MethodGet, target: "http://localhost:8080/items?page=2",
+			wantCode: http.StatusMovedPermanently, wantURL: "https://localhost:9443/items?page=2",
+		},
+		"ok: POST keeps its method": {
+			port: "9443", method: http.MethodPost, target: "http://localhost:8080/items",
+			wantCode: http.StatusPermanentRedirect, wantURL: "https://localhost:9443/items",
+		},
+		"ok: default port is omitted": {
+			port: "443", method: http.MethodGet, target: "http://example.com/search",
+			wantCode: http.StatusMovedPermanently, wantURL: "https://example.com/search",
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			rr := httptest.NewRecorder()
+			httpsRedirectHandler(tt.port).ServeHTTP(rr, httptest.NewRequest(tt.method, tt.target, nil))
+			if rr.Code != tt.wantCode {
+				t.Errorf("expected status code %d, got %d", tt.wantCode, rr.Code)
+			}
+			if got := rr.Header().Get("Location"); got != tt.wantURL {
+				t.Errorf("expected Location %q, got %q", tt.wantURL, got)
+			}
+		})
+	}
+}
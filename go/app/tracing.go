@@ -0,0 +1,643 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	// tracerName is the instrumentation scope of the spans created by this package.
+	tracerName = "mercari-build-training/app"
+	// serviceName identifies the API server in exported traces.
+	serviceName = "mercari-build-training-api"
+)
+
+// newTracerProvider creates a tracer provider exporting spans as configured.
+// stdout is where the stdout exporter writes. The returned function flushes and stops the exporter.
+func newTracerProvider(ctx context.Context, cfg TracingConfig, stdout io.Writer) (trace.TracerProvider, func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case "none":
+		return noop.NewTracerProvider(), func(context.Context) error { return nil }, nil
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(stdout))
+	case "otlp":
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, nil, fmt.Errorf("unknown trace exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create %s trace exporter: %w", cfg.Exporter, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName(serviceName),
+		semconv.ServiceVersion(buildVersion().Version),
+	))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	return tp, tp.Shutdown, nil
+}
+
+// setGlobalTracing makes tp and the W3C trace context propagator the defaults of the otel package,
+// for libraries which trace through the globals.
+func setGlobalTracing(tp trace.TracerProvider) {
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+}
+
+// tracingMiddleware starts a server span for every request, named after the route pattern it matches on mux.
+// A W3C traceparent header from the client makes the span a child of the client's span.
+func tracingMiddleware(next http.Handler, mux *http.ServeMux, tp trace.TracerProvider) http.Handler {
+	tracer := tp.Tracer(tracerName)
+	propagator := propagation.TraceContext{}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		route := routeOf(mux, r)
+		ctx, span := tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
+// startSpan starts a child span of the span in ctx, with the same tracer provider.
+// Without a span in ctx, it returns a span which records nothing.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	tracer := trace.SpanFromContext(ctx).TracerProvider().Tracer(tracerName)
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends span, recording err if any.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracedItemRepository starts a span around every ItemRepository operation.
+type tracedItemRepository struct {
+	next   ItemRepository
+	tracer trace.Tracer
+}
+
+// traceItemRepository wraps repo to trace its operations with tp.
+func traceItemRepository(repo ItemRepository, tp trace.TracerProvider) ItemRepository {
+	return &tracedItemRepository{next: repo, tracer: tp.Tracer(tracerName)}
+}
+
+func (t *tracedItemRepository) start(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, "ItemRepository."+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.DBSystemNameSQLite,
+		semconv.DBOperationName(operation),
+	))
+}
+
+func (t *tracedItemRepository) Insert(ctx context.Context, item *Item) (err error) {
+	ctx, span := t.start(ctx, "Insert")
+	defer func() { endSpan(span, err) }()
+	return t.next.Insert(ctx, item)
+}
+
+func (t *tracedItemRepository) LoadFromDatabase(ctx context.Context) (items []Item, err error) {
+	ctx, span := t.start(ctx, "LoadFromDatabase")
+	defer func() { endSpan(span, err) }()
+	return t.next.LoadFromDatabase(ctx)
+}
+
+func (t *tracedItemRepository) ListImageNames(ctx context.Context) (names []string, err error) {
+	ctx, span := t.start(ctx, "ListImageNames")
+	defer func() { endSpan(span, err) }()
+	return t.next.ListImageNames(ctx)
+}
+
+func (t *tracedItemRepository) SaveImageHash(ctx context.Context, imageName string, hash uint64) (err error) {
+	ctx, span := t.start(ctx, "SaveImageHash")
+	defer func() { endSpan(span, err) }()
+	return t.next.SaveImageHash(ctx, imageName, hash)
+}
+
+func (t *tracedItemRepository) FindItemsByImageHash(ctx context.Context, hash uint64, maxDistance, sellerID int) (items []Item, err error) {
+	ctx, span := t.start(ctx, "FindItemsByImageHash")
+	defer func() { endSpan(span, err) }()
+	return t.next.FindItemsByImageHash(ctx, hash, maxDistance, sellerID)
+}
+
+func (t *tracedItemRepository) FindSimilarItems(ctx context.Context, itemID int, maxDistance int) (items []Item, err error) {
+	ctx, span := t.start(ctx, "FindSimilarItems")
+	defer func() { endSpan(span, err) }()
+	return t.next.FindSimilarItems(ctx, itemID, maxDistance)
+}
+
+func (t *tracedItemRepository) FindItemsByCategory(ctx context.Context, categoryID int) (items []Item, err error) {
+	ctx, span := t.start(ctx, "FindItemsByCategory")
+	defer func() { endSpan(span, err) }()
+	return t.next.FindItemsByCategory(ctx, categoryID)
+}
+
+func (t *tracedItemRepository) FindItemsBySeller(ctx context.Context, sellerID, limit, offset int) (items []Item, err error) {
+	ctx, span := t.start(ctx, "FindItemsBySeller")
+	defer func() { endSpan(span, err) }()
+	return t.next.FindItemsBySeller(ctx, sellerID, limit, offset)
+}
+
+// tracedCategoryRepository starts a span around every CategoryRepository operation.
+type tracedCategoryRepository struct {
+	next   CategoryRepository
+	tracer trace.Tracer
+}
+
+// traceCategoryRepository wraps repo to trace its operations with tp.
+func traceCategoryRepository(repo CategoryRepository, tp trace.TracerProvider) CategoryRepository {
+	return &tracedCategoryRepository{next: repo, tracer: tp.Tracer(tracerName)}
+}
+
+func (t *tracedCategoryRepository) start(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, "CategoryRepository."+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.DBSystemNameSQLite,
+		semconv.DBOperationName(operation),
+	))
+}
+
+func (t *tracedCategoryRepository) List(ctx context.Context) (categories []Category, err error) {
+	ctx, span := t.start(ctx, "List")
+	defer func() { endSpan(span, err) }()
+	return t.next.List(ctx)
+}
+
+func (t *tracedCategoryRepository) Get(ctx context.Context, id int) (category Category, err error) {
+	ctx, span := t.start(ctx, "Get")
+	defer func() { endSpan(span, err) }()
+	return t.next.Get(ctx, id)
+}
+
+func (t *tracedCategoryRepository) Create(ctx context.Context, name string, parentID *int) (category Category, err error) {
+	ctx, span := t.start(ctx, "Create")
+	defer func() { endSpan(span, err) }()
+	return t.next.Create(ctx, name, parentID)
+}
+
+func (t *tracedCategoryRepository) Rename(ctx context.Context, id int, name string) (category Category, err error) {
+	ctx, span := t.start(ctx, "Rename")
+	defer func() { endSpan(span, err) }()
+	return t.next.Rename(ctx, id, name)
+}
+
+func (t *tracedCategoryRepository) Move(ctx context.Context, id int, parentID *int) (category Category, err error) {
+	ctx, span := t.start(ctx, "Move")
+	defer func() { endSpan(span, err) }()
+	return t.next.Move(ctx, id, parentID)
+}
+
+func (t *tracedCategoryRepository) Merge(ctx context.Context, sourceID, targetID int) (err error) {
+	ctx, span := t.start(ctx, "Merge")
+	defer func() { endSpan(span, err) }()
+	return t.next.Merge(ctx, sourceID, targetID)
+}
+
+// tracedUserRepository starts a span around every UserRepository operation.
+type tracedUserRepository struct {
+	next   UserRepository
+	tracer trace.Tracer
+}
+
+// traceUserRepository wraps repo to trace its operations with tp.
+func traceUserRepository(repo UserRepository, tp trace.TracerProvider) UserRepository {
+	return &tracedUserRepository{next: repo, tracer: tp.Tracer(tracerName)}
+}
+
+func (t *tracedUserRepository) start(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, "UserRepository."+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.DBSystemNameSQLite,
+		semconv.DBOperationName(operation),
+	))
+}
+
+func (t *tracedUserRepository) Create(ctx context.Context, name string) (user User, token string, err error) {
+	ctx, span := t.start(ctx, "Create")
+	defer func() { endSpan(span, err) }()
+	return t.next.Create(ctx, name)
+}
+
+func (t *tracedUserRepository) Get(ctx context.Context, id int) (user User, err error) {
+	ctx, span := t.start(ctx, "Get")
+	defer func() { endSpan(span, err) }()
+	return t.next.Get(ctx, id)
+}
+
+func (t *tracedUserRepository) FindByToken(ctx context.Context, token string) (user User, err error) {
+	ctx, span := t.start(ctx, "FindByToken")
+	defer func() { endSpan(span, err) }()
+	return t.next.FindByToken(ctx, token)
+}
+
+func (t *tracedUserRepository) Profile(ctx context.Context, id int) (profile UserProfile, err error) {
+	ctx, span := t.start(ctx, "Profile")
+	defer func() { endSpan(span, err) }()
+	return t.next.Profile(ctx, id)
+}
+
+func (t *tracedUserRepository) Update(ctx context.Context, id int, name, avatar *string) (user User, err error) {
+	ctx, span := t.start(ctx, "Update")
+	defer func() { endSpan(span, err) }()
+	return t.next.Update(ctx, id, name, avatar)
+}
+
+// tracedTransactionRepository starts a span around every TransactionRepository operation.
+type tracedTransactionRepository struct {
+	next   TransactionRepository
+	tracer trace.Tracer
+}
+
+// traceTransactionRepository wraps repo to trace its operations with tp.
+func traceTransactionRepository(repo TransactionRepository, tp trace.TracerProvider) TransactionRepository {
+	return &tracedTransactionRepository{next: repo, tracer: tp.Tracer(tracerName)}
+}
+
+func (t *tracedTransactionRepository) start(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, "TransactionRepository."+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.DBSystemNameSQLite,
+		semconv.DBOperationName(operation),
+	))
+}
+
+func (t *tracedTransactionRepository) Create(ctx context.Context, itemID, buyerID int) (tr Transaction, err error) {
+	ctx, span := t.start(ctx, "Create")
+	defer func() { endSpan(span, err) }()
+	return t.next.Create(ctx, itemID, buyerID)
+}
+
+func (t *tracedTransactionRepository) Get(ctx context.Context, id int) (tr Transaction, err error) {
+	ctx, span := t.start(ctx, "Get")
+	defer func() { endSpan(span, err) }()
+	return t.next.Get(ctx, id)
+}
+
+func (t *tracedTransactionRepository) Complete(ctx context.Context, id int) (tr Transaction, err error) {
+	ctx, span := t.start(ctx, "Complete")
+	defer func() { endSpan(span, err) }()
+	return t.next.Complete(ctx, id)
+}
+
+// tracedReviewRepository starts a span around every ReviewRepository operation.
+type tracedReviewRepository struct {
+	next   ReviewRepository
+	tracer trace.Tracer
+}
+
+// traceReviewRepository wraps repo to trace its operations with tp.
+func traceReviewRepository(repo ReviewRepository, tp trace.TracerProvider) ReviewRepository {
+	return &tracedReviewRepository{next: repo, tracer: tp.Tracer(tracerName)}
+}
+
+func (t *tracedReviewRepository) start(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, "ReviewRepository."+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.DBSystemNameSQLite,
+		semconv.DBOperationName(operation),
+	))
+}
+
+func (t *tracedReviewRepository) Create(ctx context.Context, transactionID, reviewerID int, rating, comment string) (review Review, err error) {
+	ctx, span := t.start(ctx, "Create")
+	defer func() { endSpan(span, err) }()
+	return t.next.Create(ctx, transactionID, reviewerID, rating, comment)
+}
+
+func (t *tracedReviewRepository) Update(ctx context.Context, id, reviewerID int, rating, comment *string) (review Review, err error) {
+	ctx, span := t.start(ctx, "Update")
+	defer func() { endSpan(span, err) }()
+	return t.next.Update(ctx, id, reviewerID, rating, comment)
+}
+
+func (t *tracedReviewRepository) ListByReviewee(ctx context.Context, userID, limit, offset int) (reviews []Review, err error) {
+	ctx, span := t.start(ctx, "ListByReviewee")
+	defer func() { endSpan(span, err) }()
+	return t.next.ListByReviewee(ctx, userID, limit, offset)
+}
+
+// tracedMessageRepository starts a span around every MessageRepository operation.
+type tracedMessageRepository struct {
+	next   MessageRepository
+	tracer trace.Tracer
+}
+
+// traceMessageRepository wraps repo to trace its operations with tp.
+func traceMessageRepository(repo MessageRepository, tp trace.TracerProvider) MessageRepository {
+	return &tracedMessageRepository{next: repo, tracer: tp.Tracer(tracerName)}
+}
+
+func (t *tracedMessageRepository) start(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, "MessageRepository."+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.DBSystemNameSQLite,
+		semconv.DBOperationName(operation),
+	))
+}
+
+func (t *tracedMessageRepository) Create(ctx context.Context, transactionID, senderID int, body, image string) (msg Message, err error) {
+	ctx, span := t.start(ctx, "Create")
+	defer func() { endSpan(span, err) }()
+	return t.next.Create(ctx, transactionID, senderID, body, image)
+}
+
+func (t *tracedMessageRepository) Get(ctx context.Context, transactionID, id int) (msg Message, err error) {
+	ctx, span := t.start(ctx, "Get")
+	defer func() { endSpan(span, err) }()
+	return t.next.Get(ctx, transactionID, id)
+}
+
+func (t *tracedMessageRepository) List(ctx context.Context, transactionID, afterID, limit int) (messages []Message, err error) {
+	ctx, span := t.start(ctx, "List")
+	defer func() { endSpan(span, err) }()
+	return t.next.List(ctx, transactionID, afterID, limit)
+}
+
+func (t *tracedMessageRepository) MarkRead(ctx context.Context, transactionID, readerID, upToID int) (n int, err error) {
+	ctx, span := t.start(ctx, "MarkRead")
+	defer func() { endSpan(span, err) }()
+	return t.next.MarkRead(ctx, transactionID, readerID, upToID)
+}
+
+func (t *tracedMessageRepository) UnreadCounts(ctx context.Context, userID int) (counts []UnreadCount, err error) {
+	ctx, span := t.start(ctx, "UnreadCounts")
+	defer func() { endSpan(span, err) }()
+	return t.next.UnreadCounts(ctx, userID)
+}
+
+// tracedNotificationRepository starts a span around every NotificationRepository operation.
+type tracedNotificationRepository struct {
+	next   NotificationRepository
+	tracer trace.Tracer
+}
+
+// traceNotificationRepository wraps repo to trace its operations with tp.
+func traceNotificationRepository(repo NotificationRepository, tp trace.TracerProvider) NotificationRepository {
+	return &tracedNotificationRepository{next: repo, tracer: tp.Tracer(tracerName)}
+}
+
+func (t *tracedNotificationRepository) start(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, "NotificationRepository."+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.DBSystemNameSQLite,
+		semconv.DBOperationName(operation),
+	))
+}
+
+func (t *tracedNotificationRepository) Create(ctx context.Context, userID int, notificationType string, data NotificationData) (notification Notification, err error) {
+	ctx, span := t.start(ctx, "Create")
+	defer func() { endSpan(span, err) }()
+	return t.next.Create(ctx, userID, notificationType, data)
+}
+
+func (t *tracedNotificationRepository) List(ctx context.Context, userID int, unreadOnly bool, limit, offset int) (notifications []Notification, err error) {
+	ctx, span := t.start(ctx, "List")
+	defer func() { endSpan(span, err) }()
+	return t.next.List(ctx, userID, unreadOnly, limit, offset)
+}
+
+func (t *tracedNotificationRepository) CountUnread(ctx context.Context, userID int) (count int, err error) {
+	ctx, span := t.start(ctx, "CountUnread")
+	defer func() { endSpan(span, err) }()
+	return t.next.CountUnread(ctx, userID)
+}
+
+func (t *tracedNotificationRepository) MarkRead(ctx context.Context, userID, upToID int) (count int, err error) {
+	ctx, span := t.start(ctx, "MarkRead")
+	defer func() { endSpan(span, err) }()
+	return t.next.MarkRead(ctx, userID, upToID)
+}
+
+func (t *tracedNotificationRepository) Preferences(ctx context.Context, userID int) (prefs NotificationPreferences, err error) {
+	ctx, span := t.start(ctx, "Preferences")
+	defer func() { endSpan(span, err) }()
+	return t.next.Preferences(ctx, userID)
+}
+
+func (t *tracedNotificationRepository) SetPreferences(ctx context.Context, userID int, prefs NotificationPreferences) (err error) {
+	ctx, span := t.start(ctx, "SetPreferences")
+	defer func() { endSpan(span, err) }()
+	return t.next.SetPreferences(ctx, userID, prefs)
+}
+
+func (t *tracedNotificationRepository) Cursor(ctx context.Context) (cursor NotificationCursor, err error) {
+	ctx, span := t.start(ctx, "Cursor")
+	defer func() { endSpan(span, err) }()
+	return t.next.Cursor(ctx)
+}
+
+func (t *tracedNotificationRepository) Missed(ctx context.Context, since NotificationCursor) (events []Event, err error) {
+	ctx, span := t.start(ctx, "Missed")
+	defer func() { endSpan(span, err) }()
+	return t.next.Missed(ctx, since)
+}
+
+// tracedSavedSearchRepository starts a span around every SavedSearchRepository operation.
+type tracedSavedSearchRepository struct {
+	next   SavedSearchRepository
+	tracer trace.Tracer
+}
+
+// traceSavedSearchRepository wraps repo to trace its operations with tp.
+func traceSavedSearchRepository(repo SavedSearchRepository, tp trace.TracerProvider) SavedSearchRepository {
+	return &tracedSavedSearchRepository{next: repo, tracer: tp.Tracer(tracerName)}
+}
+
+func (t *tracedSavedSearchRepository) start(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, "SavedSearchRepository."+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.DBSystemNameSQLite,
+		semconv.DBOperationName(operation),
+	))
+}
+
+func (t *tracedSavedSearchRepository) Create(ctx context.Context, userID int, criteria SearchCriteria, notify bool) (search SavedSearch, err error) {
+	ctx, span := t.start(ctx, "Create")
+	defer func() { endSpan(span, err) }()
+	return t.next.Create(ctx, userID, criteria, notify)
+}
+
+func (t *tracedSavedSearchRepository) List(ctx context.Context, userID int) (searches []SavedSearch, err error) {
+	ctx, span := t.start(ctx, "List")
+	defer func() { endSpan(span, err) }()
+	return t.next.List(ctx, userID)
+}
+
+func (t *tracedSavedSearchRepository) Delete(ctx context.Context, userID, id int) (err error) {
+	ctx, span := t.start(ctx, "Delete")
+	defer func() { endSpan(span, err) }()
+	return t.next.Delete(ctx, userID, id)
+}
+
+func (t *tracedSavedSearchRepository) NewItems(ctx context.Context, userID, id, limit int) (items []Item, more bool, err error) {
+	ctx, span := t.start(ctx, "NewItems")
+	defer func() { endSpan(span, err) }()
+	return t.next.NewItems(ctx, userID, id, limit)
+}
+
+func (t *tracedSavedSearchRepository) Match(ctx context.Context, item Item) (searches []SavedSearch, err error) {
+	ctx, span := t.start(ctx, "Match")
+	defer func() { endSpan(span, err) }()
+	return t.next.Match(ctx, item)
+}
+
+// tracedOfferRepository starts a span around every OfferRepository operation.
+type tracedOfferRepository struct {
+	next   OfferRepository
+	tracer trace.Tracer
+}
+
+// traceOfferRepository wraps repo to trace its operations with tp.
+func traceOfferRepository(repo OfferRepository, tp trace.TracerProvider) OfferRepository {
+	return &tracedOfferRepository{next: repo, tracer: tp.Tracer(tracerName)}
+}
+
+func (t *tracedOfferRepository) start(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, "OfferRepository."+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.DBSystemNameSQLite,
+		semconv.DBOperationName(operation),
+	))
+}
+
+func (t *tracedOfferRepository) Create(ctx context.Context, itemID, buyerID, price int) (offer Offer, err error) {
+	ctx, span := t.start(ctx, "Create")
+	defer func() { endSpan(span, err) }()
+	return t.next.Create(ctx, itemID, buyerID, price)
+}
+
+func (t *tracedOfferRepository) Get(ctx context.Context, id int) (offer Offer, err error) {
+	ctx, span := t.start(ctx, "Get")
+	defer func() { endSpan(span, err) }()
+	return t.next.Get(ctx, id)
+}
+
+func (t *tracedOfferRepository) ListByItem(ctx context.Context, itemID, userID int) (offers []Offer, err error) {
+	ctx, span := t.start(ctx, "ListByItem")
+	defer func() { endSpan(span, err) }()
+	return t.next.ListByItem(ctx, itemID, userID)
+}
+
+func (t *tracedOfferRepository) Accept(ctx context.Context, id, userID int) (offer Offer, err error) {
+	ctx, span := t.start(ctx, "Accept")
+	defer func() { endSpan(span, err) }()
+	return t.next.Accept(ctx, id, userID)
+}
+
+func (t *tracedOfferRepository) Decline(ctx context.Context, id, userID int) (offer Offer, err error) {
+	ctx, span := t.start(ctx, "Decline")
+	defer func() { endSpan(span, err) }()
+	return t.next.Decline(ctx, id, userID)
+}
+
+func (t *tracedOfferRepository) Counter(ctx context.Context, id, userID, price int) (offer Offer, err error) {
+	ctx, span := t.start(ctx, "Counter")
+	defer func() { endSpan(span, err) }()
+	return t.next.Counter(ctx, id, userID, price)
+}
+
+// tracedImageStore starts a span around every ImageStore operation.
+type tracedImageStore struct {
+	next   ImageStore
+	tracer trace.Tracer
+}
+
+// traceImageStore wraps store to trace its operations with tp.
+func traceImageStore(store ImageStore, tp trace.TracerProvider) ImageStore {
+	return &tracedImageStore{next: store, tracer: tp.Tracer(tracerName)}
+}
+
+func (t *tracedImageStore) start(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, "ImageStore."+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+func (t *tracedImageStore) Put(ctx context.Context, name string, image []byte) (err error) {
+	ctx, span := t.start(ctx, "Put", attribute.String("image.name", name), attribute.Int("image.size", len(image)))
+	defer func() { endSpan(span, err) }()
+	return t.next.Put(ctx, name, image)
+}
+
+// Get only covers opening the image, since the caller reads it afterwards.
+func (t *tracedImageStore) Get(ctx context.Context, name string) (img *ImageObject, err error) {
+	ctx, span := t.start(ctx, "Get", attribute.String("image.name", name))
+	defer func() { endSpan(span, err) }()
+	return t.next.Get(ctx, name)
+}
+
+func (t *tracedImageStore) Exists(ctx context.Context, name string) (exists bool, err error) {
+	ctx, span := t.start(ctx, "Exists", attribute.String("image.name", name))
+	defer func() {
+		span.SetAttributes(attribute.Bool("image.exists", exists))
+		endSpan(span, err)
+	}()
+	return t.next.Exists(ctx, name)
+}
+
+func (t *tracedImageStore) Verify(ctx context.Context, name string, image []byte) (valid bool, err error) {
+	ctx, span := t.start(ctx, "Verify", attribute.String("image.name", name))
+	defer func() {
+		span.SetAttributes(attribute.Bool("image.valid", valid))
+		endSpan(span, err)
+	}()
+	return t.next.Verify(ctx, name, image)
+}
+
+func (t *tracedImageStore) Touch(ctx context.Context, name string) (err error) {
+	ctx, span := t.start(ctx, "Touch", attribute.String("image.name", name))
+	defer func() { endSpan(span, err) }()
+	return t.next.Touch(ctx, name)
+}
+
+func (t *tracedImageStore) Delete(ctx context.Context, name string) (err error) {
+	ctx, span := t.start(ctx, "Delete", attribute.String("image.name", name))
+	defer func() { endSpan(span, err) }()
+	return t.next.Delete(ctx, name)
+}
+
+func (t *tracedImageStore) SignedURL(ctx context.Context, name string, expiry time.Duration) (u string, err error) {
+	ctx, span := t.start(ctx, "SignedURL", attribute.String("image.name", name))
+	defer func() { endSpan(span, err) }()
+	return t.next.SignedURL(ctx, name, expiry)
+}
+
+func (t *tracedImageStore) List(ctx context.Context) (images []ImageInfo, err error) {
+	ctx, span := t.start(ctx, "List")
+	defer func() {
+		span.SetAttributes(attribute.Int("image.count", len(images)))
+		endSpan(span, err)
+	}()
+	return t.next.List(ctx)
+}
@@ -0,0 +1,196 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+)
+
+// newTestTracerProvider returns a tracer provider recording spans in memory.
+func newTestTracerProvider(t *testing.T) (*sdktrace.TracerProvider, *tracetest.SpanRecorder) {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+	return tp, recorder
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	t.Parallel()
+
+	const (
+		traceID      = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parentSpanID = "00f067aa0ba902b7"
+	)
+
+	type wants struct {
+		name    string
+		status  codes.Code
+		traceID string
+	}
+	cases := map[string]struct {
+		path        string
+		traceparent string
+		wants
+	}{
+		"ok: named after the route": {
+			path:  "/items/1",
+			wants: wants{name: "GET /items/{item_id}", status: codes.Unset},
+		},
+		"ok: traceparent is propagated": {
+			path:        "/items/1",
+			traceparent: "00-" + traceID + "-" + parentSpanID + "-01",
+			wants:       wants{name: "GET /items/{item_id}", status: codes.Unset, traceID: traceID},
+		},
+		"ng: server error": {
+			path:  "/items/0",
+			wants: wants{name: "GET /items/{item_id}", status: codes.Error},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			tp, recorder := newTestTracerProvider(t)
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /items/{item_id}", func(w http.ResponseWriter, r *http.Request) {
+				if r.PathValue("item_id") == "0" {
+					http.Error(w, "failed", http.StatusInternalServerError)
+				}
+			})
+
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.traceparent != "" {
+				req.Header.Set("traceparent", tt.traceparent)
+			}
+			tracingMiddleware(mux, mux, tp).ServeHTTP(httptest.NewRecorder(), req)
+
+			spans := recorder.Ended()
+			if len(spans) != 1 {
+				t.Fatalf("expected 1 span, got %d", len(spans))
+			}
+			span := spans[0]
+			if span.Name() != tt.name {
+				t.Errorf("expected span name %q, got %q", tt.name, span.Name())
+			}
+			if span.Status().Code != tt.status {
+				t.Errorf("expected span status %v, got %v", tt.status, span.Status().Code)
+			}
+			if tt.traceID != "" {
+				if got := span.SpanContext().TraceID().String(); got != tt.traceID {
+					t.Errorf("expected trace ID %s, got %s", tt.traceID, got)
+				}
+				if got := span.Parent().SpanID().String(); got != parentSpanID {
+					t.Errorf("expected parent span ID %s, got %s", parentSpanID, got)
+				}
+			}
+			if !hasAttribute(span, string(semconv.HTTPRouteKey), "/items/{item_id}") {
+				t.Errorf("expected http.route attribute, got %v", span.Attributes())
+			}
+		})
+	}
+}
+
+func TestAddItemTracing(t *testing.T) {
+	t.Parallel()
+
+	tp, recorder := newTestTracerProvider(t)
+	repo := &itemRepository{fileName: filepath.Join(t.TempDir(), "items.json"), db: newTestDB(t)}
+	h := &Handlers{
+		itemRepo:   traceItemRepository(repo, tp),
+		imageStore: traceImageStore(NewLocalImageStore(t.TempDir()), tp),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /items", h.AddItem)
+
+	req := newAddItemRequest(t, map[string]string{"name": "used iPhone 16e", "category": "phone"}, testImage)
+	rr := httptest.NewRecorder()
+	tracingMiddleware(mux, mux, tp).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+
+	// each span is a child of the span it is listed with
+	parents := map[string]string{
+		"parseAddItemRequest":   "POST /items",
+		"storeImage":            "POST /items",
+		"ImageStore.Verify":     "storeImage",
+		"ImageStore.Put":        "storeImage",
+		"imageDHash":            "POST /items",
+		"ItemRepository.Insert": "POST /items",
+		"SELECT categories":     "ItemRepository.Insert",
+		"INSERT items":          "ItemRepository.Insert",
+	}
+	root, ok := spans["POST /items"]
+	if !ok {
+		t.Fatalf("expected a server span, got %v", spanNames(recorder.Ended()))
+	}
+	for name, parent := range parents {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("expected span %q, got %v", name, spanNames(recorder.Ended()))
+			continue
+		}
+		if span.SpanContext().TraceID() != root.SpanContext().TraceID() {
+			t.Errorf("expected span %q to be in the trace of the request", name)
+		}
+		if got := span.Parent().SpanID(); got != spans[parent].SpanContext().SpanID() {
+			t.Errorf("expected span %q to be a child of %q", name, parent)
+		}
+	}
+}
+
+func TestNewTracerProvider(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	tp, shutdown, err := newTracerProvider(context.Background(), TracingConfig{Exporter: "stdout"}, &buf)
+	if err != nil {
+		t.Fatalf("failed to create tracer provider: %v", err)
+	}
+	_, span := tp.Tracer(tracerName).Start(context.Background(), "test span")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("failed to shut down tracer provider: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"Name":"test span"`) {
+		t.Errorf("expected the span to be exported to stdout, got %s", buf.String())
+	}
+
+	if _, _, err := newTracerProvider(context.Background(), TracingConfig{Exporter: "zipkin"}, &buf); err == nil {
+		t.Error("expected an error for an unknown exporter")
+	}
+}
+
+func hasAttribute(span sdktrace.ReadOnlySpan, key, value string) bool {
+	for _, attr := range span.Attributes() {
+		if string(attr.Key) == key && attr.Value.Emit() == value {
+			return true
+		}
+	}
+	return false
+}
+
+func spanNames(spans []sdktrace.ReadOnlySpan) []string {
+	names := make([]string, len(spans))
+	for i, span := range spans {
+		names[i] = span.Name()
+	}
+	return names
+}
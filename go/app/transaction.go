@@ -0,0 +1,274 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+var (
+	errTransactionNotFound = errors.New("transaction not found")
+	// errItemSold is returned when the item already has a transaction.
+	errItemSold = errors.New("item is already sold")
+	// errInvalidTransaction is returned for purchases which can't happen, such as of one's own item.
+	errInvalidTransaction = errors.New("invalid transaction")
+	// errTransactionState is returned when the status of the transaction doesn't allow the operation.
+	errTransactionState = errors.New("transaction is not in a state allowing this")
+	// errForbidden is returned when the user is not the one allowed to do the operation.
+	errForbidden = errors.New("forbidden")
+)
+
+// statuses of a transaction.
+const (
+	// TransactionTrading is the status from the purchase until the buyer receives the item.
+	TransactionTrading = "trading"
+	// TransactionCompleted is the status after the buyer received the item.
+	TransactionCompleted = "completed"
+)
+
+// Transaction is the sale of an item from its seller to a buyer.
+type Transaction struct {
+	ID       int    `json:"id"`
+	ItemID   int    `json:"item_id"`
+	SellerID int    `json:"seller_id"`
+	BuyerID  int    `json:"buyer_id"`
+	Status   string `json:"status"`
+	// Price is what the buyer pays, which is the price negotiated in an accepted offer if the buyer had one.
+	Price     int       `json:"price"`
+	CreatedAt time.Time `json:"created_at"`
+	// CompletedAt is nil until the transaction is completed.
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+// isParty reports whether the user is the seller or the buyer.
+func (t Transaction) isParty(userID int) bool {
+	return userID == t.SellerID || userID == t.BuyerID
+}
+
+// counterparty returns the other party of the transaction to the user.
+func (t Transaction) counterparty(userID int) int {
+	if userID == t.SellerID {
+		return t.BuyerID
+	}
+	return t.SellerID
+}
+
+// Please run `go generate ./...` to generate the mock implementation
+// TransactionRepository is an interface to manage transactions.
+//
+//go:generate go run go.uber.org/mock/mockgen -source=$GOFILE -package=${GOPACKAGE} -destination=./mock_$GOFILE
+type TransactionRepository interface {
+	// Create starts the purchase of the item by the buyer, at the price of an accepted offer of the buyer if any.
+	// It returns errItemNotFound, errItemSold if the item already has a transaction,
+	// or errInvalidTransaction if the item has no seller or the buyer is the seller.
+	Create(ctx context.Context, itemID, buyerID int) (Transaction, error)
+	// Get returns errTransactionNotFound if the transaction does not exist.
+	Get(ctx context.Context, id int) (Transaction, error)
+	// Complete completes a transaction in trading, or returns errTransactionState.
+	Complete(ctx context.Context, id int) (Transaction, error)
+}
+
+// transactionRepository is an implementation of TransactionRepository
+type transactionRepository struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+// NewTransactionRepository creates a new transactionRepository.
+func NewTransactionRepository(db *sql.DB) TransactionRepository {
+	return &transactionRepository{db: db, now: time.Now}
+}
+
+func (t *transactionRepository) Create(ctx context.Context, itemID, buyerID int) (Transaction, error) {
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Transaction{}, err
+	}
+	defer tx.Rollback()
+
+	var sellerID sql.NullInt64
+	var price int
+	err = tx.QueryRowContext(ctx, "SELECT seller_id, price FROM items WHERE id = ?", itemID).Scan(&sellerID, &price)
+	if err == sql.ErrNoRows {
+		return Transaction{}, errItemNotFound
+	}
+	if err != nil {
+		return Transaction{}, err
+	}
+	if !sellerID.Valid {
+		return Transaction{}, fmt.Errorf("%w: item %d has no seller", errInvalidTransaction, itemID)
+	}
+	if int(sellerID.Int64) == buyerID {
+		return Transaction{}, fmt.Errorf("%w: sellers can't buy their own items", errInvalidTransaction)
+	}
+
+	// the accepted offer is used up by the purchase
+	offer, err := acceptedOffer(ctx, tx, itemID, buyerID, t.now().UTC())
+	switch {
+	case err == nil:
+		price = offer.Price
+		if _, err := tx.ExecContext(ctx, "UPDATE offers SET status = ?, updated_at = ? WHERE id = ?", OfferPurchased, t.now().UTC(), offer.ID); err != nil {
+			return Transaction{}, err
+		}
+	case !errors.Is(err, errOfferNotFound):
+		return Transaction{}, err
+	}
+
+	// the unique item_id lets only one of concurrent purchases succeed
+	result, err := tx.ExecContext(ctx, "INSERT INTO transactions (item_id, seller_id, buyer_id, status, price) VALUES (?, ?, ?, ?, ?)",
+		itemID, sellerID.Int64, buyerID, TransactionTrading, price)
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return Transaction{}, errItemSold
+	}
+	if err != nil {
+		return Transaction{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Transaction{}, err
+	}
+	tr, err := getTransaction(ctx, tx, int(id))
+	if err != nil {
+		return Transaction{}, err
+	}
+	return tr, tx.Commit()
+}
+
+func (t *transactionRepository) Get(ctx context.Context, id int) (Transaction, error) {
+	return getTransaction(ctx, t.db, id)
+}
+
+func getTransaction(ctx context.Context, q queryer, id int) (Transaction, error) {
+	var tr Transaction
+	err := q.QueryRowContext(ctx, `
+		SELECT id, item_id, seller_id, buyer_id, status, price, created_at, completed_at
+		FROM transactions
+		WHERE id = ?`, id).
+		Scan(&tr.ID, &tr.ItemID, &tr.SellerID, &tr.BuyerID, &tr.Status, &tr.Price, &tr.CreatedAt, &tr.CompletedAt)
+	if err == sql.ErrNoRows {
+		return Transaction{}, errTransactionNotFound
+	}
+	return tr, err
+}
+
+func (t *transactionRepository) Complete(ctx context.Context, id int) (Transaction, error) {
+	result, err := t.db.ExecContext(ctx, "UPDATE transactions SET status = ?, completed_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?",
+		TransactionCompleted, id, TransactionTrading)
+	if err != nil {
+		return Transaction{}, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return Transaction{}, err
+	}
+	tr, err := t.Get(ctx, id)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if n == 0 {
+		return Transaction{}, fmt.Errorf("%w: transaction %d is %s", errTransactionState, id, tr.Status)
+	}
+	return tr, nil
+}
+
+// PurchaseItem is a handler to buy an item for POST /items/{item_id}/purchase .
+func (s *Handlers) PurchaseItem(w http.ResponseWriter, r *http.Request) {
+	var req ItemIDRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	buyerID, _ := currentUserID(r.Context())
+
+	tr, err := s.transactionRepo.Create(r.Context(), req.ID, buyerID)
+	if err != nil {
+		s.writeTransactionError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/transactions/%d", tr.ID))
+	writeJSON(w, http.StatusCreated, tr)
+}
+
+type TransactionIDRequest struct {
+	ID int `path:"transaction_id" validate:"required,min=1"`
+}
+
+// partyTransaction returns the transaction of the request if the authenticated user is one of its parties.
+// Transactions of others are reported as not found, so that their existence is not revealed.
+func (s *Handlers) partyTransaction(ctx context.Context, id int) (Transaction, int, error) {
+	userID, _ := currentUserID(ctx)
+	tr, err := s.transactionRepo.Get(ctx, id)
+	if err != nil {
+		return Transaction{}, 0, err
+	}
+	if !tr.isParty(userID) {
+		return Transaction{}, 0, errTransactionNotFound
+	}
+	return tr, userID, nil
+}
+
+// GetTransaction is a handler to return a transaction to its parties for GET /transactions/{transaction_id} .
+func (s *Handlers) GetTransaction(w http.ResponseWriter, r *http.Request) {
+	var req TransactionIDRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+
+	tr, _, err := s.partyTransaction(r.Context(), req.ID)
+	if err != nil {
+		s.writeTransactionError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tr)
+}
+
+// CompleteTransaction is a handler for the buyer to confirm the receipt of the item
+// for POST /transactions/{transaction_id}/complete .
+func (s *Handlers) CompleteTransaction(w http.ResponseWriter, r *http.Request) {
+	var req TransactionIDRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+
+	tr, userID, err := s.partyTransaction(r.Context(), req.ID)
+	if err != nil {
+		s.writeTransactionError(w, r, err)
+		return
+	}
+	if userID != tr.BuyerID {
+		s.writeTransactionError(w, r, fmt.Errorf("%w: only the buyer can complete the transaction", errForbidden))
+		return
+	}
+
+	tr, err = s.transactionRepo.Complete(r.Context(), tr.ID)
+	if err != nil {
+		s.writeTransactionError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tr)
+}
+
+// writeTransactionError writes the status code of an error of transactions and the features built on them.
+func (s *Handlers) writeTransactionError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errTransactionNotFound), errors.Is(err, errItemNotFound), errors.Is(err, errOfferNotFound), errors.Is(err, errMessageNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, errItemSold), errors.Is(err, errTransactionState), errors.Is(err, errOfferExists), errors.Is(err, errOfferState):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, errInvalidTransaction), errors.Is(err, errInvalidOffer):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		loggerFromContext(r.Context()).Error("failed to manage transactions: ", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,234 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testSale is a seller with a listed item and a buyer, stored in a test database.
+type testSale struct {
+	db                  *sql.DB
+	imgDir              string
+	seller, buyer       User
+	sellerTok, buyerTok string
+	itemID              int
+}
+
+// newTestSale creates a seller listing an item and a buyer in a new test database.
+func newTestSale(t *testing.T) *testSale {
+	t.Helper()
+
+	ctx := context.Background()
+	s := &testSale{db: newTestDB(t), imgDir: t.TempDir()}
+	users := NewUserRepository(s.db)
+	var err error
+	if s.seller, s.sellerTok, err = users.Create(ctx, "seller"); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	if s.buyer, s.buyerTok, err = users.Create(ctx, "buyer"); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	items := &itemRepository{fileName: t.TempDir() + "/items.json", db: s.db}
+	if err := items.Insert(ctx, &Item{Name: "jacket", Category: "Fashion", Image: "a.jpg", SellerID: s.seller.ID}); err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+	s.db.QueryRow("SELECT MAX(id) FROM items").Scan(&s.itemID)
+	return s
+}
+
+// handler serves the item lists, transaction, offer, review, message, image, notification and search routes with the users authenticated by their tokens.
+func (s *testSale) handler() http.Handler {
+	h := &Handlers{
+		imageStore:       NewLocalImageStore(s.imgDir),
+		userRepo:         NewUserRepository(s.db),
+		transactionRepo:  NewTransactionRepository(s.db),
+		reviewRepo:       NewReviewRepository(s.db),
+		messageRepo:      NewMessageRepository(s.db),
+		notificationRepo: NewNotificationRepository(s.db),
+		savedSearchRepo:  NewSavedSearchRepository(s.db),
+		offerRepo:        NewOfferRepository(s.db),
+		itemRepo:         NewItemRepository(s.db),
+		db:               s.db,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items", h.GetItem)
+	mux.Handle("POST /items/{item_id}/purchase", requireUser(http.HandlerFunc(h.PurchaseItem)))
+	mux.Handle("POST /items/{item_id}/offers", requireUser(http.HandlerFunc(h.MakeOffer)))
+	mux.Handle("GET /items/{item_id}/offers", requireUser(http.HandlerFunc(h.GetItemOffers)))
+	mux.Handle("GET /offers/{offer_id}", requireUser(http.HandlerFunc(h.GetOffer)))
+	mux.Handle("POST /offers/{offer_id}/accept", requireUser(http.HandlerFunc(h.AcceptOffer)))
+	mux.Handle("POST /offers/{offer_id}/decline", requireUser(http.HandlerFunc(h.DeclineOffer)))
+	mux.Handle("POST /offers/{offer_id}/counter", requireUser(http.HandlerFunc(h.CounterOffer)))
+	mux.Handle("GET /transactions/{transaction_id}", requireUser(http.HandlerFunc(h.GetTransaction)))
+	mux.Handle("POST /transactions/{transaction_id}/complete", requireUser(http.HandlerFunc(h.CompleteTransaction)))
+	mux.Handle("POST /transactions/{transaction_id}/reviews", requireUser(http.HandlerFunc(h.CreateReview)))
+	mux.Handle("PATCH /reviews/{review_id}", requireUser(http.HandlerFunc(h.UpdateReview)))
+	mux.HandleFunc("GET /users/{user_id}/reviews", h.GetUserReviews)
+	mux.HandleFunc("GET /users/{user_id}/items", h.GetUserItems)
+	mux.Handle("GET /transactions/{transaction_id}/messages", requireUser(http.HandlerFunc(h.GetMessages)))
+	mux.Handle("POST /transactions/{transaction_id}/messages", requireUser(http.HandlerFunc(h.PostMessage)))
+	mux.Handle("POST /transactions/{transaction_id}/messages/read", requireUser(http.HandlerFunc(h.ReadMessages)))
+	mux.Handle("GET /transactions/{transaction_id}/messages/{message_id}/image", requireUser(http.HandlerFunc(h.GetMessageImage)))
+	mux.HandleFunc("GET /images/{filename}", h.GetImage)
+	mux.Handle("GET /messages/unread", requireUser(http.HandlerFunc(h.GetUnreadMessages)))
+	mux.Handle("GET /notifications", requireUser(http.HandlerFunc(h.GetNotifications)))
+	mux.Handle("POST /notifications/read", requireUser(http.HandlerFunc(h.ReadNotifications)))
+	mux.Handle("GET /notifications/preferences", requireUser(http.HandlerFunc(h.GetNotificationPreferences)))
+	mux.Handle("PUT /notifications/preferences", requireUser(http.HandlerFunc(h.UpdateNotificationPreferences)))
+	mux.HandleFunc("GET /search", h.SearchItem)
+	mux.Handle("POST /saved-searches", requireUser(http.HandlerFunc(h.CreateSavedSearch)))
+	mux.Handle("GET /saved-searches", requireUser(http.HandlerFunc(h.GetSavedSearches)))
+	mux.Handle("DELETE /saved-searches/{saved_search_id}", requireUser(http.HandlerFunc(h.DeleteSavedSearch)))
+	mux.Handle("GET /saved-searches/{saved_search_id}/new", requireUser(http.HandlerFunc(h.GetSavedSearchNewItems)))
+	return authMiddleware(mux, h.userRepo)
+}
+
+// doWithToken sends a request with the token and returns the response.
+func doWithToken(t *testing.T, handler http.Handler, method, target, body, token string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestTransactionRepository(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sale := newTestSale(t)
+	repo := NewTransactionRepository(sale.db)
+
+	if _, err := repo.Create(ctx, sale.itemID, sale.seller.ID); !errors.Is(err, errInvalidTransaction) {
+		t.Errorf("expected errInvalidTransaction for buying one's own item, got %v", err)
+	}
+	if _, err := repo.Create(ctx, 999, sale.buyer.ID); !errors.Is(err, errItemNotFound) {
+		t.Errorf("expected errItemNotFound, got %v", err)
+	}
+
+	tr, err := repo.Create(ctx, sale.itemID, sale.buyer.ID)
+	if err != nil {
+		t.Fatalf("failed to create transaction: %v", err)
+	}
+	if tr.SellerID != sale.seller.ID || tr.BuyerID != sale.buyer.ID || tr.Status != TransactionTrading || tr.CompletedAt != nil {
+		t.Errorf("unexpected transaction %+v", tr)
+	}
+	if _, err := repo.Create(ctx, sale.itemID, sale.buyer.ID); !errors.Is(err, errItemSold) {
+		t.Errorf("expected errItemSold for a second purchase, got %v", err)
+	}
+
+	tr, err = repo.Complete(ctx, tr.ID)
+	if err != nil || tr.Status != TransactionCompleted || tr.CompletedAt == nil {
+		t.Errorf("expected the transaction to be completed, got %+v, %v", tr, err)
+	}
+	if _, err := repo.Complete(ctx, tr.ID); !errors.Is(err, errTransactionState) {
+		t.Errorf("expected errTransactionState for completing twice, got %v", err)
+	}
+	if _, err := repo.Complete(ctx, 999); !errors.Is(err, errTransactionNotFound) {
+		t.Errorf("expected errTransactionNotFound, got %v", err)
+	}
+}
+
+func TestTransactionHandlers(t *testing.T) {
+	t.Parallel()
+
+	sale := newTestSale(t)
+	handler := sale.handler()
+	_, strangerTok, err := NewUserRepository(sale.db).Create(context.Background(), "stranger")
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	purchase := fmt.Sprintf("/items/%d/purchase", sale.itemID)
+	if rr := doWithToken(t, handler, "POST", purchase, "", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status code %d for an anonymous purchase, got %d", http.StatusUnauthorized, rr.Code)
+	}
+	if rr := doWithToken(t, handler, "POST", purchase, "", sale.sellerTok); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status code %d for buying one's own item, got %d", http.StatusBadRequest, rr.Code)
+	}
+	rr := doWithToken(t, handler, "POST", purchase, "", sale.buyerTok)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	location := rr.Header().Get("Location")
+	if rr := doWithToken(t, handler, "POST", purchase, "", strangerTok); rr.Code != http.StatusConflict {
+		t.Errorf("expected status code %d for a sold item, got %d", http.StatusConflict, rr.Code)
+	}
+
+	cases := []struct {
+		name, method, target, token string
+		wantCode                    int
+	}{
+		{"ok: seller sees the transaction", "GET", location, sale.sellerTok, http.StatusOK},
+		{"ng: stranger can't see the transaction", "GET", location, strangerTok, http.StatusNotFound},
+		{"ng: seller can't complete", "POST", location + "/complete", sale.sellerTok, http.StatusForbidden},
+		{"ok: buyer completes", "POST", location + "/complete", sale.buyerTok, http.StatusOK},
+		{"ng: buyer completes twice", "POST", location + "/complete", sale.buyerTok, http.StatusConflict},
+	}
+	// the cases depend on each other, so they run in order
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := doWithToken(t, handler, tt.method, tt.target, "", tt.token); rr.Code != tt.wantCode {
+				t.Errorf("expected status code %d, got %d: %s", tt.wantCode, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestPurchaseListedItem(t *testing.T) {
+	t.Parallel()
+
+	sale := newTestSale(t)
+	handler := sale.handler()
+
+	// a buyer only knows the items from the list
+	rr := doWithToken(t, handler, "GET", "/items", "", "")
+	var list struct {
+		Items []struct {
+			ID   int    `json:"id"`
+			Name string `json:"name"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil || len(list.Items) != 1 || list.Items[0].ID == 0 {
+		t.Fatalf("expected the listed item with its ID, got %+v, %v", list, err)
+	}
+
+	rr = doWithToken(t, handler, "POST", fmt.Sprintf("/items/%d/purchase", list.Items[0].ID), "", sale.buyerTok)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	var tr Transaction
+	if err := json.NewDecoder(rr.Body).Decode(&tr); err != nil || tr.ItemID != sale.itemID {
+		t.Errorf("expected a transaction of item %d, got %+v, %v", sale.itemID, tr, err)
+	}
+}
+
+func TestPurchaseSellerItem(t *testing.T) {
+	t.Parallel()
+
+	sale := newTestSale(t)
+	handler := sale.handler()
+
+	// a buyer browsing the seller's page only knows the items from there
+	rr := doWithToken(t, handler, "GET", fmt.Sprintf("/users/%d/items", sale.seller.ID), "", "")
+	var page GetUserItemsResponse
+	if err := json.NewDecoder(rr.Body).Decode(&page); err != nil || len(page.Items) != 1 || page.Items[0].ID != sale.itemID {
+		t.Fatalf("expected item %d on the seller's page, got %+v, %v", sale.itemID, page, err)
+	}
+
+	rr = doWithToken(t, handler, "POST", fmt.Sprintf("/items/%d/purchase", page.Items[0].ID), "", sale.buyerTok)
+	if rr.Code != http.StatusCreated {
+		t.Errorf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+}
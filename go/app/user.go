@@ -0,0 +1,328 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var errUserNotFound = errors.New("user not found")
+
+// defaultItemsPerPage is the page size of paginated item lists when the client doesn't give one.
+const defaultItemsPerPage = 20
+
+// User is a member of the marketplace, who lists items as a seller.
+type User struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// Avatar is the image name of the avatar, served by GET /images/{filename}, or "" if the user has none.
+	Avatar   string    `json:"avatar"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// RatingSummary counts the ratings a user received from the other parties of their transactions.
+type RatingSummary struct {
+	Good   int `json:"good"`
+	Normal int `json:"normal"`
+	Bad    int `json:"bad"`
+}
+
+// UserProfile is the public page of a user.
+type UserProfile struct {
+	User
+	ListingCount int           `json:"listing_count"`
+	Rating       RatingSummary `json:"rating"`
+}
+
+// newUserToken generates a token authenticating a user, and returns it with the hash to store.
+func newUserToken() (token, hash string) {
+	var b [32]byte
+	rand.Read(b[:])
+	token = base64.RawURLEncoding.EncodeToString(b[:])
+	return token, hashUserToken(token)
+}
+
+// hashUserToken returns the hash a token is stored and looked up by.
+// Tokens are random, so a plain sha256 is enough to keep them from being recovered from the database.
+func hashUserToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// authUserKey is the context key of the ID of the user authenticated by authMiddleware.
+type authUserKey struct{}
+
+// currentUserID returns the ID of the user authenticated by authMiddleware, if any.
+func currentUserID(ctx context.Context) (int, bool) {
+	id, ok := ctx.Value(authUserKey{}).(int)
+	return id, ok
+}
+
+// Please run `go generate ./...` to generate the mock implementation
+// UserRepository is an interface to manage users.
+//
+//go:generate go run go.uber.org/mock/mockgen -source=$GOFILE -package=${GOPACKAGE} -destination=./mock_$GOFILE
+type UserRepository interface {
+	// Create creates a user and returns it with the token authenticating them.
+	// Only the hash of the token is stored, so it can't be shown again.
+	Create(ctx context.Context, name string) (User, string, error)
+	// Get returns errUserNotFound if the user does not exist.
+	Get(ctx context.Context, id int) (User, error)
+	// FindByToken returns errUserNotFound if no user has the token.
+	FindByToken(ctx context.Context, token string) (User, error)
+	// Profile returns the public page of the user, or errUserNotFound.
+	Profile(ctx context.Context, id int) (UserProfile, error)
+	// Update changes the name and the avatar of the user. Nil values are left as they are.
+	Update(ctx context.Context, id int, name, avatar *string) (User, error)
+}
+
+// userRepository is an implementation of UserRepository
+type userRepository struct {
+	db *sql.DB
+}
+
+// NewUserRepository creates a new userRepository.
+func NewUserRepository(db *sql.DB) UserRepository {
+	return &userRepository{db: db}
+}
+
+func (u *userRepository) Create(ctx context.Context, name string) (User, string, error) {
+	token, hash := newUserToken()
+	result, err := u.db.ExecContext(ctx, "INSERT INTO users (name, token_hash) VALUES (?, ?)", name, hash)
+	if err != nil {
+		return User{}, "", err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return User{}, "", err
+	}
+	// the join date is set by the database
+	user, err := u.Get(ctx, int(id))
+	if err != nil {
+		return User{}, "", err
+	}
+	return user, token, nil
+}
+
+func (u *userRepository) Get(ctx context.Context, id int) (User, error) {
+	return u.find(ctx, "id = ?", id)
+}
+
+func (u *userRepository) FindByToken(ctx context.Context, token string) (User, error) {
+	return u.find(ctx, "token_hash = ?", hashUserToken(token))
+}
+
+func (u *userRepository) find(ctx context.Context, where string, arg any) (User, error) {
+	var user User
+	err := u.db.QueryRowContext(ctx, "SELECT id, name, avatar_name, created_at FROM users WHERE "+where, arg).
+		Scan(&user.ID, &user.Name, &user.Avatar, &user.JoinedAt)
+	if err == sql.ErrNoRows {
+		return User{}, errUserNotFound
+	}
+	return user, err
+}
+
+func (u *userRepository) Profile(ctx context.Context, id int) (UserProfile, error) {
+	var profile UserProfile
+	err := u.db.QueryRowContext(ctx, `
+		SELECT users.id, users.name, users.avatar_name, users.created_at,
+			(SELECT COUNT(*) FROM items WHERE items.seller_id = users.id),
+			(SELECT COUNT(*) FROM reviews WHERE reviews.reviewee_id = users.id AND reviews.rating = ?),
+			(SELECT COUNT(*) FROM reviews WHERE reviews.reviewee_id = users.id AND reviews.rating = ?),
+			(SELECT COUNT(*) FROM reviews WHERE reviews.reviewee_id = users.id AND reviews.rating = ?)
+		FROM users
+		WHERE users.id = ?`, RatingGood, RatingNormal, RatingBad, id).
+		Scan(&profile.ID, &profile.Name, &profile.Avatar, &profile.JoinedAt, &profile.ListingCount,
+			&profile.Rating.Good, &profile.Rating.Normal, &profile.Rating.Bad)
+	if err == sql.ErrNoRows {
+		return UserProfile{}, errUserNotFound
+	}
+	return profile, err
+}
+
+func (u *userRepository) Update(ctx context.Context, id int, name, avatar *string) (User, error) {
+	result, err := u.db.ExecContext(ctx, "UPDATE users SET name = IFNULL(?, name), avatar_name = IFNULL(?, avatar_name) WHERE id = ?", name, avatar, id)
+	if err != nil {
+		return User{}, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return User{}, err
+	} else if n == 0 {
+		return User{}, errUserNotFound
+	}
+	return u.Get(ctx, id)
+}
+
+type CreateUserRequest struct {
+	Name string `json:"name" validate:"trim,required,max=50,singleline"`
+}
+
+type CreateUserResponse struct {
+	User User `json:"user"`
+	// Token authenticates the user as "Authorization: Bearer <token>". It is only shown here.
+	Token string `json:"token"`
+}
+
+// CreateUser is a handler to register a user for POST /users .
+func (s *Handlers) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var req CreateUserRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+
+	user, token, err := s.userRepo.Create(r.Context(), req.Name)
+	if err != nil {
+		s.writeUserError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/users/%d", user.ID))
+	writeJSON(w, http.StatusCreated, CreateUserResponse{User: user, Token: token})
+}
+
+type GetUserRequest struct {
+	ID int `path:"user_id" validate:"required,min=1"`
+}
+
+// GetUser is a handler to return the public page of a user for GET /users/{user_id} .
+func (s *Handlers) GetUser(w http.ResponseWriter, r *http.Request) {
+	var req GetUserRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	s.writeUserProfile(w, r, req.ID)
+}
+
+// GetMe is a handler to return the page of the authenticated user for GET /users/me .
+func (s *Handlers) GetMe(w http.ResponseWriter, r *http.Request) {
+	id, _ := currentUserID(r.Context())
+	s.writeUserProfile(w, r, id)
+}
+
+func (s *Handlers) writeUserProfile(w http.ResponseWriter, r *http.Request, id int) {
+	profile, err := s.userRepo.Profile(r.Context(), id)
+	if err != nil {
+		s.writeUserError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, profile)
+}
+
+type GetUserItemsRequest struct {
+	ID int `path:"user_id" validate:"required,min=1"`
+	// Page is the 1-based page number, 1 if not given.
+	Page    *int `query:"page" validate:"min=1"`
+	PerPage *int `query:"per_page" validate:"min=1,max=100"`
+}
+
+type GetUserItemsResponse struct {
+	Items   []Item `json:"items"`
+	Page    int    `json:"page"`
+	PerPage int    `json:"per_page"`
+	// Total is the number of items of the user on every page.
+	Total int `json:"total"`
+}
+
+// GetUserItems is a handler to return the items listed by a user, newest first, for GET /users/{user_id}/items .
+func (s *Handlers) GetUserItems(w http.ResponseWriter, r *http.Request) {
+	var req GetUserItemsRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	page, perPage := 1, defaultItemsPerPage
+	if req.Page != nil {
+		page = *req.Page
+	}
+	if req.PerPage != nil {
+		perPage = *req.PerPage
+	}
+
+	profile, err := s.userRepo.Profile(r.Context(), req.ID)
+	if err != nil {
+		s.writeUserError(w, r, err)
+		return
+	}
+	items, err := s.itemRepo.FindItemsBySeller(r.Context(), req.ID, perPage, (page-1)*perPage)
+	if err != nil {
+		s.writeUserError(w, r, err)
+		return
+	}
+	if items == nil {
+		items = []Item{}
+	}
+	writeJSON(w, http.StatusOK, GetUserItemsResponse{
+		Items:   items,
+		Page:    page,
+		PerPage: perPage,
+		Total:   profile.ListingCount,
+	})
+}
+
+type UpdateMeRequest struct {
+	Name *string `form:"name" json:"name" validate:"trim,min=1,max=50,singleline"`
+	// Avatar is base64-encoded in JSON bodies.
+	Avatar []byte `form:"avatar" json:"avatar"`
+}
+
+func (req *UpdateMeRequest) validate() []FieldError {
+	if req.Name == nil && len(req.Avatar) == 0 {
+		return []FieldError{{Field: "name", Message: "is required unless avatar is given"}}
+	}
+	return nil
+}
+
+// UpdateMe is a handler to edit the profile of the authenticated user for PATCH /users/me .
+// The avatar is stored like the images of items, so identical avatars share a file.
+func (s *Handlers) UpdateMe(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, _ := currentUserID(ctx)
+
+	maxImageSize := s.imageSizeLimit()
+	r.Body = http.MaxBytesReader(w, r.Body, maxImageSize*4/3+1<<20)
+	var req UpdateMeRequest
+	if err := bind(r, &req); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+	if int64(len(req.Avatar)) > maxImageSize {
+		http.Error(w, fmt.Sprintf("avatar is larger than %d bytes", maxImageSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var avatar *string
+	if len(req.Avatar) > 0 {
+		fileName, err := s.storeImage(ctx, req.Avatar)
+		if err != nil {
+			loggerFromContext(ctx).Error("failed to store avatar: ", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		avatar = &fileName
+	}
+
+	user, err := s.userRepo.Update(ctx, id, req.Name, avatar)
+	if err != nil {
+		s.writeUserError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+// writeUserError writes the status code of a UserRepository error.
+func (s *Handlers) writeUserError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errUserNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	loggerFromContext(r.Context()).Error("failed to manage users: ", "error", err)
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,216 @@
+package app
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestUserRepository(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	db := newTestDB(t)
+	repo := NewUserRepository(db)
+	items := &itemRepository{fileName: t.TempDir() + "/items.json", db: db}
+
+	seller, token, err := repo.Create(ctx, "Alice")
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	if seller.ID == 0 || seller.Name != "Alice" || seller.JoinedAt.IsZero() {
+		t.Errorf("expected the created user with a join date, got %+v", seller)
+	}
+	if got, err := repo.FindByToken(ctx, token); err != nil || got.ID != seller.ID {
+		t.Errorf("expected the token to find the user, got %+v, %v", got, err)
+	}
+	if _, err := repo.FindByToken(ctx, "guess"); !errors.Is(err, errUserNotFound) {
+		t.Errorf("expected errUserNotFound for an unknown token, got %v", err)
+	}
+	var stored string
+	db.QueryRow("SELECT token_hash FROM users WHERE id = ?", seller.ID).Scan(&stored)
+	if stored == token {
+		t.Error("expected the token not to be stored in plain text")
+	}
+
+	for i := range 3 {
+		item := &Item{Name: fmt.Sprintf("item %d", i), Category: "Books", Image: "a.jpg", SellerID: seller.ID}
+		if err := items.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+	if err := items.Insert(ctx, &Item{Name: "anonymous", Category: "Books", Image: "b.jpg"}); err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+
+	profile, err := repo.Profile(ctx, seller.ID)
+	if err != nil || profile.ListingCount != 3 {
+		t.Errorf("expected 3 listings, got %+v, %v", profile, err)
+	}
+	page, err := items.FindItemsBySeller(ctx, seller.ID, 2, 0)
+	if err != nil || len(page) != 2 || page[0].Name != "item 2" || page[0].SellerID != seller.ID {
+		t.Errorf("expected the 2 newest items first, got %+v, %v", page, err)
+	}
+	if page, err := items.FindItemsBySeller(ctx, seller.ID, 2, 2); err != nil || len(page) != 1 || page[0].Name != "item 0" {
+		t.Errorf("expected the oldest item on the second page, got %+v, %v", page, err)
+	}
+
+	name, avatar := "Alice B.", "avatar.jpg"
+	updated, err := repo.Update(ctx, seller.ID, &name, &avatar)
+	if err != nil || updated.Name != name || updated.Avatar != avatar || !updated.JoinedAt.Equal(seller.JoinedAt) {
+		t.Errorf("expected the name and avatar to change, got %+v, %v", updated, err)
+	}
+	if updated, err := repo.Update(ctx, seller.ID, nil, nil); err != nil || updated.Name != name {
+		t.Errorf("expected nil values to be kept, got %+v, %v", updated, err)
+	}
+	if _, err := repo.Update(ctx, 999, &name, nil); !errors.Is(err, errUserNotFound) {
+		t.Errorf("expected errUserNotFound, got %v", err)
+	}
+
+	// avatars must survive the image sweeper
+	names, err := items.ListImageNames(ctx)
+	if err != nil || !slices.Contains(names, avatar) {
+		t.Errorf("expected the avatar among the image names, got %v, %v", names, err)
+	}
+}
+
+func TestUserHandlers(t *testing.T) {
+	t.Parallel()
+
+	db := newTestDB(t)
+	users := NewUserRepository(db)
+	h := &Handlers{
+		imageStore: NewLocalImageStore(t.TempDir()),
+		itemRepo:   &itemRepository{fileName: t.TempDir() + "/items.json", db: db},
+		userRepo:   users,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /users", h.CreateUser)
+	mux.Handle("GET /users/me", requireUser(http.HandlerFunc(h.GetMe)))
+	mux.Handle("PATCH /users/me", requireUser(http.HandlerFunc(h.UpdateMe)))
+	mux.HandleFunc("GET /users/{user_id}", h.GetUser)
+	mux.HandleFunc("GET /users/{user_id}/items", h.GetUserItems)
+	mux.HandleFunc("POST /items", h.AddItem)
+	handler := authMiddleware(mux, users)
+
+	do := func(req *http.Request, token string) *httptest.ResponseRecorder {
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do(httptest.NewRequest("POST", "/users", strings.NewReader(`{"name":" Alice "}`)), "")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	var created CreateUserResponse
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	if created.User.Name != "Alice" || created.Token == "" {
+		t.Fatalf("expected the trimmed user with a token, got %+v", created)
+	}
+	if want := fmt.Sprintf("/users/%d", created.User.ID); rr.Header().Get("Location") != want {
+		t.Errorf("expected Location %s, got %q", want, rr.Header().Get("Location"))
+	}
+
+	// items listed while signed in belong to the seller
+	for range 2 {
+		if rr := do(newAddItemRequest(t, map[string]string{"name": "book", "category": "Books"}, testImage), created.Token); rr.Code != http.StatusOK {
+			t.Fatalf("failed to add item: %d %s", rr.Code, rr.Body.String())
+		}
+	}
+	if rr := do(newAddItemRequest(t, map[string]string{"name": "anonymous", "category": "Books"}, testImage), ""); rr.Code != http.StatusOK {
+		t.Fatalf("failed to add item: %d %s", rr.Code, rr.Body.String())
+	}
+
+	avatar := base64.StdEncoding.EncodeToString(testImage)
+	rr = do(httptest.NewRequest("PATCH", "/users/me", strings.NewReader(`{"name":"Alice B.","avatar":"`+avatar+`"}`)), created.Token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var updated User
+	json.Unmarshal(rr.Body.Bytes(), &updated)
+	if updated.Name != "Alice B." || !hashedImageName.MatchString(updated.Avatar) {
+		t.Errorf("expected the new name and a hashed avatar name, got %+v", updated)
+	}
+
+	cases := map[string]struct {
+		method, target, body, token string
+		wantCode                    int
+		check                       func(t *testing.T, body []byte)
+	}{
+		"ok: profile": {
+			method: "GET", target: fmt.Sprintf("/users/%d", created.User.ID),
+			wantCode: http.StatusOK,
+			check: func(t *testing.T, body []byte) {
+				var got UserProfile
+				json.Unmarshal(body, &got)
+				if got.Name != "Alice B." || got.Avatar != updated.Avatar || got.ListingCount != 2 || got.JoinedAt.IsZero() {
+					t.Errorf("unexpected profile %+v", got)
+				}
+			},
+		},
+		"ok: own profile": {
+			method: "GET", target: "/users/me", token: created.Token,
+			wantCode: http.StatusOK,
+			check: func(t *testing.T, body []byte) {
+				var got UserProfile
+				json.Unmarshal(body, &got)
+				if got.ID != created.User.ID {
+					t.Errorf("expected user %d, got %+v", created.User.ID, got)
+				}
+			},
+		},
+		"ok: second page of items": {
+			method: "GET", target: fmt.Sprintf("/users/%d/items?page=2&per_page=1", created.User.ID),
+			wantCode: http.StatusOK,
+			check: func(t *testing.T, body []byte) {
+				var got GetUserItemsResponse
+				json.Unmarshal(body, &got)
+				if len(got.Items) != 1 || got.Page != 2 || got.PerPage != 1 || got.Total != 2 || got.Items[0].SellerID != created.User.ID {
+					t.Errorf("unexpected page %+v", got)
+				}
+			},
+		},
+		"ok: page after the last": {
+			method: "GET", target: fmt.Sprintf("/users/%d/items?page=5", created.User.ID),
+			wantCode: http.StatusOK,
+			check: func(t *testing.T, body []byte) {
+				if !strings.Contains(string(body), `"items":[]`) {
+					t.Errorf("expected an empty page, got %s", body)
+				}
+			},
+		},
+		"ng: page 0":                  {method: "GET", target: fmt.Sprintf("/users/%d/items?page=0", created.User.ID), wantCode: http.StatusUnprocessableEntity},
+		"ng: too many per page":       {method: "GET", target: fmt.Sprintf("/users/%d/items?per_page=101", created.User.ID), wantCode: http.StatusUnprocessableEntity},
+		"ng: missing user":            {method: "GET", target: "/users/999", wantCode: http.StatusNotFound},
+		"ng: items of a missing user": {method: "GET", target: "/users/999/items", wantCode: http.StatusNotFound},
+		"ng: edit without token":      {method: "PATCH", target: "/users/me", body: `{"name":"Mallory"}`, wantCode: http.StatusUnauthorized},
+		"ng: edit with unknown token": {method: "PATCH", target: "/users/me", body: `{"name":"Mallory"}`, token: "guess", wantCode: http.StatusUnauthorized},
+		"ng: edit nothing":            {method: "PATCH", target: "/users/me", body: `{}`, token: created.Token, wantCode: http.StatusUnprocessableEntity},
+		"ng: blank name":              {method: "PATCH", target: "/users/me", body: `{"name":" "}`, token: created.Token, wantCode: http.StatusUnprocessableEntity},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			rr := do(httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)), tt.token)
+			if rr.Code != tt.wantCode {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wantCode, rr.Code, rr.Body.String())
+			}
+			if tt.check != nil {
+				tt.check(t, rr.Body.Bytes())
+			}
+		})
+	}
+}
@@ -0,0 +1,312 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// This file provides the validation of request structs.
+//
+// Rules are given by the validate tag of each field, separated by commas and applied in order:
+//
+//	trim       removes leading and trailing white space from a string before the other rules
+//	required   rejects the zero value
+//	min=N      strings need at least N characters, slices N elements, and numbers a value of N
+//	max=N      strings may have at most N characters, slices N elements, and numbers a value of N
+//	oneof=A B  the string must be one of the space-separated values
+//	singleline rejects control characters, including line breaks
+//	multiline  rejects control characters other than line breaks and tabs
+//	url        the string must be an absolute http or https URL
+//	email      the string must be a bare email address such as user@example.com
+//
+// Characters are counted as Unicode code points, so "ファッション" has 6.
+// Rules other than required are skipped for fields which are not given, so optional fields are only checked when given.
+// A field is not given if it is the zero value; for pointers, only nil is, so that an explicit zero can be rejected.
+//
+// Rules involving several fields are checked by a validate method on the request type.
+
+// FieldError is a problem with one field of a request.
+type FieldError struct {
+	// Field is the name of the field in the request, such as its JSON key.
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is every problem found in a request.
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		msgs = append(msgs, fe.Field+" "+fe.Message)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// structValidator is implemented by request types with rules involving several fields.
+// It is called after the tag rules, on the trimmed values.
+type structValidator interface {
+	validate() []FieldError
+}
+
+// validate checks v, a pointer to a request struct, against its validate tags and its validate method.
+// Strings with the trim rule are trimmed in place.
+// It returns a *ValidationError listing every problem, or nil if there is none.
+func validate(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("validate: %T is not a pointer to a struct", v))
+	}
+	rv = rv.Elem()
+
+	var errs []FieldError
+	for _, f := range structRules(rv.Type()) {
+		field := rv.Field(f.index)
+		for _, rule := range f.rules {
+			if msg := rule(field); msg != "" {
+				errs = append(errs, FieldError{Field: f.name, Message: msg})
+				// later rules would only report the same field again
+				break
+			}
+		}
+	}
+	if sv, ok := v.(structValidator); ok {
+		errs = append(errs, sv.validate()...)
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// rule checks a field, and returns a message describing the problem or "" if there is none.
+type rule func(field reflect.Value) string
+
+type fieldRules struct {
+	index int
+	name  string
+	rules []rule
+}
+
+// ruleCache caches the rules of each struct type, since tags never change.
+var ruleCache sync.Map // reflect.Type -> []fieldRules
+
+// structRules returns the rules of the fields of t, parsing the tags on first use.
+// Invalid tags are programming errors, so they panic.
+func structRules(t reflect.Type) []fieldRules {
+	if cached, ok := ruleCache.Load(t); ok {
+		return cached.([]fieldRules)
+	}
+
+	var fields []fieldRules
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("validate")
+		if tag == "" || !sf.IsExported() {
+			continue
+		}
+		f := fieldRules{index: i, name: fieldName(sf)}
+		for _, spec := range strings.Split(tag, ",") {
+			r, err := parseRule(spec)
+			if err != nil {
+				panic(fmt.Sprintf("validate: field %s of %s: %v", sf.Name, t, err))
+			}
+			f.rules = append(f.rules, r)
+		}
+		fields = append(fields, f)
+	}
+
+	ruleCache.Store(t, fields)
+	return fields
+}
+
+// fieldName returns the name clients know the field by: its JSON key, else its form key, else the Go name.
+func fieldName(sf reflect.StructField) string {
+	for _, key := range []string{"json", "form", "query", "path"} {
+		if name, _, _ := strings.Cut(sf.Tag.Get(key), ","); name != "" && name != "-" {
+			return name
+		}
+	}
+	return sf.Name
+}
+
+func parseRule(spec string) (rule, error) {
+	name, arg, _ := strings.Cut(strings.TrimSpace(spec), "=")
+	switch name {
+	case "trim":
+		return trimRule, nil
+	case "required":
+		return requiredRule, nil
+	case "min", "max":
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s rule %q", name, spec)
+		}
+		return sizeRule(name == "min", n), nil
+	case "oneof":
+		values := strings.Fields(arg)
+		if len(values) == 0 {
+			return nil, fmt.Errorf("invalid oneof rule %q", spec)
+		}
+		return oneOfRule(values), nil
+	case "singleline":
+		return controlRule(false), nil
+	case "multiline":
+		return controlRule(true), nil
+	case "url":
+		return urlRule, nil
+	case "email":
+		return emailRule, nil
+	default:
+		return nil, fmt.Errorf("unknown rule %q", spec)
+	}
+}
+
+// deref returns the value a pointer points to, and whether the field is given:
+// a pointer is given unless it is nil, even if it points to a zero value, and other fields unless they are zero.
+func deref(field reflect.Value) (reflect.Value, bool) {
+	if field.Kind() != reflect.Pointer {
+		return field, !field.IsZero()
+	}
+	if field.IsNil() {
+		return field, false
+	}
+	return field.Elem(), true
+}
+
+func trimRule(field reflect.Value) string {
+	if v, ok := deref(field); ok && v.Kind() == reflect.String && v.CanSet() {
+		v.SetString(strings.TrimSpace(v.String()))
+	}
+	return ""
+}
+
+func requiredRule(field reflect.Value) string {
+	if _, ok := deref(field); !ok {
+		return "is required"
+	}
+	return ""
+}
+
+func sizeRule(isMin bool, n int) rule {
+	return func(field reflect.Value) string {
+		v, ok := deref(field)
+		if !ok {
+			return ""
+		}
+
+		var size int64
+		var unit string
+		switch v.Kind() {
+		case reflect.String:
+			size, unit = int64(utf8.RuneCountInString(v.String())), " characters"
+		case reflect.Slice, reflect.Map, reflect.Array:
+			size, unit = int64(v.Len()), " elements"
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			size = v.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			size = int64(v.Uint())
+		default:
+			return ""
+		}
+
+		switch {
+		case isMin && size < int64(n):
+			return fmt.Sprintf("must be at least %d%s", n, unit)
+		case !isMin && size > int64(n):
+			return fmt.Sprintf("must be at most %d%s", n, unit)
+		}
+		return ""
+	}
+}
+
+func oneOfRule(values []string) rule {
+	return func(field reflect.Value) string {
+		v, ok := deref(field)
+		if !ok || v.Kind() != reflect.String {
+			return ""
+		}
+		for _, value := range values {
+			if v.String() == value {
+				return ""
+			}
+		}
+		return "must be one of " + strings.Join(values, ", ")
+	}
+}
+
+// controlRule rejects control characters, invalid UTF-8, and the bidirectional formatting characters
+// which can make text display differently from what it contains.
+func controlRule(multiline bool) rule {
+	return func(field reflect.Value) string {
+		v, ok := deref(field)
+		if !ok || v.Kind() != reflect.String {
+			return ""
+		}
+		s := v.String()
+		if !utf8.ValidString(s) {
+			return "must be valid UTF-8"
+		}
+		for _, r := range s {
+			if multiline && (r == '\n' || r == '\r' || r == '\t') {
+				continue
+			}
+			if unicode.IsControl(r) || isBidiControl(r) {
+				if !multiline && (r == '\n' || r == '\r') {
+					return "must be a single line"
+				}
+				return "must not contain control characters"
+			}
+		}
+		return ""
+	}
+}
+
+func isBidiControl(r rune) bool {
+	return (r >= '\u202a' && r <= '\u202e') || (r >= '\u2066' && r <= '\u2069') || r == '\u200e' || r == '\u200f' || r == '\u061c'
+}
+
+// writeValidationError writes the problems of a request as a JSON 422 response.
+func writeValidationError(w http.ResponseWriter, r *http.Request, err *ValidationError) {
+	writeJSON(w, http.StatusUnprocessableEntity, ErrorResponse{
+		Message:   "invalid request",
+		RequestID: requestIDFromContext(r.Context()),
+		Errors:    err.Errors,
+	})
+}
+
+func urlRule(field reflect.Value) string {
+	v, ok := deref(field)
+	if !ok || v.Kind() != reflect.String {
+		return ""
+	}
+	u, err := url.Parse(v.String())
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "must be an http or https URL"
+	}
+	return ""
+}
+
+func emailRule(field reflect.Value) string {
+	v, ok := deref(field)
+	if !ok || v.Kind() != reflect.String {
+		return ""
+	}
+	// display names such as "Alice <alice@example.com>" are rejected, since the value is used as an address
+	addr, err := mail.ParseAddress(v.String())
+	if err != nil || addr.Address != v.String() {
+		return "must be an email address"
+	}
+	return ""
+}
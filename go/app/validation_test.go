@@ -0,0 +1,155 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type testValidationRequest struct {
+	Name    string   `json:"name" validate:"trim,required,max=5,singleline"`
+	Note    string   `json:"note" validate:"max=10,multiline"`
+	Format  string   `form:"format" validate:"oneof=json text"`
+	Count   *int     `json:"count" validate:"min=1,max=3"`
+	Tags    []string `json:"tags" validate:"max=2"`
+	Website string   `json:"website" validate:"url"`
+	Email   string   `json:"email" validate:"email"`
+	// Other is compared with Name by the validate method.
+	Other string `json:"other"`
+}
+
+func (r *testValidationRequest) validate() []FieldError {
+	if r.Other != "" && r.Other == r.Name {
+		return []FieldError{{Field: "other", Message: "must differ from name"}}
+	}
+	return nil
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	zero, four := 0, 4
+	cases := map[string]struct {
+		req      testValidationRequest
+		wantName string
+		wantErrs []FieldError
+	}{
+		"ok: valid request": {
+			req:      testValidationRequest{Name: " bag ", Note: "a\nb\tc", Format: "json", Tags: []string{"a"}, Website: "https://example.com", Email: "alice@example.com"},
+			wantName: "bag",
+		},
+		"ok: length counts characters": {
+			req:      testValidationRequest{Name: "ファッショ"},
+			wantName: "ファッショ",
+		},
+		"ng: required after trimming": {
+			req:      testValidationRequest{Name: " \t "},
+			wantErrs: []FieldError{{Field: "name", Message: "is required"}},
+		},
+		"ng: every field is reported": {
+			req: testValidationRequest{Name: "ファッション", Note: "0123456789a", Format: "xml", Count: &four, Tags: []string{"a", "b", "c"}, Website: "ftp://example.com", Email: "Alice <alice@example.com>"},
+			wantErrs: []FieldError{
+				{Field: "name", Message: "must be at most 5 characters"},
+				{Field: "note", Message: "must be at most 10 characters"},
+				{Field: "format", Message: "must be one of json, text"},
+				{Field: "count", Message: "must be at most 3"},
+				{Field: "tags", Message: "must be at most 2 elements"},
+				{Field: "website", Message: "must be an http or https URL"},
+				{Field: "email", Message: "must be an email address"},
+			},
+		},
+		"ng: explicit zero pointer": {
+			req:      testValidationRequest{Name: "bag", Count: &zero},
+			wantErrs: []FieldError{{Field: "count", Message: "must be at least 1"}},
+		},
+		"ng: line break in a single line": {
+			req:      testValidationRequest{Name: "a\nb"},
+			wantErrs: []FieldError{{Field: "name", Message: "must be a single line"}},
+		},
+		"ng: control characters": {
+			req: testValidationRequest{Name: "a\x00b", Note: "\x1b[31m"},
+			wantErrs: []FieldError{
+				{Field: "name", Message: "must not contain control characters"},
+				{Field: "note", Message: "must not contain control characters"},
+			},
+		},
+		"ng: bidirectional override": {
+			req:      testValidationRequest{Name: "a\u202eb"},
+			wantErrs: []FieldError{{Field: "name", Message: "must not contain control characters"}},
+		},
+		"ng: invalid utf-8": {
+			req:      testValidationRequest{Name: "a\xffb"},
+			wantErrs: []FieldError{{Field: "name", Message: "must be valid UTF-8"}},
+		},
+		"ng: rules of the validate method": {
+			req:      testValidationRequest{Name: "bag", Other: "bag"},
+			wantErrs: []FieldError{{Field: "other", Message: "must differ from name"}},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := tt.req
+			err := validate(&req)
+			if tt.wantErrs == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if req.Name != tt.wantName {
+					t.Errorf("expected name %q, got %q", tt.wantName, req.Name)
+				}
+				return
+			}
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("expected *ValidationError, got %v", err)
+			}
+			if diff := cmp.Diff(tt.wantErrs, validationErr.Errors); diff != "" {
+				t.Errorf("unexpected errors (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestValidatePanicsOnInvalidTag(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		if p := recover(); p == nil || !strings.Contains(p.(string), "unknown rule") {
+			t.Errorf("expected a panic for an unknown rule, got %v", p)
+		}
+	}()
+	validate(&struct {
+		Name string `validate:"requried"`
+	}{})
+}
+
+func TestWriteRequestError(t *testing.T) {
+	t.Parallel()
+
+	req := httptest.NewRequest("POST", "/items", nil)
+	req = req.WithContext(context.WithValue(req.Context(), requestIDKey{}, "req-1"))
+	rr := httptest.NewRecorder()
+	writeRequestError(rr, req, &ValidationError{Errors: []FieldError{{Field: "name", Message: "is required"}}})
+
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status code %d, got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+	var got ErrorResponse
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response body: %v", err)
+	}
+	want := ErrorResponse{Message: "invalid request", RequestID: "req-1", Errors: []FieldError{{Field: "name", Message: "is required"}}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected response body (-want +got):\n%s", diff)
+	}
+}
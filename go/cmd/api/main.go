@@ -1,20 +1,33 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"mercari-build-training/app"
 	"os"
 )
 
-const (
-	port         = "9000"
-	imageDirPath = "images"
-)
-
 func main() {
 	// This is the entry point of the application.
-	// You don't need to modify this function.
+	// The configuration is read from the config file, environment variables and flags; run with -h for the flags.
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	printConfig := fs.Bool("print-config", false, "print the configuration with secrets redacted and exit")
+
+	cfg, err := app.LoadConfig(fs, os.Args[1:], os.LookupEnv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if *printConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	os.Exit(app.Server{
-		Port:         port,
-		ImageDirPath: imageDirPath,
+		Config: cfg,
 	}.Run())
 }
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"mercari-build-training/app"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func main() {
+	// This is the entry point of the command generating a self-signed certificate for serving HTTPS locally.
+	// Start the server with -tls-cert and -tls-key pointing to the generated files.
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	dir := fs.String("dir", "certs", "directory to write dev-cert.pem and dev-key.pem to")
+	hosts := fs.String("hosts", "localhost,127.0.0.1,::1", "comma-separated host names and addresses of the certificate")
+	validFor := fs.Duration("valid-for", 365*24*time.Hour, "how long the certificate is valid")
+	fs.Parse(os.Args[1:])
+
+	certPEM, keyPEM, err := app.GenerateDevCertificate(strings.Split(*hosts, ","), *validFor)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	certFile := filepath.Join(*dir, "dev-cert.pem")
+	keyFile := filepath.Join(*dir, "dev-key.pem")
+	if err := os.WriteFile(certFile, certPEM, 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	// the private key is readable by the owner only
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("wrote %s and %s\nstart the server with: -tls-cert %s -tls-key %s\n", certFile, keyFile, certFile, keyFile)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"mercari-build-training/app"
+	"os"
+)
+
+func main() {
+	// This is the entry point of the command deleting images which no item refers to.
+	// It shares the configuration of the server; -sweep-grace sets how long unreferenced images are kept.
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "print the images to delete without deleting them")
+
+	cfg, err := app.LoadConfig(fs, os.Args[1:], os.LookupEnv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	os.Exit(app.Sweeper{
+		Config: cfg,
+		DryRun: *dryRun,
+	}.Run())
+}
@@ -3,10 +3,156 @@ CREATE TABLE items (
     name TEXT NOT NULL,
     category_id INTEGER NOT NULL,
     image_name TEXT NOT NULL,
-    FOREIGN KEY (category_id) REFERENCES categories(id)
+    -- price is in yen; items listed before prices were added are 0
+    price INTEGER NOT NULL DEFAULT 0 CHECK (price >= 0),
+    -- seller_id is NULL for items listed without signing in
+    seller_id INTEGER,
+    FOREIGN KEY (category_id) REFERENCES categories(id),
+    FOREIGN KEY (seller_id) REFERENCES users(id)
 );
 
 CREATE TABLE categories (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
-    name TEXT NOT NULL
-);
\ No newline at end of file
+    name TEXT NOT NULL,
+    -- name_key is the normalized name, so that names differing only in case, width or spacing are one category
+    name_key TEXT NOT NULL,
+    parent_id INTEGER,
+    FOREIGN KEY (parent_id) REFERENCES categories(id)
+);
+
+-- siblings have distinct names; root categories have no parent, so they are grouped under 0
+CREATE UNIQUE INDEX categories_parent_name_key ON categories (IFNULL(parent_id, 0), name_key);
+CREATE INDEX items_category_id ON items (category_id);
+CREATE INDEX items_seller_id ON items (seller_id);
+
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    -- avatar_name is the image name of the avatar, or empty if the user has none
+    avatar_name TEXT NOT NULL DEFAULT '',
+    -- token_hash is the sha256 of the token authenticating the user, so that a leaked database doesn't leak tokens
+    token_hash TEXT NOT NULL UNIQUE,
+    -- email is where notifications are emailed, or empty if the user gave none; it is never shown to others
+    email TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE transactions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    -- an item is sold at most once
+    item_id INTEGER NOT NULL UNIQUE,
+    seller_id INTEGER NOT NULL,
+    buyer_id INTEGER NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('trading', 'completed')),
+    -- price is what the buyer pays: the price of the item, or the one negotiated in an accepted offer
+    price INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP,
+    FOREIGN KEY (item_id) REFERENCES items(id),
+    FOREIGN KEY (seller_id) REFERENCES users(id),
+    FOREIGN KEY (buyer_id) REFERENCES users(id)
+);
+
+-- the price negotiations of buyers with the sellers of items; the buyer and the seller take turns until one accepts or declines
+CREATE TABLE offers (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    item_id INTEGER NOT NULL,
+    seller_id INTEGER NOT NULL,
+    buyer_id INTEGER NOT NULL,
+    -- price is the latest price offered by the buyer or countered by the seller
+    price INTEGER NOT NULL CHECK (price > 0),
+    status TEXT NOT NULL CHECK (status IN ('pending', 'countered', 'accepted', 'declined', 'purchased')),
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    -- the offer expires at expires_at unless it is answered, or purchased once accepted
+    expires_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (item_id) REFERENCES items(id),
+    FOREIGN KEY (seller_id) REFERENCES users(id),
+    FOREIGN KEY (buyer_id) REFERENCES users(id)
+);
+
+CREATE INDEX offers_item_id ON offers (item_id, buyer_id);
+
+CREATE TABLE reviews (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    transaction_id INTEGER NOT NULL,
+    reviewer_id INTEGER NOT NULL,
+    reviewee_id INTEGER NOT NULL,
+    rating TEXT NOT NULL CHECK (rating IN ('good', 'normal', 'bad')),
+    comment TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    -- each party of a transaction reviews the other once
+    UNIQUE (transaction_id, reviewer_id),
+    FOREIGN KEY (transaction_id) REFERENCES transactions(id),
+    FOREIGN KEY (reviewer_id) REFERENCES users(id),
+    FOREIGN KEY (reviewee_id) REFERENCES users(id)
+);
+
+CREATE INDEX reviews_reviewee_id ON reviews (reviewee_id);
+
+CREATE TABLE messages (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    transaction_id INTEGER NOT NULL,
+    sender_id INTEGER NOT NULL,
+    body TEXT NOT NULL DEFAULT '',
+    -- the attached image stored in the images directory, or '' for none
+    image_name TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    -- set when the other party reads the message
+    read_at TIMESTAMP,
+    FOREIGN KEY (transaction_id) REFERENCES transactions(id),
+    FOREIGN KEY (sender_id) REFERENCES users(id)
+);
+
+CREATE INDEX messages_transaction_id ON messages (transaction_id, id);
+
+CREATE TABLE notifications (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    type TEXT NOT NULL,
+    -- the IDs of what the notification is about, in JSON
+    data TEXT NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL,
+    read_at TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX notifications_user_id ON notifications (user_id, id);
+
+-- how each user receives each type of notification; types without a row use the defaults of the server
+CREATE TABLE notification_preferences (
+    user_id INTEGER NOT NULL,
+    type TEXT NOT NULL,
+    in_app INTEGER NOT NULL,
+    email INTEGER NOT NULL,
+    PRIMARY KEY (user_id, type),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- the searches users saved to be told about new matching items; criteria which are NULL or empty match every item
+CREATE TABLE saved_searches (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    keyword TEXT NOT NULL DEFAULT '',
+    category_id INTEGER,
+    min_price INTEGER,
+    max_price INTEGER,
+    -- notify is set if the user is notified of each new matching item
+    notify INTEGER NOT NULL DEFAULT 0,
+    -- the newest item when the user last checked the new items; only items after it are new
+    checked_item_id INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (category_id) REFERENCES categories(id)
+);
+
+CREATE INDEX saved_searches_user_id ON saved_searches (user_id);
+
+CREATE TABLE image_hashes (
+    image_name TEXT PRIMARY KEY,
+    phash INTEGER NOT NULL
+);
+
+-- the schema version checked by GET /readyz; bump it together with schemaVersion in app/health.go
+PRAGMA user_version = 8;